@@ -0,0 +1,457 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package export converts fortio results into formats other tools
+// understand: HdrHistogram interval logs, CSV percentile tables, JUnit XML
+// and Markdown summaries. Exporters work from the JSON serialization of the
+// results so they apply equally to live runs and to saved data/ files.
+package export // import "fortio.org/fortio/export"
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/periodic"
+	"fortio.org/fortio/stats"
+)
+
+// Results is the subset of any runner's results (http, grpc, tcp, udp) the
+// exporters need. Deserialized from the common JSON output.
+type Results struct {
+	periodic.RunnerResults
+	// RetCodes is the per code (http) or status (grpc, tcp...) count, keys are always strings in JSON.
+	RetCodes map[string]int64 `json:",omitempty"`
+	// ErrorCategories is the breakdown of the socket errors by category (dns, reset,...).
+	ErrorCategories map[string]int64 `json:",omitempty"`
+	// CheckFailures is the breakdown of the http responses failing the checks (status, json,...).
+	CheckFailures map[string]int64 `json:",omitempty"`
+	// Checks are the response checks configured for http runs, by (json) name, e.g. "Contains".
+	Checks map[string]json.RawMessage `json:",omitempty"`
+	// URL is set for http runs.
+	URL string `json:",omitempty"`
+	// Destination is set for grpc, tcp and udp runs.
+	Destination string `json:",omitempty"`
+}
+
+// Target returns the URL or Destination of the run.
+func (r *Results) Target() string {
+	if r.URL != "" {
+		return r.URL
+	}
+	return r.Destination
+}
+
+// ErrorCount returns the number of calls which returned false from Run().
+func (r *Results) ErrorCount() int64 {
+	if r.ErrorsDurationHistogram == nil {
+		return 0
+	}
+	return r.ErrorsDurationHistogram.Count
+}
+
+// FromJSON deserializes saved (or just serialized) results.
+func FromJSON(data []byte) (*Results, error) {
+	var res Results
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	if res.DurationHistogram == nil {
+		return nil, fmt.Errorf("not a fortio result: missing DurationHistogram")
+	}
+	return &res, nil
+}
+
+// FromResult converts any of the runners results to the exporter input.
+func FromResult(res periodic.HasRunnerResult) (*Results, error) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	return FromJSON(data)
+}
+
+// Exporter writes results in a given format.
+type Exporter interface {
+	// Name is the short name of the format, used for ?format= and the -export flag.
+	Name() string
+	// Extension is the file extension, including the leading dot, for that format.
+	Extension() string
+	// ContentType is the mime type to use when serving that format over http.
+	ContentType() string
+	// Export writes the results to w.
+	Export(w io.Writer, res *Results) error
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      = make(map[string]Exporter)
+)
+
+// Register adds (or replaces) an exporter, making it available by Name() and Extension().
+func Register(e Exporter) {
+	registryMutex.Lock()
+	registry[strings.ToLower(e.Name())] = e
+	registryMutex.Unlock()
+}
+
+// Get returns the exporter for the given format name or nil if there isn't one.
+func Get(name string) Exporter {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	return registry[strings.ToLower(name)]
+}
+
+// ForPath returns the exporter whose Extension() matches the end of the
+// file name or path p, or nil if none does.
+func ForPath(p string) Exporter {
+	lp := strings.ToLower(p)
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	var best Exporter
+	for _, e := range registry {
+		ext := e.Extension()
+		// longest match so ".junit.xml" would win over ".xml"
+		if strings.HasSuffix(lp, ext) && (best == nil || len(ext) > len(best.Extension())) {
+			best = e
+		}
+	}
+	return best
+}
+
+// Names returns the sorted list of registered format names.
+func Names() []string {
+	registryMutex.Lock()
+	res := make([]string, 0, len(registry))
+	for k := range registry {
+		res = append(res, k)
+	}
+	registryMutex.Unlock()
+	sort.Strings(res)
+	return res
+}
+
+// StripExtension removes the exporter's extension from the path, if present.
+func StripExtension(e Exporter, p string) string {
+	ext := e.Extension()
+	if strings.HasSuffix(strings.ToLower(p), ext) {
+		return p[:len(p)-len(ext)]
+	}
+	return strings.TrimSuffix(p, path.Ext(p))
+}
+
+//nolint:gochecknoinits // registration of the built in formats.
+func init() {
+	Register(HdrLog{})
+	Register(CSV{})
+	Register(JUnit{})
+	Register(Markdown{})
+}
+
+// sortedCodes returns the RetCodes keys, sorted numerically when possible.
+func sortedCodes(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		var a, b int
+		_, errA := fmt.Sscanf(keys[i], "%d", &a)
+		_, errB := fmt.Sscanf(keys[j], "%d", &b)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// defaultPercentiles is what we output when the results don't have any calculated.
+var defaultPercentiles = []float64{50, 75, 90, 99, 99.9}
+
+// percentiles returns the percentiles already calculated in the histogram or
+// calculates the default ones.
+func percentiles(h *stats.HistogramData) []stats.Percentile {
+	if h == nil || h.Count == 0 {
+		return nil
+	}
+	if len(h.Percentiles) > 0 {
+		return h.Percentiles
+	}
+	res := make([]stats.Percentile, 0, len(defaultPercentiles))
+	for _, p := range defaultPercentiles {
+		res = append(res, stats.Percentile{Percentile: p, Value: h.CalcPercentile(p)})
+	}
+	return res
+}
+
+// CSV exports the percentile table of the call durations (in milliseconds).
+type CSV struct{}
+
+// Name of the format.
+func (CSV) Name() string { return "csv" }
+
+// Extension of the format.
+func (CSV) Extension() string { return ".csv" }
+
+// ContentType of the format.
+func (CSV) ContentType() string { return "text/csv; charset=UTF-8" }
+
+// Export writes the percentile table, with min as p0 and max as p100.
+func (CSV) Export(w io.Writer, res *Results) error {
+	h := res.DurationHistogram
+	if _, err := fmt.Fprintln(w, "Percentile,Latency(ms)"); err != nil {
+		return err
+	}
+	if h.Count == 0 {
+		return nil
+	}
+	_, _ = fmt.Fprintf(w, "0,%g\n", 1000.*h.Min)
+	for _, p := range percentiles(h) {
+		_, _ = fmt.Fprintf(w, "%g,%g\n", p.Percentile, 1000.*p.Value)
+	}
+	_, err := fmt.Fprintf(w, "100,%g\n", 1000.*h.Max)
+	return err
+}
+
+// Markdown exports a human readable summary of the run.
+type Markdown struct{}
+
+// Name of the format.
+func (Markdown) Name() string { return "md" }
+
+// Extension of the format.
+func (Markdown) Extension() string { return ".md" }
+
+// ContentType of the format.
+func (Markdown) ContentType() string { return "text/markdown; charset=UTF-8" }
+
+// Export writes the Markdown summary.
+func (Markdown) Export(w io.Writer, res *Results) error {
+	h := res.DurationHistogram
+	title := res.Labels
+	if title == "" {
+		title = res.ID
+	}
+	_, _ = fmt.Fprintf(w, "# Fortio %s run: %s\n\n", res.RunType, title)
+	_, _ = fmt.Fprintln(w, "| Metric | Value |")
+	_, _ = fmt.Fprintln(w, "|---|---|")
+	if t := res.Target(); t != "" {
+		_, _ = fmt.Fprintf(w, "| Target | `%s` |\n", t)
+	}
+	_, _ = fmt.Fprintf(w, "| Start time | %s |\n", res.StartTime.Format("2006-01-02 15:04:05 MST"))
+	_, _ = fmt.Fprintf(w, "| Requested | %s qps, %s |\n", res.RequestedQPS, res.RequestedDuration)
+	_, _ = fmt.Fprintf(w, "| Actual | %.1f qps over %v |\n", res.ActualQPS, res.ActualDuration)
+	_, _ = fmt.Fprintf(w, "| Connections | %d |\n", res.NumThreads)
+	_, _ = fmt.Fprintf(w, "| Calls | %d (%d errors) |\n", h.Count, res.ErrorCount())
+	if h.Count > 0 {
+		_, _ = fmt.Fprintf(w, "| Latency avg | %.3f ms (+/- %.3f) |\n", 1000.*h.Avg, 1000.*h.StdDev)
+		_, _ = fmt.Fprintf(w, "| Latency min / max | %.3f / %.3f ms |\n", 1000.*h.Min, 1000.*h.Max)
+	}
+	_, _ = fmt.Fprintf(w, "| Fortio version | %s |\n", res.Version)
+	if ps := percentiles(h); len(ps) > 0 {
+		_, _ = fmt.Fprint(w, "\n## Latency percentiles\n\n| Percentile | Latency (ms) |\n|---|---|\n")
+		for _, p := range ps {
+			_, _ = fmt.Fprintf(w, "| p%g | %.3f |\n", p.Percentile, 1000.*p.Value)
+		}
+	}
+	if len(res.RetCodes) > 0 {
+		_, _ = fmt.Fprint(w, "\n## Return codes\n\n| Code | Count | % |\n|---|---|---|\n")
+		for _, k := range sortedCodes(res.RetCodes) {
+			v := res.RetCodes[k]
+			_, _ = fmt.Fprintf(w, "| %s | %d | %.1f |\n", k, v, 100.*float64(v)/float64(h.Count))
+		}
+	}
+	if len(res.ErrorCategories) > 0 {
+		_, _ = fmt.Fprint(w, "\n## Socket errors\n\n| Category | Count | % |\n|---|---|---|\n")
+		for _, k := range fnet.ErrorCategories {
+			if v := res.ErrorCategories[k]; v > 0 {
+				_, _ = fmt.Fprintf(w, "| %s | %d | %.1f |\n", k, v, 100.*float64(v)/float64(h.Count))
+			}
+		}
+	}
+	if len(res.CheckFailures) > 0 {
+		_, _ = fmt.Fprint(w, "\n## Failed checks\n\n| Check | Count | % |\n|---|---|---|\n")
+		keys := make([]string, 0, len(res.CheckFailures))
+		for k := range res.CheckFailures {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := res.CheckFailures[k]
+			_, _ = fmt.Fprintf(w, "| %s | %d | %.1f |\n", k, v, 100.*float64(v)/float64(h.Count))
+		}
+	}
+	if cs := res.ClientStats; cs != nil && cs.Saturated {
+		_, _ = fmt.Fprint(w, "\n## Load generator saturated\n\nResults reflect fortio's limits more than the target's:\n\n")
+		for _, x := range cs.Warnings {
+			_, _ = fmt.Fprintf(w, "- %s\n", x)
+		}
+	}
+	if len(res.Exemplars) > 0 {
+		_, _ = fmt.Fprint(w, "\n## Slowest calls\n\n| Start | Thread | Latency (ms) | Ok | Details |\n|---|---|---|---|---|\n")
+		for _, x := range res.Exemplars {
+			_, _ = fmt.Fprintf(w, "| %s | %d | %.3f | %t | `%s` |\n",
+				x.StartTime.Format("15:04:05.000"), x.Thread, 1000.*x.Latency, x.Status, x.Details)
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// JUnit exports the run as a JUnit XML test suite, one test case per assertion
+// on the results (for CI systems).
+type JUnit struct{}
+
+// Name of the format.
+func (JUnit) Name() string { return "junit" }
+
+// Extension of the format.
+func (JUnit) Extension() string { return ".xml" }
+
+// ContentType of the format.
+func (JUnit) ContentType() string { return "application/xml; charset=UTF-8" }
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+// Assertion is one pass/fail check made on the results. Failure is empty when the check passed.
+type Assertion struct {
+	Name    string
+	Failure string
+}
+
+// checkCategories maps the names of the http response checks to their CheckFailures
+// category, in the order they are evaluated.
+var checkCategories = [][2]string{
+	{"Status", "status"}, {"Contains", "contains"}, {"Regex", "regex"}, {"JSON", "json"}, {"Headers", "header"}, {"Size", "size"},
+}
+
+// Assertions returns the checks done on the results: the run did make calls,
+// none of them were errors, then one per configured response check (the status one
+// always applies when any is set) and one per category of socket errors that occurred.
+func Assertions(res *Results) []Assertion {
+	count := res.DurationHistogram.Count
+	pct := func(n int64) float64 {
+		return 100. * float64(n) / float64(count)
+	}
+	calls := Assertion{Name: "calls"}
+	if count == 0 {
+		calls.Failure = "no calls were made"
+	}
+	errs := Assertion{Name: "errors"}
+	if n := res.ErrorCount(); n > 0 {
+		errs.Failure = fmt.Sprintf("%d errors out of %d calls (%.2f%%)", n, count, pct(n))
+	}
+	assertions := []Assertion{calls, errs}
+	for _, c := range checkCategories {
+		_, configured := res.Checks[c[0]]
+		configured = configured || (len(res.Checks) > 0 && c[1] == "status") // always done along the others
+		n := res.CheckFailures[c[1]]
+		if !configured && n == 0 {
+			continue
+		}
+		a := Assertion{Name: "check " + c[1]}
+		if n > 0 {
+			a.Failure = fmt.Sprintf("%d responses failed the %s check out of %d calls (%.2f%%)", n, c[1], count, pct(n))
+		}
+		assertions = append(assertions, a)
+	}
+	for _, c := range fnet.ErrorCategories {
+		if n := res.ErrorCategories[c]; n > 0 {
+			assertions = append(assertions, Assertion{
+				Name:    "socket errors " + c,
+				Failure: fmt.Sprintf("%d %s socket errors out of %d calls (%.2f%%)", n, c, count, pct(n)),
+			})
+		}
+	}
+	return assertions
+}
+
+// Export writes the JUnit XML.
+func (JUnit) Export(w io.Writer, res *Results) error {
+	className := "fortio." + strings.ToLower(res.RunType)
+	suite := junitTestSuite{
+		Name:      res.ID,
+		Time:      res.ActualDuration.Seconds(),
+		Timestamp: res.StartTime.Format("2006-01-02T15:04:05"),
+	}
+	for _, a := range Assertions(res) {
+		tc := junitTestCase{Name: a.Name, ClassName: className, Time: suite.Time}
+		if a.Failure != "" {
+			tc.Failure = &junitFailure{Message: a.Failure, Text: res.Target()}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Tests = len(suite.TestCases)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ToFile exports the results according to spec: either a file path whose
+// extension selects the format, or a bare format name (e.g. "csv") to write to stdout.
+// Returns the name of what was written to, for logging.
+func ToFile(spec string, res *Results) (string, error) {
+	if e := Get(spec); e != nil {
+		return "stdout", e.Export(os.Stdout, res)
+	}
+	e := ForPath(spec)
+	if e == nil {
+		return spec, fmt.Errorf("unknown export format for %q, should be one of %v or a file with matching extension", spec, Names())
+	}
+	f, err := os.Create(spec)
+	if err != nil {
+		return spec, err
+	}
+	err = e.Export(f, res)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return spec, err
+}
@@ -0,0 +1,204 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"fortio.org/assert"
+	"fortio.org/fortio/periodic"
+	"fortio.org/fortio/stats"
+)
+
+type testRunnable struct{}
+
+func (testRunnable) Run(t int) (bool, string) {
+	return t != 0, ""
+}
+
+func makeResults(t *testing.T) *Results {
+	o := periodic.RunnerOptions{
+		QPS:         -1,
+		Exactly:     20,
+		NumThreads:  2,
+		Percentiles: []float64{50, 99},
+		Labels:      "export test",
+	}
+	r := periodic.NewPeriodicRunner(&o)
+	r.Options().MakeRunners(testRunnable{})
+	rr := r.Run()
+	r.Options().ReleaseRunners()
+	res, err := FromResult(&rr)
+	if err != nil {
+		t.Fatalf("Unexpected error converting results: %v", err)
+	}
+	res.RetCodes = map[string]int64{"200": 10, "503": 5, "-1": 5}
+	return res
+}
+
+func TestRegistry(t *testing.T) {
+	assert.Equal(t, Names(), []string{"csv", "hdrlog", "junit", "md"}, "registered formats")
+	assert.CheckEquals(t, Get("CSV"), Exporter(CSV{}), "case insensitive get")
+	assert.CheckEquals(t, ForPath("foo/bar.hlog"), Exporter(HdrLog{}), "by extension")
+	assert.CheckEquals(t, ForPath("foo/bar.XML"), Exporter(JUnit{}), "case insensitive extension")
+	assert.Assert(t, ForPath("foo/bar.json") == nil, "no json exporter")
+	assert.CheckEquals(t, StripExtension(Markdown{}, "data/abc.md"), "data/abc", "strip extension")
+	_, err := FromJSON([]byte(`{"foo": 42}`))
+	assert.Assert(t, err != nil, "should error on non results json")
+}
+
+func TestCSVAndMarkdown(t *testing.T) {
+	res := makeResults(t)
+	var b bytes.Buffer
+	assert.CheckEquals(t, CSV{}.Export(&b, res), nil, "csv export")
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.CheckEquals(t, len(lines), 5, "header + min + 2 percentiles + max")
+	assert.CheckEquals(t, lines[0], "Percentile,Latency(ms)", "header")
+	assert.Assert(t, strings.HasPrefix(lines[2], "50,"), "p50 line")
+	assert.Assert(t, strings.HasPrefix(lines[4], "100,"), "max line")
+	b.Reset()
+	assert.CheckEquals(t, Markdown{}.Export(&b, res), nil, "markdown export")
+	md := b.String()
+	assert.Assert(t, strings.HasPrefix(md, "# Fortio  run: export test\n"), "title: "+md)
+	assert.Assert(t, strings.Contains(md, "| Calls | 20 (10 errors) |"), "calls line: "+md)
+	// numerical sort of the codes:
+	assert.Assert(t, strings.Index(md, "| -1 |") < strings.Index(md, "| 200 |"), "-1 before 200")
+	assert.Assert(t, strings.Index(md, "| 200 |") < strings.Index(md, "| 503 |"), "200 before 503")
+	assert.Assert(t, !strings.Contains(md, "saturated"), "no saturation section: "+md)
+	res.ClientStats = &periodic.ClientStats{Saturated: true, Warnings: []string{"fortio used 99% cpu out of 1 core(s)"}}
+	b.Reset()
+	assert.CheckEquals(t, Markdown{}.Export(&b, res), nil, "markdown export")
+	assert.Assert(t, strings.Contains(b.String(), "## Load generator saturated\n"), "saturation section: "+b.String())
+	assert.Assert(t, strings.Contains(b.String(), "- fortio used 99% cpu out of 1 core(s)\n"), "saturation warning: "+b.String())
+	res.CheckFailures = map[string]int64{"json": 2}
+	b.Reset()
+	assert.CheckEquals(t, Markdown{}.Export(&b, res), nil, "markdown export")
+	assert.Assert(t, strings.Contains(b.String(), "## Failed checks\n"), "checks section: "+b.String())
+	assert.Assert(t, strings.Contains(b.String(), "| json | 2 | 10.0 |\n"), "json check line: "+b.String())
+}
+
+func TestJUnit(t *testing.T) {
+	res := makeResults(t)
+	var b bytes.Buffer
+	assert.CheckEquals(t, JUnit{}.Export(&b, res), nil, "junit export")
+	var suite junitTestSuite
+	if err := xml.Unmarshal(b.Bytes(), &suite); err != nil {
+		t.Fatalf("Unable to parse back junit xml %v: %s", err, b.String())
+	}
+	assert.CheckEquals(t, suite.Tests, 2, "2 assertions")
+	assert.CheckEquals(t, suite.Failures, 1, "errors assertion should fail")
+	assert.CheckEquals(t, suite.TestCases[1].Failure.Message, "10 errors out of 20 calls (50.00%)", "failure message")
+	// one test case per configured check and per socket error category:
+	res.Checks = map[string]json.RawMessage{"Contains": json.RawMessage(`"ok"`), "JSON": json.RawMessage(`"a.b=1"`)}
+	res.CheckFailures = map[string]int64{"json": 2}
+	res.ErrorCategories = map[string]int64{"reset": 5}
+	b.Reset()
+	assert.CheckEquals(t, JUnit{}.Export(&b, res), nil, "junit export")
+	suite = junitTestSuite{}
+	if err := xml.Unmarshal(b.Bytes(), &suite); err != nil {
+		t.Fatalf("Unable to parse back junit xml %v: %s", err, b.String())
+	}
+	names := []string{}
+	for _, tc := range suite.TestCases {
+		names = append(names, tc.Name)
+	}
+	assert.Equal(t, names, []string{"calls", "errors", "check status", "check contains", "check json", "socket errors reset"}, "test cases")
+	assert.CheckEquals(t, suite.Failures, 3, "errors, json check and reset should fail")
+	assert.Assert(t, suite.TestCases[3].Failure == nil, "contains check passed")
+	assert.CheckEquals(t, suite.TestCases[4].Failure.Message, "2 responses failed the json check out of 20 calls (10.00%)", "json failure")
+	assert.Assert(t, strings.Contains(b.String(), `<testcase name="check json" classname="fortio.`), "xml: "+b.String())
+	assert.CheckEquals(t, suite.TestCases[5].Failure.Message, "5 reset socket errors out of 20 calls (25.00%)", "reset failure")
+}
+
+// decodeHdr is the reverse of EncodeCompressed, returns the total count.
+func decodeHdr(t *testing.T, b64 string) int64 {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		t.Fatalf("bad base64: %v", err)
+	}
+	assert.CheckEquals(t, binary.BigEndian.Uint32(data), uint32(0x1c849314), "compressed cookie")
+	assert.CheckEquals(t, int(binary.BigEndian.Uint32(data[4:])), len(data)-8, "compressed length")
+	zr, err := zlib.NewReader(bytes.NewReader(data[8:]))
+	if err != nil {
+		t.Fatalf("bad zlib: %v", err)
+	}
+	enc, _ := io.ReadAll(zr)
+	assert.CheckEquals(t, binary.BigEndian.Uint32(enc), uint32(0x1c849313), "encoding cookie")
+	assert.CheckEquals(t, int(binary.BigEndian.Uint32(enc[4:])), len(enc)-hdrHeaderSize, "payload length")
+	r := bytes.NewReader(enc[hdrHeaderSize:])
+	var total int64
+	for r.Len() > 0 {
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatalf("bad varint: %v", err)
+		}
+		if v > 0 {
+			total += v
+		}
+	}
+	return total
+}
+
+func TestHdrHistogramIndex(t *testing.T) {
+	h := NewHdrHistogram(hdrHighestTrackable, 3)
+	// values below 2048 have unit resolution:
+	assert.CheckEquals(t, h.countsIndex(0), 0, "0")
+	assert.CheckEquals(t, h.countsIndex(1000), 1000, "1000")
+	assert.CheckEquals(t, h.countsIndex(2047), 2047, "2047")
+	// then 2 units wide
+	assert.CheckEquals(t, h.countsIndex(2048), 2048, "2048")
+	assert.CheckEquals(t, h.countsIndex(2049), 2048, "2049")
+	assert.CheckEquals(t, h.countsIndex(2050), 2049, "2050")
+	h.RecordN(-5, 1)
+	h.RecordN(2*hdrHighestTrackable, 1)
+	assert.CheckEquals(t, h.counts[0], int64(1), "negative clamped to 0")
+	assert.CheckEquals(t, h.counts[len(h.counts)-1], int64(1), "too large clamped to max")
+}
+
+func TestHdrLog(t *testing.T) {
+	res := &Results{}
+	res.RunType = "HTTP"
+	res.StartTime = time.Unix(1441812279, 474000000)
+	res.ActualDuration = 2 * time.Second
+	h := stats.NewHistogram(0, 0.001)
+	for i := 1; i <= 100; i++ {
+		h.Record(float64(i) / 1000.)
+	}
+	res.DurationHistogram = h.Export()
+	e := stats.NewHistogram(0, 0.001)
+	e.Record(0.5)
+	res.ErrorsDurationHistogram = e.Export()
+	var b bytes.Buffer
+	assert.CheckEquals(t, HdrLog{}.Export(&b, res), nil, "hdrlog export")
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.CheckEquals(t, len(lines), 7, "5 header lines + 2 intervals")
+	assert.CheckEquals(t, lines[2], "#[StartTime: 1441812279.474 (seconds since epoch), "+
+		res.StartTime.Format(time.UnixDate)+"]", "start time")
+	parts := strings.Split(lines[5], ",")
+	assert.Equal(t, parts[:3], []string{"0.000", "2.000", "100.000"}, "interval line")
+	assert.CheckEquals(t, decodeHdr(t, parts[3]), int64(100), "total count")
+	parts = strings.Split(lines[6], ",")
+	assert.CheckEquals(t, parts[0], "Tag=errors", "errors tag")
+	assert.CheckEquals(t, decodeHdr(t, parts[4]), int64(1), "errors count")
+}
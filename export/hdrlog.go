@@ -0,0 +1,193 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+
+	"fortio.org/fortio/stats"
+)
+
+// HdrLog exports the duration histograms as an HdrHistogram interval log
+// (https://github.com/HdrHistogram/HdrHistogram/blob/master/src/main/java/org/HdrHistogram/HistogramLogWriter.java)
+// with a single interval covering the whole run. Values are recorded in
+// microseconds, so the Interval_Max column is in milliseconds.
+// Error cases are in a second interval tagged "errors".
+// Note that fortio's histogram buckets are coarser than HdrHistogram's,
+// each bucket is recorded at its mid point.
+type HdrLog struct{}
+
+// Name of the format.
+func (HdrLog) Name() string { return "hdrlog" }
+
+// Extension of the format.
+func (HdrLog) Extension() string { return ".hlog" }
+
+// ContentType of the format.
+func (HdrLog) ContentType() string { return "text/plain; charset=UTF-8" }
+
+// Export writes the interval log.
+func (HdrLog) Export(w io.Writer, res *Results) error {
+	start := float64(res.StartTime.UnixNano()) / 1e9
+	_, _ = fmt.Fprintf(w, "#[Fortio %s run %s]\n", res.RunType, res.ID)
+	_, _ = fmt.Fprintln(w, "#[Histogram log format version 1.3]")
+	_, _ = fmt.Fprintf(w, "#[StartTime: %.3f (seconds since epoch), %s]\n", start, res.StartTime.Format(time.UnixDate))
+	_, _ = fmt.Fprintf(w, "#[BaseTime: %.3f (seconds since epoch)]\n", start)
+	_, err := fmt.Fprintln(w, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"")
+	if err != nil {
+		return err
+	}
+	interval := res.ActualDuration.Seconds()
+	for _, th := range []struct {
+		tag string
+		h   *stats.HistogramData
+	}{{"", res.DurationHistogram}, {"errors", res.ErrorsDurationHistogram}} {
+		if th.h == nil || th.h.Count == 0 {
+			continue
+		}
+		hdr := NewHdrHistogram(hdrHighestTrackable, 3)
+		for _, b := range th.h.Data {
+			hdr.RecordN(int64(math.Round(1e6*(b.Start+b.End)/2.)), b.Count)
+		}
+		enc, err := hdr.EncodeCompressed()
+		if err != nil {
+			return err
+		}
+		tag := ""
+		if th.tag != "" {
+			tag = "Tag=" + th.tag + ","
+		}
+		_, err = fmt.Fprintf(w, "%s0.000,%.3f,%.3f,%s\n", tag, interval, 1000.*th.h.Max,
+			base64.StdEncoding.EncodeToString(enc))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hdrHighestTrackable is 1 hour in microseconds.
+const hdrHighestTrackable = int64(3600 * 1e6)
+
+// HdrHistogram is a minimal HdrHistogram, just enough to record values
+// and produce the standard V2 compressed encoding.
+type HdrHistogram struct {
+	highestTrackable  int64
+	significantDigits int
+	subBucketHalfMag  int
+	subBucketHalf     int
+	subBucketMask     int64
+	maxValue          int64
+	counts            []int64
+}
+
+// NewHdrHistogram creates a histogram with lowest discernible value 1 and
+// the given highest trackable value and number of significant digits (1-5).
+func NewHdrHistogram(highestTrackable int64, significantDigits int) *HdrHistogram {
+	largestSingleUnit := 2 * int64(math.Pow10(significantDigits))
+	subBucketCountMag := int(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	h := &HdrHistogram{
+		highestTrackable:  highestTrackable,
+		significantDigits: significantDigits,
+		subBucketHalfMag:  subBucketCountMag - 1,
+		subBucketHalf:     1 << (subBucketCountMag - 1),
+		subBucketMask:     int64(1<<subBucketCountMag) - 1,
+	}
+	h.counts = make([]int64, h.countsIndex(highestTrackable)+1)
+	return h
+}
+
+func (h *HdrHistogram) countsIndex(v int64) int {
+	bucketIdx := 64 - h.subBucketHalfMag - 1 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	subBucketIdx := int(v >> bucketIdx)
+	return ((bucketIdx + 1) << h.subBucketHalfMag) + subBucketIdx - h.subBucketHalf
+}
+
+// RecordN records the value n times. Values are clamped to [0, highestTrackable].
+func (h *HdrHistogram) RecordN(v int64, n int64) {
+	if v < 0 {
+		v = 0
+	}
+	if v > h.highestTrackable {
+		v = h.highestTrackable
+	}
+	if v > h.maxValue {
+		h.maxValue = v
+	}
+	h.counts[h.countsIndex(v)] += n
+}
+
+const (
+	hdrEncodingCookie   = 0x1c849303 | 0x10
+	hdrCompressedCookie = 0x1c849304 | 0x10
+	hdrHeaderSize       = 40
+)
+
+// Encode returns the uncompressed V2 encoding (zig zag LEB128 counts with
+// runs of zeros as negative numbers).
+func (h *HdrHistogram) Encode() []byte {
+	var payload bytes.Buffer
+	tmp := make([]byte, binary.MaxVarintLen64)
+	maxIdx := h.countsIndex(h.maxValue)
+	for i := 0; i <= maxIdx; {
+		c := h.counts[i]
+		i++
+		if c == 0 {
+			zeros := int64(1)
+			for i <= maxIdx && h.counts[i] == 0 {
+				zeros++
+				i++
+			}
+			if zeros > 1 {
+				c = -zeros
+			}
+		}
+		payload.Write(tmp[:binary.PutVarint(tmp, c)]) // PutVarint is zig zag LEB128
+	}
+	res := make([]byte, hdrHeaderSize, hdrHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(res[0:], hdrEncodingCookie)
+	binary.BigEndian.PutUint32(res[4:], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(res[8:], 0) // normalizing index offset
+	binary.BigEndian.PutUint32(res[12:], uint32(h.significantDigits))
+	binary.BigEndian.PutUint64(res[16:], 1) // lowest discernible value
+	binary.BigEndian.PutUint64(res[24:], uint64(h.highestTrackable))
+	binary.BigEndian.PutUint64(res[32:], math.Float64bits(1.0)) // integer to double conversion ratio
+	return append(res, payload.Bytes()...)
+}
+
+// EncodeCompressed returns the compressed V2 encoding, as used in interval logs.
+func (h *HdrHistogram) EncodeCompressed() ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(make([]byte, 8))
+	zw := zlib.NewWriter(&b)
+	if _, err := zw.Write(h.Encode()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	res := b.Bytes()
+	binary.BigEndian.PutUint32(res[0:], hdrCompressedCookie)
+	binary.BigEndian.PutUint32(res[4:], uint32(len(res)-8))
+	return res, nil
+}
@@ -29,9 +29,11 @@ import (
 	"fortio.org/fortio/log"
 	"fortio.org/fortio/periodic"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 // Dial dials grpc using insecure or tls transport security when serverAddr
@@ -56,6 +58,20 @@ func Dial(o *GRPCRunnerOptions) (*grpc.ClientConn, error) {
 			return net.Dial(fnet.UnixDomainSocket, o.UnixDomainSocket)
 		}))
 	}
+	timeout := o.ReqTimeout
+	if timeout <= 0 {
+		timeout = fhttp.HTTPReqTimeOutDefaultValue
+	}
+	proxy, err := o.TLSOptions.ProxyDialer(timeout)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		log.Infof("Using proxy %s for grpc connection to %v", proxy.URL.Host, serverAddr)
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return proxy.DialContext(ctx, "tcp", addr)
+		}))
+	}
 	conn, err := grpc.Dial(serverAddr, opts...)
 	if err != nil {
 		log.Errf("failed to connect to %s with certificate %s and override %s: %v", serverAddr, o.CACert, o.CertOverride, err)
@@ -77,12 +93,57 @@ type GRPCRunnerResults struct {
 	Destination string
 	Streams     int
 	Ping        bool
+	// Breakdown of the Error RetCodes by category (fnet.ErrConnectRefused, fnet.ErrTLS,...)
+	ErrorCategories HealthResultMap `json:",omitempty"`
+	// Retries made per the retry policy, if any
+	Retries      *fhttp.RetryStats `json:",omitempty"`
+	aborter      *periodic.Aborter
+	retrier      *fhttp.Retrier
+	abortOnError string
+	okCodes      *fhttp.CodeSet
 }
 
 // Run exercises GRPC health check or ping at the target QPS.
 // To be set as the Function in RunnerOptions.
 func (grpcstate *GRPCRunnerResults) Run(t int) (bool, string) {
 	log.Debugf("Calling in %d", t)
+	status, err := grpcstate.call(t)
+	attempts := 1
+	for grpcstate.retrier != nil && grpcstate.retryable(status, err, attempts) {
+		attempts++
+		status, err = grpcstate.call(t)
+	}
+	ok, details := grpcstate.record(status, err)
+	if grpcstate.retrier != nil {
+		grpcstate.retrier.Done(grpcstate.Retries, attempts, ok)
+	}
+	return ok, details
+}
+
+// record counts the status or error of the (last attempt of the) call and returns whether it
+// is successful and its details.
+func (grpcstate *GRPCRunnerResults) record(status grpc_health_v1.HealthCheckResponse_ServingStatus, err error) (bool, string) {
+	if err != nil {
+		if code := grpcstatus.Code(err); grpcstate.codeOK(int(code)) {
+			grpcstate.RetCodes[code.String()]++
+			return true, code.String()
+		}
+		log.Warnf("Error making grpc call: %v", err)
+		grpcstate.RetCodes[Error]++
+		category := fnet.ErrorCategory(err)
+		grpcstate.ErrorCategories[category]++
+		if grpcstate.abortOnError == category {
+			grpcstate.aborter.Abort(false)
+			log.Infof("Aborted run because of %s error: %v", category, err)
+		}
+		return false, err.Error()
+	}
+	grpcstate.RetCodes[status.String()]++
+	return grpcstate.statusOK(status), status.String()
+}
+
+// call makes one health check or ping call.
+func (grpcstate *GRPCRunnerResults) call(t int) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
 	var err error
 	var res interface{}
 	status := grpc_health_v1.HealthCheckResponse_SERVING
@@ -97,16 +158,31 @@ func (grpcstate *GRPCRunnerResults) Run(t int) (bool, string) {
 		}
 	}
 	log.Debugf("For %d (ping=%v) got %v %v", t, grpcstate.Ping, err, res)
-	if err != nil {
-		log.Warnf("Error making grpc call: %v", err)
-		grpcstate.RetCodes[Error]++
-		return false, err.Error()
+	return status, err
+}
+
+// retryable returns whether the failed attempt number attempt, with status or err, is to be retried
+// per the retry policy, after waiting for its backoff. Returns false for successful calls.
+func (grpcstate *GRPCRunnerResults) retryable(
+	status grpc_health_v1.HealthCheckResponse_ServingStatus, err error, attempt int,
+) bool {
+	if err == nil {
+		if grpcstate.statusOK(status) {
+			return false
+		}
+		// no grpc error: NOT_SERVING (or an unknown status) is retried like Unavailable
+		return grpcstate.retrier.Retry(grpcstate.Retries, attempt, int(codes.Unavailable), "", status.String())
 	}
-	grpcstate.RetCodes[status.String()]++
-	if status == grpc_health_v1.HealthCheckResponse_SERVING {
-		return true, "SERVING"
+	code := grpcstatus.Code(err)
+	if grpcstate.codeOK(int(code)) {
+		return false
+	}
+	category := fnet.ErrorCategory(err)
+	reason := code.String()
+	if category != fnet.ErrOther {
+		reason += " " + category
 	}
-	return false, status.String()
+	return grpcstate.retrier.Retry(grpcstate.Retries, attempt, int(code), category, reason)
 }
 
 // GRPCRunnerOptions includes the base RunnerOptions plus grpc specific
@@ -123,6 +199,64 @@ type GRPCRunnerOptions struct {
 	CertOverride       string        // Override the cert virtual host of authority for testing
 	AllowInitialErrors bool          // whether initial errors don't cause an abort
 	UsePing            bool          // use our own Ping proto for grpc load instead of standard health check one.
+	// Which error category (fnet.ErrConnectRefused, fnet.ErrTLS,...) cause an abort of the run (default "" = don't abort)
+	AbortOnError string
+	// grpc status codes considered successful, see ParseCodes() (default nil = only OK)
+	OKCodes *fhttp.CodeSet
+	// Optional retries of the failed calls, see NewRetryPolicy()
+	Retry *fhttp.RetryPolicy `json:",omitempty"`
+	// Timeout for connecting through the Proxy (default fhttp.HTTPReqTimeOutDefaultValue)
+	ReqTimeout time.Duration
+}
+
+// NotServing is the code for the health checks answered with a NOT_SERVING status (which
+// aren't grpc errors) in the ok codes, e.g. "OK,NOT_SERVING". It isn't a grpc status code.
+const NotServing = -2
+
+// codeOK returns whether the grpc status code (or NotServing) counts as successful:
+// OK always does, the ok codes add to it.
+func (grpcstate *GRPCRunnerResults) codeOK(code int) bool {
+	return code == int(codes.OK) || (grpcstate.okCodes != nil && grpcstate.okCodes.Contains(code))
+}
+
+// statusOK returns whether the health check status of a call without error counts as successful.
+func (grpcstate *GRPCRunnerResults) statusOK(status grpc_health_v1.HealthCheckResponse_ServingStatus) bool {
+	if status == grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		return grpcstate.codeOK(NotServing)
+	}
+	return status == grpc_health_v1.HealthCheckResponse_SERVING
+}
+
+// ParseCodes parses a set of grpc status codes, e.g. "OK,NotFound" or "0,5" or "0-5".
+// Names are case insensitive and can also be in the NOT_FOUND form. The health check
+// NOT_SERVING status can also be listed, see NotServing.
+func ParseCodes(spec string) (*fhttp.CodeSet, error) {
+	return fhttp.ParseCodeSet(spec, okCodeByName)
+}
+
+func okCodeByName(name string) (int, bool) {
+	if strings.ToLower(strings.ReplaceAll(name, "_", "")) == "notserving" {
+		return NotServing, true
+	}
+	return codeByName(name)
+}
+
+// NewRetryPolicy returns the policy retrying up to maxAttempts times the calls failing with
+// the grpc status codes (names or numbers) or error categories listed in retryOn, e.g.
+// "Unavailable,connect_refused" (or all the failures when empty). The health checks answered
+// NOT_SERVING are retried as Unavailable. See fhttp.NewRetryPolicy.
+func NewRetryPolicy(maxAttempts int, retryOn string) (*fhttp.RetryPolicy, error) {
+	return fhttp.NewRetryPolicy(maxAttempts, retryOn, codeByName)
+}
+
+func codeByName(name string) (int, bool) {
+	name = strings.ToLower(strings.ReplaceAll(name, "_", ""))
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.ToLower(c.String()) == name {
+			return int(c), true
+		}
+	}
+	return 0, false
 }
 
 // RunGRPCTest runs an http test and returns the aggregated stats.
@@ -153,11 +287,15 @@ func RunGRPCTest(o *GRPCRunnerOptions) (*GRPCRunnerResults, error) {
 	r := periodic.NewPeriodicRunner(&o.RunnerOptions)
 	defer r.Options().Abort()
 	numThreads := r.Options().NumThreads // may change
+	retrier := o.Retry.NewRetrier(r.Options().Stop.StopChan)
 	total := GRPCRunnerResults{
 		RetCodes:    make(HealthResultMap),
 		Destination: o.Destination,
 		Streams:     o.Streams,
 		Ping:        o.UsePing,
+		// Errors breakdown
+		ErrorCategories: make(HealthResultMap),
+		Retries:         fhttp.NewRetryStats(o.Retry),
 	}
 	grpcstate := make([]GRPCRunnerResults, numThreads)
 	out := r.Options().Out // Important as the default value is set from nil to stdout inside NewPeriodicRunner
@@ -196,12 +334,21 @@ func RunGRPCTest(o *GRPCRunnerOptions) (*GRPCRunnerResults, error) {
 				_, err = grpcstate[i].clientH.Check(context.Background(), &grpcstate[i].reqH)
 			}
 		}
+		if err != nil && o.OKCodes != nil && o.OKCodes.Contains(int(grpcstatus.Code(err))) {
+			err = nil
+		}
 		if !o.AllowInitialErrors && err != nil {
 			log.Errf("Error in first grpc call (ping = %v) for %s: %v", o.UsePing, o.Destination, err)
 			return nil, err
 		}
 		// Setup the stats for each 'thread'
 		grpcstate[i].RetCodes = make(HealthResultMap)
+		grpcstate[i].ErrorCategories = make(HealthResultMap)
+		grpcstate[i].aborter = r.Options().Stop
+		grpcstate[i].abortOnError = o.AbortOnError
+		grpcstate[i].okCodes = o.OKCodes
+		grpcstate[i].retrier = retrier
+		grpcstate[i].Retries = fhttp.NewRetryStats(o.Retry)
 	}
 
 	if o.Profiler != "" {
@@ -240,6 +387,12 @@ func RunGRPCTest(o *GRPCRunnerOptions) (*GRPCRunnerResults, error) {
 			}
 			total.RetCodes[k] += grpcstate[i].RetCodes[k]
 		}
+		for k, v := range grpcstate[i].ErrorCategories {
+			total.ErrorCategories[k] += v
+		}
+		if total.Retries != nil {
+			total.Retries.Transfer(grpcstate[i].Retries)
+		}
 		// TODO: if grpc client needs 'cleanup'/Close like http one, do it on original NumThreads
 	}
 	// Cleanup state:
@@ -252,6 +405,10 @@ func RunGRPCTest(o *GRPCRunnerOptions) (*GRPCRunnerResults, error) {
 	for _, k := range keys {
 		_, _ = fmt.Fprintf(out, "%s %s : %d\n", which, k, total.RetCodes[k])
 	}
+	fnet.PrintErrorCategories(out, total.ErrorCategories, float64(total.DurationHistogram.Count))
+	if total.Retries != nil {
+		total.Retries.Print(out)
+	}
 	return &total, nil
 }
 
@@ -299,6 +299,55 @@ func TestGRPCRunnerWithError(t *testing.T) {
 	}
 }
 
+func TestGRPCRunnerOKCodes(t *testing.T) {
+	iPort := PingServerTCP("0", "", "", "bar", 0)
+	okCodes, err := ParseCodes("OK, NOT_FOUND")
+	if err != nil {
+		t.Fatalf("unexpected parse error %v", err)
+	}
+	o := GRPCRunnerOptions{
+		Destination: fmt.Sprintf("localhost:%d", iPort),
+		Service:     "svc2", // unknown service: NotFound
+		OKCodes:     okCodes,
+	}
+	o.Exactly = 10
+	o.QPS = -1
+	res, err := RunGRPCTest(&o)
+	if err != nil {
+		t.Fatalf("unexpected error with NotFound as ok code: %v", err)
+	}
+	if res.RetCodes["NotFound"] != 10 || res.ErrorsDurationHistogram.Count != 0 {
+		t.Errorf("expected all NotFound and successful calls, got %v %d", res.RetCodes, res.ErrorsDurationHistogram.Count)
+	}
+	// OK (SERVING) is always successful, NOT_SERVING only when listed:
+	for _, tst := range []struct {
+		service string
+		okCodes string
+		errors  int64
+	}{
+		{"bar", "NotFound", 0},
+		{"bar_down", "NotFound", 10},
+		{"bar_down", "NotFound,NOT_SERVING", 0},
+	} {
+		o.Service = tst.service
+		o.OKCodes, _ = ParseCodes(tst.okCodes)
+		o.AllowInitialErrors = true
+		res, err = RunGRPCTest(&o)
+		if err != nil || res.ErrorsDurationHistogram.Count != tst.errors {
+			t.Errorf("%s with %q: expected %d errors, got %v %v", tst.service, tst.okCodes, tst.errors, err, res.RetCodes)
+		}
+	}
+	for _, spec := range []string{"0,5", "ok,NotFound", "0-5"} {
+		cs, err := ParseCodes(spec)
+		if err != nil || !cs.Contains(5) || !cs.Contains(0) {
+			t.Errorf("unexpected %v %v for %q", cs, err, spec)
+		}
+	}
+	if _, err = ParseCodes("NotACode"); err == nil {
+		t.Errorf("expected error for invalid grpc code name")
+	}
+}
+
 func TestGRPCDestination(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -388,3 +437,46 @@ func TestGRPCDestination(t *testing.T) {
 		}
 	}
 }
+
+func TestGRPCRunnerRetries(t *testing.T) {
+	iPort := PingServerTCP("0", "", "", "bar", 0)
+	o := GRPCRunnerOptions{
+		Destination: fmt.Sprintf("localhost:%d", iPort),
+		Service:     "svc2", // unknown service: NotFound
+	}
+	o.Exactly = 5
+	o.QPS = -1
+	o.NumThreads = 1
+	var err error
+	if o.Retry, err = NewRetryPolicy(3, "NOT_FOUND"); err != nil {
+		t.Fatalf("unexpected parse error %v", err)
+	}
+	res, err := RunGRPCTest(&o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.RetCodes[Error] != 5 || res.Retries.Retries != 10 || res.Retries.Attempts[3] != 5 ||
+		res.Retries.Reasons["NotFound"] != 10 || res.Retries.SuccessAfterRetry != 0 {
+		t.Errorf("expected 2 retries of each NotFound call, got %v %+v", res.RetCodes, res.Retries)
+	}
+	o.Retry, _ = NewRetryPolicy(3, "Unavailable")
+	res, err = RunGRPCTest(&o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Retries.Retries != 0 || res.Retries.Attempts[1] != 5 {
+		t.Errorf("expected no retry of NotFound, got %+v", res.Retries)
+	}
+	// NOT_SERVING is retried as Unavailable:
+	o.Service = "bar_down"
+	res, err = RunGRPCTest(&o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.RetCodes["NOT_SERVING"] != 5 || res.Retries.Retries != 10 || res.Retries.Reasons["NOT_SERVING"] != 10 {
+		t.Errorf("expected 2 retries of each NOT_SERVING call, got %v %+v", res.RetCodes, res.Retries)
+	}
+	if _, err = NewRetryPolicy(2, "NotACode"); err == nil {
+		t.Errorf("expected error for invalid grpc code name")
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Categories of failed response checks, reported in HTTPRunnerResults.CheckFailures.
+const (
+	CheckStatus   = "status"
+	CheckContains = "contains"
+	CheckRegex    = "regex"
+	CheckJSON     = "json"
+	CheckHeader   = "header"
+	CheckSize     = "size"
+)
+
+// CheckCategories lists all the check failure categories, in the order they are evaluated and printed.
+var CheckCategories = []string{CheckStatus, CheckContains, CheckRegex, CheckJSON, CheckHeader, CheckSize}
+
+// ResponseChecks are the assertions made on each response of an http run. A response
+// failing any of them counts as an error, categorized by the first failing check.
+// Need to call Init() to parse and validate them before use.
+type ResponseChecks struct {
+	// Expected status codes, as a CodeSet, e.g. "200,201" (default is the runner's OKCodes or 200 only).
+	Status string `json:",omitempty"`
+	// Substring the body must contain.
+	Contains string `json:",omitempty"`
+	// Regular expression the body must match.
+	Regex string `json:",omitempty"`
+	// JSON body value check, in the form `path=value` where path is dot separated
+	// object keys and array indexes (e.g. `items.0.name=foo`) and value is either the
+	// json encoding of the expected value or, for strings, the string itself.
+	JSON string `json:",omitempty"`
+	// Header that must be present, as `Name` or `Name: value` for a specific value.
+	Headers []string `json:",omitempty"`
+	// Allowed body size range, in bytes, as `min:max` (either can be omitted).
+	Size string `json:",omitempty"`
+	// parsed values
+	status    *CodeSet
+	regex     *regexp.Regexp
+	jsonPath  []string
+	jsonValue string
+	headers   [][2]string // lowercase name, value
+	minSize   int
+	maxSize   int
+}
+
+// Init parses the checks, returns an error if any of them is invalid.
+//
+//nolint:funlen
+func (rc *ResponseChecks) Init() error {
+	rc.status = nil
+	if rc.Status != "" {
+		var err error
+		if rc.status, err = ParseCodeSet(rc.Status, nil); err != nil {
+			return fmt.Errorf("invalid expected status: %w", err)
+		}
+	}
+	rc.regex = nil
+	if rc.Regex != "" {
+		var err error
+		if rc.regex, err = regexp.Compile(rc.Regex); err != nil {
+			return fmt.Errorf("invalid body regex %q: %w", rc.Regex, err)
+		}
+	}
+	rc.jsonPath = nil
+	if rc.JSON != "" {
+		path, value, found := strings.Cut(rc.JSON, "=")
+		if !found || path == "" {
+			return fmt.Errorf("invalid json check %q, should be path=value", rc.JSON)
+		}
+		rc.jsonPath = strings.Split(path, ".")
+		rc.jsonValue = value
+	}
+	rc.headers = nil
+	for _, h := range rc.Headers {
+		name, value, _ := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return fmt.Errorf("invalid header check %q, should be Name or Name: value", h)
+		}
+		rc.headers = append(rc.headers, [2]string{strings.ToLower(name), strings.TrimSpace(value)})
+	}
+	rc.minSize, rc.maxSize = 0, -1
+	if rc.Size != "" {
+		minS, maxS, found := strings.Cut(rc.Size, ":")
+		if !found {
+			return fmt.Errorf("invalid size range %q, should be min:max", rc.Size)
+		}
+		var err error
+		if minS != "" {
+			if rc.minSize, err = strconv.Atoi(minS); err != nil {
+				return fmt.Errorf("invalid min size in %q: %w", rc.Size, err)
+			}
+		}
+		if maxS != "" {
+			if rc.maxSize, err = strconv.Atoi(maxS); err != nil {
+				return fmt.Errorf("invalid max size in %q: %w", rc.Size, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Empty returns true when no check is set.
+func (rc *ResponseChecks) Empty() bool {
+	return rc.Status == "" && rc.Contains == "" && rc.Regex == "" && rc.JSON == "" && len(rc.Headers) == 0 && rc.Size == ""
+}
+
+// HasHeaderChecks returns true if some of the checks need the response headers.
+func (rc *ResponseChecks) HasHeaderChecks() bool {
+	return len(rc.Headers) > 0
+}
+
+// Check returns the category of the first failing check for the given
+// response (as returned by Fetcher.Fetch()) or "" if all checks pass.
+func (rc *ResponseChecks) Check(code int, data []byte, headerLen int) string {
+	return rc.check(code, data, headerLen, -1)
+}
+
+// check is Check with the actual body size, for streamed responses of which data only has the
+// beginning of the body, or -1 for the size of the (dechunked) body in data.
+func (rc *ResponseChecks) check(code int, data []byte, headerLen int, bodySize int64) string {
+	if (rc.status == nil && code != http.StatusOK) || (rc.status != nil && !rc.status.Contains(code)) {
+		return CheckStatus
+	}
+	headers := data[:headerLen]
+	body := data[headerLen:]
+	if headerLen > 0 && bytes.Contains(bytes.ToLower(headers), []byte("\ntransfer-encoding: chunked")) {
+		body = dechunk(body)
+	}
+	if rc.Contains != "" && !bytes.Contains(body, []byte(rc.Contains)) {
+		return CheckContains
+	}
+	if rc.regex != nil && !rc.regex.Match(body) {
+		return CheckRegex
+	}
+	if rc.jsonPath != nil && !rc.jsonOK(body) {
+		return CheckJSON
+	}
+	if len(rc.headers) > 0 && !rc.headersOK(headers) {
+		return CheckHeader
+	}
+	if bodySize < 0 {
+		bodySize = int64(len(body))
+	}
+	if bodySize < int64(rc.minSize) || (rc.maxSize >= 0 && bodySize > int64(rc.maxSize)) {
+		return CheckSize
+	}
+	return ""
+}
+
+// jsonOK walks the json body along the path and compares the value found.
+func (rc *ResponseChecks) jsonOK(body []byte) bool {
+	v, found := jsonLookup(body, rc.jsonPath)
+	if !found {
+		return false
+	}
+	if s, isString := v.(string); isString && s == rc.jsonValue {
+		return true
+	}
+	return jsonString(v) == rc.jsonValue
+}
+
+// jsonLookup returns the value found in the json body along the path of object keys and array indexes.
+func jsonLookup(body []byte, path []string) (interface{}, bool) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, false
+	}
+	for _, p := range path {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var found bool
+			if v, found = t[p]; !found {
+				return nil, false
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(p)
+			if err != nil || idx < 0 || idx >= len(t) {
+				return nil, false
+			}
+			v = t[idx]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// jsonString returns the json encoding of v.
+func jsonString(v interface{}) string {
+	j, _ := json.Marshal(v)
+	return string(j)
+}
+
+// headersOK checks the headers presence/values on the raw http/1.x format headers.
+func (rc *ResponseChecks) headersOK(headers []byte) bool {
+	parsed := parseRawHeaders(headers)
+	for _, h := range rc.headers {
+		found := false
+		for _, value := range parsed.Values(h[0]) {
+			if h[1] == "" || value == h[1] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRawHeaders returns the headers of the raw http/1.x format status line and headers
+// returned by the clients.
+func parseRawHeaders(headers []byte) http.Header {
+	res := http.Header{}
+	lines := strings.Split(string(headers), "\r\n")
+	for _, l := range lines[1:] { // skip the status line
+		if name, value, ok := strings.Cut(l, ":"); ok {
+			res.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	return res
+}
+
+// dechunk returns the data of a chunked encoded body (as much as can be parsed).
+func dechunk(body []byte) []byte {
+	res := []byte{}
+	for len(body) > 0 {
+		off, size := ParseChunkSize(body)
+		if size <= 0 {
+			break
+		}
+		if off+size > len(body) { // truncated (e.g. streamed) body
+			res = append(res, body[off:]...)
+			break
+		}
+		res = append(res, body[off:off+size]...)
+		body = body[off+size:]
+		if len(body) >= 2 {
+			body = body[2:] // CR LF after the chunk data
+		}
+	}
+	return res
+}
+
+// PrintCheckFailures prints the non zero check failures counts, in
+// CheckCategories order, with their percentage of totalCount calls.
+func PrintCheckFailures(out io.Writer, failures map[string]int64, totalCount float64) {
+	for _, c := range CheckCategories {
+		if v := failures[c]; v > 0 {
+			_, _ = fmt.Fprintf(out, "Check failed %s : %d (%.1f %%)\n", c, v, 100.*float64(v)/totalCount)
+		}
+	}
+}
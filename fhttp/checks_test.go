@@ -0,0 +1,169 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResponseChecks(t *testing.T) {
+	headers := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nX-Foo: bar\r\n\r\n"
+	body := `{"items":[{"name":"foo","n":3}],"ok":true}`
+	data := []byte(headers + body)
+	chunked := []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n{\"a\":\r\n3\r\n42}\r\n0\r\n\r\n")
+	tests := []struct {
+		checks ResponseChecks
+		code   int
+		data   []byte
+		hlen   int
+		result string
+	}{
+		{ResponseChecks{}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{}, http.StatusCreated, data, len(headers), CheckStatus},
+		{ResponseChecks{Status: "201, 204"}, http.StatusCreated, data, len(headers), ""},
+		{ResponseChecks{Contains: `"ok":true`}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{Contains: "X-Foo"}, http.StatusOK, data, len(headers), CheckContains},
+		{ResponseChecks{Regex: `"n":[0-9]+`}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{Regex: `^HTTP`}, http.StatusOK, data, len(headers), CheckRegex},
+		{ResponseChecks{JSON: "items.0.name=foo"}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{JSON: "items.0.n=3"}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{JSON: "ok=true"}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{JSON: "items.1.name=foo"}, http.StatusOK, data, len(headers), CheckJSON},
+		{ResponseChecks{JSON: "items.0.n=4"}, http.StatusOK, data, len(headers), CheckJSON},
+		{ResponseChecks{JSON: "a=42"}, http.StatusOK, chunked, len(chunked) - len("5\r\n{\"a\":\r\n3\r\n42}\r\n0\r\n\r\n"), ""},
+		{ResponseChecks{Headers: []string{"x-foo", "Content-Type: application/json"}}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{Headers: []string{"X-Foo: baz"}}, http.StatusOK, data, len(headers), CheckHeader},
+		{ResponseChecks{Headers: []string{"X-Bar"}}, http.StatusOK, data, len(headers), CheckHeader},
+		{ResponseChecks{Size: fmt.Sprintf("%d:%d", len(body), len(body))}, http.StatusOK, data, len(headers), ""},
+		{ResponseChecks{Size: "100:"}, http.StatusOK, data, len(headers), CheckSize},
+		{ResponseChecks{Size: ":10"}, http.StatusOK, data, len(headers), CheckSize},
+		// first failing check is the one reported:
+		{ResponseChecks{Contains: "nope", Size: ":10"}, http.StatusOK, data, len(headers), CheckContains},
+	}
+	for i, tst := range tests {
+		if err := tst.checks.Init(); err != nil {
+			t.Fatalf("%d: unexpected init error %v for %+v", i, err, tst.checks)
+		}
+		if res := tst.checks.Check(tst.code, tst.data, tst.hlen); res != tst.result {
+			t.Errorf("%d: got %q, expected %q for %+v", i, res, tst.result, tst.checks)
+		}
+	}
+	for _, bad := range []ResponseChecks{
+		{Status: "20x"}, {Regex: "("}, {JSON: "novalue"}, {Headers: []string{": x"}}, {Size: "12"}, {Size: "a:"},
+	} {
+		bad := bad
+		if err := bad.Init(); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+}
+
+func TestHTTPRunnerChecks(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/checks/", EchoHandler)
+	opts := HTTPRunnerOptions{}
+	opts.Init(fmt.Sprintf("http://localhost:%d/checks/?status=200:50,201:50&size=10", addr.Port))
+	opts.QPS = -1
+	opts.Exactly = 40
+	opts.NumThreads = 2
+	opts.Checks = &ResponseChecks{Status: "200,201", Size: ":5"}
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CheckFailures[CheckSize] != 40 || res.ErrorsDurationHistogram.Count != 40 {
+		t.Errorf("expected all calls to fail the size check, got %v %d", res.CheckFailures, res.ErrorsDurationHistogram.Count)
+	}
+	opts.Checks = &ResponseChecks{Status: "200,201", Size: "10:10", Headers: []string{"Content-Length: 10"}}
+	res, err = RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.CheckFailures) != 0 || res.ErrorsDurationHistogram.Count != 0 || res.RetCodes[http.StatusCreated] == 0 {
+		t.Errorf("expected all calls to pass the checks, got %v %v", res.CheckFailures, res.RetCodes)
+	}
+	opts.DisableFastClient = true
+	if _, err = RunHTTPTest(&opts); err == nil {
+		t.Errorf("expected error for header checks with the std client")
+	}
+}
+
+func TestCodeSet(t *testing.T) {
+	cs, err := ParseCodeSet("200-299, 304,404,-1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for code, expected := range map[int]bool{200: true, 250: true, 299: true, 300: false, 304: true, 404: true, 418: false, -1: true, 0: false} {
+		if cs.Contains(code) != expected {
+			t.Errorf("Contains(%d) expected %v", code, expected)
+		}
+	}
+	j, _ := json.Marshal(HTTPOptions{OKCodes: cs})
+	var o HTTPOptions
+	if err = json.Unmarshal(j, &o); err != nil || o.OKCodes.String() != "200-299, 304,404,-1" || !o.OKCodes.Contains(304) {
+		t.Errorf("json round trip failed %v %s", err, j)
+	}
+	for _, bad := range []string{"", "abc", "300-200", "200-", "1,x"} {
+		if _, err = ParseCodeSet(bad, nil); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestHTTPRunnerOKCodes(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/okcodes/", EchoHandler)
+	opts := HTTPRunnerOptions{}
+	opts.Init(fmt.Sprintf("http://localhost:%d/okcodes/?status=404", addr.Port))
+	opts.QPS = -1
+	opts.Exactly = 20
+	opts.NumThreads = 2
+	opts.OKCodes, _ = ParseCodeSet("200,404", nil)
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RetCodes[http.StatusNotFound] != 20 || res.ErrorsDurationHistogram.Count != 0 {
+		t.Errorf("expected 404s to be successes %v %d", res.RetCodes, res.ErrorsDurationHistogram.Count)
+	}
+	// the ok 404s are fully read and their connections kept alive:
+	if res.SocketCount != 2 || res.HeaderSizes.Min == 0 {
+		t.Errorf("expected 404s on 2 connections, got %d sockets, %v header min size", res.SocketCount, res.HeaderSizes.Min)
+	}
+	// and kept alive for the 201s which aren't ok:
+	opts.URL = fmt.Sprintf("http://localhost:%d/okcodes/?status=201", addr.Port)
+	opts.OKCodes, _ = ParseCodeSet("200", nil)
+	opts.AllowInitialErrors = true
+	res, err = RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RetCodes[http.StatusCreated] != 20 || res.ErrorsDurationHistogram.Count != 20 || res.SocketCount != 2 {
+		t.Errorf("expected 201 errors on 2 connections %v %d %d", res.RetCodes, res.ErrorsDurationHistogram.Count, res.SocketCount)
+	}
+	opts.AllowInitialErrors = false
+	opts.URL = fmt.Sprintf("http://localhost:%d/okcodes/?status=404", addr.Port)
+	// and warmup fails when 404 isn't ok:
+	opts.OKCodes, _ = ParseCodeSet("200-299", nil)
+	opts.Exactly = 0
+	opts.Duration = 100 * time.Millisecond
+	if _, err = RunHTTPTest(&opts); err == nil {
+		t.Errorf("expected warmup error for 404 not in ok codes")
+	}
+}
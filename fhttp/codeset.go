@@ -0,0 +1,107 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CodeSet is a set of status codes, e.g. the http codes to consider successful.
+// Its text form is a comma separated list of codes and inclusive ranges, e.g.
+// "200-299,304,404". It is (un)marshaled as that text form in JSON.
+type CodeSet struct {
+	ranges [][2]int
+	spec   string
+}
+
+// ParseCodeSet parses the comma separated list of codes and ranges. The optional
+// names function allows symbolic codes (e.g. grpc's NotFound), numbers are always
+// accepted.
+func ParseCodeSet(spec string, names func(string) (int, bool)) (*CodeSet, error) {
+	cs := CodeSet{spec: spec}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		// not using Cut on "-" so negative codes (SocketError) can be listed
+		fromS, toS := s, s
+		if idx := strings.Index(s[1:], "-"); idx >= 0 {
+			fromS, toS = s[:idx+1], s[idx+2:]
+		}
+		from, err := parseCode(fromS, names)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseCode(toS, names)
+		if err != nil {
+			return nil, err
+		}
+		if to < from {
+			return nil, fmt.Errorf("invalid code range %q, end before start", s)
+		}
+		cs.ranges = append(cs.ranges, [2]int{from, to})
+	}
+	if len(cs.ranges) == 0 {
+		return nil, fmt.Errorf("empty code set %q", spec)
+	}
+	return &cs, nil
+}
+
+func parseCode(s string, names func(string) (int, bool)) (int, error) {
+	s = strings.TrimSpace(s)
+	code, err := strconv.Atoi(s)
+	if err == nil {
+		return code, nil
+	}
+	if names != nil {
+		if code, found := names(s); found {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid code %q", s)
+}
+
+// Contains returns true if code is in the set.
+func (cs *CodeSet) Contains(code int) bool {
+	for _, r := range cs.ranges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the text form the set was parsed from.
+func (cs *CodeSet) String() string {
+	return cs.spec
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (cs *CodeSet) MarshalText() ([]byte, error) {
+	return []byte(cs.spec), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (for numerical codes only).
+func (cs *CodeSet) UnmarshalText(text []byte) error {
+	p, err := ParseCodeSet(string(text), nil)
+	if err != nil {
+		return err
+	}
+	*cs = *p
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// cookieJar is a client's cookie jar (with the domain, path and expiry handling
+// of net/http/cookiejar) which also counts the cookies received.
+type cookieJar struct {
+	jar      *cookiejar.Jar
+	url      *url.URL // the options' url, for the FastClient and the stats
+	received int64
+}
+
+// newCookieJar returns a new jar, seeded with the options' Cookies, if enabled (nil otherwise).
+func newCookieJar(o *HTTPOptions) (*cookieJar, error) {
+	if !o.CookieJar && len(o.Cookies) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, err
+	}
+	jar, _ := cookiejar.New(nil) // never returns an error
+	for _, c := range o.Cookies {
+		cookies := parseSetCookies([]string{c})
+		if len(cookies) == 0 {
+			return nil, fmt.Errorf("invalid cookie %q, should be name=value[; attributes]", c)
+		}
+		jar.SetCookies(u, cookies)
+	}
+	return &cookieJar{jar: jar, url: u}, nil
+}
+
+func parseSetCookies(values []string) []*http.Cookie {
+	return (&http.Response{Header: http.Header{"Set-Cookie": values}}).Cookies()
+}
+
+// SetCookies implements http.CookieJar.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.received += int64(len(cookies))
+	j.jar.SetCookies(u, cookies)
+}
+
+// Cookies implements http.CookieJar.
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// withCookies returns a copy of req with the jar's cookies added.
+func (j *cookieJar) withCookies(req *http.Request) *http.Request {
+	r := *req
+	r.Header = req.Header.Clone()
+	for _, c := range j.jar.Cookies(req.URL) {
+		r.AddCookie(c)
+	}
+	return &r
+}
+
+// addCookieHeader appends to buf the raw http/1.x request req with a Cookie: header
+// for the jar's cookies inserted, returns nil if there isn't any.
+func (j *cookieJar) addCookieHeader(buf, req []byte) []byte {
+	cookies := j.jar.Cookies(j.url)
+	if len(cookies) == 0 {
+		return nil
+	}
+	end := bytes.Index(req, []byte("\r\n\r\n")) + 2
+	buf = append(buf, req[:end]...)
+	buf = append(buf, "Cookie: "...)
+	for i, c := range cookies {
+		if i > 0 {
+			buf = append(buf, "; "...)
+		}
+		buf = append(buf, c.String()...)
+	}
+	buf = append(buf, "\r\n"...)
+	return append(buf, req[end:]...)
+}
+
+// updateFromHeaders stores the cookies of the Set-Cookie: lines of raw http/1.x response headers.
+func (j *cookieJar) updateFromHeaders(headers []byte) {
+	if !bytes.Contains(bytes.ToLower(headers), []byte("\nset-cookie:")) {
+		return
+	}
+	var values []string
+	for _, l := range strings.Split(string(headers), "\r\n") {
+		name, value, found := strings.Cut(l, ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), "Set-Cookie") {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	j.SetCookies(j.url, parseSetCookies(values))
+}
+
+// CookieStats returns the number of cookies received and the number of cookies
+// currently in the jar for the options' url, 0s if the cookie jar isn't enabled.
+func (j *cookieJar) CookieStats() (int64, int) {
+	if j == nil {
+		return 0, 0
+	}
+	return j.received, len(j.jar.Cookies(j.url))
+}
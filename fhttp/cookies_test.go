@@ -0,0 +1,138 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// cookieTestHandler returns the request's Cookie header as body and sets
+// a n=<previous n + 1> cookie and an other one for a path never requested.
+func cookieTestHandler(w http.ResponseWriter, r *http.Request) {
+	n := 0
+	if c, err := r.Cookie("n"); err == nil {
+		n, _ = strconv.Atoi(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: "n", Value: strconv.Itoa(n + 1), Path: "/c/"})
+	http.SetCookie(w, &http.Cookie{Name: "other", Value: "x", Path: "/elsewhere"})
+	_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+}
+
+func TestCookieJar(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/c/", cookieTestHandler)
+	url := fmt.Sprintf("http://localhost:%d/c/x", addr.Port)
+	tests := []struct {
+		name string
+		opts HTTPOptions
+	}{
+		{"std", HTTPOptions{DisableFastClient: true}},
+		{"fast", HTTPOptions{}},
+		{"h2", HTTPOptions{H2: true}},
+	}
+	for _, tst := range tests {
+		o := tst.opts
+		o.URL = url
+		o.CookieJar = true
+		o.Cookies = []string{"seed=s1; Path=/c"}
+		cli, err := NewClient(&o)
+		if err != nil {
+			t.Fatalf("%s: client error %v", tst.name, err)
+		}
+		expected := []string{"seed=s1", "n=1; seed=s1", "n=2; seed=s1"}
+		for i, e := range expected {
+			code, data, header := cli.Fetch()
+			if code != http.StatusOK || string(data[header:]) != e {
+				t.Errorf("%s: call %d got %d %q, expected cookies %q", tst.name, i, code, data[header:], e)
+			}
+		}
+		received, stored := cli.(interface{ CookieStats() (int64, int) }).CookieStats()
+		if received != 6 || stored != 2 {
+			t.Errorf("%s: got %d cookies received, %d stored, expected 6 and 2", tst.name, received, stored)
+		}
+		cli.Close()
+	}
+	o := HTTPOptions{URL: url, Cookies: []string{"invalid"}}
+	if _, err := NewClient(&o); err == nil {
+		t.Errorf("Expected error for invalid seed cookie")
+	}
+	// Without the jar, no cookie is sent back:
+	o = HTTPOptions{URL: url}
+	cli, _ := NewClient(&o)
+	cli.Fetch()
+	if code, data, header := cli.Fetch(); code != http.StatusOK || len(data) != header {
+		t.Errorf("Unexpected cookies without jar %d %q", code, data)
+	}
+	cli.Close()
+}
+
+func TestHTTPRunnerCookies(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/c/", cookieTestHandler)
+	opts := HTTPRunnerOptions{}
+	opts.Init(fmt.Sprintf("http://localhost:%d/c/x", addr.Port))
+	opts.CookieJar = true
+	opts.QPS = 100
+	opts.Exactly = 10
+	opts.NumThreads = 2
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	// 2 cookies received per call, only n applies to the url.
+	if res.RetCodes[http.StatusOK] != 10 || res.CookiesReceived != 20 || res.CookiesStored != 2 {
+		t.Errorf("Unexpected cookie counts %d %d (codes %v)", res.CookiesReceived, res.CookiesStored, res.RetCodes)
+	}
+}
+
+// Cookies set along a non ok response (e.g. a login redirect) are kept too.
+func TestCookieJarRedirect(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/login/", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/login/"})
+			w.Header().Set("Location", "/login/done")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+	})
+	tests := []struct {
+		name string
+		opts HTTPOptions
+	}{
+		{"fast", HTTPOptions{}},
+		{"h2", HTTPOptions{H2: true}},
+	}
+	for _, tst := range tests {
+		o := tst.opts
+		o.URL = fmt.Sprintf("http://localhost:%d/login/x", addr.Port)
+		o.CookieJar = true
+		cli, err := NewClient(&o)
+		if err != nil {
+			t.Fatalf("%s: client error %v", tst.name, err)
+		}
+		if code, _, _ := cli.Fetch(); code != http.StatusFound {
+			t.Errorf("%s: first call got %d, expected a 302", tst.name, code)
+		}
+		if code, data, header := cli.Fetch(); code != http.StatusOK || string(data[header:]) != "session=s1" {
+			t.Errorf("%s: second call got %d %q, expected the session cookie", tst.name, code, data[header:])
+		}
+		cli.Close()
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/jrpc"
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/stats"
+)
+
+// MaxEvents is the maximum number of events the echo server's events= parameter generates.
+const MaxEvents = 1000000
+
+// eventStreamHeader identifies server-sent events responses, whose events end with an empty line,
+// the other streamed responses (e.g. NDJSON) have one event per line.
+var eventStreamHeader = []byte("\r\ncontent-type: text/event-stream")
+
+// eventStats are a client's streamed responses (StreamEvents) events counts and timings. It is
+// the io.Writer the dechunked bodies are copied to, splitting them into events.
+type eventStats struct {
+	sent      time.Time // when the request was sent, for the time to first event
+	sse       bool      // current stream is server-sent events
+	lineStart bool
+	comment   bool // sse comment line (e.g. ": keepalive"), not part of the events
+	pending   bool // some data of the next event was received
+	last      time.Time
+	count     int64 // of the current stream
+	events    int64
+	streams   int64
+	drops     int64
+	first     *stats.Histogram // time to first event
+	gaps      *stats.Histogram // between consecutive events of a stream
+	rates     *stats.Histogram // events per second of each stream
+}
+
+func newEventStats(offset, resolution float64) *eventStats {
+	return &eventStats{
+		first: stats.NewHistogram(offset, resolution),
+		gaps:  stats.NewHistogram(offset, resolution),
+		rates: stats.NewHistogram(0, 0.1),
+	}
+}
+
+// begin starts a new stream, server-sent events when sse is true.
+func (e *eventStats) begin(sse bool) {
+	e.sse = sse
+	e.lineStart, e.comment, e.pending = true, false, false
+	e.count = 0
+}
+
+func (e *eventStats) Write(p []byte) (int, error) {
+	now := time.Now()
+	for _, b := range p {
+		switch {
+		case b == '\r':
+		case b == '\n':
+			if e.pending && (!e.sse || e.lineStart) {
+				e.event(now)
+			}
+			e.lineStart, e.comment = true, false
+		case e.comment:
+		case e.sse && e.lineStart && b == ':':
+			e.comment, e.lineStart = true, false
+		default:
+			e.lineStart, e.pending = false, true
+		}
+	}
+	return len(p), nil
+}
+
+func (e *eventStats) event(now time.Time) {
+	if e.count == 0 {
+		e.first.Record(now.Sub(e.sent).Seconds())
+	} else {
+		e.gaps.Record(now.Sub(e.last).Seconds())
+	}
+	e.last = now
+	e.count++
+	e.events++
+	e.pending = false
+}
+
+// end finishes the current stream, dropped when err isn't nil.
+func (e *eventStats) end(err error) {
+	if err != nil {
+		e.drops++
+	} else if e.pending && !e.sse {
+		e.event(time.Now()) // last line without a newline
+	}
+	e.streams++
+	if e.count > 0 {
+		if d := e.last.Sub(e.sent).Seconds(); d > 0 {
+			e.rates.Record(float64(e.count) / d)
+		}
+	}
+}
+
+// transfer adds the counts and histograms of src into e (and resets src's histograms).
+func (e *eventStats) transfer(src *eventStats) {
+	e.events += src.events
+	e.streams += src.streams
+	e.drops += src.drops
+	e.first.Transfer(src.first)
+	e.gaps.Transfer(src.gaps)
+	e.rates.Transfer(src.rates)
+}
+
+// print prints the counts and, depending on the log level, the histograms or just their counters.
+func (e *eventStats) print(out io.Writer, res *HTTPRunnerResults) {
+	dropPct := 0.
+	if e.streams > 0 {
+		dropPct = 100. * float64(e.drops) / float64(e.streams)
+	}
+	_, _ = fmt.Fprintf(out, "Stream events: %d in %d streams, %d dropped (%.1f %%)\n", e.events, e.streams, e.drops, dropPct)
+	if log.Log(log.Info) {
+		res.TimeToFirstEvent.Print(out, "Time to first event histogram (s)")
+		res.EventGaps.Print(out, "Inter event gap histogram (s)")
+		res.StreamEventsPerSec.Print(out, "Stream events per second histogram")
+	} else if log.Log(log.Warning) {
+		e.first.Counter.Print(out, "Time to first event (s)")
+		e.gaps.Counter.Print(out, "Inter event gap (s)")
+		e.rates.Counter.Print(out, "Stream events per second")
+	}
+}
+
+// EventStats returns the number of events, streams and dropped streams received so far
+// (with StreamEvents).
+func (c *FastClient) EventStats() (int64, int64, int64) {
+	if c.events == nil {
+		return 0, 0, 0
+	}
+	return c.events.events, c.events.streams, c.events.drops
+}
+
+// writeEvents streams the echo server's events=n events, as server-sent events or, with
+// event-format=ndjson, as json lines. Each event is sent after its event-delay, with
+// event-size bytes of (hex) data, and the stream is aborted with event-drop % chance per event.
+func writeEvents(w http.ResponseWriter, r *http.Request, status int, eventsStr string) {
+	n, err := strconv.Atoi(eventsStr)
+	if err != nil || n < 0 {
+		log.Warnf("Bad input events %q, not a positive number", eventsStr)
+		http.Error(w, "invalid events number", http.StatusBadRequest)
+		return
+	}
+	if n > MaxEvents {
+		n = MaxEvents
+	}
+	ndjson := r.FormValue("event-format") == "ndjson"
+	if ndjson {
+		jrpc.SetHeaderIfMissing(w.Header(), "Content-Type", "application/x-ndjson")
+	} else {
+		jrpc.SetHeaderIfMissing(w.Header(), "Content-Type", "text/event-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	log.LogVf("Streaming %d events (ndjson %t) to %v", n, ndjson, r.RemoteAddr)
+	for i := 0; i < n; i++ {
+		if d := generateDelay(r.FormValue("event-delay")); d > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(d):
+			}
+		}
+		if generateSingleProbability(r.FormValue("event-drop"), "event-drop") {
+			log.LogVf("Dropping the events stream to %v after %d events", r.RemoteAddr, i)
+			panic(http.ErrAbortHandler) // closes the connection without ending the response
+		}
+		size := generateSize(r.FormValue("event-size"))
+		if size < 0 {
+			size = 0
+		}
+		data := hex.EncodeToString(fnet.Payload[:(size+1)/2])[:size]
+		if ndjson {
+			_, err = fmt.Fprintf(w, "{\"id\":%d,\"data\":%q}\n", i, data)
+		} else {
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", i, data)
+		}
+		if err != nil {
+			log.LogVf("Error writing event %d to %v: %v", i, r.RemoteAddr, err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventStatsSplit(t *testing.T) {
+	tests := []struct {
+		sse    bool
+		chunks []string
+		err    error
+		events int64
+	}{
+		{true, []string{"id: 1\ndata: a\n\n", "data: b\r\n", "\r\n"}, nil, 2},
+		{true, []string{": keepalive\n\n", "data: a\n", "data: b\n\n", ": ping\n\n"}, nil, 1},
+		{true, []string{"data: a\n\ndata: partial"}, nil, 1},
+		{false, []string{"{\"a\":1}\n{\"a\"", ":2}\n\n", "{\"a\":3}"}, nil, 3},
+		{false, []string{"line1\nline2\npartial"}, errors.New("drop"), 2},
+	}
+	for i, tst := range tests {
+		e := newEventStats(0, 0.001)
+		e.sent = time.Now()
+		e.begin(tst.sse)
+		for _, c := range tst.chunks {
+			_, _ = e.Write([]byte(c))
+		}
+		e.end(tst.err)
+		drops := int64(0)
+		if tst.err != nil {
+			drops = 1
+		}
+		if e.events != tst.events || e.streams != 1 || e.drops != drops {
+			t.Errorf("%d: got %d events, %d streams, %d drops, expected %d events", i, e.events, e.streams, e.drops, tst.events)
+		}
+		if e.first.Count != 1 || e.gaps.Count != tst.events-1 || e.rates.Count != 1 {
+			t.Errorf("%d: unexpected histograms counts %d %d %d", i, e.first.Count, e.gaps.Count, e.rates.Count)
+		}
+	}
+}
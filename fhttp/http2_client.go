@@ -0,0 +1,434 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/stats"
+	"golang.org/x/net/http2"
+)
+
+// h2Conn is an http/2 connection shared by the streams (H2Clients) using it.
+// It (re)connects as needed: when the server sent a GOAWAY or the connection broke.
+type h2Conn struct {
+	mu                sync.Mutex
+	transport         *http2.Transport
+	cc                *http2.ClientConn
+	dest              net.Addr
+	hostname          string
+	port              string
+	resolve           string
+	noResolveEachConn bool
+	https             bool
+	tlsConfig         *tls.Config
+	reqTimeout        time.Duration
+	socketCount       int
+	ipAddrUsage       *stats.Occurrence
+	connectStats      *stats.Histogram
+	proxy             *fnet.ProxyDialer // to tunnel to tunnelAddr through, if set
+	tunnelAddr        string
+	sourceIPs         *fnet.SourceIPs // local IPs to connect from, when set
+}
+
+// H2Client is a fast http/2 client: like the FastClient it bypasses the net/http
+// client and its connection pool and directly uses one http/2 connection, either
+// negotiated with ALPN for https:// urls or with prior knowledge (h2c) for http:// urls.
+// Several H2Clients can share the same connection as concurrent streams, see NewStream().
+// The returned data is the status line and headers, in http/1.x format, followed by the body
+// so the sizes are comparable with the FastClient's.
+type H2Client struct {
+	conn        *h2Conn
+	leader      bool // whether this stream owns (reports the stats of, closes) the connection
+	id          int
+	url         string
+	req         *http.Request
+	tmplURL     url.URL // marked url of the request template
+	body        []byte
+	tmpl        *requestTemplate // when the url, headers or body have {tokens}
+	rnd         *rand.Rand       // for the template values
+	tmplVals    [][]byte         // reused buffers of the template values
+	jar         *cookieJar       // when CookieJar or Cookies are set
+	seedCookies []string         // to seed the jars of new streams
+	buffer      []byte
+	errCategory string
+	logErrors   bool
+	okCodes     *CodeSet
+	offset      time.Duration
+	resolution  float64
+	bytesSent   int64
+	dropped     int64 // body bytes read past the buffer and discarded
+}
+
+// NewH2Client makes a fast http/2 client with its own connection.
+func NewH2Client(o *HTTPOptions) (*H2Client, error) {
+	o.Init(o.URL)
+	if o.HTTP10 {
+		log.Warnf("[%d] -http1.0 ignored with -h2", o.ID)
+	}
+	if o.ConnReuseRange != [2]int{0, 0} {
+		return nil, fmt.Errorf("-connection-reuse isn't supported with -h2 (the streams share one connection)")
+	}
+	tmpl, err := o.template()
+	if err != nil {
+		log.Errf("[%d] Invalid request template: %v", o.ID, err)
+		return nil, err
+	}
+	if tmpl != nil {
+		o = tmpl.options(o)
+	}
+	req, err := newHTTPRequest(o)
+	if req == nil {
+		return nil, err
+	}
+	u := req.URL
+	hc := &h2Conn{
+		transport:         &http2.Transport{AllowHTTP: true, DisableCompression: !o.Compression, StrictMaxConcurrentStreams: true},
+		hostname:          u.Hostname(),
+		port:              u.Port(),
+		resolve:           o.Resolve,
+		noResolveEachConn: o.NoResolveEachConn,
+		https:             o.https,
+		reqTimeout:        o.HTTPReqTimeOut,
+		ipAddrUsage:       stats.NewOccurrence(),
+		connectStats:      stats.NewHistogram(o.Offset.Seconds(), o.Resolution),
+	}
+	if hc.port == "" {
+		hc.port = u.Scheme
+	}
+	if o.https {
+		hc.tlsConfig, err = o.TLSOptions.TLSClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		hc.tlsConfig.ServerName = hc.hostname
+		hc.tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+	}
+	if hc.proxy, err = o.TLSOptions.ProxyDialer(o.HTTPReqTimeOut); err != nil {
+		return nil, err
+	}
+	if hc.sourceIPs, err = o.sourceIPList(); err != nil {
+		return nil, err
+	}
+	switch {
+	case hc.proxy != nil:
+		if hc.tunnelAddr, err = proxyTarget(hc.hostname, hc.port, o.Resolve); err != nil {
+			return nil, err
+		}
+		hc.dest = hc.proxy.Addr(hc.tunnelAddr)
+		hc.noResolveEachConn = true // the proxy resolves the host name
+	case o.UnixDomainSocket != "":
+		log.Infof("[%d] Using unix domain socket %v instead of %v %v", o.ID, o.UnixDomainSocket, hc.hostname, hc.port)
+		hc.dest = &net.UnixAddr{Name: o.UnixDomainSocket, Net: fnet.UnixDomainSocket}
+		hc.noResolveEachConn = true
+	default:
+		tAddr, err := resolve(hc.hostname, hc.port, o.Resolve, hc.ipAddrUsage)
+		if tAddr == nil {
+			return nil, err
+		}
+		hc.dest = tAddr
+	}
+	c := &H2Client{
+		conn:       hc,
+		leader:     true,
+		id:         o.ID,
+		url:        o.URL,
+		req:        req,
+		tmplURL:    *u,
+		body:       o.Payload,
+		tmpl:       tmpl,
+		buffer:     make([]byte, BufferSizeKb*1024),
+		logErrors:  o.LogErrors,
+		okCodes:    o.OKCodes,
+		offset:     o.Offset,
+		resolution: o.Resolution,
+	}
+	if tmpl != nil {
+		c.rnd = newTemplateRand(o.ID)
+	}
+	if c.jar, err = newCookieJar(o); err != nil {
+		return nil, err
+	}
+	c.seedCookies = o.Cookies
+	return c, nil
+}
+
+// NewStream returns a new client, with id as its logging id, sharing the
+// connection of c (for concurrent streams on the same http/2 connection).
+func (c *H2Client) NewStream(id int) *H2Client {
+	s := *c
+	s.leader = false
+	s.id = id
+	s.req = c.req.Clone(context.Background())
+	if c.tmpl != nil {
+		s.rnd = newTemplateRand(id)
+		s.tmplVals = nil // not shared with the other streams
+	}
+	if c.jar != nil {
+		// each stream has its own jar, the url and seed cookies were already validated
+		s.jar, _ = newCookieJar(&HTTPOptions{URL: c.jar.url.String(), CookieJar: true, Cookies: c.seedCookies})
+	}
+	s.buffer = make([]byte, len(c.buffer))
+	return &s
+}
+
+// get returns the current connection, (re)connecting if needed or if the
+// current one is the broken one passed as argument. Returns the error category on failure.
+func (h *h2Conn) get(broken *http2.ClientConn) (*http2.ClientConn, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cc != nil && h.cc != broken && h.cc.CanTakeNewRequest() {
+		return h.cc, ""
+	}
+	if h.cc != nil {
+		_ = h.cc.Close()
+		h.cc = nil
+	}
+	h.socketCount++
+	if h.socketCount > 1 && !h.noResolveEachConn {
+		dest, err := resolve(h.hostname, h.port, h.resolve, h.ipAddrUsage)
+		if err != nil {
+			log.Errf("Unable to resolve hostname %v: %v", h.hostname, err)
+			return nil, fnet.ErrDNS
+		}
+		h.dest = dest
+	}
+	d := &net.Dialer{Timeout: h.reqTimeout}
+	if h.proxy == nil {
+		d.LocalAddr = h.sourceIPs.LocalAddr(h.dest.Network())
+	}
+	now := time.Now()
+	var conn net.Conn
+	var err error
+	switch {
+	case h.https:
+		var tlsConn *tls.Conn
+		tlsConn, err = h.dialTLS(d)
+		if err == nil && tlsConn.ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+			_ = tlsConn.Close()
+			err = fmt.Errorf("tls: server didn't negotiate h2 (got %q)", tlsConn.ConnectionState().NegotiatedProtocol)
+		}
+		conn = tlsConn
+	case h.proxy != nil:
+		conn, err = h.proxy.DialContext(context.Background(), "tcp", h.tunnelAddr)
+	default:
+		conn, err = d.Dial(h.dest.Network(), h.dest.String())
+	}
+	h.connectStats.Record(time.Since(now).Seconds())
+	if err == nil && h.proxy != nil {
+		h.ipAddrUsage.Record(conn.RemoteAddr().String())
+	}
+	if err != nil {
+		log.Errf("Unable to h2 connect to %v : %v", h.dest, err)
+		category := fnet.ErrorCategory(err)
+		if h.https && (category == fnet.ErrOther || category == fnet.ErrShortRead || category == fnet.ErrReset) {
+			category = fnet.ErrTLS // failed during the handshake
+		}
+		return nil, category
+	}
+	h.cc, err = h.transport.NewClientConn(conn)
+	if err != nil {
+		log.Errf("Unable to start http/2 on %v : %v", h.dest, err)
+		_ = conn.Close()
+		return nil, fnet.ErrorCategory(err)
+	}
+	return h.cc, ""
+}
+
+// dialTLS connects, directly or through the proxy, and does the TLS handshake.
+func (h *h2Conn) dialTLS(d *net.Dialer) (*tls.Conn, error) {
+	if h.proxy == nil {
+		return tls.DialWithDialer(d, h.dest.Network(), h.dest.String(), h.tlsConfig)
+	}
+	conn, err := h.proxy.DialContext(context.Background(), "tcp", h.tunnelAddr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, h.tlsConfig)
+	_ = tlsConn.SetDeadline(time.Now().Add(h.reqTimeout))
+	if err = tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// Fetch makes one request on the shared connection. Returns http code, data
+// (headers and body) and offset of the body.
+func (c *H2Client) Fetch() (int, []byte, int) {
+	return c.fetch(true)
+}
+
+//nolint:funlen
+func (c *H2Client) fetch(canRetry bool) (int, []byte, int) {
+	c.errCategory = ""
+	body := c.body
+	if c.tmpl != nil {
+		c.tmplVals = c.tmpl.values(c.tmplVals, c.rnd, c.id)
+		var err error
+		if body, err = c.tmpl.apply(c.req, &c.tmplURL, c.tmplVals); err != nil {
+			log.Errf("[%d] Unable to expand the request template: %v", c.id, err)
+			c.errCategory = fnet.ErrOther
+			return SocketError, nil, 0
+		}
+		c.req.ContentLength = int64(len(body))
+	}
+	if len(body) > 0 {
+		c.req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	cc, category := c.conn.get(nil)
+	if cc == nil {
+		c.errCategory = category
+		return SocketError, nil, 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.conn.reqTimeout)
+	defer cancel()
+	req := c.req
+	if c.jar != nil {
+		req = c.jar.withCookies(c.req)
+	}
+	resp, err := cc.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		if canRetry && !cc.CanTakeNewRequest() && ctx.Err() == nil {
+			// it's ok for the (idle) connection to go away once, auto reconnect:
+			log.Infof("[%d] Retrying on new connection after %v", c.id, err)
+			c.conn.get(cc)
+			return c.fetch(false)
+		}
+		log.Errf("[%d] Unable to send h2 %s request for %s : %v", c.id, c.req.Method, c.url, err)
+		c.errCategory = fnet.ErrorCategory(err)
+		return SocketError, []byte(err.Error()), 0
+	}
+	c.bytesSent += requestSize(req, len(body))
+	if c.jar != nil {
+		c.jar.SetCookies(req.URL, resp.Cookies())
+	}
+	data, headerLen, dropped, err := bufferResponse(resp, c.buffer, "HTTP/2.0")
+	size := len(data)
+	code := resp.StatusCode
+	if err != nil {
+		log.Errf("[%d] Read error for %s %d : %v", c.id, c.url, size, err)
+		code = SocketError
+		c.errCategory = fnet.ErrorCategory(err)
+		if ctx.Err() != nil {
+			c.errCategory = fnet.ErrReadTimeout
+		}
+	}
+	if dropped > 0 {
+		c.dropped += dropped
+		log.Warnf("[%d] Dropped %d bytes of the body after the first %d, increase -httpbufferkb", c.id, dropped, size)
+	}
+	if c.logErrors && !codeIsOK(c.okCodes, code) {
+		log.Warnf("[%d] Non ok http code %d (%s)", c.id, code, resp.Status)
+	}
+	log.Debugf("[%d] Got %d for %s %s - %d bytes (%d headers)", c.id, code, c.req.Method, c.url, size, headerLen)
+	return code, data[:size], headerLen
+}
+
+// bufferResponse puts the status line and headers, http/1.x style, followed by
+// the body in buffer, like the FastClient returns them, and closes the body.
+// The rest of a body bigger than the buffer is read and discarded, its size returned
+// as dropped. The returned error is for reading the body.
+func bufferResponse(resp *http.Response, buffer []byte, proto string) ([]byte, int, int64, error) {
+	hb := bytes.NewBuffer(buffer[:0])
+	_, _ = fmt.Fprintf(hb, "%s %s\r\n", proto, resp.Status)
+	_ = resp.Header.Write(hb)
+	hb.WriteString("\r\n")
+	headerLen := hb.Len()
+	data := hb.Bytes()
+	if headerLen < len(buffer) {
+		data = buffer // wasn't reallocated
+	}
+	n, err := io.ReadFull(resp.Body, data[headerLen:])
+	var dropped int64
+	if err == nil {
+		// buffer is full, consume the rest so the stream completes normally
+		dropped, err = io.Copy(io.Discard, resp.Body)
+	}
+	resp.Body.Close()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil // normal end of (shorter than the buffer) body
+	}
+	return data[:headerLen+n], headerLen, dropped, err
+}
+
+// LastURL returns the url of the last request, after {tokens} substitution.
+func (c *H2Client) LastURL() string {
+	if c.tmpl != nil && c.tmpl.urlTokens {
+		return c.req.URL.String()
+	}
+	return c.url
+}
+
+// ErrorCategory returns the category of the transport error of the last request, if any.
+func (c *H2Client) ErrorCategory() string {
+	return c.errCategory
+}
+
+// CookieStats returns the number of cookies received and currently in the jar for the url.
+func (c *H2Client) CookieStats() (int64, int) {
+	return c.jar.CookieStats()
+}
+
+// BytesSent returns the size of the requests sent so far (as http/1.1 text, see requestSize).
+func (c *H2Client) BytesSent() int64 {
+	return c.bytesSent
+}
+
+// DroppedBytes returns the number of body bytes which didn't fit in the buffer and were discarded.
+func (c *H2Client) DroppedBytes() int64 {
+	return c.dropped
+}
+
+// GetIPAddress returns the ip addresses and connection stats of the shared connection
+// for the first stream and empty ones for the others so they're counted only once.
+func (c *H2Client) GetIPAddress() (*stats.Occurrence, *stats.Histogram) {
+	if !c.leader {
+		return stats.NewOccurrence(), stats.NewHistogram(c.offset.Seconds(), c.resolution)
+	}
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	return c.conn.ipAddrUsage, c.conn.connectStats
+}
+
+// Close closes the connection, when called on the stream owning it.
+func (c *H2Client) Close() {
+	if !c.leader {
+		return
+	}
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	log.Debugf("[%d] Closing h2 client %s socket count %d", c.id, c.url, c.conn.socketCount)
+	if c.conn.cc != nil {
+		if err := c.conn.cc.Close(); err != nil {
+			log.Warnf("[%d] Error closing h2 connection: %v", c.id, err)
+		}
+		c.conn.cc = nil
+	}
+}
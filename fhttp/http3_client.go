@@ -0,0 +1,348 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/stats"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// h3Conn is the http/3 (QUIC) connection shared by the streams (H3Clients) using it.
+// The http3.RoundTripper reconnects as needed, through our dial() to keep the stats.
+type h3Conn struct {
+	mu           sync.Mutex
+	rt           *http3.RoundTripper
+	resolve      string
+	zeroRTT      int64 // number of connections which used 0-RTT resumption
+	ipAddrUsage  *stats.Occurrence
+	connectStats *stats.Histogram // time until the connection is usable (early data or handshake done)
+	handshakes   *stats.Histogram // time until the handshake is complete
+}
+
+// H3Client is an http/3 client, over QUIC, using one connection which can be
+// shared by several H3Clients as concurrent streams, see NewStream(). Like for
+// the H2Client, the returned data is the status line and headers, in http/1.x format,
+// followed by the body. TLS session tickets are kept so reconnections can resume and
+// GET requests are sent as 0-RTT early data when possible.
+type H3Client struct {
+	conn        *h3Conn
+	leader      bool // whether this stream owns (reports the stats of, closes) the connection
+	id          int
+	url         string
+	req         *http.Request
+	tmplURL     url.URL // marked url of the request template
+	body        []byte
+	tmpl        *requestTemplate // when the url, headers or body have {tokens}
+	rnd         *rand.Rand       // for the template values
+	tmplVals    [][]byte         // reused buffers of the template values
+	jar         *cookieJar       // when CookieJar or Cookies are set
+	seedCookies []string         // to seed the jars of new streams
+	buffer      []byte
+	errCategory string
+	logErrors   bool
+	okCodes     *CodeSet
+	reqTimeout  time.Duration
+	offset      time.Duration
+	resolution  float64
+	bytesSent   int64
+	dropped     int64 // body bytes read past the buffer and discarded
+}
+
+// NewH3Client makes an http/3 client with its own connection, the url must be https://.
+func NewH3Client(o *HTTPOptions) (*H3Client, error) {
+	o.Init(o.URL)
+	if !o.https {
+		return nil, fmt.Errorf("http/3 requires an https:// url, got %q", o.URL)
+	}
+	if o.UnixDomainSocket != "" {
+		return nil, fmt.Errorf("http/3 (QUIC) doesn't support unix domain sockets")
+	}
+	if o.Proxy != "" {
+		return nil, fmt.Errorf("http/3 (QUIC) doesn't support proxies")
+	}
+	tmpl, err := o.template()
+	if err != nil {
+		log.Errf("[%d] Invalid request template: %v", o.ID, err)
+		return nil, err
+	}
+	if tmpl != nil {
+		o = tmpl.options(o)
+	}
+	req, err := newHTTPRequest(o)
+	if req == nil {
+		return nil, err
+	}
+	tlsConfig, err := o.TLSOptions.TLSClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	hc := &h3Conn{
+		resolve:      o.Resolve,
+		ipAddrUsage:  stats.NewOccurrence(),
+		connectStats: stats.NewHistogram(o.Offset.Seconds(), o.Resolution),
+		handshakes:   stats.NewHistogram(o.Offset.Seconds(), o.Resolution),
+	}
+	hc.rt = &http3.RoundTripper{
+		DisableCompression: !o.Compression,
+		TLSClientConfig:    tlsConfig,
+		QuicConfig:         &quic.Config{HandshakeIdleTimeout: o.HTTPReqTimeOut},
+		Dial:               hc.dial,
+	}
+	u := req.URL
+	c := &H3Client{
+		conn:       hc,
+		leader:     true,
+		id:         o.ID,
+		url:        o.URL,
+		req:        req,
+		tmplURL:    *u,
+		body:       o.Payload,
+		tmpl:       tmpl,
+		buffer:     make([]byte, BufferSizeKb*1024),
+		logErrors:  o.LogErrors,
+		okCodes:    o.OKCodes,
+		reqTimeout: o.HTTPReqTimeOut,
+		offset:     o.Offset,
+		resolution: o.Resolution,
+	}
+	if tmpl != nil {
+		c.rnd = newTemplateRand(o.ID)
+	}
+	if c.jar, err = newCookieJar(o); err != nil {
+		return nil, err
+	}
+	c.seedCookies = o.Cookies
+	return c, nil
+}
+
+// NewStream returns a new client, with id as its logging id, sharing the
+// connection of c (for concurrent streams on the same QUIC connection).
+func (c *H3Client) NewStream(id int) *H3Client {
+	s := *c
+	s.leader = false
+	s.id = id
+	s.req = c.req.Clone(context.Background())
+	if c.tmpl != nil {
+		s.rnd = newTemplateRand(id)
+		s.tmplVals = nil // not shared with the other streams
+	}
+	if c.jar != nil {
+		// each stream has its own jar, the url and seed cookies were already validated
+		s.jar, _ = newCookieJar(&HTTPOptions{URL: c.jar.url.String(), CookieJar: true, Cookies: c.seedCookies})
+	}
+	s.buffer = make([]byte, len(c.buffer))
+	return &s
+}
+
+// dial is the http3.RoundTripper's Dial, it records the connection stats.
+func (h *h3Conn) dial(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if h.resolve != "" {
+		host = h.resolve
+	}
+	udpAddr, err := fnet.UDPResolveDestination(net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.ipAddrUsage.Record(udpAddr.String())
+	h.mu.Unlock()
+	start := time.Now()
+	conn, err := quic.DialAddrEarly(ctx, udpAddr.String(), tlsCfg, cfg)
+	h.mu.Lock()
+	h.connectStats.Record(time.Since(start).Seconds())
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-conn.Context().Done():
+			return
+		}
+		h.mu.Lock()
+		h.handshakes.Record(time.Since(start).Seconds())
+		if conn.ConnectionState().Used0RTT {
+			h.zeroRTT++
+		}
+		h.mu.Unlock()
+	}()
+	return conn, nil
+}
+
+// roundTrip sends the request, GET requests as 0-RTT early data when the connection is
+// resumed. The http3.RoundTripper only does that for its GET_0RTT pseudo method, which is
+// set on a copy so the request itself, and what is logged and reported, stays a GET.
+func (h *h3Conn) roundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		early := *req
+		early.Method = http3.MethodGet0RTT
+		req = &early
+	}
+	return h.rt.RoundTrip(req)
+}
+
+// Fetch makes one request on the shared connection. Returns http code, data
+// (headers and body) and offset of the body.
+//
+//nolint:funlen
+func (c *H3Client) Fetch() (int, []byte, int) {
+	c.errCategory = ""
+	body := c.body
+	if c.tmpl != nil {
+		c.tmplVals = c.tmpl.values(c.tmplVals, c.rnd, c.id)
+		var err error
+		if body, err = c.tmpl.apply(c.req, &c.tmplURL, c.tmplVals); err != nil {
+			log.Errf("[%d] Unable to expand the request template: %v", c.id, err)
+			c.errCategory = fnet.ErrOther
+			return SocketError, nil, 0
+		}
+		c.req.ContentLength = int64(len(body))
+	}
+	if len(body) > 0 {
+		c.req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.reqTimeout)
+	defer cancel()
+	req := c.req
+	if c.jar != nil {
+		req = c.jar.withCookies(c.req)
+	}
+	resp, err := c.conn.roundTrip(req.WithContext(ctx))
+	if err != nil {
+		log.Errf("[%d] Unable to send h3 %s request for %s : %v", c.id, c.req.Method, c.url, err)
+		c.errCategory = h3ErrorCategory(err)
+		return SocketError, []byte(err.Error()), 0
+	}
+	c.bytesSent += requestSize(req, len(body))
+	if c.jar != nil {
+		c.jar.SetCookies(req.URL, resp.Cookies())
+	}
+	data, headerLen, dropped, err := bufferResponse(resp, c.buffer, "HTTP/3.0")
+	size := len(data)
+	code := resp.StatusCode
+	if err != nil {
+		log.Errf("[%d] Read error for %s %d : %v", c.id, c.url, size, err)
+		code = SocketError
+		c.errCategory = h3ErrorCategory(err)
+	}
+	if dropped > 0 {
+		c.dropped += dropped
+		log.Warnf("[%d] Dropped %d bytes of the body after the first %d, increase -httpbufferkb", c.id, dropped, size)
+	}
+	if c.logErrors && !codeIsOK(c.okCodes, code) {
+		log.Warnf("[%d] Non ok http code %d (%s)", c.id, code, resp.Status)
+	}
+	log.Debugf("[%d] Got %d for %s %s - %d bytes (%d headers)", c.id, code, c.req.Method, c.url, size, headerLen)
+	return code, data[:size], headerLen
+}
+
+// h3ErrorCategory adds the QUIC specific errors to fnet.ErrorCategory.
+func h3ErrorCategory(err error) string {
+	var idleErr *quic.IdleTimeoutError
+	var handshakeErr *quic.HandshakeTimeoutError
+	var tErr *quic.TransportError
+	switch {
+	case errors.As(err, &handshakeErr):
+		return fnet.ErrConnectTimeout
+	case errors.As(err, &idleErr):
+		return fnet.ErrReadTimeout
+	case errors.As(err, &tErr) && tErr.ErrorCode.IsCryptoError():
+		return fnet.ErrTLS
+	}
+	return fnet.ErrorCategory(err)
+}
+
+// LastURL returns the url of the last request, after {tokens} substitution.
+func (c *H3Client) LastURL() string {
+	if c.tmpl != nil && c.tmpl.urlTokens {
+		return c.req.URL.String()
+	}
+	return c.url
+}
+
+// ErrorCategory returns the category of the transport error of the last request, if any.
+func (c *H3Client) ErrorCategory() string {
+	return c.errCategory
+}
+
+// CookieStats returns the number of cookies received and currently in the jar for the url.
+func (c *H3Client) CookieStats() (int64, int) {
+	return c.jar.CookieStats()
+}
+
+// BytesSent returns the size of the requests sent so far (as http/1.1 text, see requestSize).
+func (c *H3Client) BytesSent() int64 {
+	return c.bytesSent
+}
+
+// DroppedBytes returns the number of body bytes which didn't fit in the buffer and were discarded.
+func (c *H3Client) DroppedBytes() int64 {
+	return c.dropped
+}
+
+// GetIPAddress returns the ip addresses and connection stats of the shared connection
+// for the first stream and empty ones for the others so they're counted only once.
+func (c *H3Client) GetIPAddress() (*stats.Occurrence, *stats.Histogram) {
+	if !c.leader {
+		return stats.NewOccurrence(), stats.NewHistogram(c.offset.Seconds(), c.resolution)
+	}
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	return c.conn.ipAddrUsage, c.conn.connectStats
+}
+
+// HandshakeStats returns the QUIC handshakes durations and how many connections
+// used 0-RTT, empty for all but the first stream like GetIPAddress().
+func (c *H3Client) HandshakeStats() (*stats.Histogram, int64) {
+	if !c.leader {
+		return stats.NewHistogram(c.offset.Seconds(), c.resolution), 0
+	}
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+	return c.conn.handshakes, c.conn.zeroRTT
+}
+
+// Close closes the connection, when called on the stream owning it.
+func (c *H3Client) Close() {
+	if !c.leader {
+		return
+	}
+	log.Debugf("[%d] Closing h3 client %s", c.id, c.url)
+	if err := c.conn.rt.Close(); err != nil {
+		log.Warnf("[%d] Error closing h3 connection: %v", c.id, err)
+	}
+}
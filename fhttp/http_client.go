@@ -18,13 +18,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
 	"strconv"
@@ -35,7 +38,6 @@ import (
 	"fortio.org/fortio/jrpc"
 	"fortio.org/fortio/log"
 	"fortio.org/fortio/stats"
-	"github.com/google/uuid"
 )
 
 // Fetcher is the Url content fetcher that the different client implements.
@@ -48,6 +50,11 @@ type Fetcher interface {
 	// GetIPAddress() returns the occurrence of ip address used by this client connection,
 	// and the connection time histogram (which includes the count).
 	GetIPAddress() (*stats.Occurrence, *stats.Histogram)
+	// LastURL() returns the url of the last Fetch(), after {tokens} substitution.
+	LastURL() string
+	// ErrorCategory() returns the category (fnet.ErrDNS, fnet.ErrReset,...) of the transport
+	// error of the last Fetch(), when it returned SocketError, or "" otherwise.
+	ErrorCategory() string
 }
 
 const (
@@ -63,7 +70,6 @@ var (
 	contentLengthHeader   = []byte("\r\ncontent-length:")
 	connectionCloseHeader = []byte("\r\nconnection: close")
 	chunkedHeader         = []byte("\r\nTransfer-Encoding: chunked")
-	rander                = NewSyncReader(rand.New(rand.NewSource(time.Now().UnixNano()))) //nolint:gosec // we want fast not crypto
 )
 
 // NewHTTPOptions creates and initialize a HTTPOptions object.
@@ -168,12 +174,25 @@ type HTTPOptions struct {
 	Compression       bool // defaults to no compression, only used by std client
 	DisableFastClient bool // defaults to fast client
 	HTTP10            bool // defaults to http1.1
+	H2                bool // use the fast http/2 client (H2Client): h2 for https, prior knowledge h2c for http
+	H3                bool // use the http/3 client (H3Client), over QUIC, https only
 	DisableKeepAlive  bool // so default is keep alive
 	AllowHalfClose    bool // if not keepalive, whether to half close after request
 	FollowRedirects   bool // For the Std Client only: follow redirects.
 	initDone          bool
 	https             bool   // whether URLSchemeCheck determined this was an https:// call or not
 	Resolve           string // resolve Common Name to this ip when use CN as target url
+	// Comma separated local IPs and CIDRs to bind the new connections to, in round robin (see fnet.ParseSourceIPs).
+	SourceIP string `json:",omitempty"`
+	// source IPs shared by the clients of a run
+	sourceIPs *fnet.SourceIPs
+	// PROXY protocol header version, "v1" or "v2", sent first on the new connections of the (fast and std)
+	// http/1.x clients, for load balancers expecting it.
+	ProxyProtocol string `json:",omitempty"`
+	// Comma separated IPs and CIDRs advertised, in round robin, as the PROXY protocol source instead of the actual one.
+	ProxyProtocolSource string `json:",omitempty"`
+	// PROXY protocol shared by the clients of a run
+	proxyProto *fnet.ProxyProtocol
 	// ExtraHeaders to be added to each request (UserAgent and headers set through AddAndValidateExtraHeader()).
 	extraHeaders http.Header
 	// Host is treated specially, remember that virtual header separately.
@@ -182,18 +201,60 @@ type HTTPOptions struct {
 	UserCredentials  string        // user credentials for authorization
 	ContentType      string        // indicates request body type, implies POST instead of GET
 	Payload          []byte        // body for http request, implies POST if not empty.
-	LogErrors        bool          // whether to log non 2xx code as they occur or not
+	LogErrors        bool          // whether to log non ok codes as they occur or not
 	ID               int           `json:"-"` // thread/connect id to use for logging (thread id when used as a runner)
 	SequentialWarmup bool          // whether to do http(s) runs warmup sequentially or in parallel (new default is //)
 	ConnReuseRange   [2]int        // range of max number of connection to reuse for each thread.
 	// When false, re-resolve the DNS name when the connection breaks.
 	NoResolveEachConn bool
+	// Status codes considered ok/successful, default (nil) is 2xx (and 418) for the
+	// warmup and only 200 for the success of calls in runs. The fast client keeps its
+	// connection after 2xx (and 418) responses as well as after these codes.
+	OKCodes *CodeSet `json:",omitempty"`
+	// Data file (csv with a header line or json array of objects) for the {data:column} request template tokens.
+	TemplateData string `json:",omitempty"`
+	// Use the TemplateData rows in random order instead of round robin.
+	TemplateDataRandom bool `json:",omitempty"`
+	// Keep the cookies received, per client/connection, and send them back.
+	CookieJar bool `json:",omitempty"`
+	// Cookies to seed the jars with, as `name=value[; Path=/...]`, implies CookieJar.
+	Cookies []string `json:",omitempty"`
+	// Read the whole response bodies whatever their size, instead of up to BufferSizeKb, keeping
+	// only their first StreamKeep bytes (for the checks and the returned data). Fast client only.
+	StreamBody bool `json:",omitempty"`
+	StreamKeep int  `json:",omitempty"`
+	// Compute the sha256 of the streamed bodies, see FastClient.BodyHash().
+	StreamHash bool `json:",omitempty"`
+	// Split the streamed bodies into events (server-sent events, or lines for the other content types
+	// e.g. NDJSON) for the time to first event, inter event gaps, events per second and stream drops
+	// stats. The HTTPReqTimeOut is then the maximum time without data, instead of for the whole response.
+	StreamEvents bool `json:",omitempty"`
+	// request template shared by the clients of a run
+	tmpl *requestTemplate
 	// Optional Offset Duration; to offset the histogram of the Connection duration
 	Offset time.Duration
 	// Optional resolution divider for the Connection duration histogram. In seconds. Defaults to 0.001 or 1 millisecond.
 	Resolution float64
 }
 
+// sourceIPList returns the source IPs shared by the clients of the run, or parses SourceIP
+// for a standalone client. nil when SourceIP isn't set.
+func (h *HTTPOptions) sourceIPList() (*fnet.SourceIPs, error) {
+	if h.sourceIPs != nil || h.SourceIP == "" {
+		return h.sourceIPs, nil
+	}
+	return fnet.ParseSourceIPs(h.SourceIP)
+}
+
+// proxyProtocol returns the PROXY protocol shared by the clients of the run, or parses ProxyProtocol
+// and ProxyProtocolSource for a standalone client. nil when ProxyProtocol isn't set.
+func (h *HTTPOptions) proxyProtocol() (*fnet.ProxyProtocol, error) {
+	if h.proxyProto != nil || h.ProxyProtocol == "" {
+		return h.proxyProto, nil
+	}
+	return fnet.NewProxyProtocol(h.ProxyProtocol, h.ProxyProtocolSource)
+}
+
 // ResetHeaders resets all the headers, including the User-Agent: one (and the Host: logical special header).
 // This is used from the UI as the user agent is settable from the form UI.
 func (h *HTTPOptions) ResetHeaders() {
@@ -349,20 +410,26 @@ func newHTTPRequest(o *HTTPOptions) (*http.Request, error) {
 // http client (net/http).
 // TODO: refactor common parts with FastClient.
 type Client struct {
-	url                  string
-	path                 string // original path of the request's url
-	rawQuery             string // original query params
-	body                 []byte // original body of the request
-	req                  *http.Request
-	client               *http.Client
-	transport            *http.Transport
-	pathContainsUUID     bool // if url contains the "{uuid}" pattern (lowercase)
-	rawQueryContainsUUID bool // if any query params contains the "{uuid}" pattern (lowercase)
-	bodyContainsUUID     bool // if body contains the "{uuid}" pattern (lowercase)
-	logErrors            bool
-	id                   int
-	ipAddrUsage          *stats.Occurrence
-	connectStats         *stats.Histogram
+	url          string
+	tmplURL      url.URL // marked url of the request template
+	body         []byte  // original body of the request
+	req          *http.Request
+	client       *http.Client
+	transport    *http.Transport
+	tmpl         *requestTemplate // when the url, headers or body have {tokens}
+	rnd          *rand.Rand       // for the template values
+	tmplVals     [][]byte         // reused buffers of the template values
+	logErrors    bool
+	okCodes      *CodeSet
+	id           int
+	errCategory  string      // category of the last transport error
+	respHeader   http.Header // headers of the last response
+	jar          *cookieJar  // when CookieJar or Cookies are set
+	ipAddrUsage  *stats.Occurrence
+	connectStats *stats.Histogram
+	phases       *phaseTimings
+	trace        *httptrace.ClientTrace
+	bytesSent    int64
 }
 
 // Close cleans up any resources used by NewStdClient.
@@ -391,38 +458,37 @@ func (c *Client) ChangeURL(urlStr string) (err error) {
 // Fetch fetches the byte and code for pre created client.
 func (c *Client) Fetch() (int, []byte, int) {
 	// req can't be null (client itself would be null in that case)
-	if c.pathContainsUUID {
-		path := c.path
-		for strings.Contains(path, uuidToken) {
-			path = strings.Replace(path, uuidToken, generateUUID(), 1)
+	c.errCategory = ""
+	c.respHeader = nil
+	body := c.body
+	if c.tmpl != nil {
+		c.tmplVals = c.tmpl.values(c.tmplVals, c.rnd, c.id)
+		var err error
+		if body, err = c.tmpl.apply(c.req, &c.tmplURL, c.tmplVals); err != nil {
+			log.Errf("[%d] Unable to expand the request template: %v", c.id, err)
+			c.errCategory = fnet.ErrOther
+			return SocketError, nil, 0
 		}
-		c.req.URL.Path = path
+		c.req.ContentLength = int64(len(body))
 	}
-	if c.rawQueryContainsUUID {
-		rawQuery := c.rawQuery
-		for strings.Contains(rawQuery, uuidToken) {
-			rawQuery = strings.Replace(rawQuery, uuidToken, generateUUID(), 1)
-		}
-
-		c.req.URL.RawQuery = rawQuery
-	}
-	if c.bodyContainsUUID {
-		body := string(c.body)
-		for strings.Contains(body, uuidToken) {
-			body = strings.Replace(body, uuidToken, generateUUID(), 1)
-		}
-		bodyBytes := []byte(body)
-		c.req.ContentLength = int64(len(bodyBytes))
-		c.req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-	} else if len(c.body) > 0 {
-		c.req.Body = io.NopCloser(bytes.NewReader(c.body))
+	if len(body) > 0 {
+		c.req.Body = io.NopCloser(bytes.NewReader(body))
 	}
 
-	resp, err := c.client.Do(c.req)
+	req := c.req
+	if c.jar != nil {
+		// net/http adds the jar's cookies to the request's headers, so use a copy
+		r := *c.req
+		r.Header = c.req.Header.Clone()
+		req = &r
+	}
+	resp, err := c.client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), c.trace)))
 	if err != nil {
 		log.Errf("[%d] Unable to send %s request for %s : %v", c.id, c.req.Method, c.url, err)
-		return -1, []byte(err.Error()), 0
+		c.errCategory = fnet.ErrorCategory(err)
+		return SocketError, []byte(err.Error()), 0
 	}
+	c.bytesSent += requestSize(req, len(body))
 	var data []byte
 	if log.LogDebug() {
 		if data, err = httputil.DumpResponse(resp, false); err != nil {
@@ -431,69 +497,143 @@ func (c *Client) Fetch() (int, []byte, int) {
 			log.Debugf("[%d] For URL %s, received:\n%s", c.id, c.url, data)
 		}
 	}
+	c.respHeader = resp.Header
 	data, err = io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
+		c.phases.cancel(phaseTransfer)
 		log.Errf("[%d] Unable to read response for %s : %v", c.id, c.url, err)
 		code := resp.StatusCode
-		if codeIsOK(code) {
+		if codeIsOK(c.okCodes, code) {
 			code = http.StatusNoContent
 			log.Warnf("[%d] Ok code despite read error, switching code to %d", c.id, code)
 		}
 		return code, data, 0
 	}
+	c.phases.end(phaseTransfer)
 	code := resp.StatusCode
 	log.Debugf("[%d] Got %d : %s for %s %s - response is %d bytes", c.id, code, resp.Status, c.req.Method, c.url, len(data))
-	if c.logErrors && !codeIsOK(code) {
+	if c.logErrors && !codeIsOK(c.okCodes, code) {
 		log.Warnf("[%d] Non ok http code %d", c.id, code)
 	}
 	return code, data, 0
 }
 
+// LastURL returns the url of the last request, after {tokens} substitution.
+func (c *Client) LastURL() string {
+	if c.tmpl != nil && c.tmpl.urlTokens {
+		return c.req.URL.String()
+	}
+	return c.url
+}
+
+// ErrorCategory returns the category of the transport error of the last request, if any.
+func (c *Client) ErrorCategory() string {
+	return c.errCategory
+}
+
+// CookieStats returns the number of cookies received and currently in the jar for the url.
+func (c *Client) CookieStats() (int64, int) {
+	return c.jar.CookieStats()
+}
+
+// BytesSent returns the size of the requests sent so far (as http/1.1 text, see requestSize).
+func (c *Client) BytesSent() int64 {
+	return c.bytesSent
+}
+
+// phaseTimings returns the client's per phase timings.
+func (c *Client) phaseTimings() *phaseTimings {
+	return c.phases
+}
+
 // GetIPAddress get the ip address that DNS resolves to when using stdClient and connection stats.
 func (c *Client) GetIPAddress() (*stats.Occurrence, *stats.Histogram) {
 	return c.ipAddrUsage, c.connectStats
 }
 
-// NewClient creates either a standard or fast client (depending on
-// the DisableFastClient flag).
+// NewClient creates either a standard, http/3, fast http/2 or fast client (depending on
+// the DisableFastClient, H3 and H2 flags).
 func NewClient(o *HTTPOptions) (Fetcher, error) {
 	o.Init(o.URL) // For completely new options
 	// For changes to options after init
 	o.URLSchemeCheck()
+	if o.StreamBody && (o.DisableFastClient || o.H2 || o.H3) {
+		log.Warnf("[%d] Response streaming is only supported by the fast http/1.x client", o.ID)
+	}
 	if o.DisableFastClient {
 		return NewStdClient(o)
 	}
+	if o.H3 {
+		return NewH3Client(o)
+	}
+	if o.H2 {
+		return NewH2Client(o)
+	}
 	return NewFastClient(o)
 }
 
 // NewStdClient creates a client object that wraps the net/http standard client.
 func NewStdClient(o *HTTPOptions) (*Client, error) {
 	o.Init(o.URL) // also normalizes NumConnections etc to be valid.
+	tmpl, err := o.template()
+	if err != nil {
+		log.Errf("[%d] Invalid request template: %v", o.ID, err)
+		return nil, err
+	}
+	if tmpl != nil {
+		o = tmpl.options(o)
+	}
 	req, err := newHTTPRequest(o)
 	if req == nil {
 		return nil, err
 	}
 
 	client := Client{
-		url:                  o.URL,
-		path:                 req.URL.Path,
-		pathContainsUUID:     strings.Contains(req.URL.Path, uuidToken),
-		rawQuery:             req.URL.RawQuery,
-		rawQueryContainsUUID: strings.Contains(req.URL.RawQuery, uuidToken),
-		body:                 o.Payload,
-		bodyContainsUUID:     strings.Contains(string(o.Payload), uuidToken),
-		req:                  req,
+		url:     o.URL,
+		tmplURL: *req.URL,
+		body:    o.Payload,
+		tmpl:    tmpl,
+		req:     req,
 		client: &http.Client{
 			Timeout: o.HTTPReqTimeOut,
 		},
 		id:          o.ID,
 		logErrors:   o.LogErrors,
+		okCodes:     o.OKCodes,
 		ipAddrUsage: stats.NewOccurrence(),
 		// Keep track of timing for connection (re)establishment.
 		connectStats: stats.NewHistogram(o.Offset.Seconds(), o.Resolution),
+		phases:       newPhaseTimings(o.Offset.Seconds(), o.Resolution),
+	}
+	client.trace = client.phases.clientTrace()
+	if tmpl != nil {
+		client.rnd = newTemplateRand(o.ID)
+	}
+	if client.jar, err = newCookieJar(o); err != nil {
+		return nil, err
+	}
+	if client.jar != nil {
+		client.client.Jar = client.jar
 	}
 
+	proxy, err := o.TLSOptions.ProxyDialer(o.HTTPReqTimeOut)
+	if err != nil {
+		log.Errf("[%d] Invalid proxy %q: %v", o.ID, o.Proxy, err)
+		return nil, err
+	}
+	sourceIPs, err := o.sourceIPList()
+	if err != nil {
+		log.Errf("[%d] Invalid source ip %q: %v", o.ID, o.SourceIP, err)
+		return nil, err
+	}
+	proxyProto, err := o.proxyProtocol()
+	if err != nil {
+		log.Errf("[%d] Invalid proxy protocol %q: %v", o.ID, o.ProxyProtocol, err)
+		return nil, err
+	}
+	// plain http requests are sent as is to http proxies (by the transport), the rest is tunneled
+	forward := proxy != nil && !o.https && proxy.Forwards()
 	tr := http.Transport{
 		MaxIdleConns:        o.NumConnections,
 		MaxIdleConnsPerHost: o.NumConnections,
@@ -502,14 +642,25 @@ func NewStdClient(o *HTTPOptions) (*Client, error) {
 		Proxy:               http.ProxyFromEnvironment,
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			// redirect all connections to resolved ip, and use cn as sni host
-			if o.Resolve != "" {
+			if o.Resolve != "" && !forward {
 				addr = o.Resolve + addr[strings.LastIndex(addr, ":"):]
 			}
 			var conn net.Conn
 			now := time.Now()
-			conn, err = (&net.Dialer{
-				Timeout: o.HTTPReqTimeOut,
-			}).DialContext(ctx, network, addr)
+			if proxy != nil && !forward {
+				conn, err = client.dialProxy(ctx, proxy, addr)
+			} else {
+				conn, err = (&net.Dialer{
+					Timeout:   o.HTTPReqTimeOut,
+					LocalAddr: sourceIPs.LocalAddr(network),
+				}).DialContext(ctx, network, addr)
+			}
+			if err == nil {
+				if err = proxyProto.Write(conn, conn.LocalAddr(), conn.RemoteAddr()); err != nil {
+					conn.Close()
+					conn = nil
+				}
+			}
 			client.connectStats.Record(time.Since(now).Seconds())
 			if conn != nil {
 				newRemoteAddress := conn.RemoteAddr().String()
@@ -525,6 +676,12 @@ func NewStdClient(o *HTTPOptions) (*Client, error) {
 		TLSHandshakeTimeout: o.HTTPReqTimeOut,
 	}
 
+	if proxy != nil {
+		tr.Proxy = nil
+		if forward {
+			tr.Proxy = http.ProxyURL(proxy.URL)
+		}
+	}
 	client.client.Transport = &tr
 	client.transport = &tr
 
@@ -543,6 +700,22 @@ func NewStdClient(o *HTTPOptions) (*Client, error) {
 	return &client, nil
 }
 
+// dialProxy connects to the proxy and tunnels to addr through it, timing the tunnel setup.
+func (c *Client) dialProxy(ctx context.Context, proxy *fnet.ProxyDialer, addr string) (net.Conn, error) {
+	conn, err := proxy.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.phases.start(phaseTunnel)
+	if err = proxy.Tunnel(conn, addr); err != nil {
+		c.phases.cancel(phaseTunnel)
+		conn.Close()
+		return nil, err
+	}
+	c.phases.end(phaseTunnel)
+	return conn, nil
+}
+
 // FetchURL fetches the data at the given url using the standard client and default options.
 // Returns the http status code (http.StatusOK == 200 for success) and the data.
 // To be used only for single fetches or when performance doesn't matter as the client is closed at the end.
@@ -583,8 +756,19 @@ type FastClient struct {
 	parseHeaders bool // don't bother in http/1.0
 	halfClose    bool // allow/do half close when keepAlive is false
 	reqTimeout   time.Duration
-	uuidMarkers  [][]byte
+	tmpl         *requestTemplate // when the url, headers or body have {tokens}
+	rnd          *rand.Rand       // for the template values
+	tmplVals     [][]byte         // reused buffers of the template values
+	tmplHead     []byte           // marked request line and headers, when tmpl != nil
+	tmplBody     []byte           // marked body
+	reqBuf       []byte           // reused buffer for the expanded requests
+	bodyBuf      []byte           // reused buffer for the expanded body
+	lastURL      string           // url after {tokens} substitution
+	jar          *cookieJar       // when CookieJar or Cookies are set
+	cookieBuf    []byte           // reused buffer for the requests with cookies
+	errCategory  string           // category of the last transport error
 	logErrors    bool
+	okCodes      *CodeSet
 	id           int
 	https        bool
 	tlsConfig    *tls.Config
@@ -597,6 +781,53 @@ type FastClient struct {
 	connReuse      int
 	reuseCount     int
 	connectStats   *stats.Histogram
+	phases         *phaseTimings
+	sourceIPs      *fnet.SourceIPs     // local IPs to connect from, when set
+	proxyProto     *fnet.ProxyProtocol // PROXY protocol header to send first, when set
+	proxy          *fnet.ProxyDialer   // when connecting through a proxy
+	forward        bool                // plain http requests sent as is to the http proxy
+	tunnelAddr     string              // otherwise the host:port to tunnel to through the proxy
+	bytesSent      int64
+	// StreamBody mode
+	stream       bool
+	streamKeep   int
+	streamBuf    []byte      // for the reads of the discarded body bytes
+	hash         hash.Hash   // when StreamHash is set
+	streamed     int64       // size of the last streamed response
+	streamedBody int64       // and of its body
+	bodyHash     string      // hex sha256 of the last streamed body
+	events       *eventStats // when StreamEvents is set
+	// Pipelining mode
+	pipe    *pipeline // connection shared with the other clients of the pipeline
+	respEnd int       // end of the last (complete) response in the buffer, the rest is the next one's
+}
+
+// LastURL returns the url of the last request, after {tokens} substitution.
+func (c *FastClient) LastURL() string {
+	if c.tmpl != nil && c.tmpl.urlTokens {
+		return c.lastURL
+	}
+	return c.url
+}
+
+// ErrorCategory returns the category of the transport error of the last request, if any.
+func (c *FastClient) ErrorCategory() string {
+	return c.errCategory
+}
+
+// CookieStats returns the number of cookies received and currently in the jar for the url.
+func (c *FastClient) CookieStats() (int64, int) {
+	return c.jar.CookieStats()
+}
+
+// BytesSent returns the number of bytes of the requests written so far.
+func (c *FastClient) BytesSent() int64 {
+	return c.bytesSent
+}
+
+// phaseTimings returns the client's per phase timings.
+func (c *FastClient) phaseTimings() *phaseTimings {
+	return c.phases
 }
 
 // GetIPAddress get ip address that DNS resolved to when using fast client and connection stats.
@@ -607,6 +838,9 @@ func (c *FastClient) GetIPAddress() (*stats.Occurrence, *stats.Histogram) {
 // Close cleans up any resources used by FastClient.
 func (c *FastClient) Close() {
 	log.Debugf("[%d] Closing %p %s socket count %d", c.id, c, c.url, c.socketCount)
+	if c.pipe != nil {
+		c.pipe.close()
+	}
 	if c.socket != nil {
 		if err := c.socket.Close(); err != nil {
 			log.Warnf("[%d] Error closing fast client's socket: %v", c.id, err)
@@ -620,29 +854,22 @@ func (c *FastClient) Close() {
 // the beginning and then reused many times.
 func NewFastClient(o *HTTPOptions) (Fetcher, error) { //nolint:funlen
 	method := o.Method()
-	payloadLen := len(o.Payload)
 	o.Init(o.URL)
+	tmpl, err := o.template()
+	if err != nil {
+		log.Errf("[%d] Invalid request template: %v", o.ID, err)
+		return nil, err
+	}
+	if tmpl != nil {
+		o = tmpl.options(o)
+	}
+	payloadLen := len(o.Payload)
 	proto := "1.1"
 	if o.HTTP10 {
 		proto = "1.0"
 	}
 
-	uuidStrings := []string{}
 	urlString := o.URL
-	for strings.Contains(urlString, uuidToken) {
-		uuidString := generateUUID()
-		uuidStrings = append(uuidStrings, uuidString)
-		urlString = strings.Replace(urlString, uuidToken, uuidString, 1)
-	}
-	payload := string(o.Payload)
-	for strings.Contains(payload, uuidToken) {
-		uuidString := generateUUID()
-		uuidStrings = append(uuidStrings, uuidString)
-		payload = strings.Replace(payload, uuidToken, uuidString, 1)
-	}
-	if len(uuidStrings) > 0 {
-		o.Payload = []byte(payload)
-	}
 	// Parse the url, extract components.
 	url, err := url.Parse(urlString)
 	if err != nil {
@@ -663,11 +890,13 @@ func NewFastClient(o *HTTPOptions) (Fetcher, error) { //nolint:funlen
 	// note: Host includes the port
 	bc := FastClient{
 		url: o.URL, host: url.Host, hostname: url.Hostname(), port: url.Port(),
-		http10: o.HTTP10, halfClose: o.AllowHalfClose, logErrors: o.LogErrors, id: o.ID,
+		http10: o.HTTP10, halfClose: o.AllowHalfClose, logErrors: o.LogErrors, okCodes: o.OKCodes, id: o.ID,
 		https: o.https, connReuseRange: o.ConnReuseRange, connReuse: connReuse,
 		resolve: o.Resolve, noResolveEachConn: o.NoResolveEachConn, ipAddrUsage: stats.NewOccurrence(),
+		tmpl: tmpl,
 		// Keep track of timing for connection (re)establishment.
 		connectStats: stats.NewHistogram(o.Offset.Seconds(), o.Resolution),
+		phases:       newPhaseTimings(o.Offset.Seconds(), o.Resolution),
 	}
 	if o.https {
 		bc.tlsConfig, err = o.TLSOptions.TLSClientConfig()
@@ -676,19 +905,60 @@ func NewFastClient(o *HTTPOptions) (Fetcher, error) { //nolint:funlen
 		}
 	}
 	bc.buffer = make([]byte, BufferSizeKb*1024)
+	if o.StreamBody {
+		if o.HTTP10 {
+			log.Warnf("[%d] Response streaming isn't supported with http/1.0", bc.id)
+		} else {
+			bc.stream = true
+			bc.streamKeep = o.StreamKeep
+			bc.streamBuf = make([]byte, 32*1024)
+			if o.StreamHash {
+				bc.hash = sha256.New()
+			}
+			if o.StreamEvents {
+				bc.events = newEventStats(o.Offset.Seconds(), o.Resolution)
+			}
+		}
+	}
 	if bc.port == "" {
 		bc.port = url.Scheme // ie http which turns into 80 later
 		log.LogVf("[%d] No port specified, using %s", bc.id, bc.port)
 	}
+	if bc.proxy, err = o.TLSOptions.ProxyDialer(o.HTTPReqTimeOut); err != nil {
+		log.Errf("[%d] Invalid proxy %q: %v", bc.id, o.Proxy, err)
+		return nil, err
+	}
+	if bc.sourceIPs, err = o.sourceIPList(); err != nil {
+		log.Errf("[%d] Invalid source ip %q: %v", bc.id, o.SourceIP, err)
+		return nil, err
+	}
+	if bc.proxyProto, err = o.proxyProtocol(); err != nil {
+		log.Errf("[%d] Invalid proxy protocol %q: %v", bc.id, o.ProxyProtocol, err)
+		return nil, err
+	}
 	var addr net.Addr
-	if o.UnixDomainSocket != "" {
+	switch {
+	case bc.proxy != nil:
+		target, err := proxyTarget(bc.hostname, bc.port, o.Resolve)
+		if err != nil {
+			log.Errf("[%d] Invalid port %q: %v", bc.id, bc.port, err)
+			return nil, err
+		}
+		// plain http requests are sent as is to http proxies, the rest is tunneled
+		bc.forward = !bc.https && bc.proxy.Forwards()
+		if !bc.forward {
+			bc.tunnelAddr = target
+		}
+		bc.noResolveEachConn = true // the proxy resolves the host name
+		addr = bc.proxy.Addr(target)
+	case o.UnixDomainSocket != "":
 		log.Infof("[%d] Using unix domain socket %v instead of %v %v", bc.id, o.UnixDomainSocket, bc.hostname, bc.port)
 		uds := &net.UnixAddr{Name: o.UnixDomainSocket, Net: fnet.UnixDomainSocket}
 		addr = uds
-	} else {
+	default:
 		var tAddr *net.TCPAddr // strangely we get a non nil wrap of nil if assigning to addr directly
 		var err error
-		tAddr, err = resolve(bc.hostname, bc.port, o.Resolve, bc.ipAddrUsage)
+		tAddr, err = bc.resolveDest()
 		if tAddr == nil {
 			// Error already logged
 			return nil, err
@@ -706,7 +976,11 @@ func NewFastClient(o *HTTPOptions) (Fetcher, error) { //nolint:funlen
 		bc.tlsConfig.ServerName = bc.hostname // Shouldn't have a port #571
 	}
 	var buf bytes.Buffer
-	buf.WriteString(method + " " + url.RequestURI() + " HTTP/" + proto + "\r\n")
+	requestTarget := url.RequestURI()
+	if bc.forward {
+		requestTarget = url.Scheme + "://" + url.Host + requestTarget // absolute-form for the proxy
+	}
+	buf.WriteString(method + " " + requestTarget + " HTTP/" + proto + "\r\n")
 	if !bc.http10 || customHostHeader {
 		buf.WriteString("Host: " + host + "\r\n")
 	}
@@ -724,17 +998,24 @@ func NewFastClient(o *HTTPOptions) (Fetcher, error) { //nolint:funlen
 	// This writes multiple valued headers properly (unlike calling Get() to do it ourselves)
 	_ = o.GenerateHeaders().Write(w)
 	w.Flush()
+	if bc.forward {
+		if auth := bc.proxy.ProxyAuthorization(); auth != "" {
+			buf.WriteString("Proxy-Authorization: " + auth + "\r\n")
+		}
+	}
 	buf.WriteString("\r\n")
 	// Add the payload to http body
 	if payloadLen > 0 {
 		buf.Write(o.Payload)
 	}
 	bc.req = buf.Bytes()
-	bc.uuidMarkers = [][]byte{}
-	if len(uuidStrings) > 0 {
-		for _, uuidString := range uuidStrings {
-			bc.uuidMarkers = append(bc.uuidMarkers, []byte(uuidString))
-		}
+	if bc.jar, err = newCookieJar(o); err != nil {
+		return nil, err
+	}
+	if tmpl != nil {
+		bc.rnd = newTemplateRand(o.ID)
+		bc.tmplHead = bc.req[:len(bc.req)-payloadLen]
+		bc.tmplBody = bc.req[len(bc.req)-payloadLen:]
 	}
 	log.Debugf("[%d] Created client:\n%+v\n%s", bc.id, bc.dest, bc.req)
 	return &bc, nil
@@ -753,35 +1034,97 @@ func (c *FastClient) connect() net.Conn {
 
 	// Resolve the DNS name when making new connections.
 	if c.socketCount > 1 && !c.noResolveEachConn {
-		c.dest, err = resolve(c.hostname, c.port, c.resolve, c.ipAddrUsage)
+		c.dest, err = c.resolveDest()
 		log.Debugf("[%d] Hostname %v resolve to ip %v", c.id, c.hostname, c.dest)
 		if err != nil {
 			log.Errf("[%d] Unable to resolve hostname %v: %v", c.id, c.hostname, err)
+			c.errCategory = fnet.ErrDNS
 			return nil
 		}
 	}
 
-	d := &net.Dialer{Timeout: c.reqTimeout}
 	now := time.Now()
+	c.phases.start(phaseConnect)
+	if c.proxy != nil {
+		socket, err = c.proxy.Connect(context.Background())
+	} else {
+		d := &net.Dialer{Timeout: c.reqTimeout, LocalAddr: c.sourceIPs.LocalAddr(c.dest.Network())}
+		socket, err = d.Dial(c.dest.Network(), c.dest.String())
+	}
+	if err != nil {
+		c.connectStats.Record(time.Since(now).Seconds())
+		c.phases.cancel(phaseConnect)
+		log.Errf("[%d] Unable to connect to %v : %v", c.id, c.dest, err)
+		c.errCategory = fnet.ErrorCategory(err)
+		return nil
+	}
+	c.phases.end(phaseConnect)
+	if c.proxy != nil {
+		c.ipAddrUsage.Record(socket.RemoteAddr().String())
+	}
+	if c.tunnelAddr != "" {
+		c.phases.start(phaseTunnel)
+		if err = c.proxy.Tunnel(socket, c.tunnelAddr); err != nil {
+			c.connectStats.Record(time.Since(now).Seconds())
+			c.phases.cancel(phaseTunnel)
+			socket.Close()
+			log.Errf("[%d] Unable to tunnel to %v : %v", c.id, c.dest, err)
+			c.errCategory = fnet.ErrorCategory(err)
+			return nil
+		}
+		c.phases.end(phaseTunnel)
+	}
+	if err = c.proxyProto.Write(socket, socket.LocalAddr(), socket.RemoteAddr()); err != nil {
+		c.connectStats.Record(time.Since(now).Seconds())
+		socket.Close()
+		log.Errf("[%d] Unable to send the PROXY protocol header to %v : %v", c.id, c.dest, err)
+		c.errCategory = fnet.ErrorCategory(err)
+		return nil
+	}
 	if c.https {
-		socket, err = tls.DialWithDialer(d, c.dest.Network(), c.dest.String(), c.tlsConfig)
+		// handshake separately from the connection, for the TLS phase timing
+		c.phases.start(phaseTLS)
+		tlsConn := tls.Client(socket, c.tlsConfig)
+		_ = tlsConn.SetDeadline(now.Add(c.reqTimeout))
+		err = tlsConn.Handshake()
 		c.connectStats.Record(time.Since(now).Seconds())
 		if err != nil {
+			c.phases.cancel(phaseTLS)
+			socket.Close()
 			log.Errf("[%d] Unable to TLS connect to %v : %v", c.id, c.dest, err)
+			c.errCategory = fnet.ErrorCategory(err)
+			if c.errCategory == fnet.ErrOther || c.errCategory == fnet.ErrShortRead || c.errCategory == fnet.ErrReset {
+				c.errCategory = fnet.ErrTLS // failed during the handshake
+			}
 			return nil
 		}
+		c.phases.tlsHandshakeDone(tlsConn.ConnectionState().DidResume)
+		_ = tlsConn.SetDeadline(time.Time{})
+		socket = tlsConn
 	} else {
-		socket, err = d.Dial(c.dest.Network(), c.dest.String())
 		c.connectStats.Record(time.Since(now).Seconds())
-		if err != nil {
-			log.Errf("[%d] Unable to connect to %v : %v", c.id, c.dest, err)
-			return nil
-		}
 	}
 	fnet.SetSocketBuffers(socket, len(c.buffer), len(c.req))
 	return socket
 }
 
+// resolveDest resolves the destination address, timing the DNS phase when it's an actual DNS lookup.
+func (c *FastClient) resolveDest() (*net.TCPAddr, error) {
+	dnsLookup := c.resolve == "" && net.ParseIP(c.hostname) == nil
+	if dnsLookup {
+		c.phases.start(phaseDNS)
+	}
+	addr, err := resolve(c.hostname, c.port, c.resolve, c.ipAddrUsage)
+	if dnsLookup {
+		if err != nil {
+			c.phases.cancel(phaseDNS)
+		} else {
+			c.phases.end(phaseDNS)
+		}
+	}
+	return addr, err
+}
+
 // Extra error codes outside of the HTTP Status code ranges. ie negative.
 const (
 	// SocketError is return when a transport error occurred: unexpected EOF, connection error, etc...
@@ -792,9 +1135,29 @@ const (
 
 // Fetch fetches the url content. Returns http code, data, offset of body.
 func (c *FastClient) Fetch() (int, []byte, int) {
+	code, data, headerLen := c.fetch()
+	if c.jar != nil && headerLen > 0 {
+		c.jar.updateFromHeaders(data[:headerLen])
+	}
+	return code, data, headerLen
+}
+
+func (c *FastClient) fetch() (int, []byte, int) {
 	c.code = SocketError
 	c.size = 0
 	c.headerLen = 0
+	c.errCategory = ""
+	c.streamed, c.streamedBody = 0, 0
+	c.bodyHash = ""
+	req, err := c.request()
+	if err != nil {
+		log.Errf("[%d] Unable to expand the request template: %v", c.id, err)
+		c.errCategory = fnet.ErrOther
+		return c.returnRes()
+	}
+	if c.pipe != nil {
+		return c.pipelineFetch(req)
+	}
 	// Connect or reuse existing socket:
 	conn := c.socket
 	canReuse := conn != nil
@@ -817,33 +1180,38 @@ func (c *FastClient) Fetch() (int, []byte, int) {
 	c.socket = nil // because of error returns and single retry
 	conErr := conn.SetDeadline(time.Now().Add(c.reqTimeout))
 	// Send the request:
-	req := c.req
-	if len(c.uuidMarkers) > 0 {
-		for _, uuidMarker := range c.uuidMarkers {
-			req = bytes.Replace(req, uuidMarker, []byte(generateUUID()), 1)
-		}
-	}
 	n, err := conn.Write(req)
+	c.bytesSent += int64(n)
 	if err != nil || conErr != nil {
 		if canReuse {
 			// it's ok for the (idle) socket to die once, auto reconnect:
 			log.Infof("[%d] Closing dead socket %v (%v)", c.id, c.dest, err)
 			conn.Close()
 			c.errorCount++
-			return c.Fetch() // recurse once
+			return c.fetch() // recurse once
 		}
 		log.Errf("[%d] Unable to write to %v : %v", c.id, c.dest, err)
+		if err == nil {
+			err = conErr
+		}
+		c.errCategory = fnet.ErrorCategory(err)
 		return c.returnRes()
 	}
-	if n != len(c.req) {
-		log.Errf("[%d] Short write to %v : %d instead of %d", c.id, c.dest, n, len(c.req))
+	if n != len(req) {
+		log.Errf("[%d] Short write to %v : %d instead of %d", c.id, c.dest, n, len(req))
+		c.errCategory = fnet.ErrOther
 		return c.returnRes()
 	}
+	c.phases.start(phaseTTFB)
+	if c.events != nil {
+		c.events.sent = time.Now()
+	}
 	if !c.keepAlive && c.halfClose { //nolint:nestif
 		tcpConn, ok := conn.(*net.TCPConn)
 		if ok {
 			if err = tcpConn.CloseWrite(); err != nil {
 				log.Errf("[%d] Unable to close write to %v : %v", c.id, c.dest, err)
+				c.errCategory = fnet.ErrorCategory(err)
 				return c.returnRes()
 			} // else:
 			log.Debugf("[%d] Half closed ok after sending request %v", c.id, c.dest)
@@ -853,17 +1221,74 @@ func (c *FastClient) Fetch() (int, []byte, int) {
 	}
 	// Read the response:
 	c.readResponse(conn, canReuse)
+	if c.code > 0 {
+		c.phases.end(phaseTransfer)
+	} else {
+		c.phases.cancel(phaseTTFB)
+		c.phases.cancel(phaseTransfer)
+	}
 	if c.code == RetryOnce {
 		// Special "eof on reused socket" code
-		return c.Fetch() // recurse once
+		return c.fetch() // recurse once
 	}
 	// Return the result:
 	return c.returnRes()
 }
 
-func codeIsOK(code int) bool {
-	// TODO: make this configurable
-	return (code >= 200 && code <= 299) || code == http.StatusTeapot
+// request returns the request to send: the {tokens} expanded, when templated, and the cookies added.
+func (c *FastClient) request() ([]byte, error) {
+	req := c.req
+	if c.tmpl != nil {
+		c.tmplVals = c.tmpl.values(c.tmplVals, c.rnd, c.id)
+		var err error
+		if c.bodyBuf, err = c.tmpl.expand(c.bodyBuf[:0], c.tmplBody, c.tmplVals, 0); err != nil {
+			return nil, err
+		}
+		if c.reqBuf, err = c.tmpl.expand(c.reqBuf[:0], c.tmplHead, c.tmplVals, len(c.bodyBuf)); err != nil {
+			return nil, err
+		}
+		c.reqBuf = append(c.reqBuf, c.bodyBuf...)
+		req = c.reqBuf
+		if c.tmpl.urlTokens {
+			if c.lastURL, err = c.tmpl.expandString(c.url, c.tmplVals); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.jar != nil {
+		if withCookies := c.jar.addCookieHeader(c.cookieBuf[:0], req); withCookies != nil {
+			c.cookieBuf = withCookies
+			req = withCookies
+		}
+	}
+	return req, nil
+}
+
+// codeIsOK returns whether code is in the okCodes set or, when not set, a 2xx (or 418).
+func codeIsOK(okCodes *CodeSet, code int) bool {
+	if okCodes != nil {
+		return okCodes.Contains(code)
+	}
+	return keepAliveCode(nil, code)
+}
+
+// keepAliveCode returns whether the fast client reads the whole response with that
+// code and keeps its connection: a 2xx (or 418) or one of the okCodes.
+func keepAliveCode(okCodes *CodeSet, code int) bool {
+	return (code >= 200 && code <= 299) || code == http.StatusTeapot || (okCodes != nil && okCodes.Contains(code))
+}
+
+// IsSuccess returns whether code counts as a successful call: one of the
+// OKCodes or, when not set, 200.
+func (h *HTTPOptions) IsSuccess(code int) bool {
+	return codeIsSuccess(h.OKCodes, code)
+}
+
+func codeIsSuccess(okCodes *CodeSet, code int) bool {
+	if okCodes != nil {
+		return okCodes.Contains(code)
+	}
+	return code == http.StatusOK
 }
 
 // Response reading:
@@ -877,8 +1302,10 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 	endofHeadersStart := retcodeOffset + 3
 	keepAlive := c.keepAlive
 	chunkedMode := false
-	checkConnectionClosedHeader := CheckConnectionClosedHeader
-	skipRead := false
+	checkConnectionClosedHeader := CheckConnectionClosedHeader || c.pipe != nil
+	closing := false
+	c.respEnd = 0
+	skipRead := c.size > 0 // pipelined response(s) already read along with the previous one
 	for {
 		// Ugly way to cover the case where we get more than 1 chunk at the end
 		// TODO: need automated tests
@@ -902,9 +1329,14 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 				}
 				log.Errf("[%d] Read error for %v %d : %v", c.id, c.dest, c.size, err)
 				c.code = SocketError
+				c.errCategory = fnet.ErrorCategory(err)
 				break
 			}
 			c.size += n
+			if c.size == n {
+				c.phases.end(phaseTTFB)
+				c.phases.start(phaseTransfer)
+			}
 			if log.LogDebug() {
 				log.Debugf("[%d] Read ok %d total %d so far (-%d headers = %d data) %s",
 					c.id, n, c.size, c.headerLen, c.size-c.headerLen, DebugSummary(c.buffer[c.size-n:c.size], 256))
@@ -916,13 +1348,14 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 		if !parsedHeaders && c.parseHeaders && c.size >= retcodeOffset+3 {
 			// even if the bytes are garbage we'll get a non 200 code (bytes are unsigned)
 			c.code = ParseDecimal(c.buffer[retcodeOffset : retcodeOffset+3]) // TODO do that only once...
-			// TODO handle 100 Continue, make the "ok" codes configurable
-			if !codeIsOK(c.code) {
-				if c.logErrors {
-					log.Warnf("[%d] Non ok http code %d (%v)", c.id, c.code, string(c.buffer[:retcodeOffset+3]))
-				}
-				break
+			// TODO handle 100 Continue
+			if c.logErrors && !codeIsOK(c.okCodes, c.code) {
+				log.Warnf("[%d] Non ok http code %d (%v)", c.id, c.code, string(c.buffer[:retcodeOffset+3]))
 			}
+			readAll := keepAliveCode(c.okCodes, c.code) || c.pipe != nil
+			if !readAll && c.jar == nil {
+				break
+			} // else read the whole response, to keep the (pipelined) connection, or the headers for the cookies
 			if log.LogDebug() {
 				log.Debugf("[%d] Code %d, looking for end of headers at %d / %d, last CRLF %d",
 					c.id, c.code, endofHeadersStart, c.size, c.headerLen)
@@ -947,6 +1380,13 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 				if log.LogDebug() {
 					log.Debugf("[%d] headers are %d: %q", c.id, c.headerLen, c.buffer[:idx])
 				}
+				if !readAll {
+					break // only needed the Set-Cookie headers
+				}
+				if c.stream {
+					keepAlive = c.streamBody(conn, keepAlive)
+					break
+				}
 				// Find the content length or chunked mode
 				if keepAlive {
 					var contentLength int
@@ -960,6 +1400,7 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 							break
 						}
 						max = c.headerLen + contentLength
+						c.respEnd = max
 						if log.LogDebug() { // somehow without the if we spend 400ms/10s in LogV (!)
 							log.Debugf("[%d] found content length %d", c.id, contentLength)
 						}
@@ -994,12 +1435,17 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 							c.id, c.headerLen, contentLength, (c.headerLen+contentLength)/1024+1)
 						// TODO: just consume the extra instead
 						max = len(c.buffer)
+						c.respEnd = 0
 					}
 					if checkConnectionClosedHeader {
 						if found, _ := FoldFind(c.buffer[:c.headerLen], connectionCloseHeader); found {
 							log.Infof("[%d] Server wants to close connection, no keep-alive!", c.id)
-							keepAlive = false
-							max = len(c.buffer) // reset to read as much as available
+							if c.pipe != nil {
+								closing = true // after reading this response, the next ones won't come
+							} else {
+								keepAlive = false
+								max = len(c.buffer) // reset to read as much as available
+							}
 						}
 					}
 				}
@@ -1025,9 +1471,14 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 					continue
 				} else if nextChunkLen == 0 {
 					log.Debugf("[%d] Found last chunk %d %d", c.id, max+dataStart, c.size)
-					if c.size != max+dataStart+2 || string(c.buffer[c.size-2:c.size]) != "\r\n" {
+					end := max + dataStart + 2
+					if c.pipe != nil && c.size >= end {
+						c.respEnd = end // the rest is the next pipelined response(s)
+					} else if c.pipe != nil && end <= len(c.buffer) {
+						continue // final CRLF not read yet
+					} else if c.size != end || string(c.buffer[c.size-2:c.size]) != "\r\n" {
 						log.Errf("[%d] Unexpected mismatch at the end sz=%d expected %d; end of buffer %q",
-							c.id, c.size, max+dataStart+2, c.buffer[max:c.size])
+							c.id, c.size, end, c.buffer[max:c.size])
 					}
 				} else {
 					max += dataStart + nextChunkLen + 2 // extra CR LF
@@ -1047,7 +1498,13 @@ func (c *FastClient) readResponse(conn net.Conn, reusedSocket bool) {
 		}
 	} // end of big for loop
 	// Figure out whether to keep or close the socket:
-	if keepAlive && codeIsOK(c.code) && !c.reachedReuseThreshold() {
+	if c.pipe != nil {
+		// whatever the status, when the response was fully read
+		keepAlive = keepAlive && !closing && c.respEnd > 0 && c.code > 0
+	} else {
+		keepAlive = keepAlive && keepAliveCode(c.okCodes, c.code)
+	}
+	if keepAlive && !c.reachedReuseThreshold() {
 		c.socket = conn // keep the open socket
 	} else {
 		if err := conn.Close(); err != nil {
@@ -1070,11 +1527,6 @@ func (c *FastClient) reachedReuseThreshold() bool {
 	return false
 }
 
-func generateUUID() string {
-	// We use math random instead of crypto random generator due to performance.
-	return uuid.Must(uuid.NewRandomFromReader(rander)).String()
-}
-
 // Generate reuse threshold based on the min and max value in the flag.
 func generateReuseThreshold(min int, max int) int {
 	if min == max {
@@ -1084,6 +1536,19 @@ func generateReuseThreshold(min int, max int) int {
 	return min + rand.Intn(max-min+1) //nolint:gosec // we want fast not crypto
 }
 
+// proxyTarget returns the host:port to ask the proxy to connect to: the host name (resolved
+// by the proxy) or the override IP, with the numerical port (port can be a scheme like https).
+func proxyTarget(hostname string, port string, overrideIP string) (string, error) {
+	p, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return "", err
+	}
+	if overrideIP != "" {
+		hostname = overrideIP
+	}
+	return net.JoinHostPort(hostname, strconv.Itoa(p)), nil
+}
+
 // Resolve the DNS hostname to ip address or assign the override IP.
 func resolve(hostname string, port string, overrideIP string, ipAddrUsage *stats.Occurrence) (*net.TCPAddr, error) {
 	var addr *net.TCPAddr
@@ -17,6 +17,8 @@ package fhttp // import "fortio.org/fortio/fhttp"
 // pprof import to get /debug/pprof endpoints on a mux through SetupPPROF.
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
@@ -36,6 +38,8 @@ import (
 	"fortio.org/fortio/jrpc"
 	"fortio.org/fortio/log"
 	"fortio.org/fortio/version"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 )
@@ -52,14 +56,24 @@ var (
 		"Default parameters/querystring to use if there isn't one provided explicitly. E.g \"status=404&delay=3s\"")
 	fetch2CopiesAllHeader = dflag.DynBool(flag.CommandLine, "proxy-all-headers", true,
 		"Determines if only tracing or all headers (and cookies) are copied from request on the fetch2 ui/server endpoint")
-	serverIdleTimeout = dflag.DynDuration(flag.CommandLine, "server-idle-timeout", 30*time.Second, "Default IdleTimeout for servers")
+	serverIdleTimeout   = dflag.DynDuration(flag.CommandLine, "server-idle-timeout", 30*time.Second, "Default IdleTimeout for servers")
+	serverProxyProtocol = dflag.DynBool(flag.CommandLine, "server-proxy-protocol", false,
+		"Parse the PROXY protocol (v1 or v2) header, when present, of the http servers' new connections (shown by the debug handler)")
 )
 
+// proxyConnKey is the request context key of the connection, when it can have a PROXY protocol header.
+type proxyConnKey struct{}
+
 // EchoHandler is an http server handler echoing back the input.
+// Websocket upgrade requests are handled by WebSocketEchoHandler.
 func EchoHandler(w http.ResponseWriter, r *http.Request) {
 	if log.LogVerbose() {
 		LogRequest(r, "Echo") // will also print headers
 	}
+	if IsWebSocketUpgrade(r) {
+		WebSocketEchoHandler(w, r)
+		return
+	}
 	defaultParams := defaultEchoServerParams.Get()
 	hasQuestionMark := strings.Contains(r.RequestURI, "?")
 	if !hasQuestionMark && len(defaultParams) > 0 {
@@ -96,6 +110,10 @@ func EchoHandler(w http.ResponseWriter, r *http.Request) {
 		defer gwz.Close()
 		w = gwz
 	}
+	if events := r.FormValue("events"); events != "" {
+		writeEvents(w, r, status, events)
+		return
+	}
 	size := generateSize(r.FormValue("size"))
 	if size >= 0 {
 		log.LogVf("Writing %d size with %d status", size, status)
@@ -191,13 +209,19 @@ func HTTPServerWithHandler(name string, port string, hdlr http.Handler) net.Addr
 		ReadHeaderTimeout: serverIdleTimeout.Get(),
 		IdleTimeout:       serverIdleTimeout.Get(),
 		Handler:           h2c.NewHandler(hdlr, h2s),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if pc, ok := c.(*fnet.ProxyProtocolConn); ok {
+				return context.WithValue(ctx, proxyConnKey{}, pc)
+			}
+			return ctx
+		},
 	}
 	listener, addr := fnet.Listen(name, port)
 	if listener == nil {
 		return nil // error already logged
 	}
 	go func() {
-		err := s.Serve(listener)
+		err := s.Serve(fnet.NewProxyProtocolListener(listener, serverProxyProtocol.Get))
 		if err != nil {
 			log.Fatalf("Unable to serve %s on %s: %v", name, addr.String(), err)
 		}
@@ -287,6 +311,13 @@ func DebugHandler(w http.ResponseWriter, r *http.Request) {
 	buf.WriteString(hostname)
 	buf.WriteString(" - request from ")
 	buf.WriteString(r.RemoteAddr)
+	if pc, ok := r.Context().Value(proxyConnKey{}).(*fnet.ProxyProtocolConn); ok {
+		if h := pc.ProxyHeader(); h != nil {
+			buf.WriteString(" (PROXY ")
+			buf.WriteString(h.String())
+			buf.WriteString(")")
+		}
+	}
 	buf.WriteString("\n\n")
 	buf.WriteString(r.Method)
 	buf.WriteByte(' ')
@@ -384,6 +415,48 @@ func Serve(port, debugPath string) (*http.ServeMux, net.Addr) {
 	return mux, addr
 }
 
+// HTTP3Server creates an http/3 server, named name, on (udp) address/port port
+// using the cert and key files for TLS (mandatory for QUIC). Port can include
+// binding address and/or be port 0. Returns nil on error (already logged).
+func HTTP3Server(name, port, cert, key string, hdlr http.Handler) net.Addr {
+	if cert == "" || key == "" {
+		log.Critf("%s server needs a -cert and -key for TLS", name)
+		return nil
+	}
+	c, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		log.Critf("Unable to load %s server cert and key %s %s: %v", name, cert, key, err)
+		return nil
+	}
+	udpConn, addr := fnet.UDPListen(name, port)
+	if udpConn == nil {
+		return nil // error already logged
+	}
+	s := &http3.Server{
+		Handler:    hdlr,
+		TLSConfig:  http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{c}, MinVersion: tls.VersionTLS13}),
+		QuicConfig: &quic.Config{Allow0RTT: true, MaxIdleTimeout: serverIdleTimeout.Get()},
+	}
+	go func() {
+		err := s.Serve(udpConn)
+		if err != nil {
+			log.Fatalf("Unable to serve %s on %s: %v", name, addr.String(), err)
+		}
+	}()
+	return addr
+}
+
+// ServeHTTP3 starts the debug / echo handlers of Serve() on an http/3 server.
+func ServeHTTP3(port, debugPath, cert, key string) net.Addr {
+	mux := http.NewServeMux()
+	if debugPath != "" {
+		mux.Handle(debugPath, Gzip(http.HandlerFunc(DebugHandler)))
+		mux.HandleFunc(EchoDebugPath(debugPath), EchoHandler)
+	}
+	mux.HandleFunc("/", EchoHandler)
+	return HTTP3Server("http3-echo", port, cert, key, mux)
+}
+
 // ServeTCP is Serve() but restricted to TCP (return address is assumed
 // to be TCP - will panic for unix domain).
 func ServeTCP(port, debugPath string) (*http.ServeMux, *net.TCPAddr) {
@@ -16,6 +16,9 @@ package fhttp
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,6 +27,8 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -995,6 +1000,33 @@ func TestManyUUIDsFastClient(t *testing.T) {
 	}
 }
 
+func TestLastURLUUIDs(t *testing.T) {
+	m, a := DynamicHTTPServer(false)
+	m.HandleFunc("/", ValidateManyUUID)
+	url := fmt.Sprintf("http://localhost:%d/{uuid}?uuid={uuid}", a.Port)
+	for _, std := range []bool{false, true} {
+		o := HTTPOptions{URL: url, DisableFastClient: std, Payload: []byte("{uuid}")}
+		client, _ := NewClient(&o)
+		seen := map[string]bool{}
+		for j := 0; j < 3; j++ {
+			code, _, _ := client.Fetch()
+			if code != 200 {
+				t.Errorf("Got %d instead of 200", code)
+			}
+			last := client.LastURL()
+			if strings.Contains(last, uuidToken) || seen[last] {
+				t.Errorf("Std %v: LastURL %q should have new uuids substituted", std, last)
+			}
+			seen[last] = true
+			// Check that a body uuid didn't bleed into the url:
+			if len(last) != len(url)+2*(36-len(uuidToken)) {
+				t.Errorf("Std %v: unexpected LastURL %q", std, last)
+			}
+		}
+		client.Close()
+	}
+}
+
 func TestBadUUIDFastClient(t *testing.T) {
 	m, a := DynamicHTTPServer(false)
 	m.HandleFunc("/", ValidateUUIDPath)
@@ -1152,6 +1184,48 @@ func TestDebugHandlerSortedHeaders(t *testing.T) {
 	}
 }
 
+func TestDebugHandlerProxyProtocol(t *testing.T) {
+	_ = serverProxyProtocol.Set("true")
+	defer func() { _ = serverProxyProtocol.Set("false") }()
+	m, a := DynamicHTTPServer(false)
+	m.HandleFunc("/debug", DebugHandler)
+	url := fmt.Sprintf("http://127.0.0.1:%d/debug", a.Port)
+	tests := []struct {
+		std      bool
+		version  string
+		source   string
+		expected string
+	}{
+		{false, "v1", "", " (PROXY v1 127.0.0.1:"},
+		{false, "v2", "10.1.2.3", " (PROXY v2 10.1.2.3:"},
+		{true, "v1", "10.1.2.4", " (PROXY v1 10.1.2.4:"},
+		{true, "v2", "", " (PROXY v2 127.0.0.1:"},
+		{false, "", "", ""},
+	}
+	for _, tst := range tests {
+		o := NewHTTPOptions(url)
+		o.DisableFastClient = tst.std
+		o.ProxyProtocol = tst.version
+		o.ProxyProtocolSource = tst.source
+		client, err := NewClient(o)
+		if err != nil {
+			t.Fatalf("Unable to create the client: %v", err)
+		}
+		code, data, header := client.Fetch()
+		client.Close()
+		firstLine := string(data[header:])
+		firstLine = firstLine[:strings.Index(firstLine, "\n")]
+		if code != http.StatusOK || !strings.Contains(firstLine, tst.expected) || (tst.expected == "" && strings.Contains(firstLine, "PROXY")) {
+			t.Errorf("Std %t %s: got %d %q, expected %q", tst.std, tst.version, code, firstLine, tst.expected)
+		}
+	}
+	o := NewHTTPOptions(url)
+	o.ProxyProtocol = "v3"
+	if _, err := NewClient(o); err == nil {
+		t.Errorf("Expected an error for the invalid proxy protocol version")
+	}
+}
+
 func TestEchoHeaders(t *testing.T) {
 	_, a := ServeTCP("0", "")
 	headers := []struct {
@@ -1715,3 +1789,335 @@ func BenchmarkFoldFind(b *testing.B) {
 }
 
 // -- end of benchmark tests / end of this file
+
+func h2TestHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Proto", r.Proto)
+	_, _ = w.Write([]byte("hello " + r.URL.Path))
+}
+
+func TestH2Client(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/h2/", h2TestHandler)
+	mux.HandleFunc("/echo/", EchoHandler)
+	// h2c with prior knowledge:
+	o := HTTPOptions{URL: fmt.Sprintf("http://localhost:%d/h2/{uuid}", addr.Port), H2: true}
+	cli, err := NewClient(&o)
+	if err != nil {
+		t.Fatalf("h2c client error %v", err)
+	}
+	if _, ok := cli.(*H2Client); !ok {
+		t.Fatalf("expected H2Client, got %T", cli)
+	}
+	for i := 0; i < 3; i++ {
+		code, data, header := cli.Fetch()
+		if code != http.StatusOK || !bytes.HasPrefix(data, []byte("HTTP/2.0 200 OK\r\n")) ||
+			!bytes.Contains(data[:header], []byte("X-Proto: HTTP/2.0\r\n")) {
+			t.Errorf("unexpected h2c result %d %d %q", code, header, data)
+		}
+		if body := string(data[header:]); body != "hello /h2/"+cli.LastURL()[len(o.URL)-len("{uuid}"):] {
+			t.Errorf("unexpected h2c body %q for %s", body, cli.LastURL())
+		}
+	}
+	stream := cli.(*H2Client).NewStream(1)
+	if code, _, _ := stream.Fetch(); code != http.StatusOK {
+		t.Errorf("unexpected stream code %d", code)
+	}
+	ipCount, connStats := cli.GetIPAddress()
+	ipMap := map[string]int{}
+	ipCount.PrintAndAggregate(ipMap)
+	if connStats.Count != 1 || getIPUsageCount(ipMap) != 1 {
+		t.Errorf("expected a single connection for all the requests and streams, got %d %v", connStats.Count, ipMap)
+	}
+	if _, connStats = stream.GetIPAddress(); connStats.Count != 0 {
+		t.Errorf("connections should only be reported by the first stream, got %d", connStats.Count)
+	}
+	cli.Close()
+	// body bigger than the buffer:
+	o = HTTPOptions{URL: fmt.Sprintf("http://localhost:%d/echo/?size=%d", addr.Port, BufferSizeKb*1024+1000), H2: true}
+	cli, _ = NewClient(&o)
+	code, data, header := cli.Fetch()
+	if code != http.StatusOK || len(data) != BufferSizeKb*1024 {
+		t.Errorf("expected a full buffer for the big body, got %d %d", code, len(data))
+	}
+	if dropped := cli.(*H2Client).DroppedBytes(); dropped != int64(1000+header) {
+		t.Errorf("expected %d dropped bytes, got %d", 1000+header, dropped)
+	}
+	if code, _, _ = cli.Fetch(); code != http.StatusOK {
+		t.Errorf("connection should still be usable after dropping a body, got %d", code)
+	}
+	cli.Close()
+	o = HTTPOptions{URL: fmt.Sprintf("http://localhost:%d/", addr.Port), H2: true, ConnReuseRange: [2]int{5, 10}}
+	if _, err = NewClient(&o); err == nil {
+		t.Errorf("expected -connection-reuse to be rejected with -h2")
+	}
+	// h2 negotiated with alpn:
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(h2TestHandler))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	o = HTTPOptions{URL: ts.URL + "/foo", H2: true}
+	o.Insecure = true
+	cli, err = NewClient(&o)
+	if err != nil {
+		t.Fatalf("h2 client error %v", err)
+	}
+	code, data, header = cli.Fetch()
+	if code != http.StatusOK || string(data[header:]) != "hello /foo" || !bytes.Contains(data, []byte("X-Proto: HTTP/2.0")) {
+		t.Errorf("unexpected h2 result %d %q", code, data)
+	}
+	cli.Close()
+	// https server without h2:
+	ts1 := httptest.NewTLSServer(http.HandlerFunc(h2TestHandler))
+	defer ts1.Close()
+	o = HTTPOptions{URL: ts1.URL, H2: true}
+	o.Insecure = true
+	cli, _ = NewClient(&o)
+	if code, _, _ = cli.Fetch(); code != SocketError || cli.ErrorCategory() != fnet.ErrTLS {
+		t.Errorf("expected tls socket error without h2 alpn, got %d %q", code, cli.ErrorCategory())
+	}
+	cli.Close()
+}
+
+func TestH3Client(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h2TestHandler)
+	addr := HTTP3Server("test-h3", "localhost:0", "../cert-tmp/server.crt", "../cert-tmp/server.key", mux)
+	if addr == nil {
+		t.Fatalf("unable to start h3 server")
+	}
+	port := addr.(*net.UDPAddr).Port
+	o := HTTPOptions{URL: fmt.Sprintf("https://localhost:%d/h3/{uuid}", port), H3: true}
+	o.CACert = "../cert-tmp/ca.crt"
+	cli, err := NewClient(&o)
+	if err != nil {
+		t.Fatalf("h3 client error %v", err)
+	}
+	if _, ok := cli.(*H3Client); !ok {
+		t.Fatalf("expected H3Client, got %T", cli)
+	}
+	for i := 0; i < 3; i++ {
+		code, data, header := cli.Fetch()
+		if code != http.StatusOK || !bytes.HasPrefix(data, []byte("HTTP/3.0 200 OK\r\n")) ||
+			!bytes.Contains(data[:header], []byte("X-Proto: HTTP/3.0\r\n")) {
+			t.Errorf("unexpected h3 result %d %d %q", code, header, data)
+		}
+	}
+	if m := cli.(*H3Client).req.Method; m != http.MethodGet {
+		t.Errorf("the request method should stay GET, got %q", m)
+	}
+	stream := cli.(*H3Client).NewStream(1)
+	if code, _, _ := stream.Fetch(); code != http.StatusOK {
+		t.Errorf("unexpected stream code %d", code)
+	}
+	_, connStats := cli.GetIPAddress()
+	handshakes, _ := cli.(*H3Client).HandshakeStats()
+	if connStats.Count != 1 || handshakes.Count != 1 {
+		t.Errorf("expected a single connection and handshake, got %d %d", connStats.Count, handshakes.Count)
+	}
+	if handshakes, _ = stream.HandshakeStats(); handshakes.Count != 0 {
+		t.Errorf("handshakes should only be reported by the first stream, got %d", handshakes.Count)
+	}
+	cli.Close()
+	// http/3 is https only:
+	o = HTTPOptions{URL: fmt.Sprintf("http://localhost:%d/", port), H3: true}
+	if _, err = NewClient(&o); err == nil {
+		t.Errorf("expected error for http:// h3 url")
+	}
+}
+
+func TestTLSClientConfigOptions(t *testing.T) {
+	to := TLSOptions{
+		MinTLSVersion: "1.0", MaxTLSVersion: "tls1.2", CipherSuites: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, ",
+		Curves: "x25519,P-256", ALPN: "http/1.1", SessionResumption: true,
+	}
+	cfg, err := to.TLSClientConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS10 || cfg.MaxVersion != tls.VersionTLS12 ||
+		!reflect.DeepEqual(cfg.CipherSuites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}) ||
+		!reflect.DeepEqual(cfg.CurvePreferences, []tls.CurveID{tls.X25519, tls.CurveP256}) ||
+		!reflect.DeepEqual(cfg.NextProtos, []string{"http/1.1"}) || cfg.ClientSessionCache == nil {
+		t.Errorf("Unexpected tls config %+v", cfg)
+	}
+	if cfg, _ = (&TLSOptions{}).TLSClientConfig(); cfg.MinVersion != tls.VersionTLS12 || cfg.ClientSessionCache != nil {
+		t.Errorf("Unexpected default tls config %+v", cfg)
+	}
+	for _, bad := range []TLSOptions{
+		{MinTLSVersion: "2.0"}, {MinTLSVersion: "1.3", MaxTLSVersion: "1.2"}, {CipherSuites: "FOO"}, {Curves: "P999"},
+	} {
+		if _, err = bad.TLSClientConfig(); err == nil {
+			t.Errorf("Expected error for %+v", bad)
+		}
+	}
+	// the error mentions the default min version when only the max is set:
+	_, err = (&TLSOptions{MaxTLSVersion: "tls1.1"}).TLSClientConfig()
+	if err == nil || err.Error() != "max TLS version 1.1 is lower than the min version 1.2" {
+		t.Errorf("Unexpected error for a max version below the default min: %v", err)
+	}
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	for _, std := range []bool{false, true} {
+		for _, resume := range []bool{false, true} {
+			o := HTTPOptions{URL: ts.URL, DisableFastClient: std, DisableKeepAlive: true}
+			o.Insecure = true
+			o.SessionResumption = resume
+			cli, err := NewClient(&o)
+			if err != nil {
+				t.Fatalf("client error %v", err)
+			}
+			for i := 0; i < 3; i++ {
+				if code, _, _ := cli.Fetch(); code != http.StatusOK {
+					t.Errorf("std %v resume %v: unexpected code %d", std, resume, code)
+				}
+			}
+			cli.Close()
+			p := cli.(interface{ phaseTimings() *phaseTimings }).phaseTimings()
+			expectedFull, expectedResumed := int64(3), int64(0)
+			if resume {
+				expectedFull, expectedResumed = 1, 2
+			}
+			if p.tlsFull != expectedFull || p.tlsResumed != expectedResumed {
+				t.Errorf("std %v resume %v: got %d full and %d resumed handshakes, expected %d and %d",
+					std, resume, p.tlsFull, p.tlsResumed, expectedFull, expectedResumed)
+			}
+		}
+	}
+}
+
+// proxyTestHandler is a minimal http proxy: CONNECT tunnels and plain http requests
+// answered directly with the requested absolute url.
+func proxyTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		_, _ = w.Write([]byte("proxied " + r.URL.String()))
+		return
+	}
+	dest, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		dest.Close()
+		return
+	}
+	_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		_, _ = io.Copy(dest, conn)
+		dest.Close()
+	}()
+	_, _ = io.Copy(conn, dest)
+	conn.Close()
+}
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(proxyTestHandler))
+	defer proxy.Close()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(h2TestHandler))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	plainURL := "http://localhost:1/plain?x=1" // never connected to directly
+	tests := []struct {
+		name     string
+		opts     HTTPOptions
+		expected string
+		tunnels  int64
+	}{
+		{"fast forward", HTTPOptions{URL: plainURL}, "proxied " + plainURL, 0},
+		{"std forward", HTTPOptions{URL: plainURL, DisableFastClient: true}, "proxied " + plainURL, 0},
+		{"fast tunnel", HTTPOptions{URL: ts.URL + "/tls"}, "hello /tls", 1},
+		{"std tunnel", HTTPOptions{URL: ts.URL + "/tls", DisableFastClient: true}, "hello /tls", 1},
+		{"h2 tunnel", HTTPOptions{URL: ts.URL + "/h2", H2: true}, "hello /h2", -1},
+	}
+	for _, tst := range tests {
+		o := tst.opts
+		o.Insecure = true
+		o.Proxy = proxy.URL
+		cli, err := NewClient(&o)
+		if err != nil {
+			t.Fatalf("%s: client error %v", tst.name, err)
+		}
+		for i := 0; i < 2; i++ {
+			code, data, header := cli.Fetch()
+			if code != http.StatusOK || string(data[header:]) != tst.expected {
+				t.Errorf("%s: unexpected result %d %q", tst.name, code, data)
+			}
+		}
+		if tst.tunnels >= 0 {
+			if n := cli.(interface{ phaseTimings() *phaseTimings }).phaseTimings().hist[phaseTunnel].Count; n != tst.tunnels {
+				t.Errorf("%s: got %d tunnels, expected %d", tst.name, n, tst.tunnels)
+			}
+		}
+		cli.Close()
+	}
+	o := HTTPOptions{URL: ts.URL, TLSOptions: TLSOptions{Proxy: "ftp://localhost"}}
+	if _, err := NewClient(&o); err == nil {
+		t.Errorf("Expected error for invalid proxy")
+	}
+}
+
+func TestStreamBody(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 200000) // much bigger than the 128k buffer
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/length", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(content); i += 300000 {
+			end := i + 300000
+			if end > len(content) {
+				end = len(content)
+			}
+			_, _ = w.Write(content[i:end])
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/eof", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Transfer-Encoding", "identity") // no chunking, closing the connection at the end
+		_, _ = w.Write(content)
+	})
+	sum := sha256.Sum256(content)
+	expectedHash := hex.EncodeToString(sum[:])
+	for _, path := range []string{"/length", "/chunked", "/eof"} {
+		o := HTTPOptions{URL: fmt.Sprintf("http://localhost:%d%s", addr.Port, path), StreamBody: true, StreamKeep: 10, StreamHash: true}
+		cli, _ := NewClient(&o)
+		fc := cli.(*FastClient)
+		for i := 0; i < 2; i++ {
+			code, data, header := cli.Fetch()
+			size, bodySize := fc.StreamedSizes()
+			if code != http.StatusOK || len(data) != header+10 || bodySize != int64(len(content)) || size <= bodySize {
+				t.Errorf("%s: unexpected streamed response %d %d+%d %d %d", path, code, header, len(data)-header, size, bodySize)
+			}
+			if path != "/chunked" && string(data[header:]) != "0123456789" {
+				t.Errorf("%s: unexpected kept body %q", path, data[header:])
+			}
+			if fc.BodyHash() != expectedHash {
+				t.Errorf("%s: got hash %s expected %s", path, fc.BodyHash(), expectedHash)
+			}
+		}
+		if _, sockets := cli.GetIPAddress(); (path == "/eof") != (sockets.Count == 2) {
+			t.Errorf("%s: unexpected %d sockets used", path, sockets.Count)
+		}
+		cli.Close()
+	}
+	o := HTTPRunnerOptions{}
+	o.URL = fmt.Sprintf("http://localhost:%d/chunked", addr.Port)
+	o.StreamBody = true
+	o.Exactly = 4
+	o.NumThreads = 1
+	o.QPS = -1
+	o.Checks = &ResponseChecks{Size: fmt.Sprintf("%d:%d", len(content), len(content))}
+	r, err := RunHTTPTest(&o)
+	if err != nil {
+		t.Fatalf("Error while starting runner: %v", err)
+	}
+	if r.RetCodes[http.StatusOK] != 4 || len(r.CheckFailures) != 0 || r.Sizes.Min <= float64(len(content)) || r.BytesReceived < 4*int64(len(content)) {
+		t.Errorf("Unexpected streamed run results %v %v %+v %d", r.RetCodes, r.CheckFailures, r.Sizes, r.BytesReceived)
+	}
+}
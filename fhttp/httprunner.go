@@ -16,13 +16,15 @@ package fhttp
 
 import (
 	"fmt"
-	"net/http"
 	"os"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"sync"
+	"time"
 
+	"fortio.org/fortio/fnet"
 	"fortio.org/fortio/log"
 	"fortio.org/fortio/periodic"
 	"fortio.org/fortio/stats"
@@ -41,37 +43,199 @@ type HTTPRunnerResults struct {
 	// internal type/data
 	sizes       *stats.Histogram
 	headerSizes *stats.Histogram
+	throughput  *throughput
 	// exported result
 	HTTPOptions
 	Sizes       *stats.HistogramData
 	HeaderSizes *stats.HistogramData
-	Sockets     []int64
-	SocketCount int64
+	// Bytes of the requests sent (as http/1.1 text for the std, h2 and h3 clients) and of the
+	// responses (Sizes) received, and the corresponding average throughput in MB/s (1000000 bytes/s)
+	BytesSent     int64
+	BytesReceived int64
+	SentMBps      float64
+	ReceivedMBps  float64
+	// Per call response throughput (MB/s received over the call's duration)
+	Throughput *stats.HistogramData
+	// Received MB/s of each connection/thread (in the same order as Sockets)
+	ConnectionsMBps []float64
+	// Bytes sent and received during each second of the run, for the bandwidth over time
+	SentPerSecond     []int64
+	ReceivedPerSecond []int64
+	Sockets           []int64
+	SocketCount       int64
 	// Connection Time stats
 	ConnectionStats *stats.HistogramData
+	// Number of connections made from each source IP (when SourceIP is set)
+	SourceIPCounts map[string]int64 `json:",omitempty"`
+	// Per phase (PhaseDNS, PhaseConnect, PhaseTLS, PhaseTTFB and PhaseTransfer) time stats, of the http/1.x clients
+	Phases map[string]*stats.HistogramData `json:",omitempty"`
+	// Streamed events (StreamEvents) counts: events, streams (calls) and streams dropped (ended by an error
+	// or timeout), and the time to first event, inter event gaps and per stream events per second stats.
+	Events             int64                `json:",omitempty"`
+	EventStreams       int64                `json:",omitempty"`
+	StreamDrops        int64                `json:",omitempty"`
+	TimeToFirstEvent   *stats.HistogramData `json:",omitempty"`
+	EventGaps          *stats.HistogramData `json:",omitempty"`
+	StreamEventsPerSec *stats.HistogramData `json:",omitempty"`
+	// Number of full and resumed (see TLSOptions.SessionResumption) TLS handshakes, of the http/1.x clients
+	TLSFullHandshakes    int64 `json:",omitempty"`
+	TLSResumedHandshakes int64 `json:",omitempty"`
+	// Breakdown of the SocketError (-1) RetCodes by category (fnet.ErrDNS, fnet.ErrReset,...)
+	ErrorCategories map[string]int64 `json:",omitempty"`
 	// http code to abort the run on (-1 for connection or other socket error)
 	AbortOn int
+	// error category to abort the run on (e.g. fnet.ErrConnectRefused)
+	AbortOnError string
+	// Number of streams per http/2 or http/3 connection (for H2 or H3 runs)
+	Streams int `json:",omitempty"`
+	// Http/1.1 pipelining depth (requests in flight per connection) of the fast client
+	Pipeline int `json:",omitempty"`
+	// Retries made per the retry policy, if any
+	Retries *RetryStats `json:",omitempty"`
+	// QUIC handshake time stats and number of 0-RTT resumed connections (for H3 runs)
+	QUICHandshakes     *stats.HistogramData `json:",omitempty"`
+	ZeroRTTConnections int64                `json:",omitempty"`
+	// Response checks, if any, and the count of responses failing them by category (CheckStatus, CheckJSON,...)
+	Checks        *ResponseChecks  `json:",omitempty"`
+	CheckFailures map[string]int64 `json:",omitempty"`
+	// Number of cookies received and left in the jars at the end of the run (when CookieJar or Cookies are set)
+	CookiesReceived int64 `json:",omitempty"`
+	CookiesStored   int64 `json:",omitempty"`
+	// Body bytes not fitting in -httpbufferkb and discarded by the h2/h3 clients
+	DroppedBytes int64 `json:",omitempty"`
+	// Per endpoint (method and url without query) results of replay runs
+	Endpoints map[string]*EndpointStats `json:",omitempty"`
+	// Per step results of session runs
+	Steps   map[string]*EndpointStats `json:",omitempty"`
 	aborter *periodic.Aborter
+	retrier *Retrier
+	replay  *ReplayClient
+	session *SessionClient
+	stream  *FastClient // when streaming the response bodies
+	// whether the url has {uuid}s, in which case the actual one is added to the call details
+	dynamicURL bool
+	// with HonorRetryAfter, pause when there is no Retry-After and next pause requested
+	throttleBackoff time.Duration
+	throttle        time.Duration
 }
 
 // Run tests http request fetching. Main call being run at the target QPS.
 // To be set as the Function in RunnerOptions.
 func (httpstate *HTTPRunnerResults) Run(t int) (bool, string) {
 	log.Debugf("Calling in %d", t)
-	code, body, headerSize := httpstate.client.Fetch()
+	sent := httpstate.bytesSent()
+	start := time.Now()
+	code, body, headerSize, attempts := httpstate.fetch()
+	duration := time.Since(start)
 	size := len(body)
+	bodySize := int64(-1)
+	if httpstate.stream != nil {
+		if total, streamedBody := httpstate.stream.StreamedSizes(); total > 0 {
+			size, bodySize = int(total), streamedBody
+		}
+	}
+	httpstate.throughput.record(httpstate.bytesSent()-sent, int64(size), start, duration)
 	log.Debugf("Got in %3d hsz %d sz %d - will abort on %d", code, headerSize, size, httpstate.AbortOn)
 	httpstate.RetCodes[code]++
+	if httpstate.throttled(code) {
+		httpstate.throttle = httpstate.retryAfter(body, headerSize)
+	}
 	httpstate.sizes.Record(float64(size))
 	httpstate.headerSizes.Record(float64(headerSize))
 	if httpstate.AbortOn == code {
 		httpstate.aborter.Abort(false)
 		log.Infof("Aborted run because of code %d - data %s", code, DebugSummary(body, 1024))
 	}
-	if code == http.StatusOK {
-		return true, "200"
+	details := strconv.Itoa(code)
+	if code == SocketError {
+		category := httpstate.client.ErrorCategory()
+		if category == "" {
+			category = fnet.ErrOther
+		}
+		httpstate.ErrorCategories[category]++
+		details += " " + category
+		if httpstate.AbortOnError == category {
+			httpstate.aborter.Abort(false)
+			log.Infof("Aborted run because of %s error - data %s", category, DebugSummary(body, 1024))
+		}
+	}
+	ok := httpstate.IsSuccess(code)
+	if httpstate.Checks != nil && code != SocketError {
+		failed := httpstate.Checks.check(code, body, headerSize, bodySize)
+		if failed != "" {
+			httpstate.CheckFailures[failed]++
+			details += " check " + failed
+		}
+		ok = (failed == "")
+	}
+	if httpstate.session != nil {
+		if step := httpstate.session.FailedStep(); step != "" {
+			ok = false
+			details += " step " + step
+		}
+	}
+	if httpstate.replay != nil {
+		endpoint := httpstate.replay.Endpoint()
+		es := httpstate.Endpoints[endpoint]
+		if es == nil {
+			es = newEndpointStats(httpstate.Offset.Seconds(), httpstate.Resolution)
+			httpstate.Endpoints[endpoint] = es
+		}
+		es.record(code, ok, duration)
+	}
+	if httpstate.dynamicURL {
+		details += " " + httpstate.client.LastURL()
+	}
+	if httpstate.retrier != nil {
+		httpstate.retrier.Done(httpstate.Retries, attempts, ok)
+	}
+	return ok, details
+}
+
+// throttled returns true if the code is a request to back off which is honored.
+func (httpstate *HTTPRunnerResults) throttled(code int) bool {
+	return httpstate.throttleBackoff > 0 && isThrottled(code)
+}
+
+// fetch makes the request, retrying it per the retry policy, if any (except the throttled
+// responses). Also returns the number of attempts made.
+func (httpstate *HTTPRunnerResults) fetch() (int, []byte, int, int) {
+	code, body, headerSize := httpstate.client.Fetch()
+	attempts := 1
+	if httpstate.retrier == nil {
+		return code, body, headerSize, attempts
+	}
+	for !httpstate.IsSuccess(code) && !httpstate.throttled(code) {
+		category, reason := "", strconv.Itoa(code)
+		if code == SocketError {
+			if category = httpstate.client.ErrorCategory(); category == "" {
+				category = fnet.ErrOther
+			}
+			reason = category
+		}
+		if !httpstate.retrier.Retry(httpstate.Retries, attempts, code, category, reason) {
+			break
+		}
+		attempts++
+		code, body, headerSize = httpstate.client.Fetch()
+	}
+	return code, body, headerSize, attempts
+}
+
+// bytesSent returns the number of bytes of the requests sent so far by the client.
+func (httpstate *HTTPRunnerResults) bytesSent() int64 {
+	if bs, ok := httpstate.client.(interface{ BytesSent() int64 }); ok {
+		return bs.BytesSent()
 	}
-	return false, fmt.Sprint(code)
+	return 0
+}
+
+// Wait implements periodic.Waiter for the replays at the recorded timings.
+func (httpstate *HTTPRunnerResults) Wait(_ int, stop chan struct{}) bool {
+	if httpstate.replay == nil {
+		return true
+	}
+	return httpstate.replay.Wait(stop)
 }
 
 // HTTPRunnerOptions includes the base RunnerOptions plus http specific
@@ -83,6 +247,31 @@ type HTTPRunnerOptions struct {
 	AllowInitialErrors bool   // whether initial errors don't cause an abort
 	// Which status code cause an abort of the run (default 0 = don't abort; reminder -1 is returned for socket errors)
 	AbortOn int
+	// Which socket error category (fnet.ErrDNS, fnet.ErrReset,...) cause an abort of the run (default "" = don't abort)
+	AbortOnError string
+	// Number of concurrent streams per connection for http/2 (H2) or http/3 (H3) runs. Like for grpc,
+	// total go routines and streams will be Streams*NumThreads.
+	Streams int
+	// Http/1.1 pipelining depth of the fast client: number of requests written back to back on each
+	// connection, without waiting for the responses, read in order. Like Streams, total go routines
+	// will be Pipeline*NumThreads.
+	Pipeline int
+	// Optional assertions on each response, failing ones count as errors.
+	Checks *ResponseChecks
+	// HAR (.har) or JSONL file of requests to replay instead of the URL/Payload, see LoadReplayFile().
+	ReplayFile string `json:",omitempty"`
+	// Order of the replay, ReplaySequential (default), ReplayRandom or ReplayTiming.
+	ReplayOrder string `json:",omitempty"`
+	// Speed factor of the ReplayTiming order, e.g. 2 to replay twice faster than recorded (default 1).
+	ReplaySpeed float64 `json:",omitempty"`
+	// Json file of the Session (steps) to run, as one call, instead of the URL/Payload, see LoadSessionFile().
+	SessionFile string `json:",omitempty"`
+	// Optional retries of the failed requests, see NewRetryPolicy().
+	Retry *RetryPolicy `json:",omitempty"`
+	// Adaptive mode: pause each connection after 429 and 503 responses for their Retry-After header
+	// (seconds or http date) or, without it, ThrottleBackoff (default DefaultThrottleBackoff).
+	HonorRetryAfter bool          `json:",omitempty"`
+	ThrottleBackoff time.Duration `json:",omitempty"`
 }
 
 // RunHTTPTest runs an http test and returns the aggregated stats.
@@ -99,8 +288,72 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 	if o.ConnReuseRange != [2]int{0, 0} {
 		connReuseMsg = fmt.Sprintf(", with connection reuse [%d, %d]", o.ConnReuseRange[0], o.ConnReuseRange[1])
 	}
-	log.Infof("Starting http test for %s with %d threads at %.1f qps and %s warmup%s",
-		o.URL, o.NumThreads, o.QPS, warmupMode, connReuseMsg)
+	if o.Checks != nil {
+		if err := o.Checks.Init(); err != nil {
+			return nil, err
+		}
+		if o.Checks.Status == "" {
+			o.Checks.status = o.OKCodes
+		}
+		if o.Checks.HasHeaderChecks() && o.DisableFastClient {
+			return nil, fmt.Errorf("header checks aren't supported with the std client")
+		}
+	}
+	streams := 1
+	if o.Streams > 1 {
+		if (o.H2 || o.H3) && !o.DisableFastClient {
+			streams = o.Streams
+		} else {
+			log.Warnf("Streams %d ignored, only supported for http/2 (-h2) or http/3 (-h3) runs", o.Streams)
+		}
+	}
+	pipeline := 1
+	if o.Pipeline > 1 {
+		if o.H2 || o.H3 || o.DisableFastClient || o.HTTP10 || o.DisableKeepAlive || o.StreamBody ||
+			o.ReplayFile != "" || o.SessionFile != "" {
+			log.Warnf("Pipeline %d ignored, only supported for http/1.1 keep-alive fast client runs (not streamed, replay or session)",
+				o.Pipeline)
+		} else {
+			pipeline = o.Pipeline
+		}
+	}
+	if (o.H2 || o.H3) && (o.ReplayFile != "" || o.SessionFile != "") {
+		log.Warnf("Replay and session runs use the std http/1.1 client, -h2/-h3 ignored")
+	}
+	retry := o.Retry
+	if retry != nil && (o.ReplayFile != "" || o.SessionFile != "") {
+		log.Warnf("Retries ignored for replay and session runs")
+		retry = nil
+	}
+	throttleBackoff := time.Duration(0)
+	if o.HonorRetryAfter {
+		throttleBackoff = o.ThrottleBackoff
+		if throttleBackoff <= 0 {
+			throttleBackoff = DefaultThrottleBackoff
+		}
+	}
+	if pipeline > 1 {
+		if o.NumThreads < 1 {
+			o.NumThreads = periodic.DefaultRunnerOptions.NumThreads
+		}
+		log.Infof("Starting http test for %s with %d*%d pipelined requests at %.1f qps and %s warmup",
+			o.URL, pipeline, o.NumThreads, o.QPS, warmupMode)
+		o.NumThreads *= pipeline
+	} else if streams > 1 {
+		if o.NumThreads < 1 {
+			o.NumThreads = periodic.DefaultRunnerOptions.NumThreads
+		}
+		proto := "http/2"
+		if o.H3 {
+			proto = "http/3"
+		}
+		log.Infof("Starting %s test for %s with %d*%d streams at %.1f qps and %s warmup",
+			proto, o.URL, streams, o.NumThreads, o.QPS, warmupMode)
+		o.NumThreads *= streams
+	} else {
+		log.Infof("Starting http test for %s with %d threads at %.1f qps and %s warmup%s",
+			o.URL, o.NumThreads, o.QPS, warmupMode, connReuseMsg)
+	}
 	r := periodic.NewPeriodicRunner(&o.RunnerOptions)
 	if o.HTTPOptions.Resolution <= 0 {
 		// Set both connect histogram params when Resolution isn't set explicitly on the HTTP options
@@ -110,7 +363,56 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 	}
 	defer r.Options().Abort()
 	numThreads := r.Options().NumThreads // can change during run for c > 2 n
+	retrier := retry.NewRetrier(r.Options().Stop.StopChan)
+	var replay *replaySource
+	if o.ReplayFile != "" {
+		reqs, err := LoadReplayFile(o.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		if replay, err = newReplaySource(reqs, o.ReplayOrder, o.ReplaySpeed); err != nil {
+			return nil, err
+		}
+		if o.URL == "" {
+			o.URL = reqs[0].URL
+		}
+	}
+	var session *Session
+	if o.SessionFile != "" {
+		if replay != nil {
+			return nil, fmt.Errorf("can't use both a replay and a session file")
+		}
+		var err error
+		if session, err = LoadSessionFile(o.SessionFile); err != nil {
+			return nil, err
+		}
+		if o.URL == "" {
+			o.URL = session.Steps[0].URL
+		}
+	}
 	o.HTTPOptions.Init(o.URL)
+	// Parse the request {tokens} once so all the clients share the {seq} counter and data rows.
+	o.HTTPOptions.tmpl = nil
+	tmpl, err := o.HTTPOptions.template()
+	if err != nil {
+		return nil, err
+	}
+	o.HTTPOptions.tmpl = tmpl
+	defer func() { o.HTTPOptions.tmpl = nil }()
+	// Likewise share the source IPs, for the round robin across all the connections.
+	sourceIPs, err := fnet.ParseSourceIPs(o.HTTPOptions.SourceIP)
+	if err != nil {
+		return nil, err
+	}
+	o.HTTPOptions.sourceIPs = sourceIPs
+	defer func() { o.HTTPOptions.sourceIPs = nil }()
+	// and the PROXY protocol advertised sources.
+	proxyProto, err := fnet.NewProxyProtocol(o.HTTPOptions.ProxyProtocol, o.HTTPOptions.ProxyProtocolSource)
+	if err != nil {
+		return nil, err
+	}
+	o.HTTPOptions.proxyProto = proxyProto
+	defer func() { o.HTTPOptions.proxyProto = nil }()
 	out := r.Options().Out // Important as the default value is set from nil to stdout inside NewPeriodicRunner
 	total := HTTPRunnerResults{
 		HTTPOptions: o.HTTPOptions,
@@ -120,6 +422,18 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 		headerSizes: stats.NewHistogram(0, 5),
 		AbortOn:     o.AbortOn,
 		aborter:     r.Options().Stop,
+		// Socket errors breakdown
+		ErrorCategories: make(map[string]int64),
+		AbortOnError:    o.AbortOnError,
+		Checks:          o.Checks,
+		CheckFailures:   make(map[string]int64),
+		Retries:         NewRetryStats(retry),
+	}
+	if streams > 1 {
+		total.Streams = streams
+	}
+	if pipeline > 1 {
+		total.Pipeline = pipeline
 	}
 	httpstate := make([]HTTPRunnerResults, numThreads)
 	// First build all the clients sequentially. This ensures we do not have data races when
@@ -129,15 +443,43 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 		// Temp mutate the option so each client gets a logging id
 		o.HTTPOptions.ID = i
 		// Create a client (and transport) and connect once for each 'thread'
-		var err error
-		httpstate[i].client, err = NewClient(&o.HTTPOptions)
-		// nil check on interface doesn't work
-		if err != nil {
-			return nil, err
+		// or, for http/2 and http/3 streams, share the previous client's connection.
+		leader := httpstate[i-i%streams].client
+		if h2, ok := leader.(*H2Client); ok && i%streams != 0 {
+			log.Debugf("Reusing previous h2 connection for %d", i)
+			httpstate[i].client = h2.NewStream(i)
+		} else if h3, ok := leader.(*H3Client); ok && i%streams != 0 {
+			log.Debugf("Reusing previous h3 connection for %d", i)
+			httpstate[i].client = h3.NewStream(i)
+		} else if replay != nil {
+			rc, err := newReplayClient(&o.HTTPOptions, replay)
+			if err != nil {
+				return nil, err
+			}
+			httpstate[i].client = rc
+			httpstate[i].replay = rc
+			httpstate[i].Endpoints = make(map[string]*EndpointStats)
+		} else if session != nil {
+			sc, err := newSessionClient(&o.HTTPOptions, session)
+			if err != nil {
+				return nil, err
+			}
+			httpstate[i].client = sc
+			httpstate[i].session = sc
+		} else {
+			var err error
+			httpstate[i].client, err = NewClient(&o.HTTPOptions)
+			// nil check on interface doesn't work
+			if err != nil {
+				return nil, err
+			}
+			if fc, ok := httpstate[i].client.(*FastClient); ok && pipeline > 1 {
+				fc.Pipeline(httpstate[i-i%pipeline].client.(*FastClient))
+			}
 		}
 		if o.SequentialWarmup && o.Exactly <= 0 {
 			code, data, headerSize := httpstate[i].client.Fetch()
-			if !o.AllowInitialErrors && !codeIsOK(code) {
+			if !o.AllowInitialErrors && !codeIsOK(o.OKCodes, code) {
 				return nil, fmt.Errorf("error %d for %s: %q", code, o.URL, string(data))
 			}
 			if i == 0 && log.LogVerbose() {
@@ -147,9 +489,24 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 		// Setup the stats for each 'thread'
 		httpstate[i].sizes = total.sizes.Clone()
 		httpstate[i].headerSizes = total.headerSizes.Clone()
+		httpstate[i].throughput = newThroughput()
+		if fc, ok := httpstate[i].client.(*FastClient); ok && fc.stream {
+			httpstate[i].stream = fc
+		}
 		httpstate[i].RetCodes = make(map[int]int64)
+		httpstate[i].ErrorCategories = make(map[string]int64)
 		httpstate[i].AbortOn = total.AbortOn
+		httpstate[i].AbortOnError = total.AbortOnError
+		httpstate[i].Checks = total.Checks
+		httpstate[i].OKCodes = total.OKCodes
+		httpstate[i].Offset = total.Offset
+		httpstate[i].Resolution = total.Resolution
+		httpstate[i].CheckFailures = make(map[string]int64)
 		httpstate[i].aborter = total.aborter
+		httpstate[i].retrier = retrier
+		httpstate[i].throttleBackoff = throttleBackoff
+		httpstate[i].Retries = NewRetryStats(retry)
+		httpstate[i].dynamicURL = (tmpl != nil && tmpl.urlTokens) || replay != nil
 	}
 	if o.Exactly <= 0 && !o.SequentialWarmup {
 		warmup := errgroup{}
@@ -157,7 +514,7 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 			i := i
 			warmup.Go(func() error {
 				code, data, headerSize := httpstate[i].client.Fetch()
-				if !o.AllowInitialErrors && !codeIsOK(code) {
+				if !o.AllowInitialErrors && !codeIsOK(o.OKCodes, code) {
 					return fmt.Errorf("error %d for %s: %q", code, o.URL, string(data))
 				}
 				if i == 0 && log.LogVerbose() {
@@ -170,6 +527,18 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 			return nil, err
 		}
 	}
+	if replay != nil {
+		replay.reset() // start the run with the first request (and clock) regardless of the warmup
+	}
+	if session != nil {
+		for i := 0; i < numThreads; i++ {
+			httpstate[i].session.resetStats()
+		}
+	}
+	runStart := time.Now()
+	for i := 0; i < numThreads; i++ {
+		httpstate[i].throughput.start = runStart
+	}
 	// TODO avoid copy pasta with grpcrunner
 	if o.Profiler != "" {
 		fc, err := os.Create(o.Profiler + ".cpu")
@@ -198,6 +567,11 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 	}
 	// Connection stats, aggregated
 	connectionStats := stats.NewHistogram(o.HTTPOptions.Offset.Seconds(), o.HTTPOptions.Resolution)
+	quicHandshakes := connectionStats.Clone()
+	phases := newPhaseTimings(o.HTTPOptions.Offset.Seconds(), o.HTTPOptions.Resolution)
+	hasPhases := false
+	events := newEventStats(o.HTTPOptions.Offset.Seconds(), o.HTTPOptions.Resolution)
+	throughput := newThroughput()
 	// Numthreads may have reduced:
 	numThreads = total.RunnerResults.NumThreads
 	// But we also must cleanup all the created clients.
@@ -207,6 +581,26 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 		// Get the report on the IP address each thread use to send traffic
 		occurrence, connStats := httpstate[i].client.GetIPAddress()
 		currentSocketUsed := connStats.Count
+		if h3, ok := httpstate[i].client.(*H3Client); ok {
+			handshakes, zeroRTT := h3.HandshakeStats()
+			quicHandshakes.Transfer(handshakes)
+			total.ZeroRTTConnections += zeroRTT
+		}
+		if pt, ok := httpstate[i].client.(interface{ phaseTimings() *phaseTimings }); ok {
+			phases.transfer(pt.phaseTimings())
+			hasPhases = true
+		}
+		if httpstate[i].stream != nil && httpstate[i].stream.events != nil {
+			events.transfer(httpstate[i].stream.events)
+		}
+		if cs, ok := httpstate[i].client.(interface{ CookieStats() (int64, int) }); ok {
+			received, stored := cs.CookieStats()
+			total.CookiesReceived += received
+			total.CookiesStored += int64(stored)
+		}
+		if db, ok := httpstate[i].client.(interface{ DroppedBytes() int64 }); ok {
+			total.DroppedBytes += db.DroppedBytes()
+		}
 		httpstate[i].client.Close()
 		fmt.Fprintf(out, "[%d] %3d socket used, resolved to %s ", i, currentSocketUsed, occurrence.PrintAndAggregate(total.IPCountMap))
 		connStats.Counter.Print(out, "connection timing")
@@ -219,8 +613,39 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 			}
 			total.RetCodes[k] += httpstate[i].RetCodes[k]
 		}
+		for k, v := range httpstate[i].ErrorCategories {
+			total.ErrorCategories[k] += v
+		}
+		for k, v := range httpstate[i].CheckFailures {
+			total.CheckFailures[k] += v
+		}
+		if total.Retries != nil {
+			total.Retries.Transfer(httpstate[i].Retries)
+		}
+		for k, v := range httpstate[i].Endpoints {
+			if total.Endpoints == nil {
+				total.Endpoints = make(map[string]*EndpointStats)
+			}
+			if total.Endpoints[k] == nil {
+				total.Endpoints[k] = newEndpointStats(o.HTTPOptions.Offset.Seconds(), o.HTTPOptions.Resolution)
+			}
+			total.Endpoints[k].transfer(v)
+		}
+		if sc := httpstate[i].session; sc != nil {
+			if total.Steps == nil {
+				total.Steps = make(map[string]*EndpointStats)
+			}
+			for j, step := range session.Steps {
+				if total.Steps[step.Name] == nil {
+					total.Steps[step.Name] = newEndpointStats(o.HTTPOptions.Offset.Seconds(), o.HTTPOptions.Resolution)
+				}
+				total.Steps[step.Name].transfer(sc.stats[j])
+			}
+		}
 		total.sizes.Transfer(httpstate[i].sizes)
 		total.headerSizes.Transfer(httpstate[i].headerSizes)
+		total.ConnectionsMBps = append(total.ConnectionsMBps, mbps(httpstate[i].throughput.received, total.ActualDuration))
+		throughput.transfer(httpstate[i].throughput)
 		connectionStats.Transfer(connStats)
 	}
 	total.ConnectionStats = connectionStats.Export().CalcPercentiles(o.Percentiles)
@@ -229,6 +654,25 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 	} else if log.Log(log.Warning) {
 		connectionStats.Counter.Print(out, "Connection time (s)")
 	}
+	if hasPhases {
+		total.Phases = phases.export(o.Percentiles)
+		if log.Log(log.Warning) {
+			phases.print(out, total.Phases, log.Log(log.Info))
+		}
+		total.TLSFullHandshakes, total.TLSResumedHandshakes = phases.tlsFull, phases.tlsResumed
+		if phases.tlsFull+phases.tlsResumed > 0 {
+			_, _ = fmt.Fprintf(out, "TLS handshakes: %d full, %d resumed\n", phases.tlsFull, phases.tlsResumed)
+		}
+	}
+	if o.H3 {
+		total.QUICHandshakes = quicHandshakes.Export().CalcPercentiles(o.Percentiles)
+		if log.Log(log.Info) {
+			total.QUICHandshakes.Print(out, "QUIC handshake time histogram (s)")
+		} else if log.Log(log.Warning) {
+			quicHandshakes.Counter.Print(out, "QUIC handshake time (s)")
+		}
+		_, _ = fmt.Fprintf(out, "0-RTT resumed connections: %d\n", total.ZeroRTTConnections)
+	}
 
 	// Sort the ip address form largest to smallest based on its usage count
 	ipList := make([]string, 0, len(total.IPCountMap))
@@ -244,15 +688,37 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 	r.Options().ReleaseRunners()
 	sort.Ints(keys)
 	totalCount := float64(total.DurationHistogram.Count)
-	_, _ = fmt.Fprintf(out, "Sockets used: %d (for perfect keepalive, would be %d)\n", total.SocketCount, r.Options().NumThreads)
+	_, _ = fmt.Fprintf(out, "Sockets used: %d (for perfect keepalive, would be %d)\n", total.SocketCount,
+		r.Options().NumThreads/(streams*pipeline))
 	_, _ = fmt.Fprintf(out, "Uniform: %t, Jitter: %t\n", total.Uniform, total.Jitter)
 	_, _ = fmt.Fprintf(out, "IP addresses distribution:\n")
 	for _, v := range ipList {
 		_, _ = fmt.Fprintf(out, "%s: %d\n", v, total.IPCountMap[v])
 	}
+	total.SourceIPCounts = sourceIPs.Counts()
+	fnet.PrintSourceIPCounts(out, total.SourceIPCounts)
 	for _, k := range keys {
 		_, _ = fmt.Fprintf(out, "Code %3d : %d (%.1f %%)\n", k, total.RetCodes[k], 100.*float64(total.RetCodes[k])/totalCount)
 	}
+	fnet.PrintErrorCategories(out, total.ErrorCategories, totalCount)
+	PrintCheckFailures(out, total.CheckFailures, totalCount)
+	if total.Retries != nil {
+		total.Retries.Print(out)
+	}
+	if total.DroppedBytes > 0 {
+		_, _ = fmt.Fprintf(out, "Dropped body bytes (over -httpbufferkb): %d\n", total.DroppedBytes)
+	}
+	if o.CookieJar || len(o.Cookies) > 0 {
+		_, _ = fmt.Fprintf(out, "Cookies received: %d, in jars at the end: %d\n", total.CookiesReceived, total.CookiesStored)
+	}
+	printEndpoints(out, total.Endpoints, o.Percentiles)
+	if session != nil {
+		steps := make([]string, 0, len(session.Steps))
+		for _, step := range session.Steps {
+			steps = append(steps, step.Name)
+		}
+		printEndpointStats(out, "Step", steps, total.Steps, o.Percentiles)
+	}
 	total.HeaderSizes = total.headerSizes.Export()
 	total.Sizes = total.sizes.Export()
 	if log.LogVerbose() {
@@ -262,6 +728,18 @@ func RunHTTPTest(o *HTTPRunnerOptions) (*HTTPRunnerResults, error) {
 		total.headerSizes.Counter.Print(out, "Response Header Sizes")
 		total.sizes.Counter.Print(out, "Response Body/Total Sizes")
 	}
+	if o.StreamEvents {
+		total.Events, total.EventStreams, total.StreamDrops = events.events, events.streams, events.drops
+		total.TimeToFirstEvent = events.first.Export().CalcPercentiles(o.Percentiles)
+		total.EventGaps = events.gaps.Export().CalcPercentiles(o.Percentiles)
+		total.StreamEventsPerSec = events.rates.Export().CalcPercentiles(o.Percentiles)
+		events.print(out, &total)
+	}
+	total.BytesSent, total.BytesReceived = throughput.sent, throughput.received
+	total.SentMBps, total.ReceivedMBps = mbps(throughput.sent, total.ActualDuration), mbps(throughput.received, total.ActualDuration)
+	total.SentPerSecond, total.ReceivedPerSecond = throughput.sentPerSec, throughput.receivedPerSec
+	total.Throughput = throughput.rates.Export().CalcPercentiles(o.Percentiles)
+	printThroughput(out, &total, throughput.rates)
 	return &total, nil
 }
 
@@ -21,17 +21,44 @@ import (
 	"compress/gzip"
 	"fmt"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"fortio.org/fortio/fnet"
 	"fortio.org/fortio/log"
 )
 
+func TestHTTPRunnerExemplars(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/foo/", EchoHandler)
+	opts := HTTPRunnerOptions{}
+	opts.QPS = -1
+	opts.Exactly = 20
+	opts.NumThreads = 2
+	opts.Exemplars = 3
+	opts.URL = fmt.Sprintf("http://localhost:%d/foo/{uuid}?status=503", addr.Port)
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Exemplars) != 3 {
+		t.Fatalf("Expected 3 slowest calls, got %+v", res.Exemplars)
+	}
+	prefix := fmt.Sprintf("503 http://localhost:%d/foo/", addr.Port)
+	for _, x := range res.Exemplars {
+		if x.Status || !strings.HasPrefix(x.Details, prefix) || strings.Contains(x.Details, uuidToken) {
+			t.Errorf("Unexpected exemplar %+v", x)
+		}
+	}
+}
+
 func TestHTTPRunner(t *testing.T) {
 	mux, addr := DynamicHTTPServer(false)
 	mux.HandleFunc("/foo/", EchoHandler)
@@ -472,6 +499,40 @@ func TestAbortOn(t *testing.T) {
 	}
 }
 
+func TestErrorCategories(t *testing.T) {
+	// Find a port with nothing listening on it:
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	for _, std := range []bool{false, true} {
+		o := HTTPRunnerOptions{}
+		o.URL = fmt.Sprintf("http://localhost:%d/", port)
+		o.DisableFastClient = std
+		o.AllowInitialErrors = true
+		o.Exactly = 10
+		o.NumThreads = 1
+		o.QPS = -1
+		r, err := RunHTTPTest(&o)
+		if err != nil {
+			t.Fatalf("Error while starting runner: %v", err)
+		}
+		if r.RetCodes[SocketError] != 10 || r.ErrorCategories[fnet.ErrConnectRefused] != 10 {
+			t.Errorf("Std %v: expected 10 %s errors, got %v %v", std, fnet.ErrConnectRefused, r.RetCodes, r.ErrorCategories)
+		}
+		o.AbortOnError = fnet.ErrConnectRefused
+		r, err = RunHTTPTest(&o)
+		if err != nil {
+			t.Fatalf("Error while starting runner: %v", err)
+		}
+		if count := r.Result().DurationHistogram.Count; count != 1 {
+			t.Errorf("Std %v: abort on %s not working, did %d requests", std, o.AbortOnError, count)
+		}
+	}
+}
+
 func TestConnectionReuseRange(t *testing.T) {
 	mux, addr := DynamicHTTPServer(false)
 	mux.HandleFunc("/foo/", EchoHandler)
@@ -621,3 +682,301 @@ func getIPUsageCount(ipCountMap map[string]int) (count int) {
 
 	return count
 }
+
+func TestHTTPRunnerH2Streams(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/h2/", EchoHandler)
+	opts := HTTPRunnerOptions{}
+	opts.Init(fmt.Sprintf("http://localhost:%d/h2/", addr.Port))
+	opts.H2 = true
+	opts.Streams = 3
+	opts.NumThreads = 2
+	opts.QPS = 200
+	opts.Exactly = 60
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RunnerResults.NumThreads != 6 || res.Streams != 3 {
+		t.Errorf("Expected 2*3 streams, got %d threads, %d streams", res.RunnerResults.NumThreads, res.Streams)
+	}
+	if res.RetCodes[http.StatusOK] != 60 || res.DurationHistogram.Count != 60 {
+		t.Errorf("Unexpected h2 results %v %d", res.RetCodes, res.DurationHistogram.Count)
+	}
+	if res.SocketCount != 2 || getIPUsageCount(res.IPCountMap) != 2 {
+		t.Errorf("Expected 2 connections shared by the streams, got %d %v", res.SocketCount, res.IPCountMap)
+	}
+	if res.HeaderSizes.Min <= 0 {
+		t.Errorf("Expected h2 header sizes to be reported %+v", res.HeaderSizes)
+	}
+}
+
+func TestHTTPRunnerH3Streams(t *testing.T) {
+	addr := ServeHTTP3("localhost:0", "", "../cert-tmp/server.crt", "../cert-tmp/server.key")
+	if addr == nil {
+		t.Fatalf("unable to start h3 server")
+	}
+	opts := HTTPRunnerOptions{}
+	opts.Init(fmt.Sprintf("https://localhost:%d/h3/", addr.(*net.UDPAddr).Port))
+	opts.CACert = "../cert-tmp/ca.crt"
+	opts.H3 = true
+	opts.Streams = 2
+	opts.NumThreads = 2
+	opts.QPS = 200
+	opts.Exactly = 40
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RunnerResults.NumThreads != 4 || res.RetCodes[http.StatusOK] != 40 {
+		t.Errorf("Unexpected h3 results %d threads %v", res.RunnerResults.NumThreads, res.RetCodes)
+	}
+	if res.SocketCount != 2 || res.QUICHandshakes == nil || res.QUICHandshakes.Count != 2 {
+		t.Errorf("Expected 2 quic connections and handshakes, got %d %+v", res.SocketCount, res.QUICHandshakes)
+	}
+}
+
+func TestHTTPRunnerThroughput(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/", EchoHandler)
+	for _, std := range []bool{false, true} {
+		o := HTTPRunnerOptions{}
+		o.URL = fmt.Sprintf("http://localhost:%d/?size=10000", addr.Port)
+		o.DisableFastClient = std
+		o.Exactly = 10
+		o.NumThreads = 2
+		o.QPS = -1
+		r, err := RunHTTPTest(&o)
+		if err != nil {
+			t.Fatalf("Error while starting runner: %v", err)
+		}
+		if r.BytesReceived < 10*10000 || r.BytesReceived > 10*(10000+500) || r.BytesSent < 10*20 || r.BytesSent > 10*500 {
+			t.Errorf("Std %v: unexpected bytes sent %d and received %d", std, r.BytesSent, r.BytesReceived)
+		}
+		if r.SentMBps <= 0 || r.ReceivedMBps <= r.SentMBps || r.Throughput.Count != 10 || len(r.ConnectionsMBps) != 2 {
+			t.Errorf("Std %v: unexpected throughput %g %g %+v %v", std, r.SentMBps, r.ReceivedMBps, r.Throughput, r.ConnectionsMBps)
+		}
+		var sent, received int64
+		for i := range r.ReceivedPerSecond {
+			sent += r.SentPerSecond[i]
+			received += r.ReceivedPerSecond[i]
+		}
+		if sent != r.BytesSent || received != r.BytesReceived {
+			t.Errorf("Std %v: per second bytes %d %d don't add up to the totals", std, sent, received)
+		}
+	}
+}
+
+func TestHTTPRunnerSourceIPs(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	var mu sync.Mutex
+	seen := make(map[string]int64)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		mu.Lock()
+		seen[host]++
+		mu.Unlock()
+	})
+	for _, std := range []bool{false, true} {
+		mu.Lock()
+		seen = make(map[string]int64)
+		mu.Unlock()
+		o := HTTPRunnerOptions{}
+		o.URL = fmt.Sprintf("http://127.0.0.1:%d/", addr.Port)
+		o.DisableFastClient = std
+		o.DisableKeepAlive = true
+		o.SourceIP = "127.0.0.2/31"
+		o.Exactly = 10
+		o.NumThreads = 2
+		o.QPS = -1
+		r, err := RunHTTPTest(&o)
+		if err != nil {
+			t.Fatalf("Error while starting runner: %v", err)
+		}
+		var total int64
+		for _, n := range r.SourceIPCounts {
+			total += n
+		}
+		if len(r.SourceIPCounts) != 2 || total < r.SocketCount {
+			t.Errorf("Std %v: unexpected source ip counts %v for %d sockets", std, r.SourceIPCounts, r.SocketCount)
+		}
+		mu.Lock()
+		if len(seen) != 2 || seen["127.0.0.2"] == 0 || seen["127.0.0.3"] == 0 {
+			t.Errorf("Std %v: unexpected requests per remote ip %v", std, seen)
+		}
+		mu.Unlock()
+	}
+	o := HTTPRunnerOptions{}
+	o.URL = fmt.Sprintf("http://127.0.0.1:%d/", addr.Port)
+	o.SourceIP = "127.0.0.0/8"
+	if _, err := RunHTTPTest(&o); err == nil {
+		t.Errorf("Expected error for too many source ips")
+	}
+}
+
+func TestHTTPRunnerStreamEvents(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/", EchoHandler)
+	tests := []struct {
+		query  string
+		events int64
+		drops  bool
+	}{
+		{"events=20&event-delay=2ms&event-size=100", 4 * 20, false},
+		{"events=20&event-format=ndjson&event-size=10", 4 * 20, false},
+		{"events=20&event-drop=100", 0, true},
+	}
+	for _, tst := range tests {
+		o := HTTPRunnerOptions{}
+		o.URL = fmt.Sprintf("http://localhost:%d/?%s", addr.Port, tst.query)
+		o.StreamBody = true
+		o.StreamEvents = true
+		o.Exactly = 4
+		o.NumThreads = 2
+		o.QPS = -1
+		r, err := RunHTTPTest(&o)
+		if err != nil {
+			t.Fatalf("Error while starting runner: %v", err)
+		}
+		if r.Events != tst.events || r.EventStreams != 4 || (r.StreamDrops == 4) != tst.drops {
+			t.Errorf("%s: got %d events in %d streams with %d drops", tst.query, r.Events, r.EventStreams, r.StreamDrops)
+		}
+		if tst.drops {
+			if r.RetCodes[SocketError] != 4 {
+				t.Errorf("%s: expected the dropped streams to be errors, got %v", tst.query, r.RetCodes)
+			}
+			continue
+		}
+		if r.RetCodes[http.StatusOK] != 4 || r.TimeToFirstEvent.Count != 4 || r.EventGaps.Count != 4*19 || r.StreamEventsPerSec.Count != 4 {
+			t.Errorf("%s: unexpected codes %v or histograms %d %d %d", tst.query, r.RetCodes,
+				r.TimeToFirstEvent.Count, r.EventGaps.Count, r.StreamEventsPerSec.Count)
+		}
+	}
+}
+
+func TestHTTPRunnerPipeline(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/", EchoHandler)
+	for _, query := range []string{"size=100", "size=10000", "events=3", "status=404:50"} {
+		o := HTTPRunnerOptions{}
+		o.URL = fmt.Sprintf("http://localhost:%d/?%s", addr.Port, query)
+		o.Pipeline = 4
+		o.NumThreads = 2
+		o.QPS = -1
+		o.Exactly = 80
+		o.AllowInitialErrors = true
+		r, err := RunHTTPTest(&o)
+		if err != nil {
+			t.Fatalf("Error while starting runner: %v", err)
+		}
+		if r.RunnerResults.NumThreads != 8 || r.Pipeline != 4 {
+			t.Errorf("%s: expected 2*4 pipelined requests, got %d threads, pipeline %d", query, r.RunnerResults.NumThreads, r.Pipeline)
+		}
+		if r.RetCodes[http.StatusOK]+r.RetCodes[http.StatusNotFound] != 80 || r.SocketCount != 2 {
+			t.Errorf("%s: unexpected codes %v or sockets %d", query, r.RetCodes, r.SocketCount)
+		}
+	}
+}
+
+func TestHTTPRunnerPipelineHeadOfLineBlocking(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		slow := count%4 == 1
+		mu.Unlock()
+		if slow {
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	o := HTTPRunnerOptions{}
+	o.URL = fmt.Sprintf("http://localhost:%d/", addr.Port)
+	o.Pipeline = 4
+	o.NumThreads = 1
+	o.QPS = -1
+	o.Exactly = 8
+	r, err := RunHTTPTest(&o)
+	if err != nil {
+		t.Fatalf("Error while starting runner: %v", err)
+	}
+	if r.RetCodes[http.StatusOK] != 8 {
+		t.Errorf("Unexpected codes %v", r.RetCodes)
+	}
+	// 1 in 4 responses is slow but the fast ones pipelined behind it are delayed too: the
+	// average would be about 12.5ms otherwise.
+	if r.DurationHistogram.Avg < 0.03 {
+		t.Errorf("Expected head of line blocking, got avg %g", r.DurationHistogram.Avg)
+	}
+}
+
+func TestHTTPRunnerRetries(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		fail := count%2 == 1
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	o := HTTPRunnerOptions{}
+	o.URL = fmt.Sprintf("http://localhost:%d/", addr.Port)
+	o.NumThreads = 1
+	o.QPS = -1
+	o.Exactly = 10
+	o.Retry, _ = NewRetryPolicy(3, "503", nil)
+	r, err := RunHTTPTest(&o)
+	if err != nil {
+		t.Fatalf("Error while starting runner: %v", err)
+	}
+	if r.RetCodes[http.StatusOK] != 10 || r.Retries == nil || r.Retries.Retries != 10 || r.Retries.SuccessAfterRetry != 10 ||
+		r.Retries.Attempts[2] != 10 || r.Retries.Reasons["503"] != 10 {
+		t.Errorf("expected each call to succeed on its second attempt, got %v %+v", r.RetCodes, r.Retries)
+	}
+	// not retried
+	o.Retry, _ = NewRetryPolicy(3, "502,connect_refused", nil)
+	r, err = RunHTTPTest(&o)
+	if err != nil {
+		t.Fatalf("Error while starting runner: %v", err)
+	}
+	if r.RetCodes[http.StatusServiceUnavailable] != 5 || r.Retries.Retries != 0 || r.Retries.Attempts[1] != 10 {
+		t.Errorf("expected no retry of 503s, got %v %+v", r.RetCodes, r.Retries)
+	}
+	// budget
+	o.URL = fmt.Sprintf("http://localhost:%d/down", addr.Port)
+	o.Exactly = 20
+	o.Retry, _ = NewRetryPolicy(5, "", nil)
+	o.Retry.Budget = 0.1
+	r, err = RunHTTPTest(&o)
+	if err != nil {
+		t.Fatalf("Error while starting runner: %v", err)
+	}
+	if r.RetCodes[http.StatusServiceUnavailable] != 20 || r.Retries.Retries != 12 || r.Retries.BudgetExhausted != 18 {
+		t.Errorf("expected retries limited by the budget, got %v %+v", r.RetCodes, r.Retries)
+	}
+	// socket errors, with backoff
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	l.Close()
+	o.URL = fmt.Sprintf("http://%s/", l.Addr())
+	o.Exactly = 4
+	o.Retry, _ = NewRetryPolicy(3, "connect_refused", nil)
+	o.Retry.Backoff = 10 * time.Millisecond
+	r, err = RunHTTPTest(&o)
+	if err != nil {
+		t.Fatalf("Error while starting runner: %v", err)
+	}
+	if r.RetCodes[SocketError] != 4 || r.Retries.Reasons[fnet.ErrConnectRefused] != 8 || r.Retries.Attempts[3] != 4 {
+		t.Errorf("expected connection refused retries, got %v %+v", r.RetCodes, r.Retries)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"fortio.org/fortio/stats"
+)
+
+// Phases of the http calls timed separately (keys of HTTPRunnerResults.Phases).
+const (
+	PhaseDNS      = "DNS"      // DNS resolution of new connections
+	PhaseConnect  = "Connect"  // TCP (or unix socket) connection, to the proxy when using one
+	PhaseTunnel   = "Tunnel"   // CONNECT or socks5 tunnel setup through the proxy
+	PhaseTLS      = "TLS"      // TLS handshake of new https connections
+	PhaseTTFB     = "TTFB"     // time to first byte, from the request being sent to the first byte of the response
+	PhaseTransfer = "Transfer" // from the first to the last byte of the response
+)
+
+// Phases is the list of the timed phases, in the order they happen.
+var Phases = []string{PhaseDNS, PhaseConnect, PhaseTunnel, PhaseTLS, PhaseTTFB, PhaseTransfer}
+
+const (
+	phaseDNS = iota
+	phaseConnect
+	phaseTunnel
+	phaseTLS
+	phaseTTFB
+	phaseTransfer
+	numPhases
+)
+
+// phaseTimings are a client's per phase histograms, and TLS handshakes counts. The std
+// client's httptrace callbacks can happen in the transport's goroutines, hence the mutex.
+type phaseTimings struct {
+	mu         sync.Mutex
+	hist       [numPhases]*stats.Histogram
+	starts     [numPhases]time.Time
+	tlsFull    int64 // number of full TLS handshakes
+	tlsResumed int64 // number of TLS handshakes resuming a previous session
+}
+
+func newPhaseTimings(offset, resolution float64) *phaseTimings {
+	p := &phaseTimings{}
+	for i := range p.hist {
+		p.hist[i] = stats.NewHistogram(offset, resolution)
+	}
+	return p
+}
+
+// start marks the beginning of a phase.
+func (p *phaseTimings) start(phase int) {
+	p.mu.Lock()
+	p.starts[phase] = time.Now()
+	p.mu.Unlock()
+}
+
+// end records the duration since the phase's start, if it was started.
+func (p *phaseTimings) end(phase int) {
+	p.mu.Lock()
+	if !p.starts[phase].IsZero() {
+		p.hist[phase].Record(time.Since(p.starts[phase]).Seconds())
+		p.starts[phase] = time.Time{}
+	}
+	p.mu.Unlock()
+}
+
+// cancel forgets the start of a phase which failed.
+func (p *phaseTimings) cancel(phase int) {
+	p.mu.Lock()
+	p.starts[phase] = time.Time{}
+	p.mu.Unlock()
+}
+
+// tlsHandshakeDone ends the TLS phase and counts the (full or resumed) handshake.
+func (p *phaseTimings) tlsHandshakeDone(resumed bool) {
+	p.end(phaseTLS)
+	p.mu.Lock()
+	if resumed {
+		p.tlsResumed++
+	} else {
+		p.tlsFull++
+	}
+	p.mu.Unlock()
+}
+
+// transfer moves the histograms data and handshakes counts of src into p.
+func (p *phaseTimings) transfer(src *phaseTimings) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	for i := range p.hist {
+		p.hist[i].Transfer(src.hist[i])
+	}
+	p.tlsFull += src.tlsFull
+	p.tlsResumed += src.tlsResumed
+	src.tlsFull, src.tlsResumed = 0, 0
+}
+
+// export returns the histograms data, with percentiles, of the phases which happened.
+func (p *phaseTimings) export(percentiles []float64) map[string]*stats.HistogramData {
+	res := make(map[string]*stats.HistogramData)
+	for i, h := range p.hist {
+		if h.Count > 0 {
+			res[Phases[i]] = h.Export().CalcPercentiles(percentiles)
+		}
+	}
+	return res
+}
+
+// print prints the phases which happened, as the exported histograms when verbose
+// or just their counters otherwise.
+func (p *phaseTimings) print(out io.Writer, phases map[string]*stats.HistogramData, verbose bool) {
+	for i, name := range Phases {
+		if p.hist[i].Count == 0 {
+			continue
+		}
+		if verbose {
+			phases[name].Print(out, name+" phase time histogram (s)")
+		} else {
+			p.hist[i].Counter.Print(out, name+" phase time (s)")
+		}
+	}
+}
+
+// clientTrace returns the httptrace hooks recording the phases of the std client's requests.
+func (p *phaseTimings) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { p.start(phaseDNS) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { p.end(phaseDNS) },
+		ConnectStart: func(string, string) { p.start(phaseConnect) },
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil {
+				p.cancel(phaseConnect)
+				return
+			}
+			p.end(phaseConnect)
+		},
+		TLSHandshakeStart: func() { p.start(phaseTLS) },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				p.cancel(phaseTLS)
+				return
+			}
+			p.tlsHandshakeDone(state.DidResume)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { p.start(phaseTTFB) },
+		GotFirstResponseByte: func() {
+			p.end(phaseTTFB)
+			p.start(phaseTransfer)
+		},
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPhaseTimings(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	})
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	tests := []struct {
+		name string
+		opts HTTPOptions
+		// expected number of DNS, Connect, Tunnel, TLS, TTFB and Transfer timings for the 3 calls
+		counts [numPhases]int64
+	}{
+		{"fast", HTTPOptions{URL: fmt.Sprintf("http://localhost:%d/slow", addr.Port)}, [numPhases]int64{1, 1, 0, 0, 3, 3}},
+		{"std", HTTPOptions{URL: fmt.Sprintf("http://localhost:%d/slow", addr.Port), DisableFastClient: true},
+			[numPhases]int64{1, 1, 0, 0, 3, 3}},
+		{"fast tls", HTTPOptions{URL: ts.URL, TLSOptions: TLSOptions{Insecure: true}}, [numPhases]int64{0, 1, 0, 1, 3, 3}},
+		{"std tls", HTTPOptions{URL: ts.URL, TLSOptions: TLSOptions{Insecure: true}, DisableFastClient: true},
+			[numPhases]int64{0, 1, 0, 1, 3, 3}},
+	}
+	for _, tst := range tests {
+		o := tst.opts
+		cli, err := NewClient(&o)
+		if err != nil {
+			t.Fatalf("%s: client error %v", tst.name, err)
+		}
+		for i := 0; i < 3; i++ {
+			if code, _, _ := cli.Fetch(); code != http.StatusOK {
+				t.Errorf("%s: unexpected code %d", tst.name, code)
+			}
+		}
+		cli.Close()
+		p := cli.(interface{ phaseTimings() *phaseTimings }).phaseTimings()
+		for i, h := range p.hist {
+			if h.Count != tst.counts[i] {
+				t.Errorf("%s: got %d %s timings, expected %d", tst.name, h.Count, Phases[i], tst.counts[i])
+			}
+		}
+		if tst.counts[phaseTLS] == 0 && p.hist[phaseTTFB].Min < 0.02 {
+			t.Errorf("%s: TTFB %g should include the 20ms server time", tst.name, p.hist[phaseTTFB].Min)
+		}
+		exported := p.export(nil)
+		if len(exported) != 4 || exported[PhaseTTFB].Count != 3 {
+			t.Errorf("%s: unexpected exported phases %+v", tst.name, exported)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+)
+
+// pipeline is the http/1.1 connection shared by the fast clients of a pipeline: each one
+// writes its request without waiting for the responses of the previous ones, which are
+// read, in order, by the clients that sent the requests.
+type pipeline struct {
+	mu      sync.Mutex // held to write a request and take the matching turn to read the response
+	conn    net.Conn   // current connection, nil until (re)connected
+	turn    chan struct{}
+	pending int // requests written and whose response isn't read yet
+	// Bytes read past the end of the previous response on leftConn, the beginning of the next
+	// one(s). Only accessed by the reader whose turn it is.
+	leftover []byte
+	leftConn net.Conn
+	closed   net.Conn // last connection closed after reading a response, idem
+}
+
+func newPipeline() *pipeline {
+	turn := make(chan struct{})
+	close(turn) // first reader doesn't wait
+	return &pipeline{turn: turn}
+}
+
+// Pipeline makes c share leader's connection, pipelining their requests (http/1.1 with keep
+// alive only). The leader's own pipeline is created on the first call.
+func (c *FastClient) Pipeline(leader *FastClient) {
+	if leader.pipe == nil {
+		leader.pipe = newPipeline()
+	}
+	c.pipe = leader.pipe
+}
+
+// close closes the shared connection, if open.
+func (p *pipeline) close() {
+	p.mu.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.mu.Unlock()
+}
+
+// done is called after reading a response on conn, kept open when keep is true. Returns
+// whether conn is still the pipeline's connection.
+func (p *pipeline) done(conn net.Conn, keep bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending--
+	if keep && p.conn != conn {
+		// replaced after a write error, nothing more to read on it
+		keep = false
+		conn.Close()
+	}
+	if !keep && p.conn == conn {
+		p.conn = nil
+	}
+	return keep
+}
+
+// pipelineFetch writes the request on the pipeline's connection, (re)connecting as needed,
+// then waits for the responses to the requests written before to be read and reads its own.
+// The latency thus includes the head of line blocking by the previous responses.
+func (c *FastClient) pipelineFetch(req []byte) (int, []byte, int) {
+	p := c.pipe
+	p.mu.Lock()
+	conn := p.conn
+	canReuse := conn != nil
+	for {
+		if conn == nil {
+			if conn = c.connect(); conn == nil {
+				p.mu.Unlock()
+				return c.returnRes()
+			}
+			p.conn = conn
+		}
+		err := conn.SetWriteDeadline(time.Now().Add(c.reqTimeout))
+		var n int
+		if err == nil {
+			n, err = conn.Write(req)
+			c.bytesSent += int64(n)
+		}
+		if err == nil && n == len(req) {
+			break
+		}
+		if p.pending == 0 {
+			conn.Close()
+		} // else closed by the reader of the last response
+		p.conn = nil
+		if err != nil && canReuse {
+			// it's ok for the (idle) socket to die once, auto reconnect:
+			log.Infof("[%d] Closing dead pipelined socket %v (%v)", c.id, c.dest, err)
+			c.errorCount++
+			canReuse = false
+			conn = nil
+			continue
+		}
+		p.mu.Unlock()
+		if err != nil {
+			log.Errf("[%d] Unable to write to %v : %v", c.id, c.dest, err)
+			c.errCategory = fnet.ErrorCategory(err)
+		} else {
+			log.Errf("[%d] Short write to %v : %d instead of %d", c.id, c.dest, n, len(req))
+			c.errCategory = fnet.ErrOther
+		}
+		return c.returnRes()
+	}
+	prev, done := p.turn, make(chan struct{})
+	p.turn = done
+	p.pending++
+	p.mu.Unlock()
+	c.phases.start(phaseTTFB)
+	defer close(done)
+	<-prev
+	if p.closed == conn {
+		log.LogVf("[%d] Pipelined connection to %v closed before the response", c.id, c.dest)
+		c.errCategory = fnet.ErrShortRead
+		c.phases.cancel(phaseTTFB)
+		p.done(conn, false)
+		return c.returnRes()
+	}
+	if p.leftConn == conn && len(p.leftover) > 0 {
+		c.size = copy(c.buffer, p.leftover)
+		c.phases.end(phaseTTFB)
+		c.phases.start(phaseTransfer)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(c.reqTimeout))
+	c.readResponse(conn, false)
+	if c.code > 0 {
+		c.phases.end(phaseTransfer)
+	} else {
+		c.phases.cancel(phaseTTFB)
+		c.phases.cancel(phaseTransfer)
+	}
+	p.leftover = p.leftover[:0]
+	p.leftConn = conn
+	keep := c.socket != nil
+	c.socket = nil // owned by the pipeline
+	if !p.done(conn, keep) {
+		// closed: the requests pipelined after this one, if any, fail
+		p.closed = conn
+		return c.returnRes()
+	}
+	p.leftover = append(p.leftover, c.buffer[c.respEnd:c.size]...)
+	c.size = c.respEnd
+	return c.returnRes()
+}
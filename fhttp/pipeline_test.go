@@ -0,0 +1,90 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// TestPipelineFetch checks the requests are all sent before any response, by a server which
+// only replies once it got them all, and that the responses, all in one write, are split.
+func TestPipelineFetch(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req []byte
+		buf := make([]byte, 4096)
+		for bytes.Count(req, []byte("\r\n\r\n")) < 3 {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			req = append(req, buf[:n]...)
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na" +
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nb\r\n1\r\nb\r\n0\r\n\r\n" +
+			"HTTP/1.1 404 Not Found\r\nContent-Length: 3\r\n\r\nccc"))
+		_, _ = conn.Read(buf) // wait for the client to close
+	}()
+	o := NewHTTPOptions(fmt.Sprintf("http://%s/", l.Addr()))
+	var clients [3]*FastClient
+	for i := range clients {
+		o.ID = i
+		c, err := NewFastClient(o)
+		if err != nil {
+			t.Fatalf("Unable to create client: %v", err)
+		}
+		clients[i] = c.(*FastClient)
+		clients[i].Pipeline(clients[0])
+	}
+	var mu sync.Mutex
+	var results []string
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *FastClient) {
+			defer wg.Done()
+			code, data, headerLen := c.Fetch()
+			mu.Lock()
+			results = append(results, fmt.Sprintf("%d %q", code, data[headerLen:]))
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	for _, c := range clients {
+		c.Close()
+	}
+	sort.Strings(results)
+	expected := []string{`200 "1\r\nb\r\n1\r\nb\r\n0\r\n\r\n"`, `200 "a"`, `404 "ccc"`}
+	if fmt.Sprint(results) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected pipelined results %q, expected %q", results, expected)
+	}
+	if clients[0].socketCount+clients[1].socketCount+clients[2].socketCount != 1 {
+		t.Errorf("Expected a single shared connection")
+	}
+}
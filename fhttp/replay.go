@@ -0,0 +1,383 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/stats"
+)
+
+// Replay orders, for HTTPRunnerOptions.ReplayOrder.
+const (
+	ReplaySequential = "sequential" // in the recorded order, shared by all the threads (default)
+	ReplayRandom     = "random"     // randomly picked requests
+	ReplayTiming     = "timing"     // recorded order at the recorded times, scaled by ReplaySpeed
+)
+
+// ReplayRequest is one recorded request. It is also the format of each line of the
+// JSONL replay files, e.g. `{"method": "POST", "url": "http://localhost:8080/x", "headers": {"Content-Type": "text/plain"}, "body": "abc", "time": 0.5}`.
+type ReplayRequest struct {
+	Method  string            `json:"method,omitempty"` // default GET, or POST when there is a body
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// Seconds since the start of the recording, for the timing order.
+	Time float64 `json:"time,omitempty"`
+}
+
+// Endpoint returns the method and url without query string, the key of the per endpoint stats.
+func (rr *ReplayRequest) Endpoint() string {
+	u, _, _ := strings.Cut(rr.URL, "?")
+	return rr.Method + " " + u
+}
+
+// harFile is the subset of the HAR (HTTP Archive) format used for replays.
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			Request         struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// LoadReplayFile reads the requests of a HAR (.har extension) or JSONL (one ReplayRequest per line) file.
+func LoadReplayFile(fname string) ([]ReplayRequest, error) {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []ReplayRequest
+	if strings.HasSuffix(strings.ToLower(fname), ".har") {
+		reqs, err = parseHAR(content)
+	} else {
+		reqs, err = parseJSONL(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse replay file %s: %w", fname, err)
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no requests in replay file %s", fname)
+	}
+	for i := range reqs {
+		r := &reqs[i]
+		if r.URL == "" {
+			return nil, fmt.Errorf("missing url for request %d of %s", i+1, fname)
+		}
+		if r.Method == "" {
+			r.Method = http.MethodGet
+			if r.Body != "" {
+				r.Method = http.MethodPost
+			}
+		}
+	}
+	log.Infof("Loaded %d requests to replay from %s", len(reqs), fname)
+	return reqs, nil
+}
+
+func parseHAR(content []byte) ([]ReplayRequest, error) {
+	var har harFile
+	if err := json.Unmarshal(content, &har); err != nil {
+		return nil, err
+	}
+	reqs := make([]ReplayRequest, 0, len(har.Log.Entries))
+	for _, e := range har.Log.Entries {
+		r := ReplayRequest{Method: e.Request.Method, URL: e.Request.URL, Body: e.Request.PostData.Text, Headers: map[string]string{}}
+		if !e.StartedDateTime.IsZero() {
+			r.Time = e.StartedDateTime.Sub(har.Log.Entries[0].StartedDateTime).Seconds()
+		}
+		for _, h := range e.Request.Headers {
+			r.Headers[h.Name] = h.Value
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+func parseJSONL(content []byte) ([]ReplayRequest, error) {
+	reqs := []ReplayRequest{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		l := bytes.TrimSpace(scanner.Bytes())
+		if len(l) == 0 {
+			continue
+		}
+		var r ReplayRequest
+		if err := json.Unmarshal(l, &r); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, scanner.Err()
+}
+
+// replaySource hands out the requests to replay, shared by all the threads of a run.
+type replaySource struct {
+	reqs   []ReplayRequest
+	order  string
+	speed  float64
+	cycle  float64 // duration of one pass through the requests, for the timing order
+	next   int64   // atomic
+	mutex  sync.Mutex
+	start  time.Time
+	random *rand.Rand
+}
+
+func newReplaySource(reqs []ReplayRequest, order string, speed float64) (*replaySource, error) {
+	switch order {
+	case "":
+		order = ReplaySequential
+	case ReplaySequential, ReplayRandom, ReplayTiming:
+	default:
+		return nil, fmt.Errorf("invalid replay order %q, should be one of %s, %s or %s",
+			order, ReplaySequential, ReplayRandom, ReplayTiming)
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+	s := &replaySource{reqs: reqs, order: order, speed: speed, random: newTemplateRand(0)}
+	if n := len(reqs); n > 1 {
+		// the last request is followed by the average interval before the next pass
+		s.cycle = reqs[n-1].Time * float64(n) / float64(n-1)
+	}
+	return s, nil
+}
+
+// reset restarts from the first request (and the clock for the timing order), used after the warmup.
+func (s *replaySource) reset() {
+	atomic.StoreInt64(&s.next, 0)
+	s.mutex.Lock()
+	s.start = time.Time{}
+	s.mutex.Unlock()
+}
+
+// pick returns the index of the next request to send and, for the timing order, when to send it.
+func (s *replaySource) pick() (int, time.Time) {
+	if s.order == ReplayRandom {
+		s.mutex.Lock()
+		idx := s.random.Intn(len(s.reqs))
+		s.mutex.Unlock()
+		return idx, time.Time{}
+	}
+	n := atomic.AddInt64(&s.next, 1) - 1
+	idx := int(n % int64(len(s.reqs)))
+	if s.order != ReplayTiming || s.cycle <= 0 {
+		return idx, time.Time{}
+	}
+	s.mutex.Lock()
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	start := s.start
+	s.mutex.Unlock()
+	offset := (float64(n/int64(len(s.reqs)))*s.cycle + s.reqs[idx].Time) / s.speed
+	return idx, start.Add(time.Duration(offset * float64(time.Second)))
+}
+
+// ReplayClient is the Fetcher used for replays: a standard client whose request
+// is replaced, for each Fetch(), by the next one from the replay source.
+type ReplayClient struct {
+	*Client
+	source  *replaySource
+	headers http.Header // base headers from the options (User-Agent, -H,...)
+	pending int         // index of the request picked by Wait(), -1 when none
+	last    int
+}
+
+// newReplayClient makes a replay client from the options (the requests url, method, headers and body replace
+// the options' ones but the other options like timeouts, TLS, resolve etc... apply).
+func newReplayClient(o *HTTPOptions, source *replaySource) (*ReplayClient, error) {
+	if !o.DisableFastClient || o.H2 || o.H3 {
+		log.LogVf("[%d] Replay uses the std client", o.ID)
+	}
+	so := *o
+	so.URL = source.reqs[0].URL
+	so.Payload = nil
+	so.tmpl = nil
+	so.TemplateData = ""
+	c, err := NewStdClient(&so)
+	if err != nil {
+		return nil, err
+	}
+	c.tmpl = nil // each request replaces the whole url, headers and body
+	return &ReplayClient{Client: c, source: source, headers: so.GenerateHeaders(), pending: -1}, nil
+}
+
+// Wait implements periodic.Waiter: for the timing order, it picks the next
+// request and waits for its scheduled time (outside of the measured latency).
+// Returns false if stop was closed while waiting.
+func (c *ReplayClient) Wait(stop chan struct{}) bool {
+	if c.source.order != ReplayTiming {
+		return true
+	}
+	idx, when := c.source.pick()
+	c.pending = idx
+	wait := time.Until(when)
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-stop:
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+// Fetch sends the next request of the replay.
+func (c *ReplayClient) Fetch() (int, []byte, int) {
+	idx := c.pending
+	c.pending = -1
+	if idx < 0 {
+		idx, _ = c.source.pick()
+	}
+	c.last = idx
+	if err := c.setRequest(c.headers, &c.source.reqs[idx]); err != nil {
+		log.Errf("[%d] Bad replay request %q : %v", c.id, c.source.reqs[idx].URL, err)
+		c.errCategory = fnet.ErrOther
+		return SocketError, []byte(err.Error()), 0
+	}
+	return c.Client.Fetch()
+}
+
+// setRequest replaces the std client's request by r, with the base headers overridden by r's.
+func (c *Client) setRequest(base http.Header, r *ReplayRequest) error {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return err
+	}
+	req := c.req
+	req.Method = r.Method
+	req.URL = u
+	req.Host = u.Host
+	req.Header = base.Clone()
+	for k, v := range r.Headers {
+		switch strings.ToLower(k) {
+		case "host":
+			req.Host = v
+		case "content-length", "connection", "keep-alive", "transfer-encoding", "upgrade":
+			// set by the transport
+		default:
+			if !strings.HasPrefix(k, ":") { // skip http/2 pseudo headers
+				req.Header.Set(k, v)
+			}
+		}
+	}
+	req.ContentLength = int64(len(r.Body))
+	req.Body = http.NoBody
+	if len(r.Body) > 0 {
+		req.Body = io.NopCloser(strings.NewReader(r.Body))
+	}
+	c.url = r.URL
+	c.body = nil
+	return nil
+}
+
+// LastURL returns the url of the last request sent.
+func (c *ReplayClient) LastURL() string {
+	return c.source.reqs[c.last].URL
+}
+
+// Endpoint returns the endpoint (method and url without query) of the last request sent.
+func (c *ReplayClient) Endpoint() string {
+	return c.source.reqs[c.last].Endpoint()
+}
+
+// EndpointStats are the results for one endpoint (method and url without query string) of a replay run.
+type EndpointStats struct {
+	Count             int64
+	Errors            int64
+	RetCodes          map[int]int64
+	DurationHistogram *stats.HistogramData
+	durations         *stats.Histogram
+}
+
+func newEndpointStats(offset, resolution float64) *EndpointStats {
+	return &EndpointStats{RetCodes: make(map[int]int64), durations: stats.NewHistogram(offset, resolution)}
+}
+
+func (es *EndpointStats) record(code int, ok bool, duration time.Duration) {
+	es.Count++
+	if !ok {
+		es.Errors++
+	}
+	es.RetCodes[code]++
+	es.durations.Record(duration.Seconds())
+}
+
+// transfer adds the src stats to es (and resets src's).
+func (es *EndpointStats) transfer(src *EndpointStats) {
+	es.Count += src.Count
+	es.Errors += src.Errors
+	for k, v := range src.RetCodes {
+		es.RetCodes[k] += v
+	}
+	es.durations.Transfer(src.durations)
+}
+
+// printEndpoints computes the percentiles and prints the per endpoint results, sorted by endpoint.
+func printEndpoints(out io.Writer, endpoints map[string]*EndpointStats, percentiles []float64) {
+	keys := make([]string, 0, len(endpoints))
+	for k := range endpoints {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	printEndpointStats(out, "Endpoint", keys, endpoints, percentiles)
+}
+
+// printEndpointStats computes the percentiles and prints the results of m in keys order.
+func printEndpointStats(out io.Writer, label string, keys []string, m map[string]*EndpointStats, percentiles []float64) {
+	for _, k := range keys {
+		es := m[k]
+		if es == nil {
+			continue
+		}
+		es.DurationHistogram = es.durations.Export().CalcPercentiles(percentiles)
+		_, _ = fmt.Fprintf(out, "%s %s : %d calls, %d errors, avg %.3f ms", label, k, es.Count, es.Errors, 1000.*es.DurationHistogram.Avg)
+		for _, p := range es.DurationHistogram.Percentiles {
+			_, _ = fmt.Fprintf(out, ", p%g %.3f ms", p.Percentile, 1000.*p.Value)
+		}
+		_, _ = fmt.Fprintln(out)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadReplayHAR(t *testing.T) {
+	har := `{"log": {"version": "1.2", "entries": [
+ {"startedDateTime": "2022-06-01T10:00:00.000Z", "request": {"method": "GET", "url": "http://localhost/a?x=1",
+  "headers": [{"name": "Accept", "value": "text/html"}]}},
+ {"startedDateTime": "2022-06-01T10:00:01.500Z", "request": {"method": "POST", "url": "http://localhost/b",
+  "headers": [], "postData": {"mimeType": "text/plain", "text": "abc"}}}
+]}}`
+	fname := filepath.Join(t.TempDir(), "test.har")
+	if err := os.WriteFile(fname, []byte(har), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := LoadReplayFile(fname)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("Expected 2 requests, got %+v", reqs)
+	}
+	if reqs[0].Endpoint() != "GET http://localhost/a" || reqs[0].Headers["Accept"] != "text/html" || reqs[0].Time != 0 {
+		t.Errorf("Unexpected first request %+v", reqs[0])
+	}
+	if reqs[1].Endpoint() != "POST http://localhost/b" || reqs[1].Body != "abc" || reqs[1].Time != 1.5 {
+		t.Errorf("Unexpected second request %+v", reqs[1])
+	}
+	// timing order: 2 requests 1.5s apart, so a 3s cycle, replayed twice faster.
+	s, err := newReplaySource(reqs, ReplayTiming, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float64{0, 0.75, 1.5, 2.25}
+	var start time.Time
+	for i, e := range expected {
+		idx, when := s.pick()
+		if i == 0 {
+			start = when
+		}
+		if idx != i%2 || when.Sub(start).Seconds() != e {
+			t.Errorf("pick %d got %d at %v, expected %d at %v", i, idx, when.Sub(start), i%2, e)
+		}
+	}
+	if _, err = newReplaySource(reqs, "bogus", 1); err == nil {
+		t.Errorf("Expected error for invalid order")
+	}
+}
+
+func TestHTTPRunnerReplay(t *testing.T) {
+	m, a := DynamicHTTPServer(false)
+	m.HandleFunc("/", EchoHandler)
+	base := fmt.Sprintf("http://localhost:%d", a.Port)
+	jsonl := fmt.Sprintf(`{"url": "%s/echo?status=201"}
+
+{"method": "POST", "url": "%s/post", "headers": {"Content-Type": "text/plain", "Content-Length": "999"}, "body": "hello"}
+{"url": "%s/notfound?status=404"}
+`, base, base, base)
+	fname := filepath.Join(t.TempDir(), "reqs.jsonl")
+	if err := os.WriteFile(fname, []byte(jsonl), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := HTTPRunnerOptions{ReplayFile: fname}
+	opts.Exactly = 9
+	opts.NumThreads = 1
+	opts.OKCodes, _ = ParseCodeSet("200-299", nil)
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if res.RetCodes[201] != 3 || res.RetCodes[200] != 3 || res.RetCodes[404] != 3 {
+		t.Errorf("Unexpected codes %+v", res.RetCodes)
+	}
+	if len(res.Endpoints) != 3 {
+		t.Fatalf("Expected 3 endpoints, got %+v", res.Endpoints)
+	}
+	post := res.Endpoints["POST "+base+"/post"]
+	if post == nil || post.Count != 3 || post.Errors != 0 || post.RetCodes[200] != 3 || post.DurationHistogram.Count != 3 {
+		t.Errorf("Unexpected post endpoint stats %+v", post)
+	}
+	notFound := res.Endpoints["GET "+base+"/notfound"]
+	if notFound == nil || notFound.Errors != 3 {
+		t.Errorf("Unexpected notfound endpoint stats %+v", notFound)
+	}
+	opts.ReplayFile = filepath.Join(t.TempDir(), "missing.jsonl")
+	if _, err = RunHTTPTest(&opts); err == nil {
+		t.Errorf("Expected error for missing replay file")
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+)
+
+const (
+	// RetryBudgetMinimum is the number of retries of a run always allowed by the RetryPolicy Budget.
+	RetryBudgetMinimum = 10
+	// DefaultRetryBackoff and DefaultRetryMaxBackoff are the RetryPolicy Backoff and MaxBackoff
+	// used by the command line flags and the REST api when not specified.
+	DefaultRetryBackoff    = 25 * time.Millisecond
+	DefaultRetryMaxBackoff = time.Second
+)
+
+// RetryPolicy is the client side retry of the failed requests of http and grpc runs.
+type RetryPolicy struct {
+	// Maximum number of attempts per request, including the first one (<= 1 means no retry).
+	MaxAttempts int
+	// Status codes to retry, e.g. 502-504 for http or Unavailable for grpc.
+	Codes *CodeSet `json:",omitempty"`
+	// Error categories (fnet.ErrConnectRefused, fnet.ErrReset,...) to retry. When neither Codes
+	// nor Errors are set, all the failed requests are retried.
+	Errors []string `json:",omitempty"`
+	// Backoff before the first retry, doubled for each next one up to MaxBackoff (if set). The actual
+	// wait is random between 0 and that value (full jitter). 0 retries immediately.
+	Backoff    time.Duration `json:",omitempty"`
+	MaxBackoff time.Duration `json:",omitempty"`
+	// Maximum retries as a fraction of the requests of the run (e.g. 0.1 for 10%), beyond the
+	// RetryBudgetMinimum. 0 means no budget.
+	Budget float64 `json:",omitempty"`
+}
+
+// NewRetryPolicy returns the policy retrying up to maxAttempts times the failures in the comma
+// separated list of codes, code ranges and error categories retryOn, e.g. "502-504,connect_refused".
+// The optional names function allows symbolic codes (see ParseCodeSet). Returns nil when
+// maxAttempts is 1 or less.
+func NewRetryPolicy(maxAttempts int, retryOn string, names func(string) (int, bool)) (*RetryPolicy, error) {
+	if maxAttempts <= 1 {
+		return nil, nil
+	}
+	p := &RetryPolicy{MaxAttempts: maxAttempts}
+	codes := []string{}
+	for _, s := range strings.Split(retryOn, ",") {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+		case fnet.IsErrorCategory(s):
+			p.Errors = append(p.Errors, s)
+		default:
+			codes = append(codes, s)
+		}
+	}
+	if len(codes) > 0 {
+		var err error
+		if p.Codes, err = ParseCodeSet(strings.Join(codes, ","), names); err != nil {
+			return nil, fmt.Errorf("%w (retry on should be codes or one of %s)", err, strings.Join(fnet.ErrorCategories, ", "))
+		}
+	}
+	return p, nil
+}
+
+// RetryOn returns whether a failure with the status code or error category (empty for
+// responses) is to be retried.
+func (p *RetryPolicy) RetryOn(code int, category string) bool {
+	if p.Codes == nil && len(p.Errors) == 0 {
+		return true
+	}
+	if p.Codes != nil && p.Codes.Contains(code) {
+		return true
+	}
+	for _, e := range p.Errors {
+		if e == category {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the (jittered) wait before the retry following the attempt number attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < math.MaxInt64/2 && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d))) //nolint:gosec // we want fast not crypto
+}
+
+// Retrier applies a RetryPolicy to the requests of a run, its budget being shared by all the
+// connections/goroutines.
+type Retrier struct {
+	policy   *RetryPolicy
+	stop     <-chan struct{}
+	requests int64 // atomic
+	retries  int64 // atomic
+}
+
+// NewRetrier returns the Retrier of a run whose abort closes stop. Returns nil when p is nil.
+func (p *RetryPolicy) NewRetrier(stop <-chan struct{}) *Retrier {
+	if p == nil {
+		return nil
+	}
+	return &Retrier{policy: p, stop: stop}
+}
+
+// Retry is called after the failed attempt number attempt (1 for the first one) of a request,
+// with the status code and error category (empty for responses) of the failure, counted in the
+// stats Reasons as reason. It returns whether to make another attempt, after waiting for the backoff.
+func (r *Retrier) Retry(s *RetryStats, attempt, code int, category, reason string) bool {
+	p := r.policy
+	if attempt >= p.MaxAttempts || !p.RetryOn(code, category) {
+		return false
+	}
+	n := atomic.AddInt64(&r.retries, 1)
+	if p.Budget > 0 && float64(n) > p.Budget*float64(atomic.LoadInt64(&r.requests)+1)+RetryBudgetMinimum {
+		atomic.AddInt64(&r.retries, -1)
+		s.BudgetExhausted++
+		log.Debugf("Retry budget exhausted, not retrying %d %s", code, category)
+		return false
+	}
+	s.Retries++
+	s.Reasons[reason]++
+	d := p.backoff(attempt)
+	log.Debugf("Retrying %s after attempt %d in %v", reason, attempt, d)
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-r.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Done records a request completed after the given attempts, successfully or not.
+func (r *Retrier) Done(s *RetryStats, attempts int, ok bool) {
+	atomic.AddInt64(&r.requests, 1)
+	s.Attempts[attempts]++
+	if ok && attempts > 1 {
+		s.SuccessAfterRetry++
+	}
+}
+
+// RetryStats are the retries of a run.
+type RetryStats struct {
+	Policy *RetryPolicy
+	// Number of requests by number of attempts made
+	Attempts map[int]int64
+	// Number of retries (attempts beyond the first one) in total and by status code or error category
+	Retries int64
+	Reasons map[string]int64 `json:",omitempty"`
+	// Requests successful after one or more retries
+	SuccessAfterRetry int64
+	// Retries not made because of the Policy Budget
+	BudgetExhausted int64
+}
+
+// NewRetryStats returns the empty stats of the policy p, nil when p is nil.
+func NewRetryStats(p *RetryPolicy) *RetryStats {
+	if p == nil {
+		return nil
+	}
+	return &RetryStats{Policy: p, Attempts: make(map[int]int64), Reasons: make(map[string]int64)}
+}
+
+// Transfer adds the counts of src into s.
+func (s *RetryStats) Transfer(src *RetryStats) {
+	for k, v := range src.Attempts {
+		s.Attempts[k] += v
+	}
+	for k, v := range src.Reasons {
+		s.Reasons[k] += v
+	}
+	s.Retries += src.Retries
+	s.SuccessAfterRetry += src.SuccessAfterRetry
+	s.BudgetExhausted += src.BudgetExhausted
+}
+
+// Print prints the retries, their reasons and the requests by number of attempts.
+func (s *RetryStats) Print(out io.Writer) {
+	requests := int64(0)
+	attempts := []int{}
+	for k, v := range s.Attempts {
+		requests += v
+		attempts = append(attempts, k)
+	}
+	amplification := 1.
+	if requests > 0 {
+		amplification = float64(requests+s.Retries) / float64(requests)
+	}
+	_, _ = fmt.Fprintf(out, "Retries: %d (%.3fx attempts per request), %d successful after retry, %d over budget\n",
+		s.Retries, amplification, s.SuccessAfterRetry, s.BudgetExhausted)
+	reasons := make([]string, 0, len(s.Reasons))
+	for k := range s.Reasons {
+		reasons = append(reasons, k)
+	}
+	sort.Strings(reasons)
+	for _, k := range reasons {
+		_, _ = fmt.Fprintf(out, "Retried %s : %d\n", k, s.Reasons[k])
+	}
+	sort.Ints(attempts)
+	for _, k := range attempts {
+		_, _ = fmt.Fprintf(out, "Attempts %d : %d (%.1f %%)\n", k, s.Attempts[k], 100.*float64(s.Attempts[k])/float64(requests))
+	}
+}
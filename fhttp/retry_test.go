@@ -0,0 +1,111 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"fortio.org/fortio/fnet"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	if p, err := NewRetryPolicy(1, "503", nil); p != nil || err != nil {
+		t.Errorf("expected no policy for 1 attempt, got %+v %v", p, err)
+	}
+	p, err := NewRetryPolicy(3, "502-504, connect_refused,reset", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if p.MaxAttempts != 3 || p.Codes.String() != "502-504" || len(p.Errors) != 2 {
+		t.Errorf("unexpected policy %+v", p)
+	}
+	tests := []struct {
+		code     int
+		category string
+		retry    bool
+	}{
+		{503, "", true},
+		{500, "", false},
+		{SocketError, fnet.ErrConnectRefused, true},
+		{SocketError, fnet.ErrReset, true},
+		{SocketError, fnet.ErrReadTimeout, false},
+	}
+	for _, tst := range tests {
+		if r := p.RetryOn(tst.code, tst.category); r != tst.retry {
+			t.Errorf("RetryOn(%d, %q) = %v, expected %v", tst.code, tst.category, r, tst.retry)
+		}
+	}
+	p, _ = NewRetryPolicy(2, "", nil)
+	if !p.RetryOn(500, "") || !p.RetryOn(SocketError, fnet.ErrOther) {
+		t.Errorf("expected all failures to be retried without retry on")
+	}
+	if _, err = NewRetryPolicy(2, "503,bogus", nil); err == nil || !strings.Contains(err.Error(), "connect_refused") {
+		t.Errorf("expected error listing the categories, got %v", err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 100, Backoff: 10 * time.Millisecond, MaxBackoff: 35 * time.Millisecond}
+	for attempt, limit := range map[int]time.Duration{1: 10, 2: 20, 3: 35, 99: 35} {
+		for i := 0; i < 50; i++ {
+			if d := p.backoff(attempt); d < 0 || d >= limit*time.Millisecond {
+				t.Errorf("backoff %v for attempt %d not within [0, %dms)", d, attempt, limit)
+			}
+		}
+	}
+	p.MaxBackoff = 0
+	if d := p.backoff(200); d < 0 {
+		t.Errorf("uncapped backoff overflowed: %v", d)
+	}
+	p.Backoff = 0
+	if d := p.backoff(3); d != 0 {
+		t.Errorf("expected no backoff, got %v", d)
+	}
+}
+
+func TestRetrierBudget(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 3, Budget: 0.5}
+	r := p.NewRetrier(nil)
+	s := NewRetryStats(p)
+	for i := 0; i < 40; i++ {
+		attempt := 1
+		for r.Retry(s, attempt, 503, "", "503") {
+			attempt++
+		}
+		r.Done(s, attempt, false)
+	}
+	// at most 50% of the requests plus the minimum
+	if s.Retries != 30 || s.BudgetExhausted == 0 || s.Reasons["503"] != 30 || s.Attempts[1]+s.Attempts[2]+s.Attempts[3] != 40 {
+		t.Errorf("unexpected retries with budget %+v", s)
+	}
+	stop := make(chan struct{})
+	close(stop)
+	p = &RetryPolicy{MaxAttempts: 2, Backoff: time.Hour}
+	r = p.NewRetrier(stop)
+	if r.Retry(NewRetryStats(p), 1, 503, "", "503") {
+		t.Errorf("expected no retry once stopped")
+	}
+	total := NewRetryStats(p)
+	total.Transfer(s)
+	out := bytes.Buffer{}
+	total.Print(&out)
+	if !strings.Contains(out.String(), "Retries: 30 (1.750x attempts per request), 0 successful after retry") ||
+		!strings.Contains(out.String(), "Retried 503 : 30\n") {
+		t.Errorf("unexpected retries output %q", out.String())
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+)
+
+// Sources of the values extracted from session steps responses.
+const (
+	ExtractHeader = "header" // Expr is the header name
+	ExtractCookie = "cookie" // Expr is the cookie name (from the Set-Cookie headers)
+	ExtractJSON   = "json"   // Expr is the dot separated json path, e.g. data.token or items.0.id
+	ExtractRegex  = "regex"  // Expr is a regular expression on the body, the value is the first group (or the whole match)
+)
+
+// Session is an ordered list of steps run by each thread/connection, as one call,
+// values extracted from the responses are available to the next steps as {var:name}
+// in their url, headers and body.
+type Session struct {
+	Steps []SessionStep `json:"steps"`
+}
+
+// SessionStep is one request of a Session.
+type SessionStep struct {
+	// Name of the step, for the per step stats (default step1, step2,...)
+	Name string `json:"name,omitempty"`
+	ReplayRequest
+	// Only run this step the first time (e.g. to login once per connection), it isn't
+	// run again later, even if a following step fails (e.g. with a 401).
+	Once bool `json:"once,omitempty"`
+	// Values to extract from the response into variables.
+	Extract []SessionExtract `json:"extract,omitempty"`
+}
+
+// SessionExtract is a value to extract from a session step's response into variable Var.
+type SessionExtract struct {
+	Var      string `json:"var"`
+	From     string `json:"from"` // ExtractHeader, ExtractCookie, ExtractJSON or ExtractRegex
+	Expr     string `json:"expr"`
+	regex    *regexp.Regexp
+	jsonPath []string
+}
+
+// LoadSessionFile reads and validates a json session file, e.g.
+// `{"steps": [{"name": "login", "url": "http://localhost:8080/login", "body": "user=x", "once": true,
+// "extract": [{"var": "token", "from": "json", "expr": "token"}]}, {"url": "http://localhost:8080/api",
+// "headers": {"Authorization": "Bearer {var:token}"}}]}`.
+func LoadSessionFile(fname string) (*Session, error) {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	if err = json.Unmarshal(content, s); err != nil {
+		return nil, fmt.Errorf("unable to parse session file %s: %w", fname, err)
+	}
+	if err = s.Init(); err != nil {
+		return nil, fmt.Errorf("invalid session file %s: %w", fname, err)
+	}
+	return s, nil
+}
+
+// Init validates the session, sets the default names and methods and compiles the extractions.
+func (s *Session) Init() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("no steps in session")
+	}
+	names := map[string]bool{}
+	allOnce := true
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step%d", i+1)
+		}
+		if names[step.Name] {
+			return fmt.Errorf("duplicate step name %q", step.Name)
+		}
+		names[step.Name] = true
+		if step.URL == "" {
+			return fmt.Errorf("missing url for step %s", step.Name)
+		}
+		if step.Method == "" {
+			step.Method = http.MethodGet
+			if step.Body != "" {
+				step.Method = http.MethodPost
+			}
+		}
+		allOnce = allOnce && step.Once
+		for j := range step.Extract {
+			e := &step.Extract[j]
+			if e.Var == "" || e.Expr == "" {
+				return fmt.Errorf("missing var or expr in extraction %d of step %s", j+1, step.Name)
+			}
+			switch e.From {
+			case ExtractHeader, ExtractCookie:
+			case ExtractJSON:
+				e.jsonPath = strings.Split(e.Expr, ".")
+			case ExtractRegex:
+				var err error
+				if e.regex, err = regexp.Compile(e.Expr); err != nil {
+					return fmt.Errorf("invalid regex in step %s: %w", step.Name, err)
+				}
+			default:
+				return fmt.Errorf("invalid extraction source %q in step %s, should be one of %s, %s, %s or %s",
+					e.From, step.Name, ExtractHeader, ExtractCookie, ExtractJSON, ExtractRegex)
+			}
+		}
+	}
+	if allOnce {
+		return fmt.Errorf("at least one step must not be once")
+	}
+	return nil
+}
+
+// expand returns the step's request with the {var:name} replaced by their value.
+func (step *SessionStep) expand(vars map[string]string) (ReplayRequest, error) {
+	r := step.ReplayRequest
+	var err error
+	if r.URL, err = expandVars(r.URL, vars); err != nil {
+		return r, err
+	}
+	if r.Body, err = expandVars(r.Body, vars); err != nil {
+		return r, err
+	}
+	if len(r.Headers) > 0 {
+		r.Headers = make(map[string]string, len(step.Headers))
+		for k, v := range step.Headers {
+			if r.Headers[k], err = expandVars(v, vars); err != nil {
+				return r, err
+			}
+		}
+	}
+	return r, nil
+}
+
+const varPrefix = "{var:"
+
+func expandVars(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, varPrefix) {
+		return s, nil
+	}
+	var res strings.Builder
+	for {
+		start := strings.Index(s, varPrefix)
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		name := s[start+len(varPrefix) : start+end]
+		v, found := vars[name]
+		if !found {
+			return "", fmt.Errorf("undefined variable %q", name)
+		}
+		res.WriteString(s[:start])
+		res.WriteString(v)
+		s = s[start+end+1:]
+	}
+	res.WriteString(s)
+	return res.String(), nil
+}
+
+// value returns the extracted value from the response headers and body.
+func (e *SessionExtract) value(header http.Header, body []byte) (string, bool) {
+	switch e.From {
+	case ExtractHeader:
+		v := header.Get(e.Expr)
+		return v, v != ""
+	case ExtractCookie:
+		for _, c := range (&http.Response{Header: header}).Cookies() {
+			if c.Name == e.Expr {
+				return c.Value, true
+			}
+		}
+	case ExtractJSON:
+		v, found := jsonLookup(body, e.jsonPath)
+		if !found {
+			return "", false
+		}
+		if s, isString := v.(string); isString {
+			return s, true
+		}
+		return jsonString(v), true
+	case ExtractRegex:
+		m := e.regex.FindSubmatch(body)
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return string(m[1]), true
+		}
+		return string(m[0]), true
+	}
+	return "", false
+}
+
+// SessionClient is the Fetcher used for session runs: a standard client running
+// all the steps of the session for each Fetch(), returning the last (or failed) step's response.
+type SessionClient struct {
+	*Client
+	session    *Session
+	headers    http.Header // base headers from the options (User-Agent, -H,...)
+	vars       map[string]string
+	done       []bool // once steps already done
+	stats      []*EndpointStats
+	offset     float64
+	resolution float64
+	failedStep string // name of the step which failed in the last Fetch(), if any
+}
+
+// newSessionClient makes a session client from the options (the steps url, method, headers and body replace
+// the options' ones but the other options like timeouts, TLS, resolve etc... apply).
+func newSessionClient(o *HTTPOptions, session *Session) (*SessionClient, error) {
+	if !o.DisableFastClient || o.H2 || o.H3 {
+		log.LogVf("[%d] Session uses the std client", o.ID)
+	}
+	so := *o
+	so.URL = session.Steps[0].URL
+	so.Payload = nil
+	so.tmpl = nil
+	so.TemplateData = ""
+	c, err := NewStdClient(&so)
+	if err != nil {
+		return nil, err
+	}
+	c.tmpl = nil // each request replaces the whole url, headers and body
+	sc := &SessionClient{
+		Client: c, session: session, headers: so.GenerateHeaders(), vars: make(map[string]string),
+		done: make([]bool, len(session.Steps)), offset: o.Offset.Seconds(), resolution: o.Resolution,
+	}
+	sc.resetStats()
+	return sc, nil
+}
+
+// resetStats clears the per step stats (e.g. after the warmup).
+func (c *SessionClient) resetStats() {
+	c.stats = make([]*EndpointStats, len(c.session.Steps))
+	for i := range c.stats {
+		c.stats[i] = newEndpointStats(c.offset, c.resolution)
+	}
+}
+
+// Fetch runs the session's steps, stopping at the first failed one.
+func (c *SessionClient) Fetch() (int, []byte, int) {
+	c.failedStep = ""
+	code, data, headerLen := SocketError, []byte(nil), 0
+	for i := range c.session.Steps {
+		step := &c.session.Steps[i]
+		if step.Once && c.done[i] {
+			continue
+		}
+		r, err := step.expand(c.vars)
+		if err == nil {
+			err = c.setRequest(c.headers, &r)
+		}
+		if err != nil {
+			log.Errf("[%d] Session step %s: %v", c.id, step.Name, err)
+			c.failedStep = step.Name
+			c.errCategory = fnet.ErrOther
+			return SocketError, []byte(err.Error()), 0
+		}
+		start := time.Now()
+		code, data, headerLen = c.Client.Fetch()
+		ok := codeIsSuccess(c.okCodes, code)
+		for j := 0; ok && j < len(step.Extract); j++ {
+			e := &step.Extract[j]
+			var v string
+			if v, ok = e.value(c.respHeader, data[headerLen:]); ok {
+				c.vars[e.Var] = v
+			} else {
+				log.Warnf("[%d] Session step %s: unable to extract %s %q for %s", c.id, step.Name, e.From, e.Expr, e.Var)
+			}
+		}
+		c.stats[i].record(code, ok, time.Since(start))
+		if !ok {
+			c.failedStep = step.Name
+			return code, data, headerLen
+		}
+		c.done[i] = true
+	}
+	return code, data, headerLen
+}
+
+// FailedStep returns the name of the step which failed in the last Fetch(), "" if all succeeded.
+func (c *SessionClient) FailedStep() string {
+	return c.failedStep
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionInit(t *testing.T) {
+	tests := []struct {
+		session Session
+		err     bool
+	}{
+		{Session{}, true},
+		{Session{Steps: []SessionStep{{ReplayRequest: ReplayRequest{URL: "http://a/"}}}}, false},
+		{Session{Steps: []SessionStep{{ReplayRequest: ReplayRequest{URL: "http://a/"}, Once: true}}}, true},
+		{Session{Steps: []SessionStep{{}}}, true},
+		{Session{Steps: []SessionStep{{Name: "x", ReplayRequest: ReplayRequest{URL: "http://a/"}},
+			{Name: "x", ReplayRequest: ReplayRequest{URL: "http://a/"}}}}, true},
+		{Session{Steps: []SessionStep{{ReplayRequest: ReplayRequest{URL: "http://a/"},
+			Extract: []SessionExtract{{Var: "v", From: "xpath", Expr: "/a"}}}}}, true},
+		{Session{Steps: []SessionStep{{ReplayRequest: ReplayRequest{URL: "http://a/"},
+			Extract: []SessionExtract{{Var: "v", From: ExtractRegex, Expr: "("}}}}}, true},
+	}
+	for i, tst := range tests {
+		err := tst.session.Init()
+		if (err != nil) != tst.err {
+			t.Errorf("%d: got error %v, expected error %v", i, err, tst.err)
+		}
+	}
+	s := Session{Steps: []SessionStep{{ReplayRequest: ReplayRequest{URL: "http://a/", Body: "x"}}}}
+	if err := s.Init(); err != nil || s.Steps[0].Name != "step1" || s.Steps[0].Method != http.MethodPost {
+		t.Errorf("Unexpected defaults %+v %v", s.Steps[0], err)
+	}
+	if _, err := expandVars("a{var:missing}", nil); err == nil {
+		t.Errorf("Expected error for undefined variable")
+	}
+	if v, _ := expandVars("{var:a}-{var:b}{}", map[string]string{"a": "1", "b": "2"}); v != "1-2{}" {
+		t.Errorf("Unexpected expansion %q", v)
+	}
+}
+
+func TestHTTPRunnerSession(t *testing.T) {
+	m, a := DynamicHTTPServer(false)
+	var logins int64
+	m.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&logins, 1)
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s123"})
+		w.Header().Set("X-Id", "42")
+		_, _ = w.Write([]byte(`{"data": {"token": "tok-` + r.FormValue("user") + `"}}`))
+	})
+	m.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok-bob" || r.Header.Get("Cookie") != "sid=s123" || r.URL.Path != "/api/42" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("item=7 ok"))
+	})
+	m.HandleFunc("/item/7", func(w http.ResponseWriter, r *http.Request) {})
+	base := fmt.Sprintf("http://localhost:%d", a.Port)
+	session := fmt.Sprintf(`{"steps": [
+ {"name": "login", "url": "%s/login", "method": "POST", "headers": {"Content-Type": "application/x-www-form-urlencoded"},
+  "body": "user=bob", "once": true, "extract": [
+   {"var": "token", "from": "json", "expr": "data.token"},
+   {"var": "sid", "from": "cookie", "expr": "sid"},
+   {"var": "id", "from": "header", "expr": "X-Id"}]},
+ {"name": "api", "url": "%s/api/{var:id}", "headers": {"Authorization": "Bearer {var:token}", "Cookie": "sid={var:sid}"},
+  "extract": [{"var": "item", "from": "regex", "expr": "item=(\\d+)"}]},
+ {"name": "item", "url": "%s/item/{var:item}"}
+]}`, base, base, base)
+	fname := filepath.Join(t.TempDir(), "session.json")
+	if err := os.WriteFile(fname, []byte(session), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := HTTPRunnerOptions{SessionFile: fname}
+	opts.QPS = 100
+	opts.Exactly = 10
+	opts.NumThreads = 2
+	res, err := RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if res.RetCodes[http.StatusOK] != 10 || res.DurationHistogram.Count != 10 {
+		t.Errorf("Unexpected results %+v", res.RetCodes)
+	}
+	if logins != 2 {
+		t.Errorf("Expected 1 login per thread, got %d", logins)
+	}
+	if len(res.Steps) != 3 {
+		t.Fatalf("Expected 3 steps stats, got %+v", res.Steps)
+	}
+	login, api := res.Steps["login"], res.Steps["api"]
+	if login.Count != 2 || login.Errors != 0 || login.DurationHistogram.Count != 2 {
+		t.Errorf("Unexpected login step stats %+v", login)
+	}
+	if api.Count != 10 || api.Errors != 0 || res.Steps["item"].Count != 10 {
+		t.Errorf("Unexpected api step stats %+v", api)
+	}
+	// Missing extraction fails the step and the call:
+	session = fmt.Sprintf(`{"steps": [{"url": "%s/item/7", "extract": [{"var": "x", "from": "header", "expr": "X-Missing"}]},
+ {"url": "%s/item/{var:x}"}]}`, base, base)
+	if err = os.WriteFile(fname, []byte(session), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts.AllowInitialErrors = true
+	res, err = RunHTTPTest(&opts)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if res.Steps["step1"].Errors != 10 || res.Steps["step2"].Count != 0 || res.ErrorsDurationHistogram.Count != 10 {
+		t.Errorf("Unexpected failed session stats %+v %+v", res.Steps["step1"], res.Steps["step2"])
+	}
+}
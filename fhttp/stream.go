@@ -0,0 +1,153 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"time"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+)
+
+// streamReader reads the body from the connection, counting the bytes and copying
+// the first ones, up to keepEnd, in the client's buffer.
+type streamReader struct {
+	c       *FastClient
+	conn    net.Conn
+	keepEnd int
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.c.events != nil {
+		// the timeout is the maximum time without data for the (long lived) events streams
+		_ = r.conn.SetReadDeadline(time.Now().Add(r.c.reqTimeout))
+	}
+	n, err := r.conn.Read(p)
+	r.c.streamed += int64(n)
+	if r.c.size < r.keepEnd {
+		r.c.size += copy(r.c.buffer[r.c.size:r.keepEnd], p[:n])
+	}
+	return n, err
+}
+
+// streamBody reads, whatever its size, the rest of the body of the response whose headers
+// were just parsed: only its first StreamKeep bytes are kept in the buffer, the rest is
+// hashed (with StreamHash) and discarded. Returns whether the connection can be kept alive.
+func (c *FastClient) streamBody(conn net.Conn, keepAlive bool) bool {
+	headers := c.buffer[:c.headerLen]
+	// body bytes read along with the headers, not overwritten as the new ones are kept after c.size
+	pre := c.buffer[c.headerLen:c.size]
+	c.streamed = int64(c.size)
+	keepEnd := c.headerLen + c.streamKeep
+	if keepEnd > len(c.buffer) {
+		keepEnd = len(c.buffer)
+	}
+	raw := io.MultiReader(bytes.NewReader(pre), &streamReader{c: c, conn: conn, keepEnd: keepEnd})
+	var body io.Reader
+	var br *bufio.Reader
+	contentLength := -1
+	if found, offset := FoldFind(headers, contentLengthHeader); found {
+		contentLength = ParseDecimal(headers[offset+len(contentLengthHeader):])
+	}
+	switch {
+	case contentLength >= 0:
+		body = io.LimitReader(raw, int64(contentLength))
+	case foldContains(headers, chunkedHeader):
+		br = bufio.NewReader(raw)
+		body = httputil.NewChunkedReader(br)
+	default:
+		log.Debugf("[%d] No content-length nor chunked encoding, streaming until the connection is closed", c.id)
+		body = raw
+		keepAlive = false
+	}
+	if CheckConnectionClosedHeader && foldContains(headers, connectionCloseHeader) {
+		keepAlive = false
+	}
+	var w io.Writer = io.Discard
+	if c.hash != nil {
+		c.hash.Reset()
+		w = c.hash
+	}
+	if c.events != nil {
+		c.events.begin(foldContains(headers, eventStreamHeader))
+		if c.hash != nil {
+			w = io.MultiWriter(c.hash, c.events)
+		} else {
+			w = c.events
+		}
+	}
+	n, err := io.CopyBuffer(w, body, c.streamBuf)
+	if err == nil && contentLength >= 0 && n < int64(contentLength) {
+		err = fmt.Errorf("connection closed after %d bytes of the %d bytes body: %w", n, contentLength, io.ErrUnexpectedEOF)
+	}
+	if err == nil && br != nil {
+		err = skipTrailer(br)
+	}
+	if c.events != nil {
+		c.events.end(err)
+	}
+	if c.size > keepEnd {
+		c.size = keepEnd
+	}
+	if err != nil {
+		log.Errf("[%d] Read error for %v after streaming %d bytes : %v", c.id, c.dest, c.streamed, err)
+		c.code = SocketError
+		c.errCategory = fnet.ErrorCategory(err)
+		return false
+	}
+	if c.hash != nil {
+		c.bodyHash = hex.EncodeToString(c.hash.Sum(nil))
+	}
+	c.streamedBody = n
+	log.Debugf("[%d] Streamed %d bytes body, response size %d", c.id, n, c.streamed)
+	return keepAlive
+}
+
+// skipTrailer reads the (usually empty) trailer after the last chunk of a chunked body.
+func skipTrailer(br *bufio.Reader) error {
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return nil
+		}
+	}
+}
+
+func foldContains(haystack []byte, needle []byte) bool {
+	found, _ := FoldFind(haystack, needle)
+	return found
+}
+
+// StreamedSizes returns the size, headers included, of the last streamed (StreamBody) response
+// and the size of its dechunked body, 0s if it wasn't streamed.
+func (c *FastClient) StreamedSizes() (int64, int64) {
+	return c.streamed, c.streamedBody
+}
+
+// BodyHash returns the hex encoded sha256 of the last streamed (and dechunked) body, when
+// StreamHash is set, "" otherwise.
+func (c *FastClient) BodyHash() string {
+	return c.bodyHash
+}
@@ -0,0 +1,533 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Request templates: the url, headers values and payload can contain the following
+// {tokens}, replaced for each request (other {...} are left as is, e.g. in json payloads):
+//
+//	{uuid}             a random uuid
+//	{seq} {seq:start}  sequence number, shared by all the connections of a run (starting at 0 or start)
+//	{rand:min:max}     random integer between min and max (inclusive)
+//	{randstr:n}        random alphanumerical string of n characters ({randstr:min:max} for a random length)
+//	{thread}           thread/connection id
+//	{ts} {ts:s} {ts:ns} {ts:rfc3339}  current time, in unix milliseconds (default), seconds, nanoseconds or RFC3339
+//	{data:column}      value of column (name or 0 based index) of the current row of the TemplateData file,
+//	                   rows are used round robin or randomly (TemplateDataRandom), one row per request.
+//
+// Values are inserted as is, without url or json escaping. Tokens can't be in the scheme,
+// host or port of the url.
+
+const (
+	// markers replacing the tokens in the url, headers and payload so the clients can be
+	// built normally from them and the values substituted for each request. The prefix
+	// is followed by a random part, per template, that isn't anywhere in the request.
+	markerPrefix = "_fortio_tpl_"
+	alphaNum     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+type tokenKind int
+
+const (
+	tokUUID tokenKind = iota
+	tokSeq
+	tokRand
+	tokRandStr
+	tokThread
+	tokTime
+	tokData
+)
+
+type templateToken struct {
+	kind   tokenKind
+	a, b   int    // seq start, rand/randstr range, data column
+	format string // for timestamps
+}
+
+// requestTemplate is the parsed url, headers and payload, with the tokens replaced
+// by markers, shared by all the clients of a run (for {seq} and the data rows).
+type requestTemplate struct {
+	tokens     []templateToken
+	marker     string // the tokens are replaced by marker<index>_ and the Content-Length by marker"len_"
+	markerB    []byte // marker, for searching the requests
+	url        string
+	payload    []byte
+	headers    http.Header // all the headers, with markers
+	urlTokens  bool
+	bodyTokens bool
+	seq        int64 // atomic
+	row        int64 // atomic, for round robin data rows
+	random     bool
+	data       [][]string
+	columns    map[string]int
+}
+
+// newRequestTemplate parses the tokens of the (initialized) options, returns nil when there isn't any.
+func newRequestTemplate(o *HTTPOptions) (*requestTemplate, error) {
+	t := &requestTemplate{random: o.TemplateDataRandom}
+	t.setMarker(o)
+	var err error
+	if t.url, err = t.parse(o.URL); err != nil {
+		return nil, err
+	}
+	t.urlTokens = len(t.tokens) > 0
+	if t.urlTokens && strings.Contains(urlDestination(t.url), t.marker) {
+		// only the path and query are expanded for each request, the connections are to a fixed destination
+		return nil, fmt.Errorf("{tokens} can only be in the path and query of the url, not its scheme, host or port: %q", o.URL)
+	}
+	payload, err := t.parse(string(o.Payload))
+	if err != nil {
+		return nil, err
+	}
+	t.bodyTokens = len(t.tokens) > 0 && strings.Contains(payload, t.marker)
+	t.headers = o.GenerateHeaders().Clone()
+	for k, values := range t.headers {
+		for i, v := range values {
+			if values[i], err = t.parse(v); err != nil {
+				return nil, err
+			}
+		}
+		t.headers[k] = values
+	}
+	if len(t.tokens) == 0 {
+		return nil, nil
+	}
+	if t.bodyTokens {
+		t.headers.Set(contentLength, t.marker+"len_")
+	}
+	t.payload = []byte(payload)
+	if err = t.loadData(o.TemplateData); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// setMarker picks the random marker, retrying in the (unlikely) case it is already
+// in the url, method, headers or payload as their literal text would then be expanded.
+func (t *requestTemplate) setMarker(o *HTTPOptions) {
+	inputs := []string{o.URL, o.Method(), string(o.Payload)}
+	for k, values := range o.GenerateHeaders() {
+		inputs = append(inputs, k)
+		inputs = append(inputs, values...)
+	}
+	for {
+		m := make([]byte, 0, len(markerPrefix)+9)
+		m = append(m, markerPrefix...)
+		for i := 0; i < 8; i++ {
+			m = append(m, alphaNum[rand.Intn(len(alphaNum))]) //nolint:gosec // only needs to be unlikely in the inputs
+		}
+		m = append(m, '_')
+		t.marker, t.markerB = string(m), m
+		found := false
+		for _, in := range inputs {
+			if strings.Contains(in, t.marker) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// urlDestination returns the scheme://host:port part of the url (with the user info if any).
+func urlDestination(u string) string {
+	start := 0
+	if i := strings.Index(u, "://"); i >= 0 {
+		start = i + 3
+	}
+	if end := strings.IndexAny(u[start:], "/?#"); end >= 0 {
+		return u[:start+end]
+	}
+	return u
+}
+
+// options returns a copy of o with the url, payload and headers replaced by their marked version.
+func (t *requestTemplate) options(o *HTTPOptions) *HTTPOptions {
+	mo := *o
+	mo.URL = t.url
+	mo.Payload = t.payload
+	mo.extraHeaders = t.headers.Clone()
+	mo.tmpl = t
+	return &mo
+}
+
+// parse replaces the tokens in s by markers and records them.
+func (t *requestTemplate) parse(s string) (string, error) {
+	var res strings.Builder
+	for {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		tok, found, err := parseToken(s[start+1 : end])
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			res.WriteString(s[:start+1])
+			s = s[start+1:]
+			continue
+		}
+		res.WriteString(s[:start])
+		res.WriteString(t.marker + strconv.Itoa(len(t.tokens)) + "_")
+		t.tokens = append(t.tokens, tok)
+		s = s[end+1:]
+	}
+	res.WriteString(s)
+	return res.String(), nil
+}
+
+// parseToken parses the inside of a {token}, found is false for unknown ones.
+//
+//nolint:funlen,gocyclo
+func parseToken(s string) (templateToken, bool, error) {
+	args := strings.Split(s, ":")
+	tok := templateToken{}
+	var err error
+	switch args[0] {
+	case "uuid":
+		tok.kind = tokUUID
+		if len(args) != 1 {
+			return tok, false, nil
+		}
+	case "seq":
+		tok.kind = tokSeq
+		if len(args) > 2 {
+			return tok, true, fmt.Errorf("invalid {%s}, should be {seq} or {seq:start}", s)
+		}
+		if len(args) == 2 {
+			if tok.a, err = strconv.Atoi(args[1]); err != nil {
+				return tok, true, fmt.Errorf("invalid {%s} start: %w", s, err)
+			}
+		}
+	case "rand", "randstr":
+		tok.kind = tokRand
+		if args[0] == "randstr" {
+			tok.kind = tokRandStr
+		}
+		if len(args) < 2 || len(args) > 3 || (tok.kind == tokRand && len(args) != 3) {
+			return tok, true, fmt.Errorf("invalid {%s}, should be {rand:min:max}, {randstr:n} or {randstr:min:max}", s)
+		}
+		if tok.a, err = strconv.Atoi(args[1]); err != nil {
+			return tok, true, fmt.Errorf("invalid {%s}: %w", s, err)
+		}
+		tok.b = tok.a
+		if len(args) == 3 {
+			if tok.b, err = strconv.Atoi(args[2]); err != nil {
+				return tok, true, fmt.Errorf("invalid {%s}: %w", s, err)
+			}
+		}
+		if tok.b < tok.a || (tok.kind == tokRandStr && tok.a < 0) {
+			return tok, true, fmt.Errorf("invalid {%s} range", s)
+		}
+	case "thread":
+		tok.kind = tokThread
+		if len(args) != 1 {
+			return tok, false, nil
+		}
+	case "ts":
+		tok.kind = tokTime
+		tok.format = "ms"
+		if len(args) > 2 {
+			return tok, true, fmt.Errorf("invalid {%s}, should be {ts} or {ts:format}", s)
+		}
+		if len(args) == 2 {
+			tok.format = args[1]
+		}
+		switch tok.format {
+		case "ms", "s", "ns", "rfc3339":
+		default:
+			return tok, true, fmt.Errorf("invalid {%s}, format should be one of ms, s, ns or rfc3339", s)
+		}
+	case "data":
+		tok.kind = tokData
+		if len(args) != 2 || args[1] == "" {
+			return tok, true, fmt.Errorf("invalid {%s}, should be {data:column}", s)
+		}
+		tok.format = args[1] // column, resolved once the data is loaded
+	default:
+		return tok, false, nil
+	}
+	return tok, true, nil
+}
+
+// loadData reads the csv (with a header line) or json (array of objects) data file, when needed.
+func (t *requestTemplate) loadData(fname string) error {
+	needsData := false
+	for _, tok := range t.tokens {
+		if tok.kind == tokData {
+			needsData = true
+		}
+	}
+	if !needsData {
+		return nil
+	}
+	if fname == "" {
+		return fmt.Errorf("{data:...} tokens need a template data file")
+	}
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	var header []string
+	if strings.HasSuffix(strings.ToLower(fname), ".json") {
+		header, t.data, err = parseJSONData(content)
+	} else {
+		var records [][]string
+		records, err = csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err == nil && len(records) > 0 {
+			header, t.data = records[0], records[1:]
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse template data %s: %w", fname, err)
+	}
+	if len(t.data) == 0 {
+		return fmt.Errorf("no data rows in %s", fname)
+	}
+	t.columns = make(map[string]int, len(header))
+	for i, h := range header {
+		t.columns[h] = i
+	}
+	for i, tok := range t.tokens {
+		if tok.kind != tokData {
+			continue
+		}
+		col, found := t.columns[tok.format]
+		if !found {
+			if col, err = strconv.Atoi(tok.format); err != nil || col < 0 || col >= len(header) {
+				return fmt.Errorf("unknown column %q in %s", tok.format, fname)
+			}
+		}
+		t.tokens[i].a = col
+	}
+	return nil
+}
+
+// parseJSONData converts an array of objects into rows, the columns being the sorted keys of all the objects.
+func parseJSONData(content []byte) ([]string, [][]string, error) {
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(content, &objects); err != nil {
+		return nil, nil, err
+	}
+	keys := map[string]bool{}
+	for _, o := range objects {
+		for k := range o {
+			keys[k] = true
+		}
+	}
+	header := make([]string, 0, len(keys))
+	for k := range keys {
+		header = append(header, k)
+	}
+	sort.Strings(header)
+	rows := make([][]string, 0, len(objects))
+	for _, o := range objects {
+		row := make([]string, len(header))
+		for i, k := range header {
+			v, found := o[k]
+			if !found {
+				continue
+			}
+			if s, isString := v.(string); isString {
+				row[i] = s
+			} else {
+				j, _ := json.Marshal(v)
+				row[i] = string(j)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return header, rows, nil
+}
+
+// values returns the values of the tokens for a new request, reusing the vals buffers
+// (of the previous request of that client).
+func (t *requestTemplate) values(vals [][]byte, rnd *rand.Rand, id int) [][]byte {
+	if len(vals) != len(t.tokens) {
+		vals = make([][]byte, len(t.tokens))
+	}
+	var row []string
+	if t.data != nil {
+		if t.random {
+			row = t.data[rnd.Intn(len(t.data))]
+		} else {
+			row = t.data[(atomic.AddInt64(&t.row, 1)-1)%int64(len(t.data))]
+		}
+	}
+	now := time.Now()
+	for i, tok := range t.tokens {
+		v := vals[i][:0]
+		switch tok.kind {
+		case tokUUID:
+			v = appendUUID(v, uuid.Must(uuid.NewRandomFromReader(rnd)))
+		case tokSeq:
+			v = strconv.AppendInt(v, int64(tok.a)+atomic.AddInt64(&t.seq, 1)-1, 10)
+		case tokRand:
+			v = strconv.AppendInt(v, int64(tok.a+rnd.Intn(tok.b-tok.a+1)), 10)
+		case tokRandStr:
+			for j := tok.a + rnd.Intn(tok.b-tok.a+1); j > 0; j-- {
+				v = append(v, alphaNum[rnd.Intn(len(alphaNum))])
+			}
+		case tokThread:
+			v = strconv.AppendInt(v, int64(id), 10)
+		case tokTime:
+			switch tok.format {
+			case "s":
+				v = strconv.AppendInt(v, now.Unix(), 10)
+			case "ns":
+				v = strconv.AppendInt(v, now.UnixNano(), 10)
+			case "rfc3339":
+				v = now.AppendFormat(v, time.RFC3339)
+			default:
+				v = strconv.AppendInt(v, now.UnixNano()/int64(time.Millisecond), 10)
+			}
+		case tokData:
+			v = append(v, row[tok.a]...)
+		}
+		vals[i] = v
+	}
+	return vals
+}
+
+// appendUUID appends the canonical xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx form of u to b.
+func appendUUID(b []byte, u uuid.UUID) []byte {
+	const hexDigits = "0123456789abcdef"
+	for i, c := range u {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			b = append(b, '-')
+		}
+		b = append(b, hexDigits[c>>4], hexDigits[c&0x0f])
+	}
+	return b
+}
+
+// expand appends b, with the markers replaced by their value, to res. The len marker
+// is replaced by bodyLen.
+func (t *requestTemplate) expand(res, b []byte, vals [][]byte, bodyLen int) ([]byte, error) {
+	for {
+		idx := bytes.Index(b, t.markerB)
+		if idx < 0 {
+			return append(res, b...), nil
+		}
+		res = append(res, b[:idx]...)
+		b = b[idx+len(t.markerB):]
+		end := bytes.IndexByte(b, '_')
+		if end < 0 {
+			return res, fmt.Errorf("unterminated template marker in %q", DebugSummary(b, 40))
+		}
+		name := b[:end]
+		b = b[end+1:]
+		if string(name) == "len" {
+			res = strconv.AppendInt(res, int64(bodyLen), 10)
+			continue
+		}
+		n := markerIndex(name)
+		if n < 0 || n >= len(vals) {
+			return res, fmt.Errorf("invalid template marker %q", name)
+		}
+		res = append(res, vals[n]...)
+	}
+}
+
+// markerIndex returns the token index of a marker, -1 if it isn't a decimal number.
+func markerIndex(name []byte) int {
+	if len(name) == 0 {
+		return -1
+	}
+	n := 0
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return -1
+		}
+		n = 10*n + int(c-'0')
+	}
+	return n
+}
+
+func (t *requestTemplate) expandString(s string, vals [][]byte) (string, error) {
+	if !strings.Contains(s, t.marker) {
+		return s, nil
+	}
+	res, err := t.expand(nil, []byte(s), vals, 0)
+	return string(res), err
+}
+
+// apply sets req's url, headers and returns the body for a new request of a
+// client built from the marked options (with path, rawQuery, body and headers the marked originals).
+func (t *requestTemplate) apply(req *http.Request, u *url.URL, vals [][]byte) ([]byte, error) {
+	var err error
+	if req.URL.Path, err = t.expandString(u.Path, vals); err != nil {
+		return nil, err
+	}
+	if req.URL.RawQuery, err = t.expandString(u.RawQuery, vals); err != nil {
+		return nil, err
+	}
+	for k, values := range t.headers {
+		if k == contentLength {
+			continue
+		}
+		for i, v := range values {
+			if !strings.Contains(v, t.marker) {
+				continue
+			}
+			if req.Header[k][i], err = t.expandString(v, vals); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !t.bodyTokens {
+		return t.payload, nil
+	}
+	return t.expand(nil, t.payload, vals, 0)
+}
+
+// template returns the run's shared request template (set by RunHTTPTest) or parses
+// a new one, nil if the options don't have any {token}.
+func (h *HTTPOptions) template() (*requestTemplate, error) {
+	if h.tmpl != nil {
+		return h.tmpl, nil
+	}
+	return newRequestTemplate(h)
+}
+
+// newTemplateRand returns the (non thread safe so one per client) random generator for the template values.
+func newTemplateRand(id int) *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))) //nolint:gosec // we want fast not crypto
+}
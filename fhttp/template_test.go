@@ -0,0 +1,179 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestTemplateParse(t *testing.T) {
+	tests := []struct {
+		in     string
+		tokens int
+		err    bool
+	}{
+		{"http://a/b", 0, false},
+		{`{"json": {"x": 1}}`, 0, false},
+		{"{uuid}{seq}{seq:10}{rand:1:6}{randstr:8}{randstr:2:5}{thread}{ts}{ts:rfc3339}", 9, false},
+		{`{"a": "{uuid}"}`, 1, false},
+		{"{uuid:x} {thread:1} {unknown}", 0, false},
+		{"{seq:x}", 0, true},
+		{"{rand:5}", 0, true},
+		{"{rand:6:1}", 0, true},
+		{"{randstr:-1}", 0, true},
+		{"{ts:hours}", 0, true},
+		{"{data:}", 0, true},
+	}
+	for _, tst := range tests {
+		tmpl := &requestTemplate{}
+		res, err := tmpl.parse(tst.in)
+		if (err != nil) != tst.err {
+			t.Errorf("parse(%q) error %v, expected error %v", tst.in, err, tst.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(tmpl.tokens) != tst.tokens {
+			t.Errorf("parse(%q) got %d tokens, expected %d (%q)", tst.in, len(tmpl.tokens), tst.tokens, res)
+		}
+		if tst.tokens == 0 && res != tst.in {
+			t.Errorf("parse(%q) changed to %q", tst.in, res)
+		}
+	}
+	o := HTTPOptions{URL: "http://localhost/{data:id}"}
+	o.Init(o.URL)
+	if _, err := newRequestTemplate(&o); err == nil {
+		t.Errorf("Expected error for {data:...} without data file")
+	}
+	for _, u := range []string{"http://host-{seq}/", "https://localhost:80{thread}/a", "http://{uuid}@localhost?a=b"} {
+		o = HTTPOptions{URL: u}
+		o.Init(o.URL)
+		if _, err := newRequestTemplate(&o); err == nil || !strings.Contains(err.Error(), "not its scheme, host or port") {
+			t.Errorf("Expected error for tokens in the destination of %q, got %v", u, err)
+		}
+	}
+	o = HTTPOptions{URL: "http://localhost:8080/{seq}?a={thread}#{uuid}"}
+	o.Init(o.URL)
+	if _, err := newRequestTemplate(&o); err != nil {
+		t.Errorf("Unexpected error for tokens in the path and query: %v", err)
+	}
+}
+
+func TestRequestTemplateFetch(t *testing.T) {
+	m, a := DynamicHTTPServer(false)
+	m.HandleFunc("/", EchoHandler)
+	dataFile := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(dataFile, []byte("id,name\n1,alice\n2,bob\n3,carol\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://localhost:%d/{data:id}?t={thread}&r={rand:10:20}", a.Port)
+	payload := `{"seq": {seq:5}, "name": "{data:name}", "s": "{randstr:1:30}", "col": "{data:0}"}`
+	bodyRe := regexp.MustCompile(`^{"seq": (\d+), "name": "(\w+)", "s": "[a-zA-Z0-9]{1,30}", "col": "(\d)"}$`)
+	names := []string{"alice", "bob", "carol"}
+	for _, std := range []bool{false, true} {
+		o := HTTPOptions{URL: url, DisableFastClient: std, Payload: []byte(payload), TemplateData: dataFile}
+		o.ID = 7
+		client, err := NewClient(&o)
+		if err != nil {
+			t.Fatalf("Std %v: unexpected error %v", std, err)
+		}
+		for j := 0; j < 5; j++ {
+			code, data, header := client.Fetch()
+			if code != 200 {
+				t.Errorf("Std %v: got %d instead of 200: %s", std, code, DebugSummary(data, 256))
+				continue
+			}
+			m := bodyRe.FindSubmatch(data[header:])
+			if m == nil {
+				t.Errorf("Std %v: unexpected body %q", std, data[header:])
+				continue
+			}
+			if string(m[1]) != fmt.Sprint(5+j) || string(m[2]) != names[j%3] || string(m[3]) != fmt.Sprint(1+j%3) {
+				t.Errorf("Std %v: unexpected values %q for request %d", std, data[header:], j)
+			}
+			expected := regexp.MustCompile(fmt.Sprintf(`^http://localhost:%d/%d\?t=7&r=(1\d|20)$`, a.Port, 1+j%3))
+			if last := client.LastURL(); !expected.MatchString(last) {
+				t.Errorf("Std %v: unexpected LastURL %q", std, last)
+			}
+		}
+		client.Close()
+	}
+}
+
+func TestRequestTemplateHeaders(t *testing.T) {
+	m, a := DynamicHTTPServer(false)
+	m.HandleFunc("/", EchoHandler)
+	url := fmt.Sprintf("http://localhost:%d/?header=X-Echo:ok", a.Port)
+	for _, std := range []bool{false, true} {
+		o := HTTPOptions{URL: url, DisableFastClient: std}
+		o.InitHeaders()
+		if err := o.AddAndValidateExtraHeader("X-Seq: {seq}-{ts:s}"); err != nil {
+			t.Fatal(err)
+		}
+		client, _ := NewClient(&o)
+		for j := 0; j < 2; j++ {
+			code, _, _ := client.Fetch()
+			if code != 200 {
+				t.Errorf("Std %v: got %d instead of 200", std, code)
+			}
+		}
+		var last string
+		if std {
+			last = client.(*Client).req.Header.Get("X-Seq")
+		} else {
+			last = string(client.(*FastClient).reqBuf)
+		}
+		if !strings.Contains(last, "1-") || strings.Contains(last, markerPrefix) {
+			t.Errorf("Std %v: unexpected last header %q", std, last)
+		}
+		client.Close()
+	}
+}
+
+func TestRequestTemplateLiteralMarker(t *testing.T) {
+	m, a := DynamicHTTPServer(false)
+	m.HandleFunc("/", EchoHandler)
+	payload := `{"a": "_fortio_tpl_0_", "b": "_fortio_tpl_len", "seq": {seq}}`
+	for _, std := range []bool{false, true} {
+		o := HTTPOptions{URL: fmt.Sprintf("http://localhost:%d/_fortio_tpl_x", a.Port), DisableFastClient: std, Payload: []byte(payload)}
+		client, err := NewClient(&o)
+		if err != nil {
+			t.Fatalf("Std %v: unexpected error %v", std, err)
+		}
+		for j := 0; j < 2; j++ {
+			code, data, header := client.Fetch()
+			expected := fmt.Sprintf(`{"a": "_fortio_tpl_0_", "b": "_fortio_tpl_len", "seq": %d}`, j)
+			if code != 200 || string(data[header:]) != expected {
+				t.Errorf("Std %v: got %d %q, expected %q", std, code, data[header:], expected)
+			}
+		}
+		client.Close()
+	}
+	tmpl := &requestTemplate{marker: "_m_", markerB: []byte("_m_")}
+	for _, bad := range []string{"a_m_3_b", "a_m_x_b", "a_m_0"} {
+		if _, err := tmpl.expand(nil, []byte(bad), [][]byte{[]byte("v")}, 0); err == nil {
+			t.Errorf("Expected error expanding %q", bad)
+		}
+	}
+	if res, err := tmpl.expand(nil, []byte("a_m_0_b_m_len_"), [][]byte{[]byte("v")}, 42); err != nil || string(res) != "avb42" {
+		t.Errorf("Unexpected expand result %q %v", res, err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fortio.org/fortio/log"
+)
+
+// DefaultThrottleBackoff is the pause after 429 and 503 responses without a (valid) Retry-After
+// header, when HonorRetryAfter is set and ThrottleBackoff isn't.
+const DefaultThrottleBackoff = time.Second
+
+// isThrottled returns true for the status codes of servers shedding load.
+func isThrottled(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter parses a Retry-After header value, either a number of seconds or an http
+// date, into the wait from now (0 for dates in the past).
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// retryAfter returns the pause requested by the last (429 or 503) response, as data and headerSize
+// returned by the client's Fetch(): its Retry-After header or the throttleBackoff.
+func (httpstate *HTTPRunnerResults) retryAfter(data []byte, headerSize int) time.Duration {
+	var v string
+	if c, ok := httpstate.client.(*Client); ok {
+		v = c.respHeader.Get("Retry-After")
+	} else if headerSize > 0 && headerSize <= len(data) {
+		v = parseRawHeaders(data[:headerSize]).Get("Retry-After")
+	}
+	d, ok := parseRetryAfter(v, time.Now())
+	if !ok {
+		if v != "" {
+			log.Warnf("Invalid Retry-After %q, using %v", v, httpstate.throttleBackoff)
+		}
+		return httpstate.throttleBackoff
+	}
+	return d
+}
+
+// Throttle implements periodic.Throttler: returns the pause requested by the last response,
+// with HonorRetryAfter.
+func (httpstate *HTTPRunnerResults) Throttle(_ int) time.Duration {
+	d := httpstate.throttle
+	httpstate.throttle = 0
+	return d
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 10, 21, 7, 28, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Fri, 21 Oct 2022 07:28:30 GMT", 30 * time.Second, true},
+		{"Fri, 21 Oct 2022 07:27:00 GMT", 0, true}, // in the past
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tst := range tests {
+		if d, ok := parseRetryAfter(tst.value, now); d != tst.expected || ok != tst.ok {
+			t.Errorf("parseRetryAfter(%q) = %v %v, expected %v %v", tst.value, d, ok, tst.expected, tst.ok)
+		}
+	}
+	headers := []byte("HTTP/1.1 429 Too Many Requests\r\nContent-Length: 0\r\nretry-after:  3\r\n\r\n")
+	if v := parseRawHeaders(headers).Get("Retry-After"); v != "3" {
+		t.Errorf("unexpected Retry-After %q", v)
+	}
+	if v := parseRawHeaders(headers).Get("Date"); v != "" {
+		t.Errorf("unexpected Date %q", v)
+	}
+}
+
+func TestHTTPRunnerHonorRetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		first := count == 1
+		mu.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	for _, std := range []bool{false, true} {
+		mu.Lock()
+		count = 0
+		mu.Unlock()
+		o := HTTPRunnerOptions{}
+		o.URL = fmt.Sprintf("http://localhost:%d/", addr.Port)
+		o.DisableFastClient = std
+		o.NumThreads = 1
+		o.QPS = -1
+		o.Exactly = 3
+		o.HonorRetryAfter = true
+		r, err := RunHTTPTest(&o)
+		if err != nil {
+			t.Fatalf("Error while starting runner: %v", err)
+		}
+		if r.RetCodes[http.StatusTooManyRequests] != 1 || r.Throttles != 1 || r.ThrottledTime != time.Second ||
+			r.ActualDuration < time.Second {
+			t.Errorf("std %v: expected a 1s pause after the 429, got %v %d %v in %v", std, r.RetCodes, r.Throttles,
+				r.ThrottledTime, r.ActualDuration)
+		}
+	}
+	// without Retry-After, and not retried
+	o := HTTPRunnerOptions{}
+	o.URL = fmt.Sprintf("http://localhost:%d/down", addr.Port)
+	o.NumThreads = 1
+	o.QPS = -1
+	o.Exactly = 4
+	o.HonorRetryAfter = true
+	o.ThrottleBackoff = 20 * time.Millisecond
+	o.Retry, _ = NewRetryPolicy(3, "", nil)
+	r, err := RunHTTPTest(&o)
+	if err != nil {
+		t.Fatalf("Error while starting runner: %v", err)
+	}
+	// the last response's pause isn't taken as there is no next call
+	if r.RetCodes[http.StatusServiceUnavailable] != 4 || r.Throttles != 3 || r.ThrottledTime != 60*time.Millisecond ||
+		r.Retries.Retries != 0 || r.EffectiveQPS != 0 {
+		t.Errorf("expected 3 pauses of 20ms and no retry, got %v %d %v %+v %g", r.RetCodes, r.Throttles, r.ThrottledTime,
+			r.Retries, r.EffectiveQPS)
+	}
+	// not throttling
+	o.HonorRetryAfter = false
+	if r, _ = RunHTTPTest(&o); r.Throttles != 0 || r.Retries.Retries != 8 {
+		t.Errorf("expected retries and no pause, got %d %+v", r.Throttles, r.Retries)
+	}
+}
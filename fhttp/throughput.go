@@ -0,0 +1,125 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/stats"
+)
+
+// bytesPerMB is the unit of the throughput results (MB/s).
+const bytesPerMB = 1000000.
+
+// requestSize returns the size of req, with a body of bodyLen bytes, as http/1.1 text. Used as
+// the bytes sent by the clients which don't write the request themselves (std, h2 and h3).
+func requestSize(req *http.Request, bodyLen int) int64 {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	n := len(req.Method) + len(" ") + len(req.URL.RequestURI()) + len(" HTTP/1.1\r\n") + len("Host: \r\n") + len(host)
+	for k, values := range req.Header {
+		for _, v := range values {
+			n += len(k) + len(": \r\n") + len(v)
+		}
+	}
+	return int64(n + len("\r\n") + bodyLen)
+}
+
+// throughput is a runner thread's bytes counts, in total and for each second of the
+// run, and histogram of the per call response MB/s.
+type throughput struct {
+	start          time.Time // of the run, for the per second counts
+	sent           int64
+	received       int64
+	sentPerSec     []int64
+	receivedPerSec []int64
+	rates          *stats.Histogram // per call received MB/s
+}
+
+func newThroughput() *throughput {
+	return &throughput{rates: stats.NewHistogram(0, 0.01)}
+}
+
+// record adds the bytes of a call which started at start and lasted duration.
+func (t *throughput) record(sent, received int64, start time.Time, duration time.Duration) {
+	t.sent += sent
+	t.received += received
+	if duration > 0 {
+		t.rates.Record(float64(received) / bytesPerMB / duration.Seconds())
+	}
+	sec := 0
+	if end := start.Add(duration); end.After(t.start) {
+		sec = int(end.Sub(t.start) / time.Second)
+	}
+	for len(t.receivedPerSec) <= sec {
+		t.sentPerSec = append(t.sentPerSec, 0)
+		t.receivedPerSec = append(t.receivedPerSec, 0)
+	}
+	t.sentPerSec[sec] += sent
+	t.receivedPerSec[sec] += received
+}
+
+// transfer adds the counts and histogram of src into t (and resets src's histogram).
+func (t *throughput) transfer(src *throughput) {
+	t.sent += src.sent
+	t.received += src.received
+	t.sentPerSec = addPerSec(t.sentPerSec, src.sentPerSec)
+	t.receivedPerSec = addPerSec(t.receivedPerSec, src.receivedPerSec)
+	t.rates.Transfer(src.rates)
+}
+
+func addPerSec(dst, src []int64) []int64 {
+	for i, v := range src {
+		if i < len(dst) {
+			dst[i] += v
+		} else {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+// mbps returns the MB/s rate of bytes over duration (0 for no duration).
+func mbps(bytes int64, duration time.Duration) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	return float64(bytes) / bytesPerMB / duration.Seconds()
+}
+
+// printThroughput prints the totals and MB/s of the run and, depending on the log level, the
+// per connection rates and the per call rates histogram (or just its counters).
+func printThroughput(out io.Writer, res *HTTPRunnerResults, rates *stats.Histogram) {
+	_, _ = fmt.Fprintf(out, "Throughput: sent %d bytes (%.3f MB/s), received %d bytes (%.3f MB/s)\n",
+		res.BytesSent, res.SentMBps, res.BytesReceived, res.ReceivedMBps)
+	if !log.Log(log.Info) {
+		if log.Log(log.Warning) {
+			rates.Counter.Print(out, "Response throughput (MB/s)")
+		}
+		return
+	}
+	_, _ = fmt.Fprintf(out, "Received MB/s per connection:")
+	for _, v := range res.ConnectionsMBps {
+		_, _ = fmt.Fprintf(out, " %.3f", v)
+	}
+	_, _ = fmt.Fprintln(out)
+	res.Throughput.Print(out, "Response throughput histogram (MB/s)")
+}
@@ -0,0 +1,290 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp // import "fortio.org/fortio/fhttp"
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // mandated by the websocket handshake (RFC 6455), not used for security
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+)
+
+// WebSocket (RFC 6455) message and control frames opcodes.
+const (
+	WebSocketContinuation = 0
+	WebSocketText         = 1
+	WebSocketBinary       = 2
+	WebSocketClose        = 8
+	WebSocketPing         = 9
+	WebSocketPong         = 10
+)
+
+const (
+	webSocketGUID    = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	webSocketVersion = "13"
+	wsFinBit         = 0x80
+	wsMaskBit        = 0x80
+	wsMaxHeaderLen   = 2 + 8 + 4 // 2 bytes + 64 bits extended length + mask key
+)
+
+// WebSocketAccept returns the Sec-WebSocket-Accept header value for the Sec-WebSocket-Key key.
+func WebSocketAccept(key string) string {
+	h := sha1.New() //nolint:gosec // see import
+	h.Write([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// IsWebSocketUpgrade returns whether r is a request to upgrade the connection to the websocket protocol.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerHasToken returns whether the comma separated values of header name contain token (case insensitive).
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketConn reads and writes websocket messages on an upgraded connection. Client
+// connections mask the frames they write. Not safe for concurrent use.
+type WebSocketConn struct {
+	Conn    net.Conn
+	MaxSize int // maximum size of the messages read
+	br      *bufio.Reader
+	client  bool
+	closed  bool // close frame sent
+	buf     []byte
+	wbuf    []byte
+	hdr     [wsMaxHeaderLen]byte
+}
+
+// NewWebSocketConn returns the websocket connection for conn, read through br (which can have
+// buffered the first frames along with the handshake), on the client side when client is true.
+func NewWebSocketConn(conn net.Conn, br *bufio.Reader, client bool) *WebSocketConn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &WebSocketConn{Conn: conn, MaxSize: fnet.MaxPayloadSize, br: br, client: client}
+}
+
+// WriteMessage writes payload as a single frame message of type opcode (WebSocketText, WebSocketBinary
+// or a control frame opcode). Returns the number of bytes written, frame header included.
+func (ws *WebSocketConn) WriteMessage(opcode byte, payload []byte) (int, error) {
+	n := len(payload)
+	ws.wbuf = append(ws.wbuf[:0], wsFinBit|opcode)
+	var mask byte
+	if ws.client {
+		mask = wsMaskBit
+	}
+	switch {
+	case n <= 125:
+		ws.wbuf = append(ws.wbuf, mask|byte(n))
+	case n <= 0xffff:
+		ws.wbuf = append(ws.wbuf, mask|126, byte(n>>8), byte(n))
+	default:
+		ws.wbuf = append(ws.wbuf, mask|127)
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(n))
+		ws.wbuf = append(ws.wbuf, l[:]...)
+	}
+	start := len(ws.wbuf)
+	if ws.client {
+		var key [4]byte
+		binary.BigEndian.PutUint32(key[:], rand.Uint32()) //nolint:gosec // masking isn't for security
+		ws.wbuf = append(ws.wbuf, key[:]...)
+		start += 4
+		ws.wbuf = append(ws.wbuf, payload...)
+		maskBytes(ws.wbuf[start:], key)
+	} else {
+		ws.wbuf = append(ws.wbuf, payload...)
+	}
+	return ws.Conn.Write(ws.wbuf)
+}
+
+func maskBytes(b []byte, key [4]byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// readFrame reads the next frame and appends its (unmasked) payload to ws.buf.
+func (ws *WebSocketConn) readFrame() (fin bool, opcode byte, err error) {
+	hdr := ws.hdr[:2]
+	if _, err = io.ReadFull(ws.br, hdr); err != nil {
+		return false, 0, err
+	}
+	fin = hdr[0]&wsFinBit != 0
+	opcode = hdr[0] & 0x0f
+	masked := hdr[1]&wsMaskBit != 0
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err = io.ReadFull(ws.br, ws.hdr[2:4]); err != nil {
+			return false, 0, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ws.hdr[2:4]))
+	case 127:
+		if _, err = io.ReadFull(ws.br, ws.hdr[2:10]); err != nil {
+			return false, 0, err
+		}
+		n = binary.BigEndian.Uint64(ws.hdr[2:10])
+	}
+	if n > uint64(ws.MaxSize-len(ws.buf)) {
+		return false, 0, fmt.Errorf("websocket message larger than the max size %d", ws.MaxSize)
+	}
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(ws.br, key[:]); err != nil {
+			return false, 0, err
+		}
+	}
+	start := len(ws.buf)
+	end := start + int(n)
+	if end > cap(ws.buf) {
+		nb := make([]byte, start, end)
+		copy(nb, ws.buf)
+		ws.buf = nb
+	}
+	ws.buf = ws.buf[:end]
+	if _, err = io.ReadFull(ws.br, ws.buf[start:]); err != nil {
+		return false, 0, err
+	}
+	if masked {
+		maskBytes(ws.buf[start:], key)
+	}
+	return fin, opcode, nil
+}
+
+// ReadMessage returns the next text or binary message, reassembled from its fragments. Pings
+// are answered and pongs skipped. A close frame is replied to (unless this side closed first)
+// and returned as io.EOF. The message is only valid until the next call.
+func (ws *WebSocketConn) ReadMessage() (byte, []byte, error) {
+	ws.buf = ws.buf[:0]
+	var opcode byte
+	for {
+		start := len(ws.buf)
+		fin, op, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case WebSocketPing, WebSocketPong, WebSocketClose:
+			payload := ws.buf[start:]
+			if op == WebSocketClose {
+				log.LogVf("Websocket close frame received from %v (%q)", ws.Conn.RemoteAddr(), payload)
+				if !ws.closed {
+					ws.closed = true
+					_, _ = ws.WriteMessage(WebSocketClose, payload)
+				}
+				return 0, nil, io.EOF
+			}
+			if op == WebSocketPing {
+				if _, err = ws.WriteMessage(WebSocketPong, payload); err != nil {
+					return 0, nil, err
+				}
+			}
+			ws.buf = ws.buf[:start] // control frames can be in between a message's fragments
+			continue
+		case WebSocketContinuation:
+			if opcode == 0 {
+				return 0, nil, fmt.Errorf("unexpected websocket continuation frame")
+			}
+		default:
+			if opcode != 0 {
+				return 0, nil, fmt.Errorf("unexpected websocket opcode %d in a fragmented message", op)
+			}
+			opcode = op
+		}
+		if fin {
+			return opcode, ws.buf, nil
+		}
+	}
+}
+
+// Close sends a normal closure (1000) close frame, if not already sent, and closes the connection.
+func (ws *WebSocketConn) Close() error {
+	if !ws.closed {
+		ws.closed = true
+		_, _ = ws.WriteMessage(WebSocketClose, []byte{0x03, 0xe8})
+	}
+	return ws.Conn.Close()
+}
+
+// WebSocketEchoHandler upgrades the request to a websocket connection and echoes back each
+// message received until the client closes it. Called by EchoHandler for upgrade requests.
+func WebSocketEchoHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Method != http.MethodGet {
+		http.Error(w, "Bad websocket upgrade request", http.StatusBadRequest)
+		return
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != webSocketVersion {
+		w.Header().Set("Sec-WebSocket-Version", webSocketVersion)
+		http.Error(w, "Unsupported websocket version "+v, http.StatusUpgradeRequired)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		log.Errf("hijacking not supported for websocket: %v", r.Proto)
+		http.Error(w, "WebSocket needs http/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Errf("hijacking error %v", err)
+		return
+	}
+	ws := NewWebSocketConn(conn, brw.Reader, false)
+	defer ws.Close()
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + WebSocketAccept(key) + "\r\n\r\n"))
+	if err != nil {
+		log.Errf("Error writing websocket upgrade response to %v: %v", r.RemoteAddr, err)
+		return
+	}
+	log.LogVf("Websocket echo connection upgraded for %v", r.RemoteAddr)
+	count := 0
+	for {
+		opcode, msg, err := ws.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.LogVf("Websocket read error from %v: %v", r.RemoteAddr, err)
+			}
+			break
+		}
+		if _, err = ws.WriteMessage(opcode, msg); err != nil {
+			log.Errf("Error writing websocket message to %v: %v", r.RemoteAddr, err)
+			break
+		}
+		count++
+	}
+	log.LogVf("Websocket echo done for %v after %d messages", r.RemoteAddr, count)
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestWebSocketAccept(t *testing.T) {
+	// Example from RFC 6455 section 1.3
+	if a := WebSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); a != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept %q", a)
+	}
+}
+
+func TestWebSocketConn(t *testing.T) {
+	c, s := net.Pipe()
+	client := NewWebSocketConn(c, nil, true)
+	server := NewWebSocketConn(s, nil, false)
+	server.MaxSize = 1000
+	go func() {
+		// fragmented text message with a ping in between, then too large a message
+		_, _ = c.Write([]byte{WebSocketText, 0x80 | 3, 0, 0, 0, 0, 'a', 'b', 'c'})
+		_, _ = c.Write([]byte{0x80 | WebSocketPing, 0x80 | 1, 0, 0, 0, 0, 'p'})
+		_, _ = c.Write([]byte{0x80 | WebSocketContinuation, 0x80 | 2, 1, 2, 3, 4, 'd' ^ 1, 'e' ^ 2})
+		_, _ = client.WriteMessage(WebSocketBinary, make([]byte, 1001))
+	}()
+	pong := make(chan []byte)
+	go func() {
+		buf := make([]byte, 3)
+		_, _ = io.ReadFull(c, buf)
+		pong <- buf
+	}()
+	op, msg, err := server.ReadMessage()
+	if err != nil || op != WebSocketText || string(msg) != "abcde" {
+		t.Errorf("Unexpected message %d %q %v", op, msg, err)
+	}
+	if _, _, err = server.ReadMessage(); err == nil {
+		t.Errorf("Expected error for message larger than the max size")
+	}
+	if res := <-pong; string(res) != string([]byte{0x80 | WebSocketPong, 1, 'p'}) {
+		t.Errorf("Unexpected pong frame %q", res)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	c, s := net.Pipe()
+	client := NewWebSocketConn(c, nil, true)
+	server := NewWebSocketConn(s, nil, false)
+	go func() { _ = client.Close() }()
+	if _, _, err := server.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected EOF on close frame, got %v", err)
+	}
+}
+
+func TestWebSocketEchoHandlerErrors(t *testing.T) {
+	mux, addr := DynamicHTTPServer(false)
+	mux.HandleFunc("/", EchoHandler)
+	url := fmt.Sprintf("http://localhost:%d/", addr.Port)
+	tests := []struct {
+		version string
+		key     string
+		code    int
+	}{
+		{"13", "", http.StatusBadRequest},
+		{"8", "dGhlIHNhbXBsZSBub25jZQ==", http.StatusUpgradeRequired},
+	}
+	for _, tst := range tests {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Connection", "keep-alive, Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", tst.version)
+		req.Header.Set("Sec-WebSocket-Key", tst.key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tst.code {
+			t.Errorf("Got %d for %+v, expected %d", resp.StatusCode, tst, tst.code)
+		}
+	}
+}
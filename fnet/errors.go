@@ -0,0 +1,144 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fnet // import "fortio.org/fortio/fnet"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Categories of transport level errors, used as keys of the ErrorCategories
+// map in the runners results and as possible -abort-on values.
+const (
+	// ErrDNS is for name resolution failures.
+	ErrDNS = "dns"
+	// ErrConnectRefused is for connection refused (nothing listening on the destination).
+	ErrConnectRefused = "connect_refused"
+	// ErrConnectTimeout is for timeouts while establishing the connection.
+	ErrConnectTimeout = "connect_timeout"
+	// ErrProxy is for the -proxy refusing or failing to set up the tunnel to the destination.
+	ErrProxy = "proxy"
+	// ErrTLS is for TLS handshake and certificate errors.
+	ErrTLS = "tls"
+	// ErrReset is for connections reset or closed by the peer while writing.
+	ErrReset = "reset"
+	// ErrReadTimeout is for timeouts while waiting for (the rest of) the response.
+	ErrReadTimeout = "read_timeout"
+	// ErrShortRead is for connections closed before the full response was read.
+	ErrShortRead = "short_read"
+	// ErrOther is for all the other errors.
+	ErrOther = "other"
+)
+
+// ErrorCategories lists all the error categories.
+var ErrorCategories = []string{
+	ErrDNS, ErrConnectRefused, ErrConnectTimeout, ErrProxy, ErrTLS, ErrReset, ErrReadTimeout, ErrShortRead, ErrOther,
+}
+
+// IsErrorCategory returns true if s is one of the ErrorCategories.
+func IsErrorCategory(s string) bool {
+	for _, c := range ErrorCategories {
+		if s == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorCategory classifies a transport error. It uses the error's type when
+// available and otherwise falls back to the error message, so it also works for
+// errors that went through a string (like grpc status errors).
+func ErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrDNS
+	}
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return ErrProxy
+	}
+	var recordErr tls.RecordHeaderError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var certErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &recordErr) || errors.As(err, &unknownAuthErr) ||
+		errors.As(err, &certErr) || errors.As(err, &hostnameErr) {
+		return ErrTLS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrConnectRefused
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return ErrReset
+	}
+	var opErr *net.OpError
+	isOpErr := errors.As(err, &opErr)
+	if os.IsTimeout(err) || errors.Is(err, os.ErrDeadlineExceeded) {
+		if isOpErr && opErr.Op == "dial" {
+			return ErrConnectTimeout
+		}
+		return ErrReadTimeout
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrShortRead
+	}
+	return errorMessageCategory(err.Error())
+}
+
+// errorMessageCategory is the fallback of ErrorCategory for errors only
+// available as strings.
+func errorMessageCategory(msg string) string {
+	switch {
+	case strings.Contains(msg, "no such host") || strings.Contains(msg, "server misbehaving"):
+		return ErrDNS
+	case strings.Contains(msg, "proxy:"):
+		return ErrProxy
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:"):
+		return ErrTLS
+	case strings.Contains(msg, "connection refused"):
+		return ErrConnectRefused
+	case strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe"):
+		return ErrReset
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "DeadlineExceeded") ||
+		strings.Contains(msg, "deadline exceeded"):
+		if strings.Contains(msg, "dial") {
+			return ErrConnectTimeout
+		}
+		return ErrReadTimeout
+	case strings.Contains(msg, "EOF") || strings.Contains(msg, "short read"):
+		return ErrShortRead
+	}
+	return ErrOther
+}
+
+// PrintErrorCategories prints the non zero error categories counts, in
+// ErrorCategories order, with their percentage of totalCount calls.
+func PrintErrorCategories(out io.Writer, categories map[string]int64, totalCount float64) {
+	for _, c := range ErrorCategories {
+		if v := categories[c]; v > 0 {
+			_, _ = fmt.Fprintf(out, "Socket error %s : %d (%.1f %%)\n", c, v, 100.*float64(v)/totalCount)
+		}
+	}
+}
@@ -507,12 +507,18 @@ func transfer(wg *sync.WaitGroup, dst net.Conn, src net.Conn) {
 // ErrNilDestination returned when trying to proxy to a nil address.
 var ErrNilDestination = fmt.Errorf("nil destination")
 
-func handleProxyRequest(conn net.Conn, dest net.Addr) {
+func handleProxyRequest(conn net.Conn, dest net.Addr, pp *ProxyProtocol) {
 	err := ErrNilDestination
 	var d net.Conn
 	if dest != nil {
 		d, err = net.Dial(dest.Network(), dest.String())
 	}
+	if err == nil {
+		// the original client's addresses
+		if err = pp.Write(d, conn.RemoteAddr(), conn.LocalAddr()); err != nil {
+			_ = d.Close()
+		}
+	}
 	if err != nil {
 		log.Errf("Proxy: unable to connect to %v for %v : %v", dest, conn.RemoteAddr(), err)
 		_ = conn.Close()
@@ -531,6 +537,12 @@ func handleProxyRequest(conn net.Conn, dest net.Addr) {
 
 // Proxy starts a tcp proxy.
 func Proxy(port string, dest net.Addr) net.Addr {
+	return ProxyWithProtocol(port, dest, nil)
+}
+
+// ProxyWithProtocol starts a tcp proxy which, when pp isn't nil, sends first to dest a PROXY
+// protocol header with the addresses of the incoming connection.
+func ProxyWithProtocol(port string, dest net.Addr, pp *ProxyProtocol) net.Addr {
 	listener, lAddr := Listen(fmt.Sprintf("proxy for %v", dest), port)
 	if listener == nil {
 		return nil // error already logged
@@ -544,7 +556,7 @@ func Proxy(port string, dest net.Addr) net.Addr {
 				log.LogVf("Proxy: Accepted proxy connection from %v -> %v (for listener %v)",
 					conn.RemoteAddr(), conn.LocalAddr(), dest)
 				// TODO limit number of go request, use worker pool, etc...
-				go handleProxyRequest(conn, dest)
+				go handleProxyRequest(conn, dest, pp)
 			}
 		}
 	}()
@@ -554,8 +566,14 @@ func Proxy(port string, dest net.Addr) net.Addr {
 // ProxyToDestination opens a proxy from the listenPort (or addr:port or unix domain socket path) and forwards
 // all traffic to destination (host:port).
 func ProxyToDestination(listenPort string, destination string) net.Addr {
+	return ProxyToDestinationWithProtocol(listenPort, destination, nil)
+}
+
+// ProxyToDestinationWithProtocol is ProxyToDestination with the optional PROXY protocol header
+// (see ProxyWithProtocol).
+func ProxyToDestinationWithProtocol(listenPort string, destination string, pp *ProxyProtocol) net.Addr {
 	addr, _ := TCPResolveDestination(destination)
-	return Proxy(listenPort, addr)
+	return ProxyWithProtocol(listenPort, addr, pp)
 }
 
 // NormalizeHostPort generates host:port string for the address or uses localhost instead of [::]
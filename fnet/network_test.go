@@ -760,3 +760,45 @@ func TestDebugSummary(t *testing.T) {
 func init() {
 	log.SetLogLevel(log.Debug)
 }
+
+func TestErrorCategory(t *testing.T) {
+	// Closed port:
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+	_, refusedErr := net.Dial("tcp", closedAddr)
+	// Timeout on read:
+	addr := fnet.TCPEchoServer("test-error-category", ":0")
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", addr.(*net.TCPAddr).Port))
+	if err != nil {
+		t.Fatalf("can't connect to our echo server: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, readErr := conn.Read(make([]byte, 10))
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{nil, ""},
+		{&net.DNSError{Err: "no such host", Name: "foo.invalid"}, fnet.ErrDNS},
+		{refusedErr, fnet.ErrConnectRefused},
+		{readErr, fnet.ErrReadTimeout},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, fnet.ErrConnectTimeout},
+		{fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF), fnet.ErrShortRead},
+		{fmt.Errorf("remote error: tls: bad certificate"), fnet.ErrTLS},
+		{fmt.Errorf("rpc error: code = Unavailable desc = read: connection reset by peer"), fnet.ErrReset},
+		{fmt.Errorf("something else"), fnet.ErrOther},
+	}
+	for _, tst := range tests {
+		if actual := fnet.ErrorCategory(tst.err); actual != tst.expected {
+			t.Errorf("Got %q for %v, expected %q", actual, tst.err, tst.expected)
+		}
+	}
+	if !fnet.IsErrorCategory(fnet.ErrReset) || fnet.IsErrorCategory("-1") {
+		t.Errorf("IsErrorCategory mismatch")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fnet // import "fortio.org/fortio/fnet"
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Proxy schemes supported by ProxyDialer.
+const (
+	ProxyHTTP   = "http"   // http proxy, tunneling with CONNECT
+	ProxySOCKS5 = "socks5" // socks5 proxy (with optional user/password authentication)
+)
+
+// ProxyError is the error returned when the proxy refuses or fails to set up the tunnel.
+type ProxyError struct {
+	Msg string
+}
+
+func (e *ProxyError) Error() string {
+	return "proxy: " + e.Msg
+}
+
+// ProxyDialer makes connections through an http (CONNECT) or socks5 proxy.
+type ProxyDialer struct {
+	URL     *url.URL
+	Timeout time.Duration // for the connection to the proxy and the tunnel setup
+}
+
+// NewProxyDialer returns a dialer for the proxy url "http://[user:password@]host:port"
+// or "socks5://[user:password@]host:port" (a host:port without scheme is an http proxy).
+func NewProxyDialer(proxyURL string, timeout time.Duration) (*ProxyDialer, error) {
+	if !strings.Contains(proxyURL, "://") {
+		proxyURL = ProxyHTTP + "://" + proxyURL
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != ProxyHTTP && u.Scheme != ProxySOCKS5 {
+		return nil, fmt.Errorf("unsupported proxy scheme %q, should be %s or %s", u.Scheme, ProxyHTTP, ProxySOCKS5)
+	}
+	if u.Port() == "" {
+		if u.Scheme == ProxyHTTP {
+			u.Host += ":8080"
+		} else {
+			u.Host += ":1080"
+		}
+	}
+	return &ProxyDialer{URL: u, Timeout: timeout}, nil
+}
+
+// ProxyAddr is the net.Addr of a destination reached through a proxy.
+type ProxyAddr struct {
+	Proxy  *url.URL
+	Target string // host:port
+}
+
+// Network is always tcp for proxied connections.
+func (a *ProxyAddr) Network() string {
+	return "tcp"
+}
+
+func (a *ProxyAddr) String() string {
+	return a.Target + " (through proxy " + a.Proxy.Host + ")"
+}
+
+// Addr returns the net.Addr, for logging, of the target ("host:port") through the proxy.
+func (p *ProxyDialer) Addr(target string) net.Addr {
+	return &ProxyAddr{Proxy: p.URL, Target: target}
+}
+
+// Forwards returns true for http proxies, to which plain http requests are sent
+// directly (with the absolute url as request target) instead of through a tunnel.
+func (p *ProxyDialer) Forwards() bool {
+	return p.URL.Scheme == ProxyHTTP
+}
+
+// ProxyAuthorization returns the Proxy-Authorization header value for the proxy's
+// user and password, "" if there isn't any.
+func (p *ProxyDialer) ProxyAuthorization() string {
+	if p.URL.User == nil {
+		return ""
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(p.URL.User.String()))
+}
+
+// Connect connects to the proxy itself.
+func (p *ProxyDialer) Connect(ctx context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: p.Timeout}
+	return d.DialContext(ctx, "tcp", p.URL.Host)
+}
+
+// Tunnel asks the proxy, on its connection conn, to connect to addr ("host:port").
+func (p *ProxyDialer) Tunnel(conn net.Conn, addr string) error {
+	if p.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(p.Timeout))
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	if p.URL.Scheme == ProxySOCKS5 {
+		return p.socks5Tunnel(conn, addr)
+	}
+	return p.connectTunnel(conn, addr)
+}
+
+// DialContext connects to addr through the proxy, suitable for net/http's Transport,
+// grpc's WithContextDialer etc... network must be a tcp one.
+func (p *ProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, fmt.Errorf("proxy: unsupported network %q", network)
+	}
+	conn, err := p.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Tunnel(conn, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *ProxyDialer) connectTunnel(conn net.Conn, addr string) error {
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if auth := p.ProxyAuthorization(); auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &ProxyError{Msg: fmt.Sprintf("CONNECT to %s: %s", addr, resp.Status)}
+	}
+	if br.Buffered() > 0 {
+		return &ProxyError{Msg: fmt.Sprintf("unexpected data after the CONNECT to %s response", addr)}
+	}
+	return nil
+}
+
+// socks5 protocol (rfc 1928 and rfc 1929 for the user/password authentication).
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5UserPassword = 2
+	socks5Connect      = 1
+	socks5IPv4         = 1
+	socks5Domain       = 3
+	socks5IPv6         = 4
+)
+
+var socks5Errors = []string{
+	"", "general failure", "connection not allowed by ruleset", "network unreachable", "host unreachable",
+	"connection refused", "TTL expired", "command not supported", "address type not supported",
+}
+
+func (p *ProxyDialer) socks5Tunnel(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("proxy: invalid port %q", portStr)
+	}
+	method := byte(socks5NoAuth)
+	if p.URL.User != nil {
+		method = socks5UserPassword
+	}
+	reply := make([]byte, 2)
+	if _, err = conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version || reply[1] != method {
+		return &ProxyError{Msg: fmt.Sprintf("socks5 authentication method %d not accepted (%d)", method, reply[1])}
+	}
+	if method == socks5UserPassword {
+		user := p.URL.User.Username()
+		password, _ := p.URL.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return &ProxyError{Msg: "socks5 user or password too long"}
+		}
+		auth := append([]byte{1, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return &ProxyError{Msg: "socks5 authentication failed"}
+		}
+	}
+	req := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return &ProxyError{Msg: "socks5 host name too long"}
+		}
+		req = append(append(req, socks5Domain, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, socks5IPv4), ip4...)
+	} else {
+		req = append(append(req, socks5IPv6), ip...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+	// reply: version, status, reserved, bound address type, address and port
+	resp := make([]byte, 4)
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		msg := "unknown error"
+		if int(resp[1]) < len(socks5Errors) {
+			msg = socks5Errors[resp[1]]
+		}
+		return &ProxyError{Msg: fmt.Sprintf("socks5 connect to %s: %s", addr, msg)}
+	}
+	var skip int
+	switch resp[3] {
+	case socks5IPv4:
+		skip = net.IPv4len
+	case socks5IPv6:
+		skip = net.IPv6len
+	case socks5Domain:
+		if _, err = io.ReadFull(conn, resp[:1]); err != nil {
+			return err
+		}
+		skip = int(resp[0])
+	default:
+		return &ProxyError{Msg: fmt.Sprintf("socks5 invalid address type %d in reply", resp[3])}
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fnet
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// proxyTestServer starts a minimal socks5 (when socks is true) or http CONNECT proxy
+// accepting only the user/password "u:p" and connecting to the requested address.
+func proxyTestServer(t *testing.T, socks bool) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var dest net.Conn
+				if socks {
+					dest = socks5TestHandshake(conn)
+				} else {
+					dest = connectTestHandshake(conn)
+				}
+				if dest == nil {
+					return
+				}
+				defer dest.Close()
+				go func() { _, _ = io.Copy(dest, conn) }()
+				_, _ = io.Copy(conn, dest)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func connectTestHandshake(conn net.Conn) net.Conn {
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || req.Method != http.MethodConnect {
+		return nil
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic dTpw" { // u:p
+		_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return nil
+	}
+	dest, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return nil
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return dest
+}
+
+func socks5TestHandshake(conn net.Conn) net.Conn {
+	buf := make([]byte, 256)
+	// greeting, expecting the user/password method only
+	if _, err := io.ReadFull(conn, buf[:3]); err != nil || buf[2] != socks5UserPassword {
+		_, _ = conn.Write([]byte{socks5Version, 0xff})
+		return nil
+	}
+	_, _ = conn.Write([]byte{socks5Version, socks5UserPassword})
+	// 1 ulen u plen p
+	if _, err := io.ReadFull(conn, buf[:5]); err != nil || string(buf[2:3]) != "u" || string(buf[4:5]) != "p" {
+		_, _ = conn.Write([]byte{1, 1})
+		return nil
+	}
+	_, _ = conn.Write([]byte{1, 0})
+	// connect request, for a domain name
+	if _, err := io.ReadFull(conn, buf[:5]); err != nil || buf[3] != socks5Domain {
+		return nil
+	}
+	n := int(buf[4])
+	if _, err := io.ReadFull(conn, buf[:n+2]); err != nil {
+		return nil
+	}
+	port := int(buf[n])<<8 | int(buf[n+1])
+	dest, err := net.Dial("tcp", net.JoinHostPort(string(buf[:n]), strconv.Itoa(port)))
+	if err != nil {
+		_, _ = conn.Write([]byte{socks5Version, 5, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+		return nil
+	}
+	_, _ = conn.Write([]byte{socks5Version, 0, 0, socks5IPv4, 127, 0, 0, 1, 0, 0})
+	return dest
+}
+
+func TestProxyDialer(t *testing.T) {
+	echo := TCPEchoServer("test-echo-proxy", ":0")
+	target := "localhost:" + GetPort(echo)
+	closed, _ := net.Listen("tcp", "localhost:0")
+	closedTarget := "localhost:" + GetPort(closed.Addr())
+	closed.Close()
+	for _, scheme := range []string{ProxyHTTP, ProxySOCKS5} {
+		proxy := proxyTestServer(t, scheme == ProxySOCKS5)
+		p, err := NewProxyDialer(scheme+"://u:p@"+proxy, 2*time.Second)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", scheme, err)
+		}
+		conn, err := p.DialContext(context.Background(), "tcp", target)
+		if err != nil {
+			t.Fatalf("%s: unexpected dial error %v", scheme, err)
+		}
+		if _, err = conn.Write([]byte("hello")); err != nil {
+			t.Errorf("%s: write error %v", scheme, err)
+		}
+		buf := make([]byte, 5)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("%s: unexpected echo %q %v", scheme, buf, err)
+		}
+		conn.Close()
+		if _, err = p.DialContext(context.Background(), "tcp", closedTarget); ErrorCategory(err) != ErrProxy {
+			t.Errorf("%s: expected proxy error category for an unreachable target, got %v", scheme, err)
+		}
+		p, _ = NewProxyDialer(scheme+"://u:wrong@"+proxy, 2*time.Second)
+		if _, err = p.DialContext(context.Background(), "tcp", target); ErrorCategory(err) != ErrProxy {
+			t.Errorf("%s: expected proxy error category for bad credentials, got %v", scheme, err)
+		}
+	}
+	if p, err := NewProxyDialer("proxy.example.com", 0); err != nil || p.URL.Host != "proxy.example.com:8080" || !p.Forwards() {
+		t.Errorf("Unexpected default http proxy %+v %v", p, err)
+	}
+	if p, err := NewProxyDialer("socks5://proxy.example.com", 0); err != nil || p.URL.Host != "proxy.example.com:1080" || p.Forwards() {
+		t.Errorf("Unexpected default socks5 proxy %+v %v", p, err)
+	}
+	if _, err := NewProxyDialer("ftp://proxy.example.com", 0); err == nil {
+		t.Errorf("Expected error for unsupported proxy scheme")
+	}
+}
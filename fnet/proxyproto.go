@@ -0,0 +1,317 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fnet // import "fortio.org/fortio/fnet"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fortio.org/fortio/log"
+)
+
+// PROXY protocol (https://www.haproxy.org/download/2.6/doc/proxy-protocol.txt) constants.
+const (
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLen    = 107 // including the CRLF
+	proxyV2HeaderLen = 16
+	proxyV2Local     = 0x20 // version 2, LOCAL command
+	proxyV2Proxy     = 0x21 // version 2, PROXY command
+	proxyV2TCP4      = 0x11
+	proxyV2UDP4      = 0x12
+	proxyV2TCP6      = 0x21
+	proxyV2UDP6      = 0x22
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocol is the PROXY protocol header (as expected by HAProxy and most load balancers)
+// sent first on outbound connections, to pass them the original addresses. Safe for concurrent use.
+type ProxyProtocol struct {
+	Version int        // 1 (text) or 2 (binary)
+	Sources *SourceIPs // source IPs advertised in round robin instead of the actual one, when set
+}
+
+// NewProxyProtocol returns the PROXY protocol for version "v1" or "v2" (or "1", "2") advertising
+// the optional comma separated source IPs and CIDRs (see ParseSourceIPs). Returns nil when version
+// is empty.
+func NewProxyProtocol(version, sources string) (*ProxyProtocol, error) {
+	if version == "" {
+		return nil, nil
+	}
+	p := &ProxyProtocol{}
+	switch strings.ToLower(version) {
+	case "v1", "1":
+		p.Version = 1
+	case "v2", "2":
+		p.Version = 2
+	default:
+		return nil, fmt.Errorf("invalid proxy protocol version %q, should be v1 or v2", version)
+	}
+	var err error
+	if p.Sources, err = ParseSourceIPs(sources); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ipPort returns the ip and port of a tcp or udp address, nil otherwise (e.g. unix sockets).
+func ipPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	}
+	return nil, 0
+}
+
+// ipv6String returns ip in IPv6 notation, e.g. ::ffff:10.1.2.3 for IPv4 addresses.
+func ipv6String(ip net.IP) string {
+	if ip.To4() != nil {
+		return "::ffff:" + ip.String()
+	}
+	return ip.String()
+}
+
+// Header returns the header for a connection from src to dst, LOCAL/UNKNOWN when they aren't
+// both ip addresses (e.g. unix domain sockets). Mixed IPv4 and IPv6 addresses are sent as IPv6.
+func (p *ProxyProtocol) Header(src, dst net.Addr) []byte {
+	srcIP, srcPort := ipPort(src)
+	dstIP, dstPort := ipPort(dst)
+	known := srcIP != nil && dstIP != nil
+	ipv4 := known && srcIP.To4() != nil && dstIP.To4() != nil
+	if !ipv4 && known {
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	} else if ipv4 {
+		srcIP, dstIP = srcIP.To4(), dstIP.To4()
+	}
+	if p.Version == 1 {
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		if ipv4 {
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", srcIP, dstIP, srcPort, dstPort))
+		}
+		return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6String(srcIP), ipv6String(dstIP), srcPort, dstPort))
+	}
+	h := make([]byte, proxyV2HeaderLen, proxyV2HeaderLen+36)
+	copy(h, proxyV2Signature)
+	if !known {
+		h[12] = proxyV2Local
+		return h // UNSPEC family, no addresses
+	}
+	h[12] = proxyV2Proxy
+	_, udp := src.(*net.UDPAddr)
+	switch {
+	case ipv4 && udp:
+		h[13] = proxyV2UDP4
+	case ipv4:
+		h[13] = proxyV2TCP4
+	case udp:
+		h[13] = proxyV2UDP6
+	default:
+		h[13] = proxyV2TCP6
+	}
+	h = append(h, srcIP...)
+	h = append(h, dstIP...)
+	var ports [4]byte
+	binary.BigEndian.PutUint16(ports[:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dstPort))
+	h = append(h, ports[:]...)
+	binary.BigEndian.PutUint16(h[14:16], uint16(len(h)-proxyV2HeaderLen))
+	return h
+}
+
+// Write writes to w the header for a connection from src to dst, with the next of the Sources
+// as source IP when set. Does nothing when p is nil.
+func (p *ProxyProtocol) Write(w io.Writer, src, dst net.Addr) error {
+	if p == nil {
+		return nil
+	}
+	if p.Sources != nil {
+		_, port := ipPort(src)
+		if _, udp := src.(*net.UDPAddr); udp {
+			src = &net.UDPAddr{IP: p.Sources.Next(), Port: port}
+		} else {
+			src = &net.TCPAddr{IP: p.Sources.Next(), Port: port}
+		}
+	}
+	h := p.Header(src, dst)
+	log.Debugf("Sending PROXY protocol v%d header %q from %v to %v", p.Version, h, src, dst)
+	_, err := w.Write(h)
+	return err
+}
+
+// ProxyHeader is a received PROXY protocol header.
+type ProxyHeader struct {
+	Version     int
+	Source      net.Addr // nil for the LOCAL (v2) or UNKNOWN (v1) connections
+	Destination net.Addr
+}
+
+func (h *ProxyHeader) String() string {
+	if h.Source == nil {
+		return fmt.Sprintf("v%d local/unknown", h.Version)
+	}
+	return fmt.Sprintf("v%d %v -> %v", h.Version, h.Source, h.Destination)
+}
+
+// ReadProxyHeader reads the PROXY protocol (v1 or v2) header at the start of br. Returns nil,
+// and consumes nothing, when br doesn't start with one.
+func ReadProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case proxyV1Prefix[0]:
+		if b, _ := br.Peek(len(proxyV1Prefix)); string(b) == proxyV1Prefix {
+			return readProxyV1(br)
+		}
+	case proxyV2Signature[0]:
+		if b, _ := br.Peek(len(proxyV2Signature)); bytes.Equal(b, proxyV2Signature) {
+			return readProxyV2(br)
+		}
+	}
+	return nil, nil
+}
+
+func readProxyV1(br *bufio.Reader) (*ProxyHeader, error) {
+	line, found, err := SmallReadUntil(br, '\n', proxyV1MaxLen)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("PROXY v1 header longer than %d", proxyV1MaxLen)
+	}
+	fields := strings.Fields(string(line))
+	h := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header %q", line)
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 addresses in %q", line)
+	}
+	h.Source = &net.TCPAddr{IP: src, Port: int(srcPort)}
+	h.Destination = &net.TCPAddr{IP: dst, Port: int(dstPort)}
+	return h, nil
+}
+
+func readProxyV2(br *bufio.Reader) (*ProxyHeader, error) {
+	hdr := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("invalid PROXY v2 version/command 0x%x", hdr[12])
+	}
+	data := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, err
+	}
+	h := &ProxyHeader{Version: 2}
+	if hdr[12] == proxyV2Local {
+		return h, nil
+	}
+	ipLen := 0
+	switch hdr[13] {
+	case proxyV2TCP4, proxyV2UDP4:
+		ipLen = net.IPv4len
+	case proxyV2TCP6, proxyV2UDP6:
+		ipLen = net.IPv6len
+	default:
+		return h, nil // unsupported (e.g. unix) family, addresses ignored
+	}
+	if len(data) < 2*ipLen+4 {
+		return nil, fmt.Errorf("PROXY v2 addresses too short (%d)", len(data))
+	}
+	src, dst := net.IP(data[:ipLen]), net.IP(data[ipLen:2*ipLen])
+	srcPort := int(binary.BigEndian.Uint16(data[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(data[2*ipLen+2:]))
+	if hdr[13] == proxyV2UDP4 || hdr[13] == proxyV2UDP6 {
+		h.Source, h.Destination = &net.UDPAddr{IP: src, Port: srcPort}, &net.UDPAddr{IP: dst, Port: dstPort}
+	} else {
+		h.Source, h.Destination = &net.TCPAddr{IP: src, Port: srcPort}, &net.TCPAddr{IP: dst, Port: dstPort}
+	}
+	return h, nil // TLVs, if any, ignored
+}
+
+// ProxyProtocolConn is an accepted connection whose PROXY protocol header, if any, is read
+// (and removed from the data) on the first Read.
+type ProxyProtocolConn struct {
+	net.Conn
+	once   sync.Once
+	br     *bufio.Reader
+	header *ProxyHeader
+	err    error
+}
+
+func (c *ProxyProtocolConn) parse() {
+	c.br = bufio.NewReader(c.Conn)
+	c.header, c.err = ReadProxyHeader(c.br)
+	if c.err != nil && !errors.Is(c.err, io.EOF) {
+		log.Warnf("Error reading the PROXY protocol header from %v: %v", c.RemoteAddr(), c.err)
+	} else if c.header != nil {
+		log.LogVf("PROXY protocol header from %v: %v", c.RemoteAddr(), c.header)
+	}
+}
+
+func (c *ProxyProtocolConn) Read(p []byte) (int, error) {
+	c.once.Do(c.parse)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+// ProxyHeader returns the PROXY protocol header received, nil if there was none. Reads it
+// if it wasn't read yet.
+func (c *ProxyProtocolConn) ProxyHeader() *ProxyHeader {
+	c.once.Do(c.parse)
+	return c.header
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	enabled func() bool
+}
+
+// NewProxyProtocolListener returns a listener whose accepted connections are ProxyProtocolConn,
+// when enabled (if not nil) returns true at the time they are accepted.
+func NewProxyProtocolListener(l net.Listener, enabled func() bool) net.Listener {
+	return &proxyProtocolListener{Listener: l, enabled: enabled}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || (l.enabled != nil && !l.enabled()) {
+		return conn, err
+	}
+	return &ProxyProtocolConn{Conn: conn}, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	src4 := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234}
+	dst4 := &net.TCPAddr{IP: net.ParseIP("10.4.5.6"), Port: 443}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 8080}
+	unix := &net.UnixAddr{Name: "/tmp/sock", Net: UnixDomainSocket}
+	tests := []struct {
+		version  int
+		src, dst net.Addr
+		v1       string // expected v1 header or String() of the parsed v2 one
+	}{
+		{1, src4, dst4, "PROXY TCP4 10.1.2.3 10.4.5.6 1234 443\r\n"},
+		{1, src4, dst6, "PROXY TCP6 ::ffff:10.1.2.3 fd00::1 1234 8080\r\n"},
+		{1, unix, unix, "PROXY UNKNOWN\r\n"},
+		{2, src4, dst4, "v2 10.1.2.3:1234 -> 10.4.5.6:443"},
+		{2, src4, dst6, "v2 10.1.2.3:1234 -> [fd00::1]:8080"},
+		{2, unix, dst4, "v2 local/unknown"},
+	}
+	for _, tst := range tests {
+		h := (&ProxyProtocol{Version: tst.version}).Header(tst.src, tst.dst)
+		if tst.version == 1 && string(h) != tst.v1 {
+			t.Errorf("Got v1 header %q, expected %q", h, tst.v1)
+		}
+		br := bufio.NewReader(io.MultiReader(bytes.NewReader(h), strings.NewReader("GET / HTTP/1.1\r\n")))
+		ph, err := ReadProxyHeader(br)
+		if err != nil || ph == nil {
+			t.Fatalf("Error reading back %q: %v %v", h, ph, err)
+		}
+		if tst.version == 2 && ph.String() != tst.v1 {
+			t.Errorf("Got v2 header %s, expected %s", ph, tst.v1)
+		}
+		if rest, _ := io.ReadAll(br); string(rest) != "GET / HTTP/1.1\r\n" {
+			t.Errorf("Unexpected data after the %q header: %q", h, rest)
+		}
+	}
+}
+
+func TestReadProxyHeaderErrors(t *testing.T) {
+	for _, data := range []string{"GET / HTTP/1.1\r\n", "PUT /PROXY", "\r\n\r\n"} {
+		br := bufio.NewReader(strings.NewReader(data))
+		h, err := ReadProxyHeader(br)
+		if h != nil || err != nil {
+			t.Errorf("Expected no header for %q, got %v %v", data, h, err)
+		}
+		if rest, _ := io.ReadAll(br); string(rest) != data {
+			t.Errorf("Data %q consumed, left %q", data, rest)
+		}
+	}
+	for _, data := range []string{
+		"PROXY TCP4 1.2.3.4\r\n",
+		"PROXY TCP4 1.2.3.4 1.2.3.5 99999 80\r\n",
+		"PROXY TCP4 1.2.3.4 1.2.3.5 80 80" + strings.Repeat(" ", 100) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x0c",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x01\x02\x03\x04",
+	} {
+		h, err := ReadProxyHeader(bufio.NewReader(strings.NewReader(data)))
+		if err == nil {
+			t.Errorf("Expected an error for %q, got %v", data, h)
+		}
+	}
+}
+
+func TestNewProxyProtocol(t *testing.T) {
+	if p, err := NewProxyProtocol("", "10.0.0.1"); p != nil || err != nil {
+		t.Errorf("Expected no proxy protocol, got %v %v", p, err)
+	}
+	if _, err := NewProxyProtocol("v3", ""); err == nil {
+		t.Errorf("Expected an error for v3")
+	}
+	if _, err := NewProxyProtocol("v1", "not.an.ip"); err == nil {
+		t.Errorf("Expected an error for the bad source")
+	}
+	p, err := NewProxyProtocol("V2", "10.0.0.0/30")
+	if err != nil || p.Version != 2 {
+		t.Fatalf("Unexpected %v %v", p, err)
+	}
+	src := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1000}
+	dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}
+	for _, expected := range []string{"10.0.0.1:1000", "10.0.0.2:1000", "10.0.0.1:1000"} {
+		var buf bytes.Buffer
+		if err = p.Write(&buf, src, dst); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		h, err := ReadProxyHeader(bufio.NewReader(&buf))
+		if err != nil || h.Source.String() != expected {
+			t.Errorf("Expected source %s, got %v %v", expected, h, err)
+		}
+	}
+	var nilP *ProxyProtocol
+	if err = nilP.Write(nil, src, dst); err != nil {
+		t.Errorf("Nil proxy protocol write should be a no-op: %v", err)
+	}
+}
+
+func TestProxyWithProtocol(t *testing.T) {
+	l, addr := Listen("proxy protocol test", "0")
+	pl := NewProxyProtocolListener(l, nil)
+	done := make(chan string, 1)
+	go func() {
+		conn, err := pl.Accept()
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer conn.Close()
+		data := make([]byte, 5)
+		_, err = io.ReadFull(conn, data)
+		done <- conn.(*ProxyProtocolConn).ProxyHeader().String() + " " + string(data) + " " + ErrorCategory(err)
+	}()
+	pp, _ := NewProxyProtocol("v1", "")
+	proxyAddr := ProxyWithProtocol("0", addr, pp)
+	conn, err := net.Dial("tcp", proxyAddr.String())
+	if err != nil {
+		t.Fatalf("Unable to connect to the proxy: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	expected := "v1 " + conn.LocalAddr().String() + " -> " + conn.RemoteAddr().String() + " hello "
+	if res := <-done; res != expected {
+		t.Errorf("Got %q, expected %q", res, expected)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fnet // import "fortio.org/fortio/fnet"
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// MaxSourceIPs is the maximum number of source IPs, e.g. from a CIDR, ParseSourceIPs accepts.
+const MaxSourceIPs = 65536
+
+// SourceIPs are the local IPs the new connections are bound to, in round robin, to spread the
+// connections over more ephemeral ports than a single IP has. Safe for concurrent use.
+type SourceIPs struct {
+	next   uint64 // first for the 64 bits alignment of the atomic operations
+	IPs    []net.IP
+	counts []int64
+}
+
+// ParseSourceIPs parses a comma separated list of IPs and CIDRs, e.g. "10.0.0.1,127.0.0.0/29".
+// The network and broadcast addresses of the IPv4 CIDRs are skipped (except for /31 and /32).
+// Returns nil for an empty list.
+func ParseSourceIPs(list string) (*SourceIPs, error) {
+	s := &SourceIPs{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid source ip %q", entry)
+			}
+			s.IPs = append(s.IPs, ip)
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		ones, bits := ipNet.Mask.Size()
+		if bits-ones > 16 {
+			return nil, fmt.Errorf("too many source ips in %q, max is %d", entry, MaxSourceIPs)
+		}
+		skipEnds := ip.To4() != nil && bits-ones > 1
+		first := len(s.IPs)
+		for ip = ip.Mask(ipNet.Mask); ipNet.Contains(ip); ip = nextIP(ip) {
+			s.IPs = append(s.IPs, ip)
+		}
+		if skipEnds {
+			s.IPs = append(s.IPs[:first], s.IPs[first+1:len(s.IPs)-1]...)
+		}
+	}
+	if len(s.IPs) == 0 {
+		return nil, nil
+	}
+	if len(s.IPs) > MaxSourceIPs {
+		return nil, fmt.Errorf("too many source ips (%d), max is %d", len(s.IPs), MaxSourceIPs)
+	}
+	s.counts = make([]int64, len(s.IPs))
+	return s, nil
+}
+
+// nextIP returns a copy of ip incremented by 1.
+func nextIP(ip net.IP) net.IP {
+	res := make(net.IP, len(ip))
+	copy(res, ip)
+	for i := len(res) - 1; i >= 0; i-- {
+		res[i]++
+		if res[i] != 0 {
+			break
+		}
+	}
+	return res
+}
+
+// Next returns the IP to use for the next connection and counts that connection.
+func (s *SourceIPs) Next() net.IP {
+	i := (atomic.AddUint64(&s.next, 1) - 1) % uint64(len(s.IPs))
+	atomic.AddInt64(&s.counts[i], 1)
+	return s.IPs[i]
+}
+
+// LocalAddr returns the local address for the next connection on network ("tcp" or "udp"),
+// nil (any local address) for other networks or when s is nil.
+func (s *SourceIPs) LocalAddr(network string) net.Addr {
+	if s == nil {
+		return nil
+	}
+	switch {
+	case strings.HasPrefix(network, "tcp"):
+		return &net.TCPAddr{IP: s.Next()}
+	case strings.HasPrefix(network, "udp"):
+		return &net.UDPAddr{IP: s.Next()}
+	}
+	return nil
+}
+
+// Counts returns the number of connections made so far from each source IP, nil if s is nil.
+func (s *SourceIPs) Counts() map[string]int64 {
+	if s == nil {
+		return nil
+	}
+	res := make(map[string]int64, len(s.IPs))
+	for i, ip := range s.IPs {
+		if n := atomic.LoadInt64(&s.counts[i]); n > 0 {
+			res[ip.String()] += n
+		}
+	}
+	return res
+}
+
+// PrintSourceIPCounts prints the number of connections per source IP, sorted by IP string.
+func PrintSourceIPCounts(out io.Writer, counts map[string]int64) {
+	if len(counts) == 0 {
+		return
+	}
+	ips := make([]string, 0, len(counts))
+	for ip := range counts {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	_, _ = fmt.Fprintf(out, "Connections per source IP:\n")
+	for _, ip := range ips {
+		_, _ = fmt.Fprintf(out, "%s: %d\n", ip, counts[ip])
+	}
+}
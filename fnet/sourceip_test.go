@@ -0,0 +1,80 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fnet
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseSourceIPs(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected string // fmt of the IPs, or "error"
+	}{
+		{"", "[]"},
+		{"127.0.0.2", "[127.0.0.2]"},
+		{" 127.0.0.2, ::1 ,", "[127.0.0.2 ::1]"},
+		{"127.0.0.0/30", "[127.0.0.1 127.0.0.2]"},
+		{"127.0.0.2/31,10.1.1.1/32", "[127.0.0.2 127.0.0.3 10.1.1.1]"},
+		{"fd00::/127", "[fd00:: fd00::1]"},
+		{"255.255.255.252/30", "[255.255.255.253 255.255.255.254]"},
+		{"not.an.ip", "error"},
+		{"127.0.0.1/33", "error"},
+		{"10.0.0.0/8", "error"},
+	}
+	for _, tst := range tests {
+		s, err := ParseSourceIPs(tst.list)
+		res := "error"
+		if err == nil {
+			res = "[]"
+			if s != nil {
+				res = fmt.Sprint(s.IPs)
+			}
+		}
+		if res != tst.expected {
+			t.Errorf("ParseSourceIPs(%q) got %s (%v), expected %s", tst.list, res, err, tst.expected)
+		}
+	}
+}
+
+func TestSourceIPsRoundRobin(t *testing.T) {
+	var none *SourceIPs
+	if none.LocalAddr("tcp") != nil || none.Counts() != nil {
+		t.Errorf("Expected nil local address and counts for no source ips")
+	}
+	s, _ := ParseSourceIPs("127.0.0.2,127.0.0.3,127.0.0.4")
+	for i := 0; i < 7; i++ {
+		_ = s.LocalAddr("tcp")
+	}
+	if a := s.LocalAddr("udp").(*net.UDPAddr); a.IP.String() != "127.0.0.3" {
+		t.Errorf("Unexpected 8th source ip %v", a)
+	}
+	if s.LocalAddr("unix") != nil {
+		t.Errorf("Expected no local address for unix sockets")
+	}
+	expected := map[string]int64{"127.0.0.2": 3, "127.0.0.3": 3, "127.0.0.4": 2}
+	if counts := s.Counts(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Got counts %v, expected %v", counts, expected)
+	}
+	var out bytes.Buffer
+	PrintSourceIPCounts(&out, expected)
+	if out.String() != "Connections per source IP:\n127.0.0.2: 3\n127.0.0.3: 3\n127.0.0.4: 2\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
@@ -24,21 +24,25 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"fortio.org/fortio/bincommon"
 	"fortio.org/fortio/dflag/configmap"
+	"fortio.org/fortio/export"
 	"fortio.org/fortio/fgrpc"
 	"fortio.org/fortio/fhttp"
 	"fortio.org/fortio/fnet"
 	"fortio.org/fortio/log"
+	"fortio.org/fortio/matrix"
 	"fortio.org/fortio/periodic"
 	"fortio.org/fortio/stats"
 	"fortio.org/fortio/tcprunner"
 	"fortio.org/fortio/udprunner"
 	"fortio.org/fortio/ui"
 	"fortio.org/fortio/version"
+	"fortio.org/fortio/wsrunner"
 )
 
 // -- Start of support for multiple proxies (-P) flags on cmd line.
@@ -69,18 +73,47 @@ func (f *httpMultiFlagList) Set(value string) error {
 
 // -- End of -M support.
 
+// -- Same for -export.
+type exportFlagList struct{}
+
+func (f *exportFlagList) String() string {
+	return ""
+}
+
+func (f *exportFlagList) Set(value string) error {
+	exports = append(exports, value)
+	return nil
+}
+
+// -- End of -export support.
+
+// -- Same for -expect-header.
+type expectHeaderFlagList struct{}
+
+func (f *expectHeaderFlagList) String() string {
+	return ""
+}
+
+func (f *expectHeaderFlagList) Set(value string) error {
+	expectHeaders = append(expectHeaders, value)
+	return nil
+}
+
+// -- End of -expect-header support.
+
 // Usage to a writer.
 func usage(w io.Writer, msgs ...interface{}) {
-	_, _ = fmt.Fprintf(w, "Φορτίο %s usage:\n\t%s command [flags] target\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+	_, _ = fmt.Fprintf(w, "Φορτίο %s usage:\n\t%s command [flags] target\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
 		version.Short(),
 		os.Args[0],
-		"where command is one of: load (load testing), server (starts ui, rest api,",
-		" http-echo, redirect, proxies, tcp-echo and grpc ping servers), tcp-echo (only",
-		" the tcp-echo server), report (report only UI server), redirect (only the",
-		" redirect server), proxies (only the -M and -P configured proxies), grpcping",
-		" (grpc client), or curl (single URL debug), or nc (single tcp or udp://",
-		" connection), or version (prints the full version and build details).",
-		"where target is a url (http load tests) or host:port (grpc health test).")
+		"where command is one of: load (load testing), matrix (load tests for each",
+		" combination of -matrix-qps, -matrix-c and -matrix-sizes), server (starts ui,",
+		" rest api, http-echo, redirect, proxies, tcp-echo and grpc ping servers),",
+		" tcp-echo (only the tcp-echo server), report (report only UI server), redirect",
+		" (only the redirect server), proxies (only the -M and -P configured proxies),",
+		" grpcping (grpc client), or curl (single URL debug), or nc (single tcp or",
+		" udp:// connection), or version (prints the full version and build details).",
+		"where target is a url (http, ws:// websocket, tcp:// or udp:// load tests) or host:port (grpc health test).")
 	bincommon.FlagsUsage(w, msgs...)
 }
 
@@ -115,7 +148,9 @@ var (
 		"tcp echo server port. Can be in the form of host:port, ip:port, `port` or /unix/domain/path or \""+disabled+"\".")
 	udpPortFlag = flag.String("udp-port", "8078",
 		"udp echo server port. Can be in the form of host:port, ip:port, `port` or \""+disabled+"\".")
-	udpAsyncFlag = flag.Bool("udp-async", false, "if true, udp echo server will use separate go routine to reply")
+	udpAsyncFlag  = flag.Bool("udp-async", false, "if true, udp echo server will use separate go routine to reply")
+	http3PortFlag = flag.String("http3-port", disabled,
+		"http/3 (QUIC) echo server udp port, needs -cert and -key. Can be in the form of host:port, ip:port, `port` or \""+disabled+"\".")
 	grpcPortFlag = flag.String("grpc-port", fnet.DefaultGRPCPort,
 		"grpc server port. Can be in the form of host:port, ip:port or `port` or /unix/domain/path or \""+disabled+
 			"\" to not start the grpc server.")
@@ -136,10 +171,53 @@ var (
 	// -M flag.
 	httpMultiFlags httpMultiFlagList
 	httpMulties    = make([]string, 0)
+	// -export flag.
+	exportFlags exportFlagList
+	exports     = make([]string, 0)
+	// -expect-header flag.
+	expectHeaderFlags expectHeaderFlagList
+	expectHeaders     = make([]string, 0)
+	// response checks flags.
+	expectStatusFlag = flag.String("expect-status", "",
+		"Expected http status `codes`, comma separated codes and ranges, others count as errors (default -ok-codes or 200)")
+	expectContainsFlag = flag.String("expect-contains", "", "`String` the http response body must contain")
+	expectRegexFlag    = flag.String("expect-regex", "", "Regular `expression` the http response body must match")
+	expectJSONFlag     = flag.String("expect-json", "",
+		"Json response body check, `path=value` where path is dot separated keys/indexes e.g. items.0.name=foo")
+	expectSizeFlag  = flag.String("expect-size", "", "Allowed http response body size `range` in bytes, min:max")
+	grpcOKCodesFlag = flag.String("grpc-ok-codes", "",
+		"Grpc status `codes` considered successful besides OK, e.g. NotFound or 0,5, "+
+			"NOT_SERVING for the health check status (default OK only)")
+	// replay flags.
+	replayFlag = flag.String("replay", "",
+		"HAR (.har) or JSONL (one {method, url, headers, body, time} object per line) `file` of requests to replay "+
+			"as load instead of the url")
+	replayOrderFlag = flag.String("replay-order", fhttp.ReplaySequential,
+		"Order of the -replay requests: sequential, random or timing (recorded times, scaled by -replay-speed)")
+	replaySpeedFlag = flag.Float64("replay-speed", 1, "Speed `factor` for the -replay-order timing, e.g. 2 for twice faster")
+	sessionFlag     = flag.String("session", "",
+		"Json `file` of the session steps each connection runs, as one call, instead of the url (see README). "+
+			"The \"once\" steps only run the first time for each connection, not again after later failures (e.g. a 401)")
 
 	allowInitialErrorsFlag = flag.Bool("allow-initial-errors", false, "Allow and don't abort on initial warmup errors")
-	abortOnFlag            = flag.Int("abort-on", 0,
-		"Http `code` that if encountered aborts the run. e.g. 503 or -1 for socket errors.")
+	abortOnFlag            = flag.String("abort-on", "",
+		"Http `code` or socket error category that if encountered aborts the run. e.g. 503, -1 for any socket error, "+
+			"or one of "+strings.Join(fnet.ErrorCategories, ", "))
+	// retry flags.
+	retryAttemptsFlag = flag.Int("retry-attempts", 1,
+		"Maximum `attempts` per http or grpc request, including the first one, 1 for no retry")
+	retryOnFlag = flag.String("retry-on", "",
+		"Comma separated http (or grpc, e.g. Unavailable) status `codes`, ranges and socket error categories to retry, "+
+			"e.g. 502-504,connect_refused (default all the failures)")
+	retryBackoffFlag = flag.Duration("retry-backoff", fhttp.DefaultRetryBackoff,
+		"Maximum backoff before the first retry, doubled for each next one, the actual wait is random up to that value")
+	retryMaxBackoffFlag = flag.Duration("retry-max-backoff", fhttp.DefaultRetryMaxBackoff, "Cap of the exponential -retry-backoff")
+	retryBudgetFlag     = flag.Float64("retry-budget", 0,
+		"Maximum retries as a `fraction` of the requests of the run, e.g. 0.1 for 10% (beyond the first 10). Default is no budget")
+	honorRetryAfterFlag = flag.Bool("honor-retry-after", false,
+		"Pause each http connection after 429 and 503 responses, for their Retry-After or -throttle-backoff, instead of keeping the qps")
+	throttleBackoffFlag = flag.Duration("throttle-backoff", fhttp.DefaultThrottleBackoff,
+		"Pause of -honor-retry-after when the response has no Retry-After header")
 	autoSaveFlag = flag.Bool("a", false, "Automatically save JSON result with filename based on labels & timestamp")
 	redirectFlag = flag.String("redirect-port", "8081", "Redirect all incoming traffic to https URL"+
 		" (need ingress to work properly). Can be in the form of host:port, ip:port, `port` or \""+disabled+"\" to disable the feature.")
@@ -161,7 +239,9 @@ var (
 	doPingLoadFlag = flag.Bool("ping", false, "grpc load test: use ping instead of health")
 	healthSvcFlag  = flag.String("healthservice", "", "which service string to pass to health check")
 	pingDelayFlag  = flag.Duration("grpc-ping-delay", 0, "grpc ping delay in response")
-	streamsFlag    = flag.Int("s", 1, "Number of streams per grpc connection")
+	streamsFlag    = flag.Int("s", 1, "Number of streams per grpc, http/2 (-h2) or http/3 (-h3) connection")
+	pipelineFlag   = flag.Int("pipeline", 1,
+		"Http/1.1 `depth` of pipelining: number of requests written back to back on each connection, without waiting for the responses")
 
 	maxStreamsFlag = flag.Uint("grpc-max-streams", 0,
 		"MaxConcurrentStreams for the grpc server. Default (0) is to leave the option unset.")
@@ -169,6 +249,15 @@ var (
 	uniformFlag   = flag.Bool("uniform", false, "set to true to de-synchronize parallel clients' requests uniformly")
 	nocatchupFlag = flag.Bool("nocatchup", false,
 		"set to exact fixed qps and prevent fortio from trying to catchup when the target fails to keep up temporarily")
+	// matrix mode flags.
+	matrixQPSFlag = flag.String("matrix-qps", "",
+		"Comma separated `list` of qps values for the matrix command, defaults to the -qps value")
+	matrixConnectionsFlag = flag.String("matrix-c", "",
+		"Comma separated `list` of connections values for the matrix command, defaults to the -c value")
+	matrixSizesFlag = flag.String("matrix-sizes", "",
+		"Comma separated `list` of payload sizes for the matrix command, defaults to the regular payload flags")
+	exemplarsFlag = flag.Int("slowest", 0,
+		"Keep and report the `N` slowest calls, with their details (status, url for http,...)")
 	// nc mode flag(s).
 	ncDontStopOnCloseFlag = flag.Bool("nc-dont-stop-on-eof", false, "in netcat (nc) mode, don't abort as soon as remote side closes")
 	// Mirror origin global setting (should be per destination eventually).
@@ -188,6 +277,11 @@ func main() {
 	flag.Var(&proxiesFlags, "P",
 		"Tcp proxies to run, e.g -P \"localport1 dest_host1:dest_port1\" -P \"[::1]:0 www.google.com:443\" ...")
 	flag.Var(&httpMultiFlags, "M", "Http multi proxy to run, e.g -M \"localport1 baseDestURL1 baseDestURL2\" -M ...")
+	flag.Var(&exportFlags, "export", "Additional output(s) of the results, e.g -export result.hlog -export md ... "+
+		"The file `path` extension selects the format, a bare format name writes to stdout. Formats: "+
+		strings.Join(export.Names(), ", "))
+	flag.Var(&expectHeaderFlags, "expect-header",
+		"Http response `header` that must be present, as Name or \"Name: value\", can be repeated")
 	bincommon.SharedMain(usage)
 	if len(os.Args) < 2 {
 		usageErr("Error: need at least 1 command parameter")
@@ -228,6 +322,8 @@ func main() {
 		fortioNC()
 	case "load":
 		fortioLoad(*curlFlag, percList)
+	case "matrix":
+		fortioMatrix(percList)
 	case "redirect":
 		isServer = true
 		fhttp.RedirectToHTTPS(*redirectFlag)
@@ -266,6 +362,11 @@ func main() {
 		if *grpcPortFlag != disabled {
 			fgrpc.PingServer(*grpcPortFlag, *bincommon.CertFlag, *bincommon.KeyFlag, fgrpc.DefaultHealthServiceName, uint32(*maxStreamsFlag))
 		}
+		if *http3PortFlag != disabled {
+			if fhttp.ServeHTTP3(*http3PortFlag, *echoDbgPathFlag, *bincommon.CertFlag, *bincommon.KeyFlag) == nil {
+				os.Exit(1) // error already logged
+			}
+		}
 		if *redirectFlag != disabled {
 			fhttp.RedirectToHTTPS(*redirectFlag)
 		}
@@ -306,12 +407,16 @@ func serverLoop(sync string) {
 
 func startProxies() int {
 	numProxies := 0
+	pp, err := fnet.NewProxyProtocol(*bincommon.ProxyProtocolFlag, *bincommon.ProxyProtocolSourceFlag)
+	if err != nil {
+		log.Errf("Invalid -proxy-protocol: %v", err)
+	}
 	for _, proxy := range proxies {
 		s := strings.SplitN(proxy, " ", 2)
 		if len(s) != 2 {
 			log.Errf("Invalid syntax for proxy \"%s\", should be \"localAddr destHost:destPort\"", proxy)
 		}
-		fnet.ProxyToDestination(s[0], s[1])
+		fnet.ProxyToDestinationWithProtocol(s[0], s[1], pp)
 		numProxies++
 	}
 	for _, hmulti := range httpMulties {
@@ -350,7 +455,16 @@ func fortioNC() {
 
 //nolint:funlen, gocognit // maybe refactor/shorten later.
 func fortioLoad(justCurl bool, percList []float64) {
-	if len(flag.Args()) != 1 {
+	if *replayFlag != "" && *sessionFlag != "" {
+		usageErr("Error: -replay and -session are mutually exclusive")
+	}
+	// the requests file (when loading) has the urls, the url argument is then optional.
+	requestsFile := *replayFlag
+	if *sessionFlag != "" {
+		requestsFile = *sessionFlag
+	}
+	nArgs := len(flag.Args())
+	if nArgs > 1 || (nArgs == 0 && (justCurl || requestsFile == "")) {
 		usageErr("Error: fortio load/curl needs a url or destination")
 	}
 	httpOpts := bincommon.SharedHTTPOptions()
@@ -359,6 +473,9 @@ func fortioLoad(justCurl bool, percList []float64) {
 		return
 	}
 	url := httpOpts.URL
+	if url == "" {
+		url = requestsFile
+	}
 	prevGoMaxProcs := runtime.GOMAXPROCS(*goMaxProcsFlag)
 	out := os.Stderr
 	qps := *qpsFlag // TODO possibly use translated <=0 to "max" from results/options normalization in periodic/
@@ -412,12 +529,17 @@ func fortioLoad(justCurl bool, percList []float64) {
 		RunID:       *bincommon.RunIDFlag,
 		Offset:      *offsetFlag,
 		NoCatchUp:   *nocatchupFlag,
+		Exemplars:   *exemplarsFlag,
 	}
 	err := ro.AddAccessLogger(*accessLogFileFlag, *accessLogFileFormat)
 	if err != nil {
 		// Error already logged.
 		os.Exit(1)
 	}
+	abortOn, abortOnError, err := parseAbortOn(*abortOnFlag)
+	if err != nil {
+		usageErr("Error parsing -abort-on: ", err)
+	}
 	var res periodic.HasRunnerResult
 	if *grpcFlag {
 		o := fgrpc.GRPCRunnerOptions{
@@ -429,32 +551,66 @@ func fortioLoad(justCurl bool, percList []float64) {
 			Payload:            httpOpts.PayloadUTF8(),
 			Delay:              *pingDelayFlag,
 			UsePing:            *doPingLoadFlag,
+			AbortOnError:       abortOnError,
+			OKCodes:            grpcOKCodes(),
+			Retry:              retryPolicy(),
+			ReqTimeout:         httpOpts.HTTPReqTimeOut,
 		}
 		o.TLSOptions = httpOpts.TLSOptions
 		res, err = fgrpc.RunGRPCTest(&o)
+	} else if wsrunner.IsWebSocketURL(url) {
+		o := wsrunner.RunnerOptions{
+			RunnerOptions: ro,
+			AbortOnError:  abortOnError,
+		}
+		o.TLSOptions = httpOpts.TLSOptions
+		o.ReqTimeout = httpOpts.HTTPReqTimeOut
+		o.Destination = url
+		o.Payload = httpOpts.Payload
+		o.Headers = httpOpts.AllHeaders()
+		o.SourceIP = httpOpts.SourceIP
+		res, err = wsrunner.RunWSTest(&o)
 	} else if strings.HasPrefix(url, tcprunner.TCPURLPrefix) {
 		o := tcprunner.RunnerOptions{
 			RunnerOptions: ro,
+			AbortOnError:  abortOnError,
 		}
 		o.ReqTimeout = httpOpts.HTTPReqTimeOut
 		o.Destination = url
 		o.Payload = httpOpts.Payload
+		o.Proxy = httpOpts.Proxy
+		o.SourceIP = httpOpts.SourceIP
+		o.ProxyProtocol = httpOpts.ProxyProtocol
+		o.ProxyProtocolSource = httpOpts.ProxyProtocolSource
 		res, err = tcprunner.RunTCPTest(&o)
 	} else if strings.HasPrefix(url, udprunner.UDPURLPrefix) {
 		o := udprunner.RunnerOptions{
 			RunnerOptions: ro,
+			AbortOnError:  abortOnError,
 		}
 		o.ReqTimeout = *udpTimeoutFlag
 		o.Destination = url
 		o.Payload = httpOpts.Payload
+		o.SourceIP = httpOpts.SourceIP
 		res, err = udprunner.RunUDPTest(&o)
 	} else {
 		o := fhttp.HTTPRunnerOptions{
 			HTTPOptions:        *httpOpts,
 			RunnerOptions:      ro,
 			Profiler:           *profileFlag,
+			Streams:            *streamsFlag,
+			Pipeline:           *pipelineFlag,
 			AllowInitialErrors: *allowInitialErrorsFlag,
-			AbortOn:            *abortOnFlag,
+			AbortOn:            abortOn,
+			AbortOnError:       abortOnError,
+			Checks:             responseChecks(),
+			ReplayFile:         *replayFlag,
+			ReplayOrder:        *replayOrderFlag,
+			ReplaySpeed:        *replaySpeedFlag,
+			SessionFile:        *sessionFlag,
+			Retry:              retryPolicy(),
+			HonorRetryAfter:    *honorRetryAfterFlag,
+			ThrottleBackoff:    *throttleBackoffFlag,
 		}
 		res, err = fhttp.RunHTTPTest(&o)
 	}
@@ -504,6 +660,190 @@ func fortioLoad(justCurl bool, percList []float64) {
 		}
 		_, _ = fmt.Fprintf(out, "Successfully wrote %d bytes of Json data to %s\n", n, jsonFileName)
 	}
+	exportResults(out, res)
+}
+
+// fortioMatrix runs the load test for each combination of the -matrix-* flags
+// values, saves each result and prints a summary table.
+func fortioMatrix(percList []float64) {
+	if len(flag.Args()) != 1 {
+		usageErr("Error: fortio matrix needs a url or destination")
+	}
+	qpsList, err := matrix.ParseFloats(*matrixQPSFlag)
+	if err != nil {
+		usageErr("Error parsing -matrix-qps: ", err)
+	}
+	if len(qpsList) == 0 {
+		qpsList = []float64{*qpsFlag}
+	}
+	cList, err := matrix.ParseInts(*matrixConnectionsFlag)
+	if err != nil {
+		usageErr("Error parsing -matrix-c: ", err)
+	}
+	if len(cList) == 0 {
+		cList = []int{*numThreadsFlag}
+	}
+	sizes, err := matrix.ParseInts(*matrixSizesFlag)
+	if err != nil {
+		usageErr("Error parsing -matrix-sizes: ", err)
+	}
+	abortOn, abortOnError, err := parseAbortOn(*abortOnFlag)
+	if err != nil {
+		usageErr("Error parsing -abort-on: ", err)
+	}
+	httpOpts := bincommon.SharedHTTPOptions()
+	ro := periodic.RunnerOptions{
+		Duration:    *durationFlag,
+		Percentiles: percList,
+		Resolution:  *resolutionFlag,
+		Out:         os.Stderr,
+		Labels:      *labelsFlag,
+		Exactly:     *exactlyFlag,
+		Jitter:      *jitterFlag,
+		Uniform:     *uniformFlag,
+		RunID:       *bincommon.RunIDFlag,
+		Offset:      *offsetFlag,
+		NoCatchUp:   *nocatchupFlag,
+		Exemplars:   *exemplarsFlag,
+	}
+	mo := matrix.Options{
+		HTTP: fhttp.HTTPRunnerOptions{
+			HTTPOptions:        *httpOpts,
+			RunnerOptions:      ro,
+			Streams:            *streamsFlag,
+			Pipeline:           *pipelineFlag,
+			AllowInitialErrors: *allowInitialErrorsFlag,
+			AbortOn:            abortOn,
+			AbortOnError:       abortOnError,
+			Checks:             responseChecks(),
+			Retry:              retryPolicy(),
+			HonorRetryAfter:    *honorRetryAfterFlag,
+			ThrottleBackoff:    *throttleBackoffFlag,
+		},
+		QPS:          qpsList,
+		Connections:  cList,
+		PayloadSizes: sizes,
+		Save: func(id string, j []byte) string {
+			fname := path.Join(*dataDirFlag, id+".json")
+			if err := os.WriteFile(fname, append(j, '\n'), 0o644); err != nil { //nolint:gosec // we do want 644
+				log.Errf("Unable to save %s: %v", fname, err)
+				return ""
+			}
+			return fname
+		},
+	}
+	if *grpcFlag {
+		mo.GRPC = &fgrpc.GRPCRunnerOptions{
+			RunnerOptions:      ro,
+			Destination:        httpOpts.URL,
+			Service:            *healthSvcFlag,
+			Streams:            *streamsFlag,
+			AllowInitialErrors: *allowInitialErrorsFlag,
+			Payload:            httpOpts.PayloadUTF8(),
+			Delay:              *pingDelayFlag,
+			UsePing:            *doPingLoadFlag,
+			AbortOnError:       abortOnError,
+			OKCodes:            grpcOKCodes(),
+			Retry:              retryPolicy(),
+			ReqTimeout:         httpOpts.HTTPReqTimeOut,
+		}
+		mo.GRPC.TLSOptions = httpOpts.TLSOptions
+	}
+	res, err := matrix.Run(&mo)
+	if res != nil {
+		res.Print(os.Stdout)
+		if u := res.BrowseURL(); u != "" {
+			_, _ = fmt.Fprintf(os.Stderr, "Chart of all the runs: fortio report -data-dir %s then open http://localhost:8080/fortio/%s\n",
+				*dataDirFlag, u)
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Aborting because of %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseAbortOn splits the -abort-on value into an http code or a socket error category.
+func parseAbortOn(value string) (int, string, error) {
+	if value == "" {
+		return 0, "", nil
+	}
+	if fnet.IsErrorCategory(value) {
+		return 0, value, nil
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, "", fmt.Errorf("%q is neither an http code nor one of %s", value, strings.Join(fnet.ErrorCategories, ", "))
+	}
+	return code, "", nil
+}
+
+// grpcOKCodes returns the parsed -grpc-ok-codes, nil if not set.
+func grpcOKCodes() *fhttp.CodeSet {
+	if *grpcOKCodesFlag == "" {
+		return nil
+	}
+	codes, err := fgrpc.ParseCodes(*grpcOKCodesFlag)
+	if err != nil {
+		usageErr("Error parsing -grpc-ok-codes: ", err)
+	}
+	return codes
+}
+
+// retryPolicy returns the retry policy from the -retry-* flags, for http or, with -grpc, grpc
+// status codes. Nil when -retry-attempts is 1 or less.
+func retryPolicy() *fhttp.RetryPolicy {
+	var p *fhttp.RetryPolicy
+	var err error
+	if *grpcFlag {
+		p, err = fgrpc.NewRetryPolicy(*retryAttemptsFlag, *retryOnFlag)
+	} else {
+		p, err = fhttp.NewRetryPolicy(*retryAttemptsFlag, *retryOnFlag, nil)
+	}
+	if err != nil {
+		usageErr("Error parsing -retry-on: ", err)
+	}
+	if p != nil {
+		p.Backoff, p.MaxBackoff, p.Budget = *retryBackoffFlag, *retryMaxBackoffFlag, *retryBudgetFlag
+	}
+	return p
+}
+
+// responseChecks returns the response checks from the -expect-* flags, nil if none is set.
+func responseChecks() *fhttp.ResponseChecks {
+	rc := fhttp.ResponseChecks{
+		Status:   *expectStatusFlag,
+		Contains: *expectContainsFlag,
+		Regex:    *expectRegexFlag,
+		JSON:     *expectJSONFlag,
+		Headers:  expectHeaders,
+		Size:     *expectSizeFlag,
+	}
+	if rc.Empty() {
+		return nil
+	}
+	if err := rc.Init(); err != nil {
+		usageErr("Error in -expect-* flags: ", err)
+	}
+	return &rc
+}
+
+// exportResults writes the results in the additional -export formats requested, if any.
+func exportResults(out io.Writer, res periodic.HasRunnerResult) {
+	if len(exports) == 0 {
+		return
+	}
+	er, err := export.FromResult(res)
+	if err != nil {
+		log.Fatalf("Unable to convert results for export: %v", err)
+	}
+	for _, spec := range exports {
+		dest, err := export.ToFile(spec, er)
+		if err != nil {
+			log.Fatalf("Unable to export results to %s: %v", dest, err)
+		}
+		_, _ = fmt.Fprintf(out, "Successfully exported results to %s\n", dest)
+	}
 }
 
 func grpcClient() {
@@ -0,0 +1,258 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package matrix runs a grid of qps x connections x payload sizes load tests
+// to characterize the latency vs throughput curve of a service.
+package matrix // import "fortio.org/fortio/matrix"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"fortio.org/fortio/fgrpc"
+	"fortio.org/fortio/fhttp"
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/periodic"
+	"fortio.org/fortio/stats"
+)
+
+// Options are the matrix parameters: the base http (or grpc) options and
+// the lists of values to combine.
+type Options struct {
+	// HTTP are the base options for http runs, QPS, NumThreads and Payload
+	// get replaced for each point of the matrix.
+	HTTP fhttp.HTTPRunnerOptions
+	// GRPC, when set, is used instead of HTTP for grpc runs.
+	GRPC *fgrpc.GRPCRunnerOptions
+	// QPS values to run at (-1 or 0 for max qps).
+	QPS []float64
+	// Connections values (number of threads).
+	Connections []int
+	// PayloadSizes values, empty means just the base payload.
+	PayloadSizes []int
+	// Save, when set, is called with each result's ID and JSON and returns where it got saved.
+	Save func(id string, json []byte) string
+	// Starting, when set, is called with each run's options before it starts (e.g. to register the run).
+	Starting func(ro *periodic.RunnerOptions)
+	// Done, when set, is called after each run, returning true stops the matrix
+	// (e.g. when that run got interrupted).
+	Done func(ro *periodic.RunnerOptions) bool
+}
+
+// Point is the summary of one run of the matrix.
+type Point struct {
+	QPS         float64
+	Connections int
+	PayloadSize int
+	ActualQPS   float64
+	Count       int64
+	Errors      int64
+	// Avg, Max and Percentiles are latencies in seconds.
+	Avg         float64
+	Max         float64
+	Percentiles []stats.Percentile
+	// Result ID and, if saved, where.
+	ResultID string
+	SavedAs  string `json:",omitempty"`
+}
+
+// Results is the summary of all the matrix runs, in run order.
+type Results struct {
+	Points []Point
+}
+
+// ParseFloats parses a comma separated list of numbers, like for -matrix-qps.
+func ParseFloats(s string) ([]float64, error) {
+	var res []float64
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// ParseInts parses a comma separated list of integers, like for -matrix-c.
+func ParseInts(s string) ([]int, error) {
+	var res []int
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+// Run runs each combination of qps, connections and payload size, in that
+// nesting order, and returns the summary. It stops at the first error.
+func Run(o *Options) (*Results, error) {
+	if len(o.QPS) == 0 || len(o.Connections) == 0 {
+		return nil, fmt.Errorf("matrix needs at least one qps and one connections value")
+	}
+	sizes := o.PayloadSizes
+	if len(sizes) == 0 {
+		sizes = []int{-1} // base payload
+	}
+	total := len(o.QPS) * len(o.Connections) * len(sizes)
+	res := &Results{}
+	for _, qps := range o.QPS {
+		for _, c := range o.Connections {
+			for _, size := range sizes {
+				log.Infof("Matrix run %d/%d: qps %g, %d connections, payload size %d", len(res.Points)+1, total, qps, c, size)
+				r, stop, err := runOne(o, qps, c, size)
+				if err != nil {
+					return res, err
+				}
+				p, err := summarize(o, r, qps, c, size)
+				if err != nil {
+					return res, err
+				}
+				res.Points = append(res.Points, p)
+				if stop {
+					log.Infof("Matrix stopped after %d/%d runs", len(res.Points), total)
+					return res, nil
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// runOne runs a single point of the matrix. size < 0 means keep the base payload.
+func runOne(o *Options, qps float64, c, size int) (periodic.HasRunnerResult, bool, error) {
+	label := fmt.Sprintf("qps=%g c=%d", qps, c)
+	if size >= 0 {
+		label += fmt.Sprintf(" size=%d", size)
+	}
+	if o.GRPC != nil {
+		g := *o.GRPC
+		setRunnerOptions(&g.RunnerOptions, qps, c, label)
+		if size >= 0 {
+			g.Payload = string(fnet.GenerateRandomPayload(size))
+		}
+		return o.run(&g.RunnerOptions, func() (periodic.HasRunnerResult, error) { return fgrpc.RunGRPCTest(&g) })
+	}
+	h := o.HTTP
+	setRunnerOptions(&h.RunnerOptions, qps, c, label)
+	if size >= 0 {
+		h.Payload = fnet.GenerateRandomPayload(size)
+	}
+	return o.run(&h.RunnerOptions, func() (periodic.HasRunnerResult, error) { return fhttp.RunHTTPTest(&h) })
+}
+
+// run calls f between the Starting and Done hooks.
+func (o *Options) run(ro *periodic.RunnerOptions, f func() (periodic.HasRunnerResult, error)) (periodic.HasRunnerResult, bool, error) {
+	if o.Starting != nil {
+		o.Starting(ro)
+	}
+	res, err := f()
+	stop := false
+	if o.Done != nil {
+		stop = o.Done(ro)
+	}
+	return res, stop, err
+}
+
+func setRunnerOptions(ro *periodic.RunnerOptions, qps float64, c int, label string) {
+	ro.QPS = qps
+	if qps <= 0 {
+		ro.QPS = -1 // 0 would be the default qps, we want max like the -qps flag
+	}
+	ro.NumThreads = c
+	ro.ID = "" // each run gets its own id
+	ro.Stop = nil
+	if ro.Labels == "" {
+		ro.Labels = "matrix " + label
+	} else {
+		ro.Labels += " matrix " + label
+	}
+}
+
+func summarize(o *Options, r periodic.HasRunnerResult, qps float64, c, size int) (Point, error) {
+	rr := r.Result()
+	h := rr.DurationHistogram
+	p := Point{
+		QPS: qps, Connections: c, PayloadSize: size,
+		ActualQPS: rr.ActualQPS, Count: h.Count, Avg: h.Avg, Max: h.Max, Percentiles: h.Percentiles,
+		ResultID: rr.ID,
+	}
+	if rr.ErrorsDurationHistogram != nil {
+		p.Errors = rr.ErrorsDurationHistogram.Count
+	}
+	if o.Save != nil {
+		j, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return p, err
+		}
+		p.SavedAs = o.Save(rr.ID, j)
+	}
+	return p, nil
+}
+
+// BrowseURL returns the (relative to the UI) url of the multi results
+// chart of the saved points.
+func (r *Results) BrowseURL() string {
+	v := url.Values{}
+	for _, p := range r.Points {
+		if p.SavedAs != "" {
+			v.Add("sel", p.ResultID)
+		}
+	}
+	if len(v) == 0 {
+		return ""
+	}
+	return "browse?" + v.Encode()
+}
+
+// Print writes the summary table, latencies in milliseconds.
+func (r *Results) Print(out io.Writer) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprint(tw, "qps\tc\tsize\tactual qps\tcalls\terrors\tavg\t")
+	if len(r.Points) > 0 {
+		for _, p := range r.Points[0].Percentiles {
+			_, _ = fmt.Fprintf(tw, "p%g\t", p.Percentile)
+		}
+	}
+	_, _ = fmt.Fprint(tw, "max\t\n")
+	for _, p := range r.Points {
+		size := "-"
+		if p.PayloadSize >= 0 {
+			size = strconv.Itoa(p.PayloadSize)
+		}
+		_, _ = fmt.Fprintf(tw, "%g\t%d\t%s\t%.1f\t%d\t%d\t%.3f\t", p.QPS, p.Connections, size, p.ActualQPS, p.Count, p.Errors, 1000.*p.Avg)
+		for _, pp := range p.Percentiles {
+			_, _ = fmt.Fprintf(tw, "%.3f\t", 1000.*pp.Value)
+		}
+		_, _ = fmt.Fprintf(tw, "%.3f\t\n", 1000.*p.Max)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package matrix
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"fortio.org/fortio/fhttp"
+	"fortio.org/fortio/periodic"
+)
+
+func TestParse(t *testing.T) {
+	f, err := ParseFloats(" 10, 100.5,,-1 ")
+	if err != nil || len(f) != 3 || f[0] != 10 || f[1] != 100.5 || f[2] != -1 {
+		t.Errorf("Unexpected ParseFloats %v %v", f, err)
+	}
+	i, err := ParseInts("1,8,16")
+	if err != nil || len(i) != 3 || i[2] != 16 {
+		t.Errorf("Unexpected ParseInts %v %v", i, err)
+	}
+	if _, err = ParseInts("1,x"); err == nil {
+		t.Errorf("Expected error for bad int list")
+	}
+	if f, err = ParseFloats(""); err != nil || f != nil {
+		t.Errorf("Expected empty list for empty string, got %v %v", f, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	mux, addr := fhttp.DynamicHTTPServer(false)
+	mux.HandleFunc("/foo/", fhttp.EchoHandler)
+	o := Options{
+		QPS:          []float64{0, 50},
+		Connections:  []int{1, 2},
+		PayloadSizes: []int{0, 100},
+	}
+	o.HTTP.URL = fmt.Sprintf("http://localhost:%d/foo/", addr.Port)
+	o.HTTP.Exactly = 10
+	o.HTTP.Labels = "test"
+	o.HTTP.Percentiles = []float64{50, 99.9}
+	saved := 0
+	o.Save = func(id string, json []byte) string {
+		saved++
+		return id
+	}
+	var labels []string
+	o.Starting = func(ro *periodic.RunnerOptions) {
+		labels = append(labels, ro.Labels)
+	}
+	o.Done = func(ro *periodic.RunnerOptions) bool {
+		return len(labels) == 6 // stop after the 6th run
+	}
+	res, err := Run(&o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Points) != 6 || saved != 6 {
+		t.Fatalf("Expected 6 points (stopped early) and saved, got %d %+v", saved, res)
+	}
+	if labels[5] != "test matrix qps=50 c=1 size=100" {
+		t.Errorf("Unexpected label %q", labels[5])
+	}
+	if p := res.Points[3]; p.QPS != 0 || p.Connections != 2 || p.PayloadSize != 100 || p.Count != 10 || p.ActualQPS <= 50 {
+		t.Errorf("Unexpected max qps point %+v", p)
+	}
+	if p := res.Points[4]; p.QPS != 50 || p.ActualQPS > 51 {
+		t.Errorf("Unexpected 50 qps point %+v", p)
+	}
+	if !strings.HasPrefix(res.BrowseURL(), "browse?sel=") || strings.Count(res.BrowseURL(), "sel=") != 6 {
+		t.Errorf("Unexpected browse url %q", res.BrowseURL())
+	}
+	var buf bytes.Buffer
+	res.Print(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 7 || !strings.Contains(lines[0], "actual qps") || !strings.Contains(lines[0], "p99.9") {
+		t.Errorf("Unexpected summary table:\n%s", buf.String())
+	}
+}
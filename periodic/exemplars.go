@@ -0,0 +1,131 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package periodic // import "fortio.org/fortio/periodic"
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Exemplar is a single call kept because it was one of the slowest of the run.
+type Exemplar struct {
+	// When the call started.
+	StartTime time.Time
+	// Thread (goroutine) id that made the call.
+	Thread int
+	// Latency of the call in seconds.
+	Latency float64
+	// Status and Details as returned by Runnable.Run().
+	Status  bool
+	Details string
+}
+
+// exemplarHeap is a min-heap on latency so the fastest of the kept calls is
+// the one evicted when a slower one comes in.
+type exemplarHeap []Exemplar
+
+func (h exemplarHeap) Len() int           { return len(h) }
+func (h exemplarHeap) Less(i, j int) bool { return h[i].Latency < h[j].Latency }
+func (h exemplarHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *exemplarHeap) Push(x interface{}) {
+	*h = append(*h, x.(Exemplar))
+}
+
+func (h *exemplarHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// Exemplars keeps the N slowest calls. Like the histograms it isn't thread
+// safe: use one per thread and Transfer() them at the end.
+// A nil *Exemplars is valid and records nothing.
+type Exemplars struct {
+	max  int
+	heap exemplarHeap
+}
+
+// NewExemplars returns an Exemplars keeping up to n of the slowest calls,
+// or nil when n <= 0.
+func NewExemplars(n int) *Exemplars {
+	if n <= 0 {
+		return nil
+	}
+	return &Exemplars{max: n, heap: make(exemplarHeap, 0, n)}
+}
+
+// Keeps returns true if a call with that latency would be kept, it is meant
+// to be checked before building the Exemplar to keep the common case cheap.
+func (e *Exemplars) Keeps(latency float64) bool {
+	if e == nil {
+		return false
+	}
+	return len(e.heap) < e.max || latency > e.heap[0].Latency
+}
+
+// Record adds the call if it's one of the N slowest seen so far.
+func (e *Exemplars) Record(x Exemplar) {
+	if !e.Keeps(x.Latency) {
+		return
+	}
+	if len(e.heap) < e.max {
+		heap.Push(&e.heap, x)
+		return
+	}
+	e.heap[0] = x
+	heap.Fix(&e.heap, 0)
+}
+
+// Transfer merges the exemplars from src into e and resets src.
+func (e *Exemplars) Transfer(src *Exemplars) {
+	if e == nil || src == nil {
+		return
+	}
+	for _, x := range src.heap {
+		e.Record(x)
+	}
+	src.heap = src.heap[:0]
+}
+
+// Sorted returns the kept calls, slowest first.
+func (e *Exemplars) Sorted() []Exemplar {
+	if e == nil || len(e.heap) == 0 {
+		return nil
+	}
+	res := make([]Exemplar, len(e.heap))
+	copy(res, e.heap)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Latency > res[j].Latency
+	})
+	return res
+}
+
+// PrintExemplars prints the slowest calls, one per line.
+func PrintExemplars(out io.Writer, exemplars []Exemplar) {
+	if len(exemplars) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(out, "# Slowest %d calls:\n", len(exemplars))
+	for _, x := range exemplars {
+		_, _ = fmt.Fprintf(out, "%s T%03d %.6g s ok=%t %s\n",
+			x.StartTime.Format("15:04:05.000000"), x.Thread, x.Latency, x.Status, x.Details)
+	}
+}
@@ -58,6 +58,29 @@ type Runnable interface {
 	Run(tid int) (status bool, details string)
 }
 
+// Waiter is optionally implemented by Runnables which need to wait before each call
+// (e.g. to replay recorded timings), that wait isn't included in the call's latency.
+// Wait returns false if stop got closed while waiting.
+type Waiter interface {
+	Wait(tid int, stop chan struct{}) bool
+}
+
+// Throttler is optionally implemented by Runnables which the target can ask to slow down (e.g.
+// http 429 responses with a Retry-After header). Throttle returns how long to pause before the
+// next call, 0 for none. The pause isn't included in the calls latency and the calls resume at
+// the requested qps without catching up. The run duration (when not using Exactly) includes the
+// pauses: it isn't extended by them, so fewer calls are made, and a pause is cut short at the end
+// of the run.
+type Throttler interface {
+	Throttle(tid int) time.Duration
+}
+
+// throttling is the time a runner was paused by its Throttler and the number of pauses.
+type throttling struct {
+	time  time.Duration
+	count int64
+}
+
 // MakeRunners creates an array of NumThreads identical Runnable instances
 // (for the (rare/test) cases where there is no unique state needed).
 func (r *RunnerOptions) MakeRunners(rr Runnable) {
@@ -207,6 +230,8 @@ type RunnerOptions struct {
 	AccessLogger AccessLogger `json:"-"`
 	// No catch-up: if true we will do exactly the requested QPS and not try to catch up if the target is temporarily slow.
 	NoCatchUp bool
+	// Number of slowest calls to keep, with their details, in the results (0, the default, keeps none).
+	Exemplars int
 	// Unique 96 character ID used as reference to saved json file. Created during Normalize().
 	ID string
 	// Time the object got first normalized, used to generate the unique ID above.
@@ -236,6 +261,15 @@ type RunnerResults struct {
 	AccessLoggerInfo        string
 	// Same as RunnerOptions ID:  Unique 96 character ID used as reference to saved json file. Created during Normalize().
 	ID string
+	// Slowest calls of the run, slowest first, when RunnerOptions.Exemplars is set.
+	Exemplars []Exemplar `json:",omitempty"`
+	// Load generator's own resource usage during the run, see ClientStats.Saturated.
+	ClientStats *ClientStats `json:",omitempty"`
+	// Time paused by the target (see Throttler), summed over the threads, and number of pauses.
+	ThrottledTime time.Duration `json:",omitempty"`
+	Throttles     int64         `json:",omitempty"`
+	// Successful calls per second, when throttled.
+	EffectiveQPS float64 `json:",omitempty"`
 }
 
 // HasRunnerResult is the interface implictly implemented by HTTPRunnerResults
@@ -509,6 +543,8 @@ func (r *periodicRunner) Run() RunnerResults {
 	errorsDuration := stats.NewHistogram(r.Offset.Seconds(), r.Resolution)
 	// Histogram and stats for Sleep time (negative offset to capture <0 sleep in their own bucket):
 	sleepTime := stats.NewHistogram(-0.001, 0.001)
+	// Slowest calls (nil when not requested)
+	slowest := NewExemplars(r.Exemplars)
 	var loggerInfo string
 	if r.AccessLogger != nil {
 		loggerInfo = r.AccessLogger.Info()
@@ -520,45 +556,63 @@ func (r *periodicRunner) Run() RunnerResults {
 			r.RunType, r.Labels, start, requestedQPS, requestedDuration,
 			0, 0, r.NumThreads, version.Short(), functionDuration.Export().CalcPercentiles(r.Percentiles),
 			errorsDuration.Export().CalcPercentiles(r.Percentiles),
-			r.Exactly, r.Jitter, r.Uniform, r.NoCatchUp, r.RunID, loggerInfo, r.ID,
+			r.Exactly, r.Jitter, r.Uniform, r.NoCatchUp, r.RunID, loggerInfo, r.ID, nil, nil, 0, 0, 0,
 		}
 	}
+	sampler := newClientSampler()
+	var throttled throttling
 	if r.NumThreads <= 1 {
 		log.LogVf("Running single threaded")
-		runOne(0, runnerChan, functionDuration, errorsDuration, sleepTime, numCalls+leftOver, start, r)
+		throttled = runOne(0, runnerChan, functionDuration, errorsDuration, sleepTime, slowest, numCalls+leftOver, start, r)
 	} else {
 		var wg sync.WaitGroup
 		var fDs, eDs, sDs []*stats.Histogram
+		var xDs []*Exemplars
+		tDs := make([]throttling, r.NumThreads)
 		for t := 0; t < r.NumThreads; t++ {
 			durP := functionDuration.Clone()
 			errP := errorsDuration.Clone()
 			sleepP := sleepTime.Clone()
+			slowP := NewExemplars(r.Exemplars)
 			fDs = append(fDs, durP)
 			eDs = append(eDs, errP)
 			sDs = append(sDs, sleepP)
+			xDs = append(xDs, slowP)
 			wg.Add(1)
 			thisNumCalls := numCalls
 			if (leftOver > 0) && (t == 0) {
 				// The first thread gets to do the additional work
 				thisNumCalls += leftOver
 			}
-			go func(t int, durP, errP, sleepP *stats.Histogram) {
-				runOne(t, runnerChan, durP, errP, sleepP, thisNumCalls, start, r)
+			go func(t int, durP, errP, sleepP *stats.Histogram, slowP *Exemplars) {
+				tDs[t] = runOne(t, runnerChan, durP, errP, sleepP, slowP, thisNumCalls, start, r)
 				wg.Done()
-			}(t, durP, errP, sleepP)
+			}(t, durP, errP, sleepP, slowP)
 		}
 		wg.Wait()
 		for t := 0; t < r.NumThreads; t++ {
 			functionDuration.Transfer(fDs[t])
 			errorsDuration.Transfer(eDs[t])
 			sleepTime.Transfer(sDs[t])
+			slowest.Transfer(xDs[t])
+			throttled.time += tDs[t].time
+			throttled.count += tDs[t].count
 		}
 	}
+	clientStats := sampler.stop()
 	elapsed := time.Since(start)
 	actualQPS := float64(functionDuration.Count) / elapsed.Seconds()
 	if log.Log(log.Warning) {
 		_, _ = fmt.Fprintf(r.Out, "Ended after %v : %d calls. qps=%.5g\n", elapsed, functionDuration.Count, actualQPS)
 	}
+	effectiveQPS := 0.
+	if throttled.count > 0 {
+		effectiveQPS = float64(functionDuration.Count-errorsDuration.Count) / elapsed.Seconds()
+		if log.Log(log.Warning) {
+			_, _ = fmt.Fprintf(r.Out, "Throttled %d times for %v (%.1f %% of the threads time), effective qps=%.5g (successful calls)\n",
+				throttled.count, throttled.time, 100.*throttled.time.Seconds()/(elapsed.Seconds()*float64(r.NumThreads)), effectiveQPS)
+		}
+	}
 	if useQPS { //nolint:nestif
 		percentNegative := 100. * float64(sleepTime.Hdata[0]) / float64(sleepTime.Count)
 		// Somewhat arbitrary percentage of time the sleep was behind so we
@@ -583,11 +637,13 @@ func (r *periodicRunner) Run() RunnerResults {
 		r.RunType, r.Labels, start, requestedQPS, requestedDuration,
 		actualQPS, elapsed, r.NumThreads, version.Short(), functionDuration.Export().CalcPercentiles(r.Percentiles),
 		errorsDuration.Export().CalcPercentiles(r.Percentiles),
-		r.Exactly, r.Jitter, r.Uniform, r.NoCatchUp, r.RunID, loggerInfo, r.ID,
+		r.Exactly, r.Jitter, r.Uniform, r.NoCatchUp, r.RunID, loggerInfo, r.ID, slowest.Sorted(), clientStats,
+		throttled.time, throttled.count, effectiveQPS,
 	}
 	if log.Log(log.Warning) {
 		result.DurationHistogram.Print(r.Out, "Aggregated Function Time")
 		result.ErrorsDurationHistogram.Print(r.Out, "Error cases")
+		PrintExemplars(r.Out, result.Exemplars)
 	} else {
 		functionDuration.Counter.Print(r.Out, "Aggregated Function Time")
 		for _, p := range result.DurationHistogram.Percentiles {
@@ -595,6 +651,9 @@ func (r *periodicRunner) Run() RunnerResults {
 		}
 		errorsDuration.Counter.Print(r.Out, "Error cases")
 	}
+	if clientStats.Saturated || log.Log(log.Verbose) {
+		clientStats.Print(r.Out)
+	}
 	select {
 	case <-runnerChan: // nothing
 		log.LogVf("RUNNER aborter already closed")
@@ -700,11 +759,12 @@ func (a *fileAccessLogger) Info() string {
 }
 
 // runOne runs in 1 go routine (or main one when -c 1 == single threaded mode).
+// Returns the time it was paused by the Throttler, if any.
 //
-//nolint:gocognit, gocyclo // we should try to simplify it though.
-func runOne(id int, runnerChan chan struct{}, funcTimes, errTimes, sleepTimes *stats.Histogram,
+//nolint:gocognit, gocyclo, funlen // we should try to simplify it though.
+func runOne(id int, runnerChan chan struct{}, funcTimes, errTimes, sleepTimes *stats.Histogram, slowest *Exemplars,
 	numCalls int64, start time.Time, r *periodicRunner,
-) {
+) (throttled throttling) {
 	var i int64
 	endTime := start.Add(r.Duration)
 	tIDStr := fmt.Sprintf("T%03d", id)
@@ -714,6 +774,8 @@ func runOne(id int, runnerChan chan struct{}, funcTimes, errTimes, sleepTimes *s
 	hasDuration := (r.Duration > 0)
 	useExactly := (r.Exactly > 0)
 	f := r.Runners[id]
+	waiter, _ := f.(Waiter)
+	throttler, _ := f.(Throttler)
 	if useQPS && r.Uniform {
 		delayBetweenRequest := 1. / perThreadQPS
 		// When using uniform mode, we should wait a bit relative to our QPS and thread ID.
@@ -724,7 +786,7 @@ func runOne(id int, runnerChan chan struct{}, funcTimes, errTimes, sleepTimes *s
 		log.Debugf("%s sleep %v for uniform distribution", tIDStr, delayDuration)
 		select {
 		case <-runnerChan:
-			return
+			return throttled
 		case <-time.After(delayDuration):
 			// continue normal execution
 		}
@@ -732,6 +794,27 @@ func runOne(id int, runnerChan chan struct{}, funcTimes, errTimes, sleepTimes *s
 
 MainLoop:
 	for {
+		if waiter != nil && !waiter.Wait(id, runnerChan) {
+			break
+		}
+		if throttler != nil {
+			d := throttler.Throttle(id)
+			// the run length includes the throttled time: endTime doesn't move with the pauses
+			if remaining := time.Until(endTime); !useExactly && hasDuration && d > remaining {
+				d = remaining
+			}
+			if d > 0 {
+				log.LogVf("%s throttled for %v", tIDStr, d)
+				throttled.count++
+				throttled.time += d
+				start = start.Add(d) // resume at the same qps instead of catching up
+				select {
+				case <-runnerChan:
+					break MainLoop
+				case <-time.After(d):
+				}
+			}
+		}
 		fStart := time.Now()
 		if !useExactly && (hasDuration && fStart.After(endTime)) {
 			if !useQPS {
@@ -741,7 +824,11 @@ MainLoop:
 			// QPS mode:
 			// Do least 2 iterations, and the last one before bailing because of time
 			if (i >= 2) && (i != numCalls-1) {
-				log.Warnf("%s warning only did %d out of %d calls before reaching %v", tIDStr, i, numCalls, r.Duration)
+				if throttled.count > 0 {
+					log.Infof("%s only did %d out of %d calls before reaching %v, throttled for %v", tIDStr, i, numCalls, r.Duration, throttled.time)
+				} else {
+					log.Warnf("%s warning only did %d out of %d calls before reaching %v", tIDStr, i, numCalls, r.Duration)
+				}
 				break
 			}
 		}
@@ -754,6 +841,9 @@ MainLoop:
 		if !status {
 			errTimes.Record(latency)
 		}
+		if slowest.Keeps(latency) {
+			slowest.Record(Exemplar{StartTime: fStart, Thread: id, Latency: latency, Status: status, Details: details})
+		}
 		// if using QPS / pre calc expected call # mode:
 		if useQPS { //nolint:nestif
 			for {
@@ -815,6 +905,7 @@ MainLoop:
 			sleepTimes.Counter.Log(tIDStr + " Sleep time")
 		}
 	}
+	return throttled
 }
 
 func formatDate(d *time.Time) string {
@@ -16,6 +16,8 @@ package periodic
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"math"
 	"os"
 	"path"
@@ -230,6 +232,130 @@ func TestExactlyMaxQps(t *testing.T) {
 	r.Options().ReleaseRunners()
 }
 
+// latencyRunner sleeps increasingly longer on thread 1.
+type latencyRunner struct {
+	calls [2]int
+}
+
+func (l *latencyRunner) Run(t int) (bool, string) {
+	l.calls[t]++
+	if t == 1 {
+		time.Sleep(time.Duration(l.calls[t]) * 10 * time.Millisecond)
+	}
+	return t == 0, fmt.Sprintf("call %d", l.calls[t])
+}
+
+func TestExemplars(t *testing.T) {
+	e := NewExemplars(3)
+	for _, l := range []float64{0.5, 0.1, 0.9, 0.2, 0.7, 0.3} {
+		e.Record(Exemplar{Latency: l})
+	}
+	other := NewExemplars(2)
+	other.Record(Exemplar{Latency: 0.8, Thread: 1})
+	other.Record(Exemplar{Latency: 0.05, Thread: 1})
+	e.Transfer(other)
+	sorted := e.Sorted()
+	if len(sorted) != 3 {
+		t.Fatalf("Expected 3 exemplars, got %+v", sorted)
+	}
+	for i, expected := range []float64{0.9, 0.8, 0.7} {
+		if sorted[i].Latency != expected {
+			t.Errorf("Exemplar %d has latency %g instead of %g", i, sorted[i].Latency, expected)
+		}
+	}
+	if other.Sorted() != nil {
+		t.Errorf("Transfer should have reset the source: %+v", other.Sorted())
+	}
+	var none *Exemplars
+	none.Record(Exemplar{Latency: 1})
+	if NewExemplars(0) != nil || none.Sorted() != nil || none.Keeps(1) {
+		t.Errorf("nil Exemplars should record nothing")
+	}
+}
+
+func TestRunExemplars(t *testing.T) {
+	o := RunnerOptions{
+		QPS:        -1, // max qps
+		NumThreads: 2,
+		Exactly:    10,
+		Exemplars:  2,
+	}
+	r := NewPeriodicRunner(&o)
+	r.Options().MakeRunners(&latencyRunner{})
+	res := r.Run()
+	r.Options().ReleaseRunners()
+	if len(res.Exemplars) != 2 {
+		t.Fatalf("Expected 2 exemplars, got %+v", res.Exemplars)
+	}
+	x := res.Exemplars[0]
+	if x.Thread != 1 || x.Status || x.Details != "call 5" || x.Latency < 0.05 {
+		t.Errorf("Slowest call should be the last one of thread 1, got %+v", x)
+	}
+	if res.Exemplars[1].Details != "call 4" {
+		t.Errorf("2nd slowest call should be the 4th one of thread 1, got %+v", res.Exemplars[1])
+	}
+}
+
+// throttleRunner asks for a pause of thread 1 after its second call, which fails.
+type throttleRunner struct {
+	calls [2]int
+	pause time.Duration
+}
+
+func (l *throttleRunner) Run(t int) (bool, string) {
+	l.calls[t]++
+	return t == 0 || l.calls[t] != 2, ""
+}
+
+func (l *throttleRunner) Throttle(t int) time.Duration {
+	if t == 1 && l.calls[t] == 2 {
+		return l.pause
+	}
+	return 0
+}
+
+func TestRunThrottle(t *testing.T) {
+	o := RunnerOptions{
+		QPS:        100, // 20ms between the calls of each thread
+		NumThreads: 2,
+		Exactly:    10,
+	}
+	r := NewPeriodicRunner(&o)
+	r.Options().MakeRunners(&throttleRunner{pause: 100 * time.Millisecond})
+	res := r.Run()
+	r.Options().ReleaseRunners()
+	if res.Throttles != 1 || res.ThrottledTime != 100*time.Millisecond {
+		t.Errorf("Expected 1 throttling of 100ms, got %d %v", res.Throttles, res.ThrottledTime)
+	}
+	// 3 more calls 20ms apart after the pause, not catching up
+	if res.ActualDuration < 170*time.Millisecond {
+		t.Errorf("Expected the calls to resume at the requested qps after the pause, run took %v", res.ActualDuration)
+	}
+	expected := 9. / res.ActualDuration.Seconds()
+	if math.Abs(res.EffectiveQPS-expected) > 0.1 {
+		t.Errorf("Expected effective qps of the 9 successful calls %g, got %g", expected, res.EffectiveQPS)
+	}
+	// with a duration, the pauses are part of it, cut short at the end of the run:
+	o = RunnerOptions{QPS: 100, NumThreads: 2, Duration: 200 * time.Millisecond}
+	r = NewPeriodicRunner(&o)
+	r.Options().MakeRunners(&throttleRunner{pause: time.Second})
+	res = r.Run()
+	r.Options().ReleaseRunners()
+	if res.Throttles != 1 || res.ThrottledTime >= 200*time.Millisecond || res.ActualDuration >= 400*time.Millisecond {
+		t.Errorf("Expected the throttling to end with the run, got %v throttled, run took %v", res.ThrottledTime, res.ActualDuration)
+	}
+	if res.DurationHistogram.Count >= 20 {
+		t.Errorf("Expected the throttled thread to make fewer calls, got %d", res.DurationHistogram.Count)
+	}
+	o = RunnerOptions{QPS: -1, NumThreads: 2, Exactly: 10}
+	r = NewPeriodicRunner(&o)
+	r.Options().MakeRunners(&Noop{})
+	if res = r.Run(); res.DurationHistogram.Count != 10 || res.Throttles != 0 || res.EffectiveQPS != 0 {
+		t.Errorf("Expected no throttling, got %+v", res)
+	}
+	r.Options().ReleaseRunners()
+}
+
 type testAccessLogger struct {
 	sync.Mutex
 	reports int64
@@ -630,3 +756,42 @@ func TestWAbortWait(t *testing.T) {
 		t.Errorf("mismatch between result object and internal count %d %d", count, res.DurationHistogram.Count)
 	}
 }
+
+func TestClientStats(t *testing.T) {
+	var count int64
+	var lock sync.Mutex
+	c := TestCount{&count, &lock}
+	o := RunnerOptions{
+		QPS:        20,
+		NumThreads: 3,
+		Duration:   1200 * time.Millisecond,
+	}
+	r := NewPeriodicRunner(&o)
+	r.Options().MakeRunners(&c)
+	res := r.Run()
+	cs := res.ClientStats
+	if cs == nil {
+		t.Fatalf("Expected client stats in results %+v", res)
+	}
+	if cs.Cores < 1 || cs.MaxGoroutines < 3 || cs.SchedLatencyMax < cs.SchedLatencyAvg {
+		t.Errorf("Unexpected client stats %+v", cs)
+	}
+	if cs.Saturated || cs.CPUPercent > SaturationCPUPercent {
+		t.Errorf("Mostly idle 20 qps run shouldn't be saturated %+v", cs)
+	}
+	// Thresholds checks
+	cs = &ClientStats{Cores: 2, CPUPercent: 190, GCPauseTotal: 100 * time.Millisecond, NumGC: 7, SchedLatencyAvg: 10 * time.Millisecond}
+	cs.check(500 * time.Millisecond)
+	if cs.Saturated {
+		t.Errorf("Short runs shouldn't be flagged %+v", cs)
+	}
+	cs.check(time.Second)
+	if !cs.Saturated || len(cs.Warnings) != 3 {
+		t.Errorf("Expected 3 saturation warnings, got %+v", cs)
+	}
+	var buf bytes.Buffer
+	cs.Print(&buf)
+	if !strings.Contains(buf.String(), "GC pauses took 10.0% of the run (7 GCs)") {
+		t.Errorf("Unexpected client stats output %q", buf.String())
+	}
+}
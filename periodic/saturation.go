@@ -0,0 +1,174 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package periodic // import "fortio.org/fortio/periodic"
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// Thresholds above which the load generator is considered the bottleneck.
+const (
+	// SaturationCPUPercent is the percentage of the available cores used by fortio.
+	SaturationCPUPercent = 90.
+	// SaturationGCPercent is the percentage of the run spent in GC pauses.
+	SaturationGCPercent = 5.
+	// SaturationSchedLatency is the average delay of the sampler's wake ups.
+	SaturationSchedLatency = 5 * time.Millisecond
+	// minSaturationDuration is the minimum run duration for the verdict to be meaningful.
+	minSaturationDuration = time.Second
+	// clientSampleInterval is how often the client stats are sampled.
+	clientSampleInterval = 100 * time.Millisecond
+)
+
+// ClientStats are the load generator's (fortio's own process) resource usage
+// during a run, to detect when the client rather than the target was the limit.
+type ClientStats struct {
+	// Cores is the number of cores fortio can use (min of GOMAXPROCS and NumCPU).
+	Cores int
+	// CPUPercent is the average process cpu usage over the run, 100% is one full core.
+	// Both cpu values are 0 on platforms where the process cpu time isn't available.
+	CPUPercent float64
+	// MaxCPUPercent is the highest cpu usage over one sampling interval.
+	MaxCPUPercent float64
+	// NumGC is the number of garbage collections during the run.
+	NumGC uint32
+	// GCPauseTotal is the total stop the world GC pause time during the run.
+	GCPauseTotal time.Duration
+	// MaxGoroutines is the highest goroutine count seen.
+	MaxGoroutines int
+	// SchedLatencyAvg and SchedLatencyMax are how late the sampler's timer
+	// wake ups were, a proxy for the go scheduler latency.
+	SchedLatencyAvg time.Duration
+	SchedLatencyMax time.Duration
+	// Saturated is true when at least one of the thresholds was crossed,
+	// Warnings then lists which.
+	Saturated bool
+	Warnings  []string `json:",omitempty"`
+}
+
+// clientSampler periodically samples the process stats in its own goroutine
+// from start() to stop().
+type clientSampler struct {
+	start      time.Time
+	startCPU   time.Duration
+	hasCPU     bool
+	startStats runtime.MemStats
+	done       chan struct{}
+	result     chan ClientStats
+}
+
+func newClientSampler() *clientSampler {
+	s := &clientSampler{done: make(chan struct{}), result: make(chan ClientStats, 1)}
+	runtime.ReadMemStats(&s.startStats)
+	s.start = time.Now()
+	s.startCPU, s.hasCPU = processCPUTime()
+	go s.run()
+	return s
+}
+
+func (s *clientSampler) run() {
+	cs := ClientStats{MaxGoroutines: runtime.NumGoroutine()}
+	var totalLate time.Duration
+	numSamples := 0
+	prev, prevCPU := s.start, s.startCPU
+	timer := time.NewTimer(clientSampleInterval)
+	expected := s.start.Add(clientSampleInterval)
+	for {
+		select {
+		case <-s.done:
+			timer.Stop()
+			if numSamples > 0 {
+				cs.SchedLatencyAvg = totalLate / time.Duration(numSamples)
+			}
+			s.result <- cs
+			return
+		case now := <-timer.C:
+			late := time.Since(expected)
+			if late < 0 {
+				late = 0
+			}
+			totalLate += late
+			numSamples++
+			if late > cs.SchedLatencyMax {
+				cs.SchedLatencyMax = late
+			}
+			if g := runtime.NumGoroutine(); g > cs.MaxGoroutines {
+				cs.MaxGoroutines = g
+			}
+			if cpu, ok := processCPUTime(); ok && s.hasCPU {
+				if pct := 100. * float64(cpu-prevCPU) / float64(now.Sub(prev)); pct > cs.MaxCPUPercent {
+					cs.MaxCPUPercent = pct
+				}
+				prevCPU = cpu
+			}
+			prev = now
+			expected = time.Now().Add(clientSampleInterval)
+			timer.Reset(clientSampleInterval)
+		}
+	}
+}
+
+// stop ends the sampling and returns the run's ClientStats.
+func (s *clientSampler) stop() *ClientStats {
+	close(s.done)
+	cs := <-s.result
+	elapsed := time.Since(s.start)
+	if cpu, ok := processCPUTime(); ok && s.hasCPU {
+		cs.CPUPercent = 100. * float64(cpu-s.startCPU) / float64(elapsed)
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	cs.NumGC = ms.NumGC - s.startStats.NumGC
+	cs.GCPauseTotal = time.Duration(ms.PauseTotalNs - s.startStats.PauseTotalNs)
+	cs.Cores = runtime.GOMAXPROCS(0)
+	if n := runtime.NumCPU(); n < cs.Cores {
+		cs.Cores = n
+	}
+	cs.check(elapsed)
+	return &cs
+}
+
+// check sets Saturated and the Warnings, runs shorter than minSaturationDuration
+// are too noisy and never flagged.
+func (cs *ClientStats) check(elapsed time.Duration) {
+	if elapsed < minSaturationDuration {
+		return
+	}
+	if cs.CPUPercent >= SaturationCPUPercent*float64(cs.Cores) {
+		cs.Warnings = append(cs.Warnings, fmt.Sprintf("fortio used %.0f%% cpu out of %d core(s)", cs.CPUPercent, cs.Cores))
+	}
+	if gcPct := 100. * cs.GCPauseTotal.Seconds() / elapsed.Seconds(); gcPct >= SaturationGCPercent {
+		cs.Warnings = append(cs.Warnings, fmt.Sprintf("GC pauses took %.1f%% of the run (%d GCs)", gcPct, cs.NumGC))
+	}
+	if cs.SchedLatencyAvg >= SaturationSchedLatency {
+		cs.Warnings = append(cs.Warnings, fmt.Sprintf("scheduler latency avg %v (max %v)", cs.SchedLatencyAvg, cs.SchedLatencyMax))
+	}
+	cs.Saturated = len(cs.Warnings) > 0
+}
+
+// Print writes the client stats line and, when saturated, the warnings.
+func (cs *ClientStats) Print(out io.Writer) {
+	if cs == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(out, "Client stats: cpu %.1f%% (max %.1f%%) of %d core(s), %d GCs pausing %v, max %d goroutines, sched latency avg %v max %v\n",
+		cs.CPUPercent, cs.MaxCPUPercent, cs.Cores, cs.NumGC, cs.GCPauseTotal, cs.MaxGoroutines, cs.SchedLatencyAvg, cs.SchedLatencyMax)
+	for _, w := range cs.Warnings {
+		_, _ = fmt.Fprintf(out, "WARNING load generator saturated, results reflect fortio's limits more than the target's: %s\n", w)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"sync"
 	"time"
 
+	"fortio.org/fortio/export"
 	"fortio.org/fortio/fhttp"
 	"fortio.org/fortio/log"
 )
@@ -171,6 +172,10 @@ func LogAndFilterDataRequest(h http.Handler) http.Handler {
 			SendTSVDataIndex(urlPrefix, w)
 			return
 		}
+		if e := exportFor(r); e != nil {
+			sendExport(w, path, e)
+			return
+		}
 		if !strings.HasSuffix(path, ".json") {
 			log.Warnf("Filtering request for non .json '%s'", path)
 			w.WriteHeader(http.StatusNotFound)
@@ -181,6 +186,42 @@ func LogAndFilterDataRequest(h http.Handler) http.Handler {
 	})
 }
 
+// exportFor returns the exporter requested either through the ?format= query
+// argument or through the extension of the requested file, or nil for the
+// regular .json data file.
+func exportFor(r *http.Request) export.Exporter {
+	if format := r.FormValue("format"); format != "" && format != "json" {
+		e := export.Get(format)
+		if e == nil {
+			log.Warnf("Unknown export format %q requested, will serve json", format)
+		}
+		return e
+	}
+	return export.ForPath(r.URL.Path)
+}
+
+// sendExport serves the .json results file matching the requested path in the exporter's format.
+func sendExport(w http.ResponseWriter, reqPath string, e export.Exporter) {
+	name := path.Base(export.StripExtension(e, strings.TrimSuffix(reqPath, ".json")))
+	data, err := os.ReadFile(path.Join(dataDir, name+".json"))
+	if err != nil {
+		log.Errf("Unable to read %s.json for %s export: %v", name, e.Name(), err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	res, err := export.FromJSON(data)
+	if err != nil {
+		log.Errf("Unable to parse %s.json for %s export: %v", name, e.Name(), err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", e.ContentType())
+	fhttp.CacheOn(w)
+	if err = e.Export(w, res); err != nil {
+		log.Errf("Error exporting %s as %s: %v", name, e.Name(), err)
+	}
+}
+
 func AddDataHandler(mux *http.ServeMux, baseurl, uipath, datadir string) {
 	gTSVCacheMutex.Lock()
 	gTSVCache.cachedResult = []byte{}
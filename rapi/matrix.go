@@ -0,0 +1,164 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rapi // import "fortio.org/fortio/rapi"
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fortio.org/fortio/fgrpc"
+	"fortio.org/fortio/fhttp"
+	"fortio.org/fortio/jrpc"
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/matrix"
+	"fortio.org/fortio/periodic"
+	"fortio.org/fortio/tcprunner"
+	"fortio.org/fortio/udprunner"
+	"fortio.org/fortio/wsrunner"
+)
+
+// RestMatrixURI is the REST api path, relative to the ui path, to run a matrix of load tests.
+const RestMatrixURI = "rest/matrix"
+
+// MatrixReply is returned by the matrix api.
+type MatrixReply struct {
+	jrpc.ServerReply
+	matrix.Results
+	// ChartURL is the url of the chart of all the runs (only when save=on).
+	ChartURL string `json:",omitempty"`
+}
+
+// RESTMatrixHandler runs a load test for each combination of the comma separated
+// matrix-qps, matrix-c and matrix-sizes values, with the other parameters
+// being the same as for the run api. Each run shows in the status api and can be
+// stopped, which also stops the rest of the matrix.
+func RESTMatrixHandler(w http.ResponseWriter, r *http.Request) {
+	fhttp.LogRequest(r, "REST Matrix Api call")
+	w.Header().Set("Content-Type", "application/json")
+	jd, runner, url, ro, httpopts, ok := restOptions(w, r)
+	if !ok {
+		return
+	}
+	if strings.HasPrefix(url, tcprunner.TCPURLPrefix) || strings.HasPrefix(url, udprunner.UDPURLPrefix) ||
+		wsrunner.IsWebSocketURL(url) {
+		Error(w, "matrix only supports http and grpc", nil)
+		return
+	}
+	mo, err := matrixOptions(r, jd, runner, url, ro, httpopts)
+	if err != nil {
+		Error(w, "invalid matrix parameters", err)
+		return
+	}
+	if FormValue(r, jd, "async") == "on" {
+		count := len(mo.QPS) * len(mo.Connections)
+		if len(mo.PayloadSizes) > 0 {
+			count *= len(mo.PayloadSizes)
+		}
+		reply := AsyncReply{Count: count}
+		reply.Message = "started"
+		if err := jrpc.ReplyOk(w, &reply); err != nil {
+			log.Errf("Error replying to matrix start: %v", err)
+		}
+		go func() {
+			_, _ = matrix.Run(mo)
+		}()
+		return
+	}
+	res, err := matrix.Run(mo)
+	if err != nil {
+		Error(w, "matrix run error", err)
+		return
+	}
+	reply := MatrixReply{Results: *res}
+	if u := res.BrowseURL(); u != "" {
+		reply.ChartURL = strings.TrimSuffix(GetDataURL(r), "data/") + u
+	}
+	if err := jrpc.ReplyOk(w, &reply); err != nil {
+		log.Errf("Error replying to matrix: %v", err)
+	}
+}
+
+// matrixOptions builds the matrix options from the request and the already
+// parsed common run options. Each run gets registered for the status and stop apis.
+func matrixOptions(r *http.Request, jd map[string]interface{}, runner, url string,
+	ro *periodic.RunnerOptions, httpopts *fhttp.HTTPOptions,
+) (*matrix.Options, error) {
+	qpsList, err := matrix.ParseFloats(FormValue(r, jd, "matrix-qps"))
+	if err != nil {
+		return nil, fmt.Errorf("matrix-qps: %w", err)
+	}
+	if len(qpsList) == 0 {
+		qpsList = []float64{ro.QPS}
+		if ro.QPS == 0 {
+			qpsList[0] = periodic.DefaultRunnerOptions.QPS // 0 means max in matrix mode
+		}
+	}
+	cList, err := matrix.ParseInts(FormValue(r, jd, "matrix-c"))
+	if err != nil {
+		return nil, fmt.Errorf("matrix-c: %w", err)
+	}
+	if len(cList) == 0 {
+		cList = []int{ro.NumThreads}
+	}
+	sizes, err := matrix.ParseInts(FormValue(r, jd, "matrix-sizes"))
+	if err != nil {
+		return nil, fmt.Errorf("matrix-sizes: %w", err)
+	}
+	mo := &matrix.Options{
+		HTTP: fhttp.HTTPRunnerOptions{
+			HTTPOptions:        *httpopts,
+			RunnerOptions:      *ro,
+			AllowInitialErrors: true,
+		},
+		QPS:          qpsList,
+		Connections:  cList,
+		PayloadSizes: sizes,
+	}
+	mo.HTTP.Streams, _ = strconv.Atoi(FormValue(r, jd, "s"))
+	if runner == ModeGRPC {
+		mo.GRPC = &fgrpc.GRPCRunnerOptions{
+			RunnerOptions: *ro,
+			Destination:   url,
+			UsePing:       FormValue(r, jd, "ping") == "on",
+		}
+		mo.GRPC.Delay, _ = time.ParseDuration(FormValue(r, jd, "grpc-ping-delay"))
+		mo.GRPC.TLSOptions = httpopts.TLSOptions
+		mo.GRPC.ReqTimeout = httpopts.HTTPReqTimeOut
+		if FormValue(r, jd, "grpc-secure") == "on" {
+			mo.GRPC.Destination = fhttp.AddHTTPS(url)
+		}
+	}
+	if FormValue(r, jd, "save") == "on" {
+		mo.Save = SaveJSON
+	}
+	var aborter *periodic.Aborter
+	mo.Starting = func(ro *periodic.RunnerOptions) {
+		ro.RunID = NextRunID()
+		aborter = UpdateRun(ro)
+	}
+	mo.Done = func(ro *periodic.RunnerOptions) bool {
+		uiRunMapMutex.Lock()
+		status, found := runs[ro.RunID]
+		stopped := found && status.State == StateStopping
+		uiRunMapMutex.Unlock()
+		RemoveRun(ro.RunID)
+		aborter.StartChan <- false
+		return stopped
+	}
+	return mo, nil
+}
@@ -36,6 +36,7 @@ import (
 	"fortio.org/fortio/stats"
 	"fortio.org/fortio/tcprunner"
 	"fortio.org/fortio/udprunner"
+	"fortio.org/fortio/wsrunner"
 )
 
 const (
@@ -181,9 +182,41 @@ func FormValue(r *http.Request, json map[string]interface{}, key string) string
 }
 
 // RESTRunHandler is api version of UI submit handler.
-func RESTRunHandler(w http.ResponseWriter, r *http.Request) { //nolint:funlen
+func RESTRunHandler(w http.ResponseWriter, r *http.Request) {
 	fhttp.LogRequest(r, "REST Run Api call")
 	w.Header().Set("Content-Type", "application/json")
+	jd, runner, url, ro, httpopts, ok := restOptions(w, r)
+	if !ok {
+		return
+	}
+	async := (FormValue(r, jd, "async") == "on")
+	runid := NextRunID()
+	ro.RunID = runid
+	log.Infof("New run id %d", runid)
+	if async {
+		ro.GenID() // Needed to reply the id, will be reused in Normalize() later as already set
+		reply := AsyncReply{RunID: runid, Count: 1, ResultID: ro.ID, ResultURL: ID2URL(r, ro.ID)}
+		reply.Message = "started" //nolint:goconst
+		err := jrpc.ReplyOk(w, &reply)
+		if err != nil {
+			log.Errf("Error replying to start: %v", err)
+		}
+		//nolint:errcheck,contextcheck // all cases handled inside for rapi callers. async code with our own aborter
+		// returned values are for the ui/uihandler.go caller.
+		go Run(nil, r, jd, runner, url, ro, httpopts, false)
+		return
+	}
+	//nolint:errcheck,contextcheck // all cases handled inside for rapi callers. aborter handles context.
+	Run(w, r, jd, runner, url, ro, httpopts, false)
+}
+
+// restOptions parses the parameters common to the run and matrix REST calls. It replies
+// with an error and returns false for ok when they aren't valid.
+//
+//nolint:funlen
+func restOptions(w http.ResponseWriter, r *http.Request) (jd map[string]interface{}, runner, url string,
+	ro *periodic.RunnerOptions, httpopts *fhttp.HTTPOptions, ok bool,
+) {
 	data, err := io.ReadAll(r.Body) // must be done before calling FormValue
 	if err != nil {
 		log.Errf("Error reading %v", err)
@@ -192,7 +225,6 @@ func RESTRunHandler(w http.ResponseWriter, r *http.Request) { //nolint:funlen
 	}
 	log.Infof("REST body: %s", fhttp.DebugSummary(data, 250))
 	jsonPath := r.FormValue("jsonPath")
-	var jd map[string]interface{}
 	if len(data) > 0 {
 		// Json input and deserialize options from that path, eg. for flagger:
 		// jsonPath=.metadata
@@ -204,13 +236,12 @@ func RESTRunHandler(w http.ResponseWriter, r *http.Request) { //nolint:funlen
 		}
 		log.Infof("Body: %+v", jd)
 	}
-	url := FormValue(r, jd, "url")
-	runner := FormValue(r, jd, "runner")
+	url = FormValue(r, jd, "url")
+	runner = FormValue(r, jd, "runner")
 	if runner == "" {
 		runner = "http"
 	}
 	log.Infof("Starting API run %s load request from %v for %s", runner, r.RemoteAddr, url)
-	async := (FormValue(r, jd, "async") == "on")
 	payload := FormValue(r, jd, "payload")
 	labels := FormValue(r, jd, "labels")
 	resolution, _ := strconv.ParseFloat(FormValue(r, jd, "r"), 64)
@@ -220,6 +251,7 @@ func RESTRunHandler(w http.ResponseWriter, r *http.Request) { //nolint:funlen
 	jitter := (FormValue(r, jd, "jitter") == "on")
 	uniform := (FormValue(r, jd, "uniform") == "on")
 	nocatchup := (FormValue(r, jd, "nocatchup") == "on")
+	slowest, _ := strconv.Atoi(FormValue(r, jd, "slowest"))
 	stdClient := (FormValue(r, jd, "stdclient") == "on")
 	sequentialWarmup := (FormValue(r, jd, "sequential-warmup") == "on")
 	httpsInsecure := (FormValue(r, jd, "https-insecure") == "on")
@@ -247,7 +279,7 @@ func RESTRunHandler(w http.ResponseWriter, r *http.Request) { //nolint:funlen
 		Error(w, "URL is required", nil)
 		return
 	}
-	ro := periodic.RunnerOptions{
+	ro = &periodic.RunnerOptions{
 		QPS:         qps,
 		Duration:    dur,
 		Out:         out,
@@ -259,18 +291,35 @@ func RESTRunHandler(w http.ResponseWriter, r *http.Request) { //nolint:funlen
 		Jitter:      jitter,
 		Uniform:     uniform,
 		NoCatchUp:   nocatchup,
+		Exemplars:   slowest,
 	}
-	runid := NextRunID()
-	ro.RunID = runid
-	log.Infof("New run id %d", runid)
-	httpopts := &fhttp.HTTPOptions{}
+	httpopts = &fhttp.HTTPOptions{}
 	httpopts.HTTPReqTimeOut = timeout // to be normalized in init 0 replaced by default value
 	httpopts = httpopts.Init(url)
 	httpopts.ResetHeaders()
 	httpopts.DisableFastClient = stdClient
+	httpopts.H2 = (FormValue(r, jd, "h2") == "on")
+	httpopts.H3 = (FormValue(r, jd, "h3") == "on")
+	httpopts.CookieJar = (FormValue(r, jd, "cookies") == "on")
+	httpopts.StreamEvents = (FormValue(r, jd, "stream-events") == "on")
+	httpopts.StreamBody = (FormValue(r, jd, "stream-body") == "on") || httpopts.StreamEvents
+	httpopts.StreamKeep, _ = strconv.Atoi(FormValue(r, jd, "stream-keep"))
 	httpopts.SequentialWarmup = sequentialWarmup
 	httpopts.Insecure = httpsInsecure
+	httpopts.MinTLSVersion = FormValue(r, jd, "tls-min-version")
+	httpopts.MaxTLSVersion = FormValue(r, jd, "tls-max-version")
+	httpopts.SessionResumption = (FormValue(r, jd, "tls-resumption") == "on")
+	httpopts.Proxy = FormValue(r, jd, "proxy")
+	httpopts.SourceIP = FormValue(r, jd, "source-ip")
+	httpopts.ProxyProtocol = FormValue(r, jd, "proxy-protocol")
+	httpopts.ProxyProtocolSource = FormValue(r, jd, "proxy-protocol-source")
 	httpopts.Resolve = resolve
+	if okCodes := FormValue(r, jd, "ok-codes"); okCodes != "" {
+		if httpopts.OKCodes, err = fhttp.ParseCodeSet(okCodes, nil); err != nil {
+			Error(w, "parsing ok-codes", err)
+			return
+		}
+	}
 	// Set the connection reuse range.
 	err = bincommon.ConnectionReuseRange.
 		WithValidator(bincommon.ConnectionReuseRangeValidator(httpopts)).
@@ -313,21 +362,8 @@ func RESTRunHandler(w http.ResponseWriter, r *http.Request) { //nolint:funlen
 		}
 	}
 	fhttp.OnBehalfOf(httpopts, r)
-	if async {
-		ro.GenID() // Needed to reply the id, will be reused in Normalize() later as already set
-		reply := AsyncReply{RunID: runid, Count: 1, ResultID: ro.ID, ResultURL: ID2URL(r, ro.ID)}
-		reply.Message = "started" //nolint:goconst
-		err := jrpc.ReplyOk(w, &reply)
-		if err != nil {
-			log.Errf("Error replying to start: %v", err)
-		}
-		//nolint:errcheck,contextcheck // all cases handled inside for rapi callers. async code with our own aborter
-		// returned values are for the ui/uihandler.go caller.
-		go Run(nil, r, jd, runner, url, &ro, httpopts, false)
-		return
-	}
-	//nolint:errcheck,contextcheck // all cases handled inside for rapi callers. aborter handles context.
-	Run(w, r, jd, runner, url, &ro, httpopts, false)
+	ok = true
+	return
 }
 
 // Run executes the run (can be called async or not, writer is nil for async mode).
@@ -349,13 +385,39 @@ func Run(w http.ResponseWriter, r *http.Request, jd map[string]interface{},
 			UsePing:       grpcPing,
 			Delay:         grpcPingDelay,
 		}
+		if okCodes := FormValue(r, jd, "grpc-ok-codes"); okCodes != "" {
+			if o.OKCodes, err = fgrpc.ParseCodes(okCodes); err != nil {
+				RemoveRun(ro.RunID) // not started
+				if !htmlMode {
+					Error(w, "parsing grpc-ok-codes", err)
+				}
+				return nil, "", nil, err
+			}
+		}
+		o.Retry, err = retryPolicy(r, jd, true)
 		o.TLSOptions = httpopts.TLSOptions
+		o.ReqTimeout = httpopts.HTTPReqTimeOut
 		if grpcSecure {
 			o.Destination = fhttp.AddHTTPS(url)
 		}
 		aborter = UpdateRun(&o.RunnerOptions)
 		// TODO: ReqTimeout: timeout
-		res, err = fgrpc.RunGRPCTest(&o)
+		if err == nil {
+			res, err = fgrpc.RunGRPCTest(&o)
+		}
+	} else if wsrunner.IsWebSocketURL(url) {
+		// TODO: copy pasta from fortio_main
+		o := wsrunner.RunnerOptions{
+			RunnerOptions: *ro,
+		}
+		o.TLSOptions = httpopts.TLSOptions
+		o.ReqTimeout = httpopts.HTTPReqTimeOut
+		o.Destination = url
+		o.Payload = httpopts.Payload
+		o.Headers = httpopts.AllHeaders()
+		o.SourceIP = httpopts.SourceIP
+		aborter = UpdateRun(&o.RunnerOptions)
+		res, err = wsrunner.RunWSTest(&o)
 	} else if strings.HasPrefix(url, tcprunner.TCPURLPrefix) {
 		// TODO: copy pasta from fortio_main
 		o := tcprunner.RunnerOptions{
@@ -364,6 +426,10 @@ func Run(w http.ResponseWriter, r *http.Request, jd map[string]interface{},
 		o.ReqTimeout = httpopts.HTTPReqTimeOut
 		o.Destination = url
 		o.Payload = httpopts.Payload
+		o.Proxy = httpopts.Proxy
+		o.SourceIP = httpopts.SourceIP
+		o.ProxyProtocol = httpopts.ProxyProtocol
+		o.ProxyProtocolSource = httpopts.ProxyProtocolSource
 		aborter = UpdateRun(&o.RunnerOptions)
 		res, err = tcprunner.RunTCPTest(&o)
 	} else if strings.HasPrefix(url, udprunner.UDPURLPrefix) {
@@ -374,6 +440,7 @@ func Run(w http.ResponseWriter, r *http.Request, jd map[string]interface{},
 		o.ReqTimeout = httpopts.HTTPReqTimeOut
 		o.Destination = url
 		o.Payload = httpopts.Payload
+		o.SourceIP = httpopts.SourceIP
 		aborter = UpdateRun(&o.RunnerOptions)
 		res, err = udprunner.RunUDPTest(&o)
 	} else {
@@ -382,8 +449,16 @@ func Run(w http.ResponseWriter, r *http.Request, jd map[string]interface{},
 			RunnerOptions:      *ro,
 			AllowInitialErrors: true,
 		}
+		o.Streams, _ = strconv.Atoi(FormValue(r, jd, "s"))
+		o.Pipeline, _ = strconv.Atoi(FormValue(r, jd, "pipeline"))
+		o.Checks = responseChecks(r, jd)
+		o.Retry, err = retryPolicy(r, jd, false)
+		o.HonorRetryAfter = (FormValue(r, jd, "honor-retry-after") == "on")
+		o.ThrottleBackoff, _ = time.ParseDuration(FormValue(r, jd, "throttle-backoff"))
 		aborter = UpdateRun(&(o.RunnerOptions))
-		res, err = fhttp.RunHTTPTest(&o)
+		if err == nil {
+			res, err = fhttp.RunHTTPTest(&o)
+		}
 	}
 	defer RemoveRun(ro.RunID)
 	defer func() {
@@ -522,7 +597,7 @@ func RemoveRun(id int64) {
 	log.LogVf("REST Removed run %d", id)
 }
 
-// AddHandlers adds the REST Api handlers for run, status and stop.
+// AddHandlers adds the REST Api handlers for run, status, stop and matrix.
 // uiPath must end with a /.
 func AddHandlers(mux *http.ServeMux, baseurl, uiPath, datadir string) {
 	AddDataHandler(mux, baseurl, uiPath, datadir)
@@ -532,7 +607,9 @@ func AddHandlers(mux *http.ServeMux, baseurl, uiPath, datadir string) {
 	mux.HandleFunc(restStatusPath, RESTStatusHandler)
 	restStopPath := uiPath + RestStopURI
 	mux.HandleFunc(restStopPath, RESTStopHandler)
-	log.Printf("REST API on %s, %s, %s", restRunPath, restStatusPath, restStopPath)
+	restMatrixPath := uiPath + RestMatrixURI
+	mux.HandleFunc(restMatrixPath, RESTMatrixHandler)
+	log.Printf("REST API on %s, %s, %s, %s", restRunPath, restStatusPath, restStopPath, restMatrixPath)
 }
 
 // SaveJSON save Json bytes to give file name (.json) in data-path dir.
@@ -609,3 +686,50 @@ func SetDataDir(datadir string) {
 func GetDataDir() string {
 	return dataDir
 }
+
+// retryPolicy returns the retry policy from the retry-* form or json values, for grpc or
+// http status codes, nil if retry-attempts isn't more than 1.
+func retryPolicy(r *http.Request, jd map[string]interface{}, grpc bool) (*fhttp.RetryPolicy, error) {
+	attempts, _ := strconv.Atoi(FormValue(r, jd, "retry-attempts"))
+	var p *fhttp.RetryPolicy
+	var err error
+	if grpc {
+		p, err = fgrpc.NewRetryPolicy(attempts, FormValue(r, jd, "retry-on"))
+	} else {
+		p, err = fhttp.NewRetryPolicy(attempts, FormValue(r, jd, "retry-on"), nil)
+	}
+	if p == nil {
+		return nil, err
+	}
+	p.Backoff, p.MaxBackoff = fhttp.DefaultRetryBackoff, fhttp.DefaultRetryMaxBackoff
+	if backoff := FormValue(r, jd, "retry-backoff"); backoff != "" {
+		p.Backoff, _ = time.ParseDuration(backoff)
+	}
+	if maxBackoff := FormValue(r, jd, "retry-max-backoff"); maxBackoff != "" {
+		p.MaxBackoff, _ = time.ParseDuration(maxBackoff)
+	}
+	p.Budget, _ = strconv.ParseFloat(FormValue(r, jd, "retry-budget"), 64)
+	return p, nil
+}
+
+// responseChecks returns the http response checks from the expect-* form or json
+// values, nil if none is set. They are validated by fhttp.RunHTTPTest.
+func responseChecks(r *http.Request, jd map[string]interface{}) *fhttp.ResponseChecks {
+	rc := fhttp.ResponseChecks{
+		Status:   FormValue(r, jd, "expect-status"),
+		Contains: FormValue(r, jd, "expect-contains"),
+		Regex:    FormValue(r, jd, "expect-regex"),
+		JSON:     FormValue(r, jd, "expect-json"),
+		Headers:  r.Form["expect-header"],
+		Size:     FormValue(r, jd, "expect-size"),
+	}
+	if len(rc.Headers) == 0 {
+		if h := FormValue(r, jd, "expect-header"); h != "" {
+			rc.Headers = []string{h}
+		}
+	}
+	if rc.Empty() {
+		return nil
+	}
+	return &rc
+}
@@ -33,6 +33,7 @@ import (
 	"fortio.org/fortio/log"
 	"fortio.org/fortio/tcprunner"
 	"fortio.org/fortio/udprunner"
+	"fortio.org/fortio/wsrunner"
 )
 
 // Generics ftw.
@@ -137,6 +138,20 @@ func TestHTTPRunnerRESTApi(t *testing.T) {
 	if savedID <= 0 {
 		t.Errorf("Saved id should be >=1: %d", savedID)
 	}
+	// Exports of that saved result, by extension and by format= query arg
+	dataURL := fmt.Sprintf("http://localhost:%d%sdata/%s", addr.Port, uiPath, res.RunnerResults.ID)
+	code, data := fhttp.FetchURL(dataURL + ".csv")
+	if code != http.StatusOK || !strings.HasPrefix(string(data), "Percentile,Latency(ms)\n0,") {
+		t.Errorf("Unexpected csv export %d: %s", code, data)
+	}
+	code, data = fhttp.FetchURL(dataURL + ".json?format=md")
+	if code != http.StatusOK || !strings.Contains(string(data), "| Calls | 100 (0 errors) |") {
+		t.Errorf("Unexpected markdown export %d: %s", code, data)
+	}
+	code, _ = fhttp.FetchURL(dataURL + "-not-there.hlog")
+	if code != http.StatusNotFound {
+		t.Errorf("Expected 404 for export of missing result, got %d", code)
+	}
 
 	// Send a bad (missing unit) duration (test error return)
 	runURL = fmt.Sprintf("%s?jsonPath=.metadata&qps=100&n=10&t=42", restURL)
@@ -499,6 +514,13 @@ func TestOtherRunnersRESTApi(t *testing.T) {
 		t.Errorf("Mismatch between grpc requests %d and ok %v (%+v)",
 			totalReq, res.RetCodes, res)
 	}
+	errObj := GetErrorResult(t, runURL+"&grpc-ok-codes=NotACode", "")
+	if errObj.Message != "parsing grpc-ok-codes" {
+		t.Errorf("Unexpected error for invalid grpc-ok-codes: %+v", errObj)
+	}
+	if runs := GetAllRuns(); len(runs) != 0 {
+		t.Errorf("The invalid run shouldn't be left in the runs: %v", runs)
+	}
 
 	tAddr := fnet.TCPEchoServer("test-echo-runner-tcp", ":0")
 	tDest := fmt.Sprintf("tcp://localhost:%d/", tAddr.(*net.TCPAddr).Port)
@@ -517,6 +539,49 @@ func TestOtherRunnersRESTApi(t *testing.T) {
 	if uRes.ActualQPS < 4 || uRes.ActualQPS > 5.1 {
 		t.Errorf("Unexpected udp qps %f", tRes.ActualQPS)
 	}
+
+	mux.HandleFunc("/ws/", fhttp.EchoHandler)
+	wDest := fmt.Sprintf("ws://localhost:%d/ws/", addr.Port)
+	runURL = fmt.Sprintf("%s?qps=%d&url=%s&t=2s&c=2", restURL, 10, wDest)
+
+	wRes := FetchResult[wsrunner.RunnerResults](t, runURL, "")
+	if wRes.ActualQPS < 8 || wRes.ActualQPS > 10.1 || wRes.RetCodes[wsrunner.WSStatusOK] != wRes.DurationHistogram.Count {
+		t.Errorf("Unexpected websocket qps %f or codes %v", wRes.ActualQPS, wRes.RetCodes)
+	}
+}
+
+func TestMatrixRESTApi(t *testing.T) {
+	mux, addr := fhttp.DynamicHTTPServer(false)
+	mux.HandleFunc("/foo/", fhttp.EchoHandler)
+	tmpDir := t.TempDir()
+	AddHandlers(mux, "", "/fortio/", tmpDir)
+	restURL := fmt.Sprintf("http://localhost:%d/fortio/%s", addr.Port, RestMatrixURI)
+	echoURL := fmt.Sprintf("http://localhost:%d/foo/", addr.Port)
+
+	runURL := fmt.Sprintf("%s?matrix-qps=-1,100&matrix-c=1,2&n=10&save=on&url=%s", restURL, echoURL)
+	res := FetchResult[MatrixReply](t, runURL, "")
+	if len(res.Points) != 4 {
+		t.Fatalf("Expected 4 matrix points, got %+v", res)
+	}
+	for i, expected := range [][2]float64{{-1, 1}, {-1, 2}, {100, 1}, {100, 2}} {
+		p := res.Points[i]
+		if p.QPS != expected[0] || float64(p.Connections) != expected[1] || p.Count != 10 || p.Errors != 0 {
+			t.Errorf("Unexpected point %d: %+v", i, p)
+		}
+		if _, err := os.Stat(path.Join(tmpDir, p.ResultID+".json")); err != nil {
+			t.Errorf("Point %d result not saved: %v", i, err)
+		}
+		if !strings.Contains(res.ChartURL, "sel="+p.ResultID) {
+			t.Errorf("Chart url %q missing point %d", res.ChartURL, i)
+		}
+	}
+	if len(GetAllRuns()) != 0 {
+		t.Errorf("Matrix runs should all be removed once done: %+v", GetAllRuns())
+	}
+
+	tAddr := fnet.TCPEchoServer("test-echo-matrix-tcp", ":0")
+	runURL = fmt.Sprintf("%s?matrix-c=1,2&n=10&url=tcp://localhost:%d/", restURL, tAddr.(*net.TCPAddr).Port)
+	GetErrorResult(t, runURL, "")
 }
 
 func TestNextGet(t *testing.T) {
@@ -551,3 +616,18 @@ func TestDataDir(t *testing.T) {
 	}
 	SetDataDir(oldDir)
 }
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/?retry-attempts=3", nil)
+	p, err := retryPolicy(r, map[string]interface{}{"retry-on": "503"}, false)
+	if err != nil || p == nil {
+		t.Fatalf("unexpected retry policy %v %v", p, err)
+	}
+	if p.Backoff != fhttp.DefaultRetryBackoff || p.MaxBackoff != fhttp.DefaultRetryMaxBackoff || !p.RetryOn(503, "") {
+		t.Errorf("expected the default backoffs and retry on 503, got %+v", p)
+	}
+	p, _ = retryPolicy(r, map[string]interface{}{"retry-backoff": "0s", "retry-max-backoff": "5s"}, false)
+	if p.Backoff != 0 || p.MaxBackoff != 5*time.Second {
+		t.Errorf("expected the specified backoffs, got %+v", p)
+	}
+}
@@ -16,11 +16,13 @@ package tcprunner
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sort"
+	"strings"
 	"syscall"
 	"time"
 
@@ -41,8 +43,13 @@ type RunnerResults struct {
 	SocketCount   int
 	BytesSent     int64
 	BytesReceived int64
-	client        *TCPClient
-	aborter       *periodic.Aborter
+	// Breakdown of the errors RetCodes by category (fnet.ErrConnectRefused, fnet.ErrReset,...)
+	ErrorCategories TCPResultMap `json:",omitempty"`
+	// Number of connections made from each source IP (when SourceIP is set)
+	SourceIPCounts map[string]int64 `json:",omitempty"`
+	client         *TCPClient
+	aborter        *periodic.Aborter
+	abortOnError   string
 }
 
 // Run tests tcp request fetching. Main call being run at the target QPS.
@@ -53,6 +60,12 @@ func (tcpstate *RunnerResults) Run(t int) (bool, string) {
 	if err != nil {
 		errStr := err.Error()
 		tcpstate.RetCodes[errStr]++
+		category := fnet.ErrorCategory(err)
+		tcpstate.ErrorCategories[category]++
+		if tcpstate.abortOnError == category {
+			tcpstate.aborter.Abort(false)
+			log.Infof("Aborted run because of %s error: %v", category, err)
+		}
 		return false, errStr
 	}
 	tcpstate.RetCodes[TCPStatusOK]++
@@ -65,6 +78,15 @@ type TCPOptions struct {
 	Payload          []byte // what to send (and check)
 	UnixDomainSocket string // Path of unix domain socket to use instead of host:port from URL
 	ReqTimeout       time.Duration
+	Proxy            string // URL of the http (CONNECT) or socks5 proxy to connect through
+	// Comma separated local IPs and CIDRs to bind the connections to, in round robin (see fnet.ParseSourceIPs).
+	SourceIP  string          `json:",omitempty"`
+	sourceIPs *fnet.SourceIPs // shared by the clients of a run
+	// PROXY protocol header version ("v1" or "v2") sent first on the connections, and the IPs and
+	// CIDRs to advertise as source in round robin instead of the actual one.
+	ProxyProtocol       string              `json:",omitempty"`
+	ProxyProtocolSource string              `json:",omitempty"`
+	proxyProto          *fnet.ProxyProtocol // shared by the clients of a run
 }
 
 // RunnerOptions includes the base RunnerOptions plus tcp specific
@@ -72,6 +94,8 @@ type TCPOptions struct {
 type RunnerOptions struct {
 	periodic.RunnerOptions
 	TCPOptions // Need to call Init() to initialize
+	// Which error category (fnet.ErrConnectRefused, fnet.ErrReset,...) cause an abort of the run (default "" = don't abort)
+	AbortOnError string
 }
 
 // TCPClient is the client used for tcp echo testing.
@@ -88,6 +112,10 @@ type TCPClient struct {
 	destination   string
 	doGenerate    bool
 	reqTimeout    time.Duration
+	sourceIPs     *fnet.SourceIPs     // local IPs to connect from, when set
+	proxyProto    *fnet.ProxyProtocol // PROXY protocol header to send first, when set
+	proxy         *fnet.ProxyDialer   // when connecting through a proxy to proxyTarget
+	proxyTarget   string
 }
 
 var (
@@ -114,17 +142,6 @@ func NewTCPClient(o *TCPOptions) (*TCPClient, error) {
 	c := TCPClient{}
 	d := o.Destination
 	c.destination = d
-	tAddr, err := fnet.ResolveDestination(d)
-	if tAddr == nil {
-		return nil, err
-	}
-	c.dest = tAddr
-	c.req = o.Payload
-	if len(c.req) == 0 { // len(nil) array is also valid and 0
-		c.doGenerate = true
-		c.req = GeneratePayload(0, 0)
-	}
-	c.buffer = make([]byte, len(c.req))
 	c.reqTimeout = o.ReqTimeout
 	if o.ReqTimeout == 0 {
 		log.Debugf("Request timeout not set, using default %v", fhttp.HTTPReqTimeOutDefaultValue)
@@ -134,16 +151,66 @@ func NewTCPClient(o *TCPOptions) (*TCPClient, error) {
 		log.Warnf("Invalid timeout %v, setting to %v", c.reqTimeout, fhttp.HTTPReqTimeOutDefaultValue)
 		c.reqTimeout = fhttp.HTTPReqTimeOutDefaultValue
 	}
+	if o.Proxy != "" {
+		// the proxy resolves the host name
+		var err error
+		if c.proxy, err = fnet.NewProxyDialer(o.Proxy, c.reqTimeout); err != nil {
+			return nil, err
+		}
+		c.proxyTarget = strings.TrimSuffix(strings.TrimPrefix(d, TCPURLPrefix), "/")
+		if _, _, err = net.SplitHostPort(c.proxyTarget); err != nil {
+			return nil, err
+		}
+		c.dest = c.proxy.Addr(c.proxyTarget)
+	} else {
+		tAddr, err := fnet.ResolveDestination(d)
+		if tAddr == nil {
+			return nil, err
+		}
+		c.dest = tAddr
+	}
+	c.req = o.Payload
+	if len(c.req) == 0 { // len(nil) array is also valid and 0
+		c.doGenerate = true
+		c.req = GeneratePayload(0, 0)
+	}
+	c.buffer = make([]byte, len(c.req))
+	c.sourceIPs = o.sourceIPs
+	if c.sourceIPs == nil {
+		var err error
+		if c.sourceIPs, err = fnet.ParseSourceIPs(o.SourceIP); err != nil {
+			return nil, err
+		}
+	}
+	c.proxyProto = o.proxyProto
+	if c.proxyProto == nil {
+		var err error
+		if c.proxyProto, err = fnet.NewProxyProtocol(o.ProxyProtocol, o.ProxyProtocolSource); err != nil {
+			return nil, err
+		}
+	}
 	return &c, nil
 }
 
 func (c *TCPClient) connect() (net.Conn, error) {
 	c.socketCount++
-	socket, err := net.Dial(c.dest.Network(), c.dest.String())
+	var socket net.Conn
+	var err error
+	if c.proxy != nil {
+		socket, err = c.proxy.DialContext(context.Background(), "tcp", c.proxyTarget)
+	} else {
+		d := &net.Dialer{LocalAddr: c.sourceIPs.LocalAddr(c.dest.Network())}
+		socket, err = d.Dial(c.dest.Network(), c.dest.String())
+	}
 	if err != nil {
 		log.Errf("Unable to connect to %v : %v", c.dest, err)
 		return nil, err
 	}
+	if err = c.proxyProto.Write(socket, socket.LocalAddr(), socket.RemoteAddr()); err != nil {
+		log.Errf("Unable to send the PROXY protocol header to %v : %v", c.dest, err)
+		socket.Close()
+		return nil, err
+	}
 	fnet.SetSocketBuffers(socket, len(c.buffer), len(c.req))
 	return socket, nil
 }
@@ -245,10 +312,24 @@ func RunTCPTest(o *RunnerOptions) (*RunnerResults, error) {
 	total := RunnerResults{
 		aborter:  r.Options().Stop,
 		RetCodes: make(TCPResultMap),
+		// Errors breakdown
+		ErrorCategories: make(TCPResultMap),
 	}
 	total.Destination = o.Destination
+	// Shared by all the clients for the round robin across all the connections.
+	sourceIPs, err := fnet.ParseSourceIPs(o.SourceIP)
+	if err != nil {
+		return nil, err
+	}
+	o.TCPOptions.sourceIPs = sourceIPs
+	defer func() { o.TCPOptions.sourceIPs = nil }()
+	proxyProto, err := fnet.NewProxyProtocol(o.ProxyProtocol, o.ProxyProtocolSource)
+	if err != nil {
+		return nil, err
+	}
+	o.TCPOptions.proxyProto = proxyProto
+	defer func() { o.TCPOptions.proxyProto = nil }()
 	tcpstate := make([]RunnerResults, numThreads)
-	var err error
 	for i := 0; i < numThreads; i++ {
 		r.Options().Runners[i] = &tcpstate[i]
 		// Create a client (and transport) and connect once for each 'thread'
@@ -265,7 +346,9 @@ func RunTCPTest(o *RunnerOptions) (*RunnerResults, error) {
 		}
 		// Setup the stats for each 'thread'
 		tcpstate[i].aborter = total.aborter
+		tcpstate[i].abortOnError = o.AbortOnError
 		tcpstate[i].RetCodes = make(TCPResultMap)
+		tcpstate[i].ErrorCategories = make(TCPResultMap)
 	}
 	total.RunnerResults = r.Run()
 	// Numthreads may have reduced but it should be ok to accumulate 0s from
@@ -281,6 +364,9 @@ func RunTCPTest(o *RunnerOptions) (*RunnerResults, error) {
 			}
 			total.RetCodes[k] += tcpstate[i].RetCodes[k]
 		}
+		for k, v := range tcpstate[i].ErrorCategories {
+			total.ErrorCategories[k] += v
+		}
 	}
 	// Cleanup state:
 	r.Options().ReleaseRunners()
@@ -291,5 +377,8 @@ func RunTCPTest(o *RunnerOptions) (*RunnerResults, error) {
 	for _, k := range keys {
 		_, _ = fmt.Fprintf(out, "tcp %s : %d (%.1f %%)\n", k, total.RetCodes[k], 100.*float64(total.RetCodes[k])/totalCount)
 	}
+	fnet.PrintErrorCategories(out, total.ErrorCategories, totalCount)
+	total.SourceIPCounts = sourceIPs.Counts()
+	fnet.PrintSourceIPCounts(out, total.SourceIPCounts)
 	return &total, nil
 }
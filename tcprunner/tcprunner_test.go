@@ -17,8 +17,11 @@ package tcprunner
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"reflect"
 	"runtime"
+	"sync"
 	"testing"
 
 	"fortio.org/fortio/fnet"
@@ -37,6 +40,29 @@ func TestTCPRunnerBadDestination(t *testing.T) {
 	t.Logf("Got expected error: %v", err)
 }
 
+func TestTCPRunnerErrorCategories(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	destination := fmt.Sprintf("tcp://localhost:%d/", l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+	opts := RunnerOptions{}
+	opts.QPS = -1
+	opts.Exactly = 20
+	opts.NumThreads = 1
+	opts.Destination = destination
+	opts.AbortOnError = fnet.ErrConnectRefused
+	res, err := RunTCPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ErrorCategories[fnet.ErrConnectRefused] != 1 || res.DurationHistogram.Count != 1 {
+		t.Errorf("Expected to abort after 1 %s error, got %v for %d calls",
+			fnet.ErrConnectRefused, res.ErrorCategories, res.DurationHistogram.Count)
+	}
+}
+
 func TestTCPRunner(t *testing.T) {
 	addr := fnet.TCPEchoServer("test-echo-runner", ":0")
 	destination := fmt.Sprintf("tcp://localhost:%d/", addr.(*net.TCPAddr).Port)
@@ -62,6 +88,80 @@ func TestTCPRunner(t *testing.T) {
 	}
 }
 
+func TestTCPRunnerSourceIPs(t *testing.T) {
+	addr := fnet.TCPEchoServer("test-echo-runner-source-ips", ":0")
+	destination := fmt.Sprintf("tcp://127.0.0.1:%d/", addr.(*net.TCPAddr).Port)
+
+	opts := RunnerOptions{}
+	opts.QPS = 100
+	opts.NumThreads = 4
+	opts.Destination = destination
+	opts.SourceIP = "127.0.0.2,127.0.0.3"
+	res, err := RunTCPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RetCodes[TCPStatusOK] != res.DurationHistogram.Count {
+		t.Errorf("Mismatch between requests %d and ok %v", res.DurationHistogram.Count, res.RetCodes)
+	}
+	expected := map[string]int64{"127.0.0.2": 2, "127.0.0.3": 2}
+	if !reflect.DeepEqual(res.SourceIPCounts, expected) {
+		t.Errorf("Got source ip counts %v, expected %v", res.SourceIPCounts, expected)
+	}
+	opts.SourceIP = "not.an.ip"
+	if _, err = RunTCPTest(&opts); err == nil {
+		t.Errorf("Expected error for invalid source ip")
+	}
+}
+
+func TestTCPRunnerProxyProtocol(t *testing.T) {
+	l, addr := fnet.Listen("test-echo-runner-proxy-protocol", ":0")
+	pl := fnet.NewProxyProtocolListener(l, nil)
+	var mu sync.Mutex
+	sources := map[string]int{}
+	go func() {
+		for {
+			conn, err := pl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if h := conn.(*fnet.ProxyProtocolConn).ProxyHeader(); h != nil && h.Source != nil {
+					mu.Lock()
+					sources[h.Source.(*net.TCPAddr).IP.String()]++
+					mu.Unlock()
+				}
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	defer l.Close()
+	opts := RunnerOptions{}
+	opts.QPS = 100
+	opts.NumThreads = 2
+	opts.Destination = fmt.Sprintf("tcp://127.0.0.1:%d/", addr.(*net.TCPAddr).Port)
+	opts.ProxyProtocol = "v2"
+	opts.ProxyProtocolSource = "10.0.0.0/30"
+	res, err := RunTCPTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RetCodes[TCPStatusOK] != res.DurationHistogram.Count {
+		t.Errorf("Mismatch between requests %d and ok %v", res.DurationHistogram.Count, res.RetCodes)
+	}
+	mu.Lock()
+	expected := map[string]int{"10.0.0.1": 1, "10.0.0.2": 1}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("Got PROXY header sources %v, expected %v", sources, expected)
+	}
+	mu.Unlock()
+	opts.ProxyProtocol = "v9"
+	if _, err = RunTCPTest(&opts); err == nil {
+		t.Errorf("Expected error for invalid proxy protocol version")
+	}
+}
+
 func TestTCPRunnerLargePayload(t *testing.T) {
 	addr := fnet.TCPEchoServer("test-echo-runner", ":0")
 	destination := fmt.Sprintf("tcp://localhost:%d/", addr.(*net.TCPAddr).Port)
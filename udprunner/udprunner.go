@@ -44,8 +44,13 @@ type RunnerResults struct {
 	SocketCount   int
 	BytesSent     int64
 	BytesReceived int64
-	client        *UDPClient
-	aborter       *periodic.Aborter
+	// Breakdown of the errors RetCodes by category (fnet.ErrConnectRefused, fnet.ErrReset,...)
+	ErrorCategories UDPResultMap `json:",omitempty"`
+	// Number of connections made from each source IP (when SourceIP is set)
+	SourceIPCounts map[string]int64 `json:",omitempty"`
+	client         *UDPClient
+	aborter        *periodic.Aborter
+	abortOnError   string
 }
 
 // Run tests udp request fetching. Main call being run at the target QPS.
@@ -56,6 +61,12 @@ func (udpstate *RunnerResults) Run(t int) (bool, string) {
 	if err != nil {
 		errStr := err.Error()
 		udpstate.RetCodes[errStr]++
+		category := fnet.ErrorCategory(err)
+		udpstate.ErrorCategories[category]++
+		if udpstate.abortOnError == category {
+			udpstate.aborter.Abort(false)
+			log.Infof("Aborted run because of %s error: %v", category, err)
+		}
 		return false, errStr
 	}
 	udpstate.RetCodes[UDPStatusOK]++
@@ -67,6 +78,9 @@ type UDPOptions struct {
 	Destination string
 	Payload     []byte // what to send (and check)
 	ReqTimeout  time.Duration
+	// Comma separated local IPs and CIDRs to bind the connections to, in round robin (see fnet.ParseSourceIPs).
+	SourceIP  string          `json:",omitempty"`
+	sourceIPs *fnet.SourceIPs // shared by the clients of a run
 }
 
 // RunnerOptions includes the base RunnerOptions plus udp specific
@@ -74,6 +88,8 @@ type UDPOptions struct {
 type RunnerOptions struct {
 	periodic.RunnerOptions
 	UDPOptions // Need to call Init() to initialize
+	// Which error category (fnet.ErrConnectRefused, fnet.ErrReset,...) cause an abort of the run (default "" = don't abort)
+	AbortOnError string
 }
 
 // UDPClient is the client used for udp echo testing.
@@ -90,6 +106,7 @@ type UDPClient struct {
 	destination   string
 	doGenerate    bool
 	reqTimeout    time.Duration
+	sourceIPs     *fnet.SourceIPs // local IPs to connect from, when set
 }
 
 var (
@@ -119,6 +136,13 @@ func NewUDPClient(o *UDPOptions) (*UDPClient, error) {
 		c.req = tcprunner.GeneratePayload(0, 0)
 	}
 	c.buffer = make([]byte, len(c.req))
+	c.sourceIPs = o.sourceIPs
+	if c.sourceIPs == nil {
+		var err error
+		if c.sourceIPs, err = fnet.ParseSourceIPs(o.SourceIP); err != nil {
+			return nil, err
+		}
+	}
 	c.reqTimeout = o.ReqTimeout
 	if o.ReqTimeout == 0 {
 		log.Debugf("Request timeout not set, using default %v", UDPTimeOutDefaultValue)
@@ -133,7 +157,8 @@ func NewUDPClient(o *UDPOptions) (*UDPClient, error) {
 
 func (c *UDPClient) connect() (net.Conn, error) {
 	c.socketCount++
-	socket, err := net.Dial(c.dest.Network(), c.dest.String())
+	d := &net.Dialer{LocalAddr: c.sourceIPs.LocalAddr(c.dest.Network())}
+	socket, err := d.Dial(c.dest.Network(), c.dest.String())
 	if err != nil {
 		log.Errf("Unable to connect to %v : %v", c.dest, err)
 		return nil, err
@@ -231,10 +256,18 @@ func RunUDPTest(o *RunnerOptions) (*RunnerResults, error) {
 	total := RunnerResults{
 		aborter:  r.Options().Stop,
 		RetCodes: make(UDPResultMap),
+		// Errors breakdown
+		ErrorCategories: make(UDPResultMap),
 	}
 	total.Destination = o.Destination
+	// Shared by all the clients for the round robin across all the connections.
+	sourceIPs, err := fnet.ParseSourceIPs(o.SourceIP)
+	if err != nil {
+		return nil, err
+	}
+	o.UDPOptions.sourceIPs = sourceIPs
+	defer func() { o.UDPOptions.sourceIPs = nil }()
 	udpstate := make([]RunnerResults, numThreads)
-	var err error
 	for i := 0; i < numThreads; i++ {
 		r.Options().Runners[i] = &udpstate[i]
 		// Create a client (and transport) and connect once for each 'thread'
@@ -251,7 +284,9 @@ func RunUDPTest(o *RunnerOptions) (*RunnerResults, error) {
 		}
 		// Setup the stats for each 'thread'
 		udpstate[i].aborter = total.aborter
+		udpstate[i].abortOnError = o.AbortOnError
 		udpstate[i].RetCodes = make(UDPResultMap)
+		udpstate[i].ErrorCategories = make(UDPResultMap)
 	}
 	total.RunnerResults = r.Run()
 	// Numthreads may have reduced but it should be ok to accumulate 0s from
@@ -267,6 +302,9 @@ func RunUDPTest(o *RunnerOptions) (*RunnerResults, error) {
 			}
 			total.RetCodes[k] += udpstate[i].RetCodes[k]
 		}
+		for k, v := range udpstate[i].ErrorCategories {
+			total.ErrorCategories[k] += v
+		}
 	}
 	// Cleanup state:
 	r.Options().ReleaseRunners()
@@ -277,5 +315,8 @@ func RunUDPTest(o *RunnerOptions) (*RunnerResults, error) {
 	for _, k := range keys {
 		_, _ = fmt.Fprintf(out, "udp %s : %d (%.1f %%)\n", k, total.RetCodes[k], 100.*float64(total.RetCodes[k])/totalCount)
 	}
+	fnet.PrintErrorCategories(out, total.ErrorCategories, totalCount)
+	total.SourceIPCounts = sourceIPs.Counts()
+	fnet.PrintSourceIPCounts(out, total.SourceIPCounts)
 	return &total, nil
 }
@@ -69,6 +69,7 @@ const logYAxe = {
 let chart = {}
 let overlayChart = {}
 let mchart = {}
+let bchart = {}
 
 function myRound (v, digits = 6) {
   const p = Math.pow(10, digits)
@@ -175,67 +176,201 @@ function fortioResultToJsChartData (res) {
       prevX = x
       prevY = y
     }
-    let prev = 1000.0 * res.DurationHistogram.Data[0].Start
-    for (let i = 0; i < len; i++) {
-      const it = res.DurationHistogram.Data[i]
-      const startX = 1000.0 * it.Start
-      const endX = 1000.0 * it.End
-      if (startX !== prev) {
-        dataH.push({
-          x: myRound(prev),
-          y: 0
-        }, {
-          x: myRound(startX),
-          y: 0
-        })
-      }
-      dataH.push({
-        x: myRound(startX),
-        y: it.Count
-      }, {
-        x: myRound(endX),
-        y: it.Count
-      })
-      prev = endX
-    }
+    histogramToChartData(res.DurationHistogram, dataH)
   }
   if (res.ErrorsDurationHistogram != null && res.ErrorsDurationHistogram.Count > 0) {
-    // TODO: make a function, same as above with dataH->dataE
-    let prev = 1000.0 * res.ErrorsDurationHistogram.Data[0].Start
-    const len = res.ErrorsDurationHistogram.Data.length
-    for (let i = 0; i < len; i++) {
-      const it = res.ErrorsDurationHistogram.Data[i]
-      const startX = 1000.0 * it.Start
-      const endX = 1000.0 * it.End
-      if (startX !== prev) {
-        dataE.push({
-          x: myRound(prev),
-          y: 0
-        }, {
-          x: myRound(startX),
-          y: 0
-        })
+    histogramToChartData(res.ErrorsDurationHistogram, dataE)
+  }
+  // Per phase (DNS, Connect, Tunnel, TLS, TTFB, Transfer) histograms of http runs, if any
+  const phases = []
+  if (res.Phases) {
+    for (const name of phaseNames) {
+      const h = res.Phases[name]
+      if (h && h.Count > 0) {
+        const data = []
+        histogramToChartData(h, data)
+        phases.push({ name, data })
       }
-      dataE.push({
-        x: myRound(startX),
-        y: it.Count
-      }, {
-        x: myRound(endX),
-        y: it.Count
-      })
-      prev = endX
     }
   }
   return {
     title: makeTitle(res),
     dataP,
     dataH,
-    dataE
+    dataE,
+    phases,
+    bandwidth: bandwidthToChartData(res),
+    exemplars: res.Exemplars
+  }
+}
+
+// MB/s sent and received during each second of http runs (null for other runs).
+function bandwidthToChartData (res) {
+  if (!res.ReceivedPerSecond) {
+    return null
+  }
+  const duration = res.ActualDuration / 1e9
+  const sent = []
+  const received = []
+  for (let i = 0; i < res.ReceivedPerSecond.length; i++) {
+    // the last second is usually partial
+    const interval = Math.min(1, Math.max(duration - i, 0.001))
+    sent.push({ x: i, y: myRound(res.SentPerSecond[i] / 1e6 / interval, 3) })
+    received.push({ x: i, y: myRound(res.ReceivedPerSecond[i] / 1e6 / interval, 3) })
+  }
+  return { sent, received }
+}
+
+// Shows the bandwidth over time chart, next to the response time one, or hides it when
+// there is no bandwidth data (non http or multiple runs).
+function showBandwidthChart (bandwidth) {
+  const container = document.getElementById('cc2')
+  if (!container) {
+    return
+  }
+  if (Object.keys(bchart).length !== 0) {
+    bchart.destroy()
+    bchart = {}
+  }
+  if (!bandwidth) {
+    container.style.display = 'none'
+    return
+  }
+  container.style.display = 'block'
+  const ctx = document.getElementById('chart2').getContext('2d')
+  bchart = new Chart(ctx, {
+    type: 'line',
+    data: {
+      datasets: [{
+        label: 'Received MB/s',
+        data: bandwidth.received,
+        fill: false,
+        borderColor: 'rgba(87, 167, 134, .9)',
+        backgroundColor: 'rgba(87, 167, 134, .75)',
+        lineTension: 0
+      }, {
+        label: 'Sent MB/s',
+        data: bandwidth.sent,
+        fill: false,
+        borderColor: 'rgba(134, 87, 167, .9)',
+        backgroundColor: 'rgba(134, 87, 167, .75)',
+        lineTension: 0
+      }]
+    },
+    options: {
+      responsive: true,
+      maintainAspectRatio: false,
+      title: {
+        display: true,
+        fontStyle: 'normal',
+        text: 'Bandwidth over time'
+      },
+      scales: {
+        xAxes: [{
+          type: 'linear',
+          scaleLabel: {
+            display: true,
+            labelString: 'Time since the start in s'
+          }
+        }],
+        yAxes: [{
+          ticks: {
+            beginAtZero: true
+          },
+          scaleLabel: {
+            display: true,
+            labelString: 'MB/s'
+          }
+        }]
+      }
+    }
+  })
+}
+
+// Appends to data the histogram's buckets as chart points (in ms).
+function histogramToChartData (h, data) {
+  let prev = 1000.0 * h.Data[0].Start
+  for (let i = 0; i < h.Data.length; i++) {
+    const it = h.Data[i]
+    const startX = 1000.0 * it.Start
+    const endX = 1000.0 * it.End
+    if (startX !== prev) {
+      data.push({
+        x: myRound(prev),
+        y: 0
+      }, {
+        x: myRound(startX),
+        y: 0
+      })
+    }
+    data.push({
+      x: myRound(startX),
+      y: it.Count
+    }, {
+      x: myRound(endX),
+      y: it.Count
+    })
+    prev = endX
+  }
+}
+
+const phaseNames = ['DNS', 'Connect', 'Tunnel', 'TLS', 'TTFB', 'Transfer']
+const phaseColors = ['rgba(230, 159, 0, .8)', 'rgba(86, 180, 233, .8)', 'rgba(0, 158, 115, .8)',
+  'rgba(204, 121, 167, .8)', 'rgba(0, 114, 178, .8)', 'rgba(213, 94, 0, .8)']
+
+// Chart datasets for the phases histograms, hidden by default (click on the legend to show).
+function phasesDatasets (phases) {
+  return phases.map(p => {
+    const color = phaseColors[phaseNames.indexOf(p.name)]
+    return {
+      label: p.name + ' phase',
+      data: p.data,
+      yAxisID: 'H',
+      pointStyle: 'rect',
+      radius: 1,
+      borderColor: color,
+      backgroundColor: color,
+      fill: false,
+      hidden: true,
+      lineTension: 0
+    }
+  })
+}
+
+function showExemplars (exemplars) {
+  // Only on pages having the exemplars div
+  const div = document.getElementById('exemplars')
+  if (!div) {
+    return
   }
+  div.textContent = ''
+  if (!exemplars || exemplars.length === 0) {
+    return
+  }
+  const table = document.createElement('table')
+  const caption = table.createCaption()
+  caption.textContent = 'Slowest ' + exemplars.length + ' calls'
+  const header = table.insertRow()
+  for (const h of ['Start', 'Thread', 'Latency (ms)', 'Ok', 'Details']) {
+    const th = document.createElement('th')
+    th.textContent = h
+    header.appendChild(th)
+  }
+  for (const x of exemplars) {
+    const row = table.insertRow()
+    row.insertCell().textContent = formatDate(x.StartTime) + '.' + ('00' + new Date(x.StartTime).getMilliseconds()).slice(-3)
+    row.insertCell().textContent = x.Thread
+    row.insertCell().textContent = myRound(1000.0 * x.Latency, 3)
+    row.insertCell().textContent = x.Status ? '✓' : '✗'
+    row.insertCell().textContent = x.Details
+  }
+  div.appendChild(table)
 }
 
 function showChart (data) {
   makeChart(data)
+  showBandwidthChart(data.bandwidth)
+  showExemplars(data.exemplars)
   // Load configuration (min, max, isLogarithmic, ...) from the update form.
   updateChartOptions(chart)
   toggleVisibility()
@@ -264,6 +399,8 @@ function makeOverlayChart (dataA, dataB) {
   }
   deleteSingleChart()
   deleteMultiChart()
+  showBandwidthChart()
+  showExemplars()
   const ctx = chartEl.getContext('2d')
   const title = makeOverlayChartTitle(dataA.title, dataB.title)
   overlayChart = new Chart(ctx, {
@@ -381,7 +518,7 @@ function makeChart (data) {
           backgroundColor: 'rgba(87, 167, 134, .75)',
           lineTension: 0
         }
-        ]
+        ].concat(phasesDatasets(data.phases))
       },
       options: {
         responsive: true,
@@ -417,6 +554,7 @@ function makeChart (data) {
     chart.data.datasets[0].data = data.dataP
     chart.data.datasets[1].data = data.dataE
     chart.data.datasets[2].data = data.dataH
+    chart.data.datasets = chart.data.datasets.slice(0, 3).concat(phasesDatasets(data.phases))
     chart.options.title.text = data.title
     updateChart(chart)
   }
@@ -604,6 +742,8 @@ function makeMultiChart () {
   }
   deleteSingleChart()
   deleteOverlayChart()
+  showBandwidthChart()
+  showExemplars()
   const ctx = chartEl.getContext('2d')
   mchart = new Chart(ctx, {
     type: 'line',
@@ -131,6 +131,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	jitter := (r.FormValue("jitter") == "on")
 	uniform := (r.FormValue("uniform") == "on")
 	nocatchup := (r.FormValue("nocatchup") == "on")
+	slowest, _ := strconv.Atoi(r.FormValue("slowest"))
 	stdClient := (r.FormValue("stdclient") == "on")
 	sequentialWarmup := (r.FormValue("sequential-warmup") == "on")
 	httpsInsecure := (r.FormValue("https-insecure") == "on")
@@ -175,6 +176,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		Jitter:      jitter,
 		Uniform:     uniform,
 		NoCatchUp:   nocatchup,
+		Exemplars:   slowest,
 	}
 	if mode == run {
 		// must not normalize, done in rapi.UpdateRun when actually starting the run
@@ -188,6 +190,8 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	defaultHeaders := httpopts.AllHeaders()
 	httpopts.ResetHeaders()
 	httpopts.DisableFastClient = stdClient
+	httpopts.H2 = (r.FormValue("h2") == "on")
+	httpopts.H3 = (r.FormValue("h3") == "on")
 	httpopts.SequentialWarmup = sequentialWarmup
 	httpopts.Insecure = httpsInsecure
 	httpopts.Resolve = resolve
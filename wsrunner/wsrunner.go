@@ -0,0 +1,444 @@
+// Copyright 2022 Fortio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsrunner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"fortio.org/fortio/fhttp"
+	"fortio.org/fortio/fnet"
+	"fortio.org/fortio/jrpc"
+	"fortio.org/fortio/log"
+	"fortio.org/fortio/periodic"
+	"fortio.org/fortio/stats"
+	"fortio.org/fortio/tcprunner"
+)
+
+type WSResultMap map[string]int64
+
+// RunnerResults is the aggregated result of a WSRunner.
+// Also is the internal type used per thread/goroutine.
+type RunnerResults struct {
+	periodic.RunnerResults
+	Destination   string
+	RetCodes      WSResultMap
+	SocketCount   int
+	BytesSent     int64 // messages payload bytes
+	BytesReceived int64
+	// Time to connect and upgrade each new connection (including the TLS handshake for wss://)
+	UpgradeHistogram *stats.HistogramData
+	// Breakdown of the errors RetCodes by category (fnet.ErrConnectRefused, fnet.ErrReset,...)
+	ErrorCategories WSResultMap `json:",omitempty"`
+	// Number of connections made from each source IP (when SourceIP is set)
+	SourceIPCounts map[string]int64 `json:",omitempty"`
+	client         *WSClient
+	aborter        *periodic.Aborter
+	abortOnError   string
+}
+
+// Run sends a message and waits for its echo. Main call being run at the target QPS.
+// To be set as the Function in RunnerOptions.
+func (wsstate *RunnerResults) Run(t int) (bool, string) {
+	log.Debugf("Calling in %d", t)
+	_, err := wsstate.client.Fetch()
+	if err != nil {
+		errStr := err.Error()
+		wsstate.RetCodes[errStr]++
+		category := fnet.ErrorCategory(err)
+		wsstate.ErrorCategories[category]++
+		if wsstate.abortOnError == category {
+			wsstate.aborter.Abort(false)
+			log.Infof("Aborted run because of %s error: %v", category, err)
+		}
+		return false, errStr
+	}
+	wsstate.RetCodes[WSStatusOK]++
+	return true, WSStatusOK
+}
+
+// WSOptions are options to the WSClient.
+type WSOptions struct {
+	fhttp.TLSOptions // for wss://, and the Proxy or UnixDomainSocket to connect through
+	Destination      string
+	Payload          []byte      // what to send (and check), as a binary message
+	Headers          http.Header // additional headers of the upgrade request (e.g. User-Agent, Authorization, Host)
+	ReqTimeout       time.Duration
+	// Comma separated local IPs and CIDRs to bind the connections to, in round robin (see fnet.ParseSourceIPs).
+	SourceIP  string          `json:",omitempty"`
+	sourceIPs *fnet.SourceIPs // shared by the clients of a run
+}
+
+// RunnerOptions includes the base RunnerOptions plus websocket specific
+// options.
+type RunnerOptions struct {
+	periodic.RunnerOptions
+	WSOptions
+	// Which error category (fnet.ErrConnectRefused, fnet.ErrReset,...) cause an abort of the run (default "" = don't abort)
+	AbortOnError string
+}
+
+// WSClient is the client used for websocket echo testing.
+type WSClient struct {
+	req           []byte
+	opcode        byte // WebSocketText for the generated payloads, WebSocketBinary otherwise
+	ws            *fhttp.WebSocketConn
+	dest          net.Addr
+	hostPort      string // host:port of the url, for the proxy
+	handshake     []byte // upgrade request, but for the key
+	tlsConfig     *tls.Config
+	connID        int // 0-9999
+	messageCount  int64
+	bytesSent     int64
+	bytesReceived int64
+	socketCount   int
+	destination   string
+	doGenerate    bool
+	reqTimeout    time.Duration
+	upgrade       *stats.Histogram
+	sourceIPs     *fnet.SourceIPs   // local IPs to connect from, when set
+	proxy         *fnet.ProxyDialer // when connecting through a proxy to hostPort
+}
+
+var (
+	// WSURLPrefix is the URL prefix for triggering websocket load.
+	WSURLPrefix = "ws://"
+	// WSSURLPrefix is the URL prefix for triggering secure (TLS) websocket load.
+	WSSURLPrefix = "wss://"
+	// WSStatusOK is the map key on success.
+	WSStatusOK  = "OK"
+	errMismatch = fmt.Errorf("reply not echoing the message")
+)
+
+// IsWebSocketURL returns whether the url is for the websocket runner (ws:// or wss://).
+func IsWebSocketURL(url string) bool {
+	return strings.HasPrefix(url, WSURLPrefix) || strings.HasPrefix(url, WSSURLPrefix)
+}
+
+// NewWSClient creates and initialize and returns a client based on the WSOptions.
+func NewWSClient(o *WSOptions) (*WSClient, error) {
+	c := WSClient{destination: o.Destination}
+	c.reqTimeout = o.ReqTimeout
+	if o.ReqTimeout <= 0 {
+		log.Debugf("Request timeout not set, using default %v", fhttp.HTTPReqTimeOutDefaultValue)
+		c.reqTimeout = fhttp.HTTPReqTimeOutDefaultValue
+	}
+	u, err := url.Parse(o.Destination)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "ws":
+		if port == "" {
+			port = "80"
+		}
+	case "wss":
+		if port == "" {
+			port = "443"
+		}
+		if c.tlsConfig, err = o.TLSClientConfig(); err != nil {
+			return nil, err
+		}
+		if c.tlsConfig.ServerName == "" {
+			c.tlsConfig.ServerName = u.Hostname()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q for websocket url %s", u.Scheme, o.Destination)
+	}
+	c.hostPort = net.JoinHostPort(u.Hostname(), port)
+	switch {
+	case o.UnixDomainSocket != "":
+		c.dest = &net.UnixAddr{Name: o.UnixDomainSocket, Net: fnet.UnixDomainSocket}
+	case o.Proxy != "":
+		// the proxy resolves the host name
+		if c.proxy, err = o.ProxyDialer(c.reqTimeout); err != nil {
+			return nil, err
+		}
+		c.dest = c.proxy.Addr(c.hostPort)
+	default:
+		tAddr, err := fnet.Resolve(u.Hostname(), port)
+		if tAddr == nil {
+			return nil, err
+		}
+		c.dest = tAddr
+	}
+	c.handshake = upgradeRequest(u, o.Headers)
+	c.req = o.Payload
+	c.opcode = fhttp.WebSocketBinary
+	if len(c.req) == 0 {
+		c.doGenerate = true
+		c.opcode = fhttp.WebSocketText
+		c.req = tcprunner.GeneratePayload(0, 0)
+	}
+	c.sourceIPs = o.sourceIPs
+	if c.sourceIPs == nil {
+		if c.sourceIPs, err = fnet.ParseSourceIPs(o.SourceIP); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// upgradeRequest returns the http/1.1 upgrade request for u, up to (excluded) the
+// Sec-WebSocket-Key header and final empty line.
+func upgradeRequest(u *url.URL, headers http.Header) []byte {
+	host := u.Host
+	if h := headers.Get("Host"); h != "" {
+		host = h
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n", u.RequestURI(), host)
+	b.WriteString("Sec-WebSocket-Version: 13\r\n")
+	if headers.Get(jrpc.UserAgentHeader) == "" {
+		fmt.Fprintf(&b, "%s: %s\r\n", jrpc.UserAgentHeader, jrpc.UserAgent)
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		if k != "Host" && k != "Content-Length" { // the upgrade request has no body
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range headers[k] {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	return b.Bytes()
+}
+
+// connect opens a new connection and upgrades it to the websocket protocol.
+func (c *WSClient) connect() error {
+	c.socketCount++
+	start := time.Now()
+	var conn net.Conn
+	var err error
+	if c.proxy != nil {
+		conn, err = c.proxy.DialContext(context.Background(), "tcp", c.hostPort)
+	} else {
+		d := &net.Dialer{Timeout: c.reqTimeout, LocalAddr: c.sourceIPs.LocalAddr(c.dest.Network())}
+		conn, err = d.Dial(c.dest.Network(), c.dest.String())
+	}
+	if err != nil {
+		log.Errf("Unable to connect to %v : %v", c.dest, err)
+		return err
+	}
+	_ = conn.SetDeadline(start.Add(c.reqTimeout))
+	if c.tlsConfig != nil {
+		tlsConn := tls.Client(conn, c.tlsConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			log.Errf("[%d] TLS handshake error with %v: %v", c.connID, c.dest, err)
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := append(c.handshake[:len(c.handshake):len(c.handshake)], "Sec-WebSocket-Key: "+key+"\r\n\r\n"...)
+	br := bufio.NewReader(conn)
+	if _, err = conn.Write(req); err == nil {
+		err = checkUpgrade(br, key)
+	}
+	if err != nil {
+		log.Errf("[%d] Websocket upgrade error with %v: %v", c.connID, c.dest, err)
+		conn.Close()
+		return err
+	}
+	c.upgrade.Record(time.Since(start).Seconds())
+	c.ws = fhttp.NewWebSocketConn(conn, br, true)
+	if c.ws.MaxSize < len(c.req) {
+		c.ws.MaxSize = len(c.req)
+	}
+	return nil
+}
+
+// checkUpgrade reads the handshake response and checks it accepted the upgrade for key.
+func checkUpgrade(br *bufio.Reader, key string) error {
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("websocket upgrade failed with status %d", resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != fhttp.WebSocketAccept(key) {
+		return fmt.Errorf("invalid Sec-WebSocket-Accept %q", accept)
+	}
+	return nil
+}
+
+// Fetch sends a message, connecting first if needed, and reads and checks its echo.
+func (c *WSClient) Fetch() ([]byte, error) {
+	c.messageCount++
+	reuse := (c.ws != nil)
+	if !reuse {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Debugf("[%d] Reusing websocket %v", c.connID, c.ws.Conn.LocalAddr())
+	}
+	ws := c.ws
+	c.ws = nil // because of error returns and single retry
+	conErr := ws.Conn.SetDeadline(time.Now().Add(c.reqTimeout))
+	if c.doGenerate {
+		c.req = tcprunner.GeneratePayload(c.connID, c.messageCount)
+	}
+	_, err := ws.WriteMessage(c.opcode, c.req)
+	if log.LogDebug() {
+		log.Debugf("[%d] sent %d (%s): %v", c.connID, len(c.req), fnet.DebugSummary(c.req, 256), err)
+	}
+	if err != nil || conErr != nil {
+		ws.Conn.Close()
+		if reuse {
+			// it's ok for the (idle) connection to die once, auto reconnect:
+			log.Infof("Closing dead websocket %v (%v)", ws.Conn.LocalAddr(), err)
+			return c.Fetch() // recurse once
+		}
+		log.Errf("[%d] Unable to write to %v: %v", c.connID, c.dest, err)
+		return nil, err
+	}
+	c.bytesSent += int64(len(c.req))
+	_, msg, err := ws.ReadMessage()
+	if log.LogDebug() {
+		log.Debugf("[%d] received %d (%s): %v", c.connID, len(msg), fnet.DebugSummary(msg, 256), err)
+	}
+	c.bytesReceived += int64(len(msg))
+	if err != nil {
+		log.Errf("[%d] Unable to read: %v", c.connID, err)
+		ws.Conn.Close()
+		return nil, err
+	}
+	if !bytes.Equal(msg, c.req) {
+		log.Infof("Mismatch between sent %q and received %q", fnet.DebugSummary(c.req, 256), fnet.DebugSummary(msg, 256))
+		ws.Conn.Close()
+		return msg, errMismatch
+	}
+	c.ws = ws // reuse on success
+	return msg, nil
+}
+
+// Close closes the last connection and returns the total number of sockets used for the run.
+func (c *WSClient) Close() int {
+	log.Debugf("Closing %p: %s socket count %d", c, c.destination, c.socketCount)
+	if c.ws != nil {
+		if err := c.ws.Close(); err != nil {
+			log.Warnf("Error closing websocket client's connection: %v", err)
+		}
+		c.ws = nil
+	}
+	return c.socketCount
+}
+
+// RunWSTest runs a websocket test and returns the aggregated stats.
+func RunWSTest(o *RunnerOptions) (*RunnerResults, error) {
+	o.RunType = "WebSocket"
+	log.Infof("Starting websocket test for %s with %d threads at %.1f qps", o.Destination, o.NumThreads, o.QPS)
+	r := periodic.NewPeriodicRunner(&o.RunnerOptions)
+	defer r.Options().Abort()
+	numThreads := r.Options().NumThreads
+	o.WSOptions.Destination = o.Destination
+	out := r.Options().Out // Important as the default value is set from nil to stdout inside NewPeriodicRunner
+	total := RunnerResults{
+		aborter:  r.Options().Stop,
+		RetCodes: make(WSResultMap),
+		// Errors breakdown
+		ErrorCategories: make(WSResultMap),
+	}
+	total.Destination = o.Destination
+	// Shared by all the clients for the round robin across all the connections.
+	sourceIPs, err := fnet.ParseSourceIPs(o.SourceIP)
+	if err != nil {
+		return nil, err
+	}
+	o.WSOptions.sourceIPs = sourceIPs
+	defer func() { o.WSOptions.sourceIPs = nil }()
+	upgrade := stats.NewHistogram(r.Options().Offset.Seconds(), r.Options().Resolution)
+	wsstate := make([]RunnerResults, numThreads)
+	for i := 0; i < numThreads; i++ {
+		r.Options().Runners[i] = &wsstate[i]
+		// Create a client and connect once for each 'thread'
+		wsstate[i].client, err = NewWSClient(&o.WSOptions)
+		if wsstate[i].client == nil {
+			return nil, fmt.Errorf("unable to create client %d for %s: %w", i, o.Destination, err)
+		}
+		wsstate[i].client.connID = i
+		wsstate[i].client.upgrade = stats.NewHistogram(upgrade.Offset, upgrade.Divider)
+		if o.Exactly <= 0 {
+			data, err := wsstate[i].client.Fetch()
+			if i == 0 && log.LogVerbose() {
+				log.LogVf("first hit of %s: err %v, received %d: %q", o.Destination, err, len(data), data)
+			}
+		}
+		// Setup the stats for each 'thread'
+		wsstate[i].aborter = total.aborter
+		wsstate[i].abortOnError = o.AbortOnError
+		wsstate[i].RetCodes = make(WSResultMap)
+		wsstate[i].ErrorCategories = make(WSResultMap)
+	}
+	total.RunnerResults = r.Run()
+	// Numthreads may have reduced but it should be ok to accumulate 0s from
+	// unused ones. We also must cleanup all the created clients.
+	keys := []string{}
+	for i := 0; i < numThreads; i++ {
+		total.SocketCount += wsstate[i].client.Close()
+		total.BytesReceived += wsstate[i].client.bytesReceived
+		total.BytesSent += wsstate[i].client.bytesSent
+		upgrade.Transfer(wsstate[i].client.upgrade)
+		for k := range wsstate[i].RetCodes {
+			if _, exists := total.RetCodes[k]; !exists {
+				keys = append(keys, k)
+			}
+			total.RetCodes[k] += wsstate[i].RetCodes[k]
+		}
+		for k, v := range wsstate[i].ErrorCategories {
+			total.ErrorCategories[k] += v
+		}
+	}
+	// Cleanup state:
+	r.Options().ReleaseRunners()
+	totalCount := float64(total.DurationHistogram.Count)
+	_, _ = fmt.Fprintf(out, "Sockets used: %d (for perfect no error run, would be %d)\n", total.SocketCount, r.Options().NumThreads)
+	_, _ = fmt.Fprintf(out, "Total Bytes sent: %d, received: %d\n", total.BytesSent, total.BytesReceived)
+	total.UpgradeHistogram = upgrade.Export().CalcPercentiles(r.Options().Percentiles)
+	if log.Log(log.Info) {
+		total.UpgradeHistogram.Print(out, "Connection upgrade time histogram (s)")
+	} else if log.Log(log.Warning) {
+		upgrade.Counter.Print(out, "Connection upgrade time (s)")
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(out, "ws %s : %d (%.1f %%)\n", k, total.RetCodes[k], 100.*float64(total.RetCodes[k])/totalCount)
+	}
+	fnet.PrintErrorCategories(out, total.ErrorCategories, totalCount)
+	total.SourceIPCounts = sourceIPs.Counts()
+	fnet.PrintSourceIPCounts(out, total.SourceIPCounts)
+	return &total, nil
+}
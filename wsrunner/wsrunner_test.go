@@ -0,0 +1,96 @@
+// Copyright 2022 Fortio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package wsrunner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fortio.org/fortio/fhttp"
+	"fortio.org/fortio/fnet"
+)
+
+func TestWSRunner(t *testing.T) {
+	mux, addr := fhttp.DynamicHTTPServer(false)
+	mux.HandleFunc("/", fhttp.EchoHandler)
+	for _, payload := range [][]byte{nil, fnet.GenerateRandomPayload(70000)} {
+		opts := RunnerOptions{}
+		opts.QPS = 100
+		opts.NumThreads = 2
+		opts.Destination = fmt.Sprintf("ws://localhost:%d/some/path?x=y", addr.Port)
+		opts.Payload = payload
+		res, err := RunWSTest(&opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totalReq := res.DurationHistogram.Count
+		if res.RetCodes[WSStatusOK] != totalReq || totalReq == 0 {
+			t.Errorf("Mismatch between requests %d and ok %v", totalReq, res.RetCodes)
+		}
+		if res.SocketCount != 2 || res.UpgradeHistogram.Count != 2 {
+			t.Errorf("Expected 2 sockets and upgrades, got %d and %d", res.SocketCount, res.UpgradeHistogram.Count)
+		}
+		if res.BytesReceived != res.BytesSent || res.BytesSent < totalReq*int64(len(payload)) {
+			t.Errorf("Bytes received %d should be bytes sent %d", res.BytesReceived, res.BytesSent)
+		}
+	}
+}
+
+func TestWSRunnerTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(fhttp.EchoHandler))
+	defer srv.Close()
+	opts := RunnerOptions{}
+	opts.QPS = -1
+	opts.Exactly = 10
+	opts.NumThreads = 1
+	opts.Destination = strings.Replace(srv.URL, "https://", WSSURLPrefix, 1)
+	opts.Insecure = true
+	res, err := RunWSTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RetCodes[WSStatusOK] != 10 || res.SocketCount != 1 {
+		t.Errorf("Expected 10 ok calls on 1 socket, got %v on %d", res.RetCodes, res.SocketCount)
+	}
+}
+
+func TestWSRunnerErrors(t *testing.T) {
+	mux, addr := fhttp.DynamicHTTPServer(false)
+	mux.HandleFunc("/", fhttp.EchoHandler)
+	mux.HandleFunc("/nows", func(w http.ResponseWriter, r *http.Request) {})
+	opts := RunnerOptions{}
+	opts.QPS = -1
+	opts.Exactly = 3
+	opts.NumThreads = 1
+	opts.Destination = fmt.Sprintf("ws://localhost:%d/nows", addr.Port)
+	res, err := RunWSTest(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RetCodes["websocket upgrade failed with status 200"] != 3 || res.SocketCount != 3 {
+		t.Errorf("Expected 3 upgrade errors, got %v on %d sockets", res.RetCodes, res.SocketCount)
+	}
+	opts.Destination = fmt.Sprintf("http://localhost:%d/", addr.Port)
+	if _, err = RunWSTest(&opts); err == nil {
+		t.Errorf("Expected error for non websocket url")
+	}
+	if !IsWebSocketURL("wss://foo/") || !IsWebSocketURL("ws://foo/") || IsWebSocketURL("http://foo/") {
+		t.Errorf("IsWebSocketURL mismatch")
+	}
+}